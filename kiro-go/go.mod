@@ -4,7 +4,9 @@ go 1.24.0
 
 require (
 	github.com/google/uuid v1.6.0
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/net v0.50.0
+	golang.org/x/sys v0.41.0
 )
 
 require golang.org/x/text v0.34.0 // indirect
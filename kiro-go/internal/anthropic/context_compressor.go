@@ -10,6 +10,7 @@ import (
 	"kiro-go/internal/kiro"
 	"kiro-go/internal/kiro/parser"
 	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
 	"kiro-go/internal/model"
 
 	"github.com/google/uuid"
@@ -23,6 +24,7 @@ func CompressContext(
 	provider *kiro.Provider,
 	creds *model.KiroCredentials,
 	actCode string,
+	pin *model.CredentialPin,
 ) ([]MessageItem, bool) {
 	if !cfg.ContextCompression {
 		return messages, false
@@ -64,7 +66,7 @@ func CompressContext(
 
 	// 用小模型做摘要
 	start := time.Now()
-	summary, err := callCompressionModel(conversationText, cfg, provider, creds, actCode)
+	summary, err := callCompressionModel(conversationText, cfg, provider, creds, actCode, pin)
 	elapsed := time.Since(start)
 
 	if err != nil {
@@ -72,8 +74,10 @@ func CompressContext(
 			"error":   err.Error(),
 			"latency": elapsed.String(),
 		})
+		metrics.Compressions.Inc("failed")
 		return messages, false
 	}
+	metrics.Compressions.Inc("ok")
 
 	logger.InfoFields(logger.CatProxy, "上下文压缩完成", logger.F{
 		"original_chars": len(conversationText),
@@ -186,6 +190,7 @@ func callCompressionModel(
 	provider *kiro.Provider,
 	creds *model.KiroCredentials,
 	actCode string,
+	pin *model.CredentialPin,
 ) (string, error) {
 	prompt := fmt.Sprintf(`请简洁地总结以下对话内容，保留关键信息（包括：代码片段、文件路径、技术决策、具体的数值/配置、错误信息等）。
 总结应该让后续的对话模型能够理解之前讨论的完整上下文。
@@ -232,7 +237,7 @@ func callCompressionModel(
 	if creds != nil {
 		resp, err = provider.CallWithCredentials(body, creds, actCode)
 	} else {
-		resp, _, err = provider.CallWithTokenManager(body)
+		resp, _, err = provider.CallWithTokenManager(body, pin, "")
 	}
 	if err != nil {
 		return "", fmt.Errorf("压缩请求失败: %v", err)
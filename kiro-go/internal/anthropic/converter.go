@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"kiro-go/internal/common"
 	"kiro-go/internal/logger"
 
 	"github.com/google/uuid"
@@ -26,6 +27,9 @@ type MessagesRequest struct {
 	TopK          *int              `json:"top_k,omitempty"`
 	StopSequences []string          `json:"stop_sequences,omitempty"`
 	ToolChoice    json.RawMessage   `json:"tool_choice,omitempty"`
+
+	// CacheCheckpoints 由服务端设置（不来自客户端）：>0 时将 cache_control 映射为 Kiro 缓存检查点，最多该数量
+	CacheCheckpoints int `json:"-"`
 }
 
 type MessageItem struct {
@@ -68,19 +72,17 @@ func injectThinkingTags(content string, budgetTokens int) string {
 
 // ConvertToKiroRequest 将 Anthropic 请求转换为 Kiro 请求体
 func ConvertToKiroRequest(req *MessagesRequest) ([]byte, error) {
-	modelID, ok := ResolveModel(req.Model)
-	if !ok {
-		return nil, fmt.Errorf("模型不支持: %s", req.Model)
+	modelID, err := CheckModel(req.Model)
+	if err != nil {
+		return nil, err
 	}
 	if len(req.Messages) == 0 {
-		return nil, fmt.Errorf("消息列表为空")
+		return nil, common.NewMessage(common.MsgMessagesEmpty)
 	}
 
-	conversationID := uuid.New().String()
-	if req.Metadata != nil && req.Metadata.UserID != "" {
-		if sid := extractSessionID(req.Metadata.UserID); sid != "" {
-			conversationID = sid
-		}
+	conversationID := SessionID(req)
+	if conversationID == "" {
+		conversationID = uuid.New().String()
 	}
 
 	// 消息规范化流水线（参考 kiro-gateway converters_core）
@@ -96,6 +98,12 @@ func ConvertToKiroRequest(req *MessagesRequest) ([]byte, error) {
 	systemPrompt := extractSystemPrompt(req.System)
 	tools := convertTools(req.Tools)
 
+	// 缓存断点需在 system 合并改写第一条消息之前收集
+	var breakpoints []int
+	if req.CacheCheckpoints > 0 {
+		breakpoints = cacheBreakpoints(req, normalized)
+	}
+
 	// 构建 history（所有消息除了最后一条）
 	historyMessages := normalized[:len(normalized)-1]
 
@@ -175,6 +183,8 @@ func ConvertToKiroRequest(req *MessagesRequest) ([]byte, error) {
 		userInput["images"] = images
 	}
 
+	applyCacheCheckpoints(breakpoints, history, userInput, req.CacheCheckpoints)
+
 	currentMessage := map[string]interface{}{"userInputMessage": userInput}
 
 	// conversationState：参考 kiro.rs converter.rs
@@ -203,6 +213,15 @@ func ConvertToKiroRequest(req *MessagesRequest) ([]byte, error) {
 	return body, nil
 }
 
+// SessionID 客户端在 metadata.user_id 中携带的会话 ID（Claude Code 的 session_<uuid>），没有时返回空
+// 同时用作 Kiro conversationId 与凭据亲和的 key
+func SessionID(req *MessagesRequest) string {
+	if req.Metadata == nil || req.Metadata.UserID == "" {
+		return ""
+	}
+	return extractSessionID(req.Metadata.UserID)
+}
+
 func extractSessionID(userID string) string {
 	idx := strings.Index(userID, "session_")
 	if idx == -1 {
@@ -14,13 +14,14 @@ import (
 	"time"
 
 	"kiro-go/internal/common"
+	"kiro-go/internal/metrics"
 	"kiro-go/internal/model"
 )
 
 // DirectProvider 直连 Anthropic API 的 provider
 // 支持多 API Key 轮询 + 故障转移
 type DirectProvider struct {
-	Config  *model.Config
+	Config  *model.ConfigRef
 	Client  *http.Client
 	keys    []string       // API key 池
 	index   uint64         // 原子轮询计数器
@@ -29,7 +30,22 @@ type DirectProvider struct {
 }
 
 // NewDirectProvider 创建直连 provider，合并单个 key 和 key 数组
-func NewDirectProvider(cfg *model.Config) *DirectProvider {
+func NewDirectProvider(cfg *model.ConfigRef) *DirectProvider {
+	keys := mergeAPIKeys(cfg.Load())
+	if len(keys) == 0 {
+		log.Fatalf("[direct] 没有可用的 Anthropic API Key")
+	}
+	log.Printf("[direct] 初始化 %d 个 API Key", len(keys))
+	return &DirectProvider{
+		Config:   cfg,
+		Client:   &http.Client{Timeout: 720 * time.Second},
+		keys:     keys,
+		disabled: make(map[int]time.Time),
+	}
+}
+
+// mergeAPIKeys 合并 anthropicApiKeys 与 anthropicApiKey（去空白、去重，单个 key 排在最前）
+func mergeAPIKeys(cfg *model.Config) []string {
 	var keys []string
 	// 先加数组里的
 	for _, k := range cfg.AnthropicAPIKeys {
@@ -51,16 +67,29 @@ func NewDirectProvider(cfg *model.Config) *DirectProvider {
 			keys = append([]string{cfg.AnthropicAPIKey}, keys...)
 		}
 	}
+	return keys
+}
+
+// ReloadKeys 配置热加载后重建 key 池；仍在池中的 key 保留冷却状态。没有可用 key 时返回错误并保留当前 key 池
+func (dp *DirectProvider) ReloadKeys(cfg *model.Config) error {
+	keys := mergeAPIKeys(cfg)
 	if len(keys) == 0 {
-		log.Fatalf("[direct] 没有可用的 Anthropic API Key")
+		return fmt.Errorf("没有可用的 Anthropic API Key")
 	}
-	log.Printf("[direct] 初始化 %d 个 API Key", len(keys))
-	return &DirectProvider{
-		Config:   cfg,
-		Client:   &http.Client{Timeout: 720 * time.Second},
-		keys:     keys,
-		disabled: make(map[int]time.Time),
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	disabled := make(map[int]time.Time)
+	for oldIdx, until := range dp.disabled {
+		for idx, k := range keys {
+			if k == dp.keys[oldIdx] {
+				disabled[idx] = until
+			}
+		}
 	}
+	dp.keys = keys
+	dp.disabled = disabled
+	log.Printf("[direct] API Key 已重新加载，共 %d 个", len(keys))
+	return nil
 }
 
 // nextKey 轮询获取下一个可用的 key，跳过被禁用的
@@ -85,6 +114,62 @@ func (dp *DirectProvider) nextKey() (string, int) {
 	return dp.keys[idx], idx
 }
 
+// keyCount 当前 key 池大小（热加载可能改变）
+func (dp *DirectProvider) keyCount() int {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+	return len(dp.keys)
+}
+
+// cooldownRemaining 所有 key 都在冷却期时，返回最早恢复的剩余时间；否则返回 0
+func (dp *DirectProvider) cooldownRemaining() time.Duration {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+
+	now := time.Now()
+	var earliest time.Duration
+	for idx := range dp.keys {
+		until, disabled := dp.disabled[idx]
+		if !disabled || !now.Before(until) {
+			return 0
+		}
+		if d := until.Sub(now); earliest == 0 || d < earliest {
+			earliest = d
+		}
+	}
+	return earliest
+}
+
+// KeyCooldowns 每个 key（按池中序号）剩余的冷却时间，未禁用为 0
+func (dp *DirectProvider) KeyCooldowns() []time.Duration {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+
+	now := time.Now()
+	cooldowns := make([]time.Duration, len(dp.keys))
+	for idx := range dp.keys {
+		if until, disabled := dp.disabled[idx]; disabled && now.Before(until) {
+			cooldowns[idx] = until.Sub(now)
+		}
+	}
+	return cooldowns
+}
+
+// exhaustedError 所有 key 尝试失败后返回给客户端的错误
+// 最后一次失败是限流/过载时，Retry-After 取最早恢复的 key 的冷却时间
+func (dp *DirectProvider) exhaustedError(lastErr error) *common.UpstreamError {
+	if lastErr == nil {
+		return &common.UpstreamError{Status: http.StatusBadGateway, Type: "api_error", Message: "All Anthropic API keys exhausted"}
+	}
+	ue := common.ClassifyError(lastErr)
+	if ue.RetryAfter > 0 {
+		if d := dp.cooldownRemaining(); d > ue.RetryAfter {
+			ue.RetryAfter = d
+		}
+	}
+	return ue
+}
+
 // disableKey 临时禁用一个 key（429/529 时冷却 60s，402 冷却 5min）
 func (dp *DirectProvider) disableKey(idx int, duration time.Duration) {
 	dp.mu.Lock()
@@ -97,48 +182,59 @@ func (dp *DirectProvider) disableKey(idx int, duration time.Duration) {
 // 支持自动重试：429/529 换 key 重试，最多尝试 len(keys) 次
 func HandlePostMessagesDirect(w http.ResponseWriter, r *http.Request, dp *DirectProvider) {
 	if r.Method != http.MethodPost {
-		common.WriteError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		common.WriteErrorCode(w, r, http.StatusMethodNotAllowed, "invalid_request_error", common.MsgMethodNotAllowed)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		common.WriteErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgReadBodyFailed)
 		return
 	}
 	defer r.Body.Close()
 
 	var req MessagesRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON: "+err.Error())
+		common.WriteErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgInvalidJSON, err.Error())
 		return
 	}
 
 	// thinking 后缀检测
 	overrideThinkingFromModelName(&req)
+	metrics.SetModel(r, req.Model)
+
+	// 直连按原模型名转发，模型范围直接按该名称检查
+	if msg := common.CheckModelScope(common.GetModelScopeFromContext(r), req.Model); msg != nil {
+		common.WriteErrorFrom(w, r, http.StatusForbidden, "permission_error", msg)
+		return
+	}
 
 	log.Printf("[direct] POST /v1/messages model=%s max_tokens=%d stream=%v messages=%d thinking=%v",
 		req.Model, req.MaxTokens, req.Stream, len(req.Messages), req.Thinking != nil && req.Thinking.Type == "enabled")
 
 	forwardBody, err := buildAnthropicRequestBody(&req)
 	if err != nil {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		common.WriteErrorFrom(w, r, http.StatusBadRequest, "invalid_request_error", err)
 		return
 	}
 
 	// 带重试的请求发送
-	maxAttempts := len(dp.keys)
+	maxAttempts := dp.keyCount()
 	if maxAttempts < 2 {
 		maxAttempts = 2
 	}
 
+	var lastErr error
 	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			metrics.UpstreamRetries.Inc("direct_key")
+		}
 		apiKey, keyIdx := dp.nextKey()
 
-		apiURL := strings.TrimRight(dp.Config.AnthropicBaseURL, "/") + "/v1/messages"
+		apiURL := strings.TrimRight(dp.Config.Load().AnthropicBaseURL, "/") + "/v1/messages"
 		httpReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, apiURL, bytes.NewReader(forwardBody))
 		if err != nil {
-			common.WriteError(w, http.StatusInternalServerError, "api_error", "Failed to create request: "+err.Error())
+			common.WriteErrorCode(w, r, http.StatusInternalServerError, "api_error", common.MsgCreateRequestFailed, err.Error())
 			return
 		}
 
@@ -154,6 +250,7 @@ func HandlePostMessagesDirect(w http.ResponseWriter, r *http.Request, dp *Direct
 		resp, err := dp.Client.Do(httpReq)
 		if err != nil {
 			log.Printf("[direct] key#%d 请求失败: %v (attempt %d/%d)", keyIdx, err, attempt+1, maxAttempts)
+			lastErr = err
 			continue
 		}
 
@@ -162,6 +259,7 @@ func HandlePostMessagesDirect(w http.ResponseWriter, r *http.Request, dp *Direct
 			respBody, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
 			log.Printf("[direct] key#%d 限流 %d: %s (attempt %d/%d)", keyIdx, resp.StatusCode, string(respBody), attempt+1, maxAttempts)
+			lastErr = common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header)
 			dp.disableKey(keyIdx, 60*time.Second)
 			time.Sleep(time.Duration(attempt+1) * 500 * time.Millisecond)
 			continue
@@ -170,15 +268,19 @@ func HandlePostMessagesDirect(w http.ResponseWriter, r *http.Request, dp *Direct
 			respBody, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
 			log.Printf("[direct] key#%d 额度不足 402: %s", keyIdx, string(respBody))
+			lastErr = common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header)
 			dp.disableKey(keyIdx, 5*time.Minute)
 			continue
 		}
 
-		// 非 200 但不可重试 → 透传错误
+		// 非 200 但不可重试 → 透传错误（已是 Anthropic 格式）
 		if resp.StatusCode != http.StatusOK {
 			respBody, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
 			log.Printf("[direct] Anthropic API 返回错误: %d %s", resp.StatusCode, string(respBody))
+			if ra := resp.Header.Get("Retry-After"); ra != "" {
+				w.Header().Set("Retry-After", ra)
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(resp.StatusCode)
 			w.Write(respBody)
@@ -191,22 +293,24 @@ func HandlePostMessagesDirect(w http.ResponseWriter, r *http.Request, dp *Direct
 			log.Printf("[direct] key#%d 成功 (attempt %d)", keyIdx, attempt+1)
 		}
 
+		var usage anthropicUsage
 		if req.Stream {
-			proxyStreamResponse(w, resp)
+			usage = proxyStreamResponse(w, resp)
 		} else {
-			proxyNonStreamResponse(w, resp)
+			usage = proxyNonStreamResponse(w, resp)
 		}
+		common.RecordUsage(r, usage.record(req.Model))
 		return
 	}
 
 	// 所有 key 都失败了
-	common.WriteError(w, http.StatusBadGateway, "api_error", "All Anthropic API keys exhausted")
+	common.WriteUpstreamError(w, dp.exhaustedError(lastErr))
 }
 
 // buildAnthropicRequestBody 构建发往 Anthropic API 的请求体
 func buildAnthropicRequestBody(req *MessagesRequest) ([]byte, error) {
 	if len(req.Messages) == 0 {
-		return nil, fmt.Errorf("消息列表为空")
+		return nil, common.NewMessage(common.MsgMessagesEmpty)
 	}
 
 	body := map[string]interface{}{
@@ -250,12 +354,13 @@ func buildAnthropicRequestBody(req *MessagesRequest) ([]byte, error) {
 	return json.Marshal(body)
 }
 
-// proxyStreamResponse 透传 Anthropic SSE 流式响应
-func proxyStreamResponse(w http.ResponseWriter, resp *http.Response) {
+// proxyStreamResponse 透传 Anthropic SSE 流式响应，返回流中的 usage
+func proxyStreamResponse(w http.ResponseWriter, resp *http.Response) anthropicUsage {
+	var usage anthropicUsage
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		common.WriteError(w, http.StatusInternalServerError, "api_error", "Streaming not supported")
-		return
+		return usage
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -269,6 +374,10 @@ func proxyStreamResponse(w http.ResponseWriter, resp *http.Response) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		fmt.Fprintf(w, "%s\n", line)
+		usage.mergeSSE(line)
+		if strings.HasPrefix(line, "event: content_block_delta") {
+			metrics.FirstToken(w)
+		}
 		if line == "" {
 			flusher.Flush()
 		}
@@ -278,18 +387,28 @@ func proxyStreamResponse(w http.ResponseWriter, resp *http.Response) {
 		log.Printf("[direct] SSE 读取错误: %v", err)
 	}
 	flusher.Flush()
+	return usage
 }
 
-// proxyNonStreamResponse 透传 Anthropic 非流式响应
-func proxyNonStreamResponse(w http.ResponseWriter, resp *http.Response) {
+// proxyNonStreamResponse 透传 Anthropic 非流式响应，返回响应中的 usage
+func proxyNonStreamResponse(w http.ResponseWriter, resp *http.Response) anthropicUsage {
+	var usage anthropicUsage
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		common.WriteError(w, http.StatusBadGateway, "api_error", "Failed to read response: "+err.Error())
-		return
+		return usage
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(body)
+
+	var parsed struct {
+		Usage anthropicUsage `json:"usage"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		usage = parsed.Usage
+	}
+	return usage
 }
 
 // CallAnthropic 给 OpenAI 兼容层用的直连入口
@@ -300,16 +419,19 @@ func (dp *DirectProvider) CallAnthropic(req *MessagesRequest, betaHeader string)
 		return nil, err
 	}
 
-	maxAttempts := len(dp.keys)
+	maxAttempts := dp.keyCount()
 	if maxAttempts < 2 {
 		maxAttempts = 2
 	}
 
 	var lastErr error
 	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			metrics.UpstreamRetries.Inc("direct_key")
+		}
 		apiKey, keyIdx := dp.nextKey()
 
-		apiURL := strings.TrimRight(dp.Config.AnthropicBaseURL, "/") + "/v1/messages"
+		apiURL := strings.TrimRight(dp.Config.Load().AnthropicBaseURL, "/") + "/v1/messages"
 		httpReq, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewReader(forwardBody))
 		if err != nil {
 			return nil, fmt.Errorf("create request failed: %w", err)
@@ -332,7 +454,7 @@ func (dp *DirectProvider) CallAnthropic(req *MessagesRequest, betaHeader string)
 		if resp.StatusCode == 429 || resp.StatusCode == 529 {
 			respBody, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
-			lastErr = fmt.Errorf("rate limited %d: %s", resp.StatusCode, string(respBody))
+			lastErr = common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header)
 			dp.disableKey(keyIdx, 60*time.Second)
 			time.Sleep(time.Duration(attempt+1) * 500 * time.Millisecond)
 			continue
@@ -340,7 +462,7 @@ func (dp *DirectProvider) CallAnthropic(req *MessagesRequest, betaHeader string)
 		if resp.StatusCode == 402 {
 			respBody, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
-			lastErr = fmt.Errorf("quota exhausted 402: %s", string(respBody))
+			lastErr = common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header)
 			dp.disableKey(keyIdx, 5*time.Minute)
 			continue
 		}
@@ -348,5 +470,5 @@ func (dp *DirectProvider) CallAnthropic(req *MessagesRequest, betaHeader string)
 		return resp, nil
 	}
 
-	return nil, fmt.Errorf("all API keys exhausted: %w", lastErr)
+	return nil, dp.exhaustedError(lastErr)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"kiro-go/internal/kiro"
 	"kiro-go/internal/kiro/parser"
 	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
 
 	"github.com/google/uuid"
 )
@@ -81,31 +83,58 @@ func HandleGetModels(w http.ResponseWriter, r *http.Request, provider *kiro.Prov
 			},
 		}
 	}
+	models = appendModelAliases(models)
 	common.WriteJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": models})
 }
 
+// appendModelAliases 在上游模型列表后追加路由表中的别名（已是上游模型 ID 的跳过）
+func appendModelAliases(models []map[string]interface{}) []map[string]interface{} {
+	existing := make(map[string]bool, len(models))
+	for _, m := range models {
+		if id, ok := m["id"].(string); ok {
+			existing[id] = true
+		}
+	}
+	aliases := ModelAliases()
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		if !existing[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		models = append(models, map[string]interface{}{
+			"id": name, "object": "model", "created": time.Now().Unix(), "owned_by": "kiro-proxy",
+			"display_name": name, "type": "chat", "alias_of": aliases[name],
+		})
+	}
+	return models
+}
+
 // HandlePostMessages POST /v1/messages
 func HandlePostMessages(w http.ResponseWriter, r *http.Request, provider *kiro.Provider) {
 	if r.Method != http.MethodPost {
-		common.WriteError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		common.WriteErrorCode(w, r, http.StatusMethodNotAllowed, "invalid_request_error", common.MsgMethodNotAllowed)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		common.WriteErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgReadBodyFailed)
 		return
 	}
 	defer r.Body.Close()
 
 	var req MessagesRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON: "+err.Error())
+		common.WriteErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgInvalidJSON, err.Error())
 		return
 	}
 
 	// 检测模型名是否包含 "thinking" 后缀，覆写 thinking 配置
 	overrideThinkingFromModelName(&req)
+	metrics.SetModel(r, req.Model)
 
 	logger.InfoFields(logger.CatRequest, "POST /v1/messages", logger.F{
 		"model":      req.Model,
@@ -118,32 +147,48 @@ func HandlePostMessages(w http.ResponseWriter, r *http.Request, provider *kiro.P
 	// WebSearch 路由：tools 只有 web_search 时走 MCP
 	if HasWebSearchTool(&req) {
 		logger.Infof(logger.CatRequest, "检测到WebSearch请求，走MCP路由")
-		HandleWebSearchRequest(w, &req, provider)
+		HandleWebSearchRequest(w, r, &req, provider)
 		return
 	}
 
-	// 上下文压缩：消息过多时用小模型压缩历史
 	creds := common.GetCredsFromContext(r)
 	actCode := common.GetActCodeFromContext(r)
-	if compressed, ok := CompressContext(req.Messages, provider.Config, provider, creds, actCode); ok {
+	pin := common.GetPinFromContext(r)
+
+	// 路由规则：按请求特征改写模型（如后台小请求改用 haiku）
+	ApplyRoutingRules(w, &req, actCode, provider.Config.Load().RoutingRules)
+
+	// 模型范围按路由、别名解析后的最终模型检查，备用模型在 CallWithFallback 中逐个检查
+	scope := common.GetModelScopeFromContext(r)
+	if msg := CheckScopedModel(scope, req.Model); msg != nil {
+		logger.Warnf(logger.CatAuth, "不允许使用该模型: %s", req.Model)
+		common.WriteErrorFrom(w, r, http.StatusForbidden, "permission_error", msg)
+		return
+	}
+
+	// 上下文压缩：消息过多时用小模型压缩历史
+	if compressed, ok := CompressContext(req.Messages, provider.Config.Load(), provider, creds, actCode, pin); ok {
 		req.Messages = compressed
 	}
 
+	EnablePromptCaching(&req, provider.Config.Load())
+	if modelID, err := CheckModel(req.Model); err == nil {
+		if err := CheckModelCapabilities(&req, provider.Catalog.Lookup(creds, modelID)); err != nil {
+			common.WriteErrorFrom(w, r, http.StatusBadRequest, "invalid_request_error", err)
+			return
+		}
+	}
+
 	kiroBody, err := ConvertToKiroRequest(&req)
 	if err != nil {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		common.WriteErrorFrom(w, r, http.StatusBadRequest, "invalid_request_error", err)
 		return
 	}
 
-	var resp *http.Response
-	if creds != nil {
-		resp, err = provider.CallWithCredentials(kiroBody, creds, actCode)
-	} else {
-		resp, _, err = provider.CallWithTokenManager(kiroBody)
-	}
+	resp, usedModel, err := provider.CallWithFallback(kiroBody, creds, actCode, pin, SessionID(&req), scope)
 	if err != nil {
 		logger.Errorf(logger.CatProxy, "Kiro API调用失败: %v", err)
-		common.WriteError(w, http.StatusBadGateway, "api_error", "Kiro API error: "+err.Error())
+		common.WriteUpstreamError(w, common.ClassifyError(err))
 		return
 	}
 	defer resp.Body.Close()
@@ -154,16 +199,32 @@ func HandlePostMessages(w http.ResponseWriter, r *http.Request, provider *kiro.P
 			"status": resp.StatusCode,
 			"body":   logger.TruncateBody(string(respBody), 500),
 		})
-		common.WriteError(w, resp.StatusCode, "api_error", fmt.Sprintf("Upstream error: %d %s", resp.StatusCode, string(respBody)))
+		common.WriteUpstreamError(w, common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header))
 		return
 	}
 
+	// 降级到备用模型时，响应的 model 字段报告实际使用的模型
+	if usedModel != kiro.RequestModelID(kiroBody) {
+		w.Header().Set("x-kiro-fallback-model", usedModel)
+		req.Model = usedModel
+	}
+	region := kiro.ServedRegion(resp)
+	if region != "" {
+		w.Header().Set("x-kiro-region", region)
+	}
+
 	thinkingEnabled := req.Thinking != nil && req.Thinking.Type == "enabled"
 
+	var streamCtx *StreamContext
 	if req.Stream {
-		handleStreamResponse(w, resp, &req, thinkingEnabled)
+		streamCtx = handleStreamResponse(w, resp, &req, thinkingEnabled)
 	} else {
-		handleNonStreamResponse(w, resp, &req, thinkingEnabled)
+		streamCtx = handleNonStreamResponse(w, resp, &req, thinkingEnabled)
+	}
+	if streamCtx != nil {
+		usage := streamCtx.UsageRecord(usedModel)
+		usage.Region = region
+		common.RecordUsage(r, usage)
 	}
 }
 
@@ -178,7 +239,8 @@ func overrideThinkingFromModelName(req *MessagesRequest) {
 }
 
 // readKiroEvents 从 HTTP 响应中读取并解析 AWS Event Stream 事件
-func readKiroEvents(resp *http.Response) []*kiro.Event {
+// 第二个返回值非 nil 表示上游连接中途断开（events 为已收到的部分）
+func readKiroEvents(resp *http.Response) ([]*kiro.Event, error) {
 	decoder := parser.NewDecoder()
 	var events []*kiro.Event
 
@@ -198,18 +260,29 @@ func readKiroEvents(resp *http.Response) []*kiro.Event {
 			}
 		}
 		if err != nil {
-			break
+			return events, StreamReadError(err, decoder)
 		}
 	}
-	return events
+}
+
+// StreamReadError 判断上游流结束是否为异常中断（OpenAI 兼容接口共用）
+// io.EOF 且没有残留半帧视为正常结束
+func StreamReadError(err error, decoder *parser.Decoder) error {
+	if err == io.EOF {
+		if decoder.Buffered() > 0 {
+			return fmt.Errorf("truncated event frame (%d bytes)", decoder.Buffered())
+		}
+		return nil
+	}
+	return err
 }
 
 // handleStreamResponse 流式响应（使用 AWS Event Stream 解析 + SSE 状态机）
-func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *MessagesRequest, thinkingEnabled bool) {
+func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *MessagesRequest, thinkingEnabled bool) *StreamContext {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		common.WriteError(w, http.StatusInternalServerError, "api_error", "Streaming not supported")
-		return
+		return nil
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -243,7 +316,7 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *Messa
 		}
 	}()
 
-	for {
+	for ctx.Err() == nil {
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
 			decoder.Feed(buf[:n])
@@ -255,31 +328,49 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *Messa
 					continue
 				}
 				sseEvents := ctx.ProcessKiroEvent(event)
+				if len(sseEvents) > 0 {
+					metrics.FirstToken(w)
+				}
 				for _, e := range sseEvents {
 					e.Write(w, flusher)
 				}
 			}
 		}
 		if err != nil {
+			if readErr := StreamReadError(err, decoder); readErr != nil {
+				for _, e := range ctx.Fail(common.StreamInterrupted(readErr)) {
+					e.Write(w, flusher)
+				}
+			}
 			break
 		}
 	}
 
 	close(done)
 
+	if streamErr := ctx.Err(); streamErr != nil {
+		logger.WarnFields(logger.CatStream, "上游流异常终止，已发送 error 事件", logger.F{
+			"error_type":    streamErr.Type,
+			"message":       streamErr.Message,
+			"output_tokens": ctx.OutputTokens,
+		})
+		return ctx
+	}
+
 	// 发送最终事件
 	for _, e := range ctx.GenerateFinalEvents() {
 		e.Write(w, flusher)
 	}
+	return ctx
 }
 
 // handleNonStreamResponse 非流式响应
 // 通过 StreamContext 正确提取 thinking blocks，避免 <thinking> 标签泄漏到 text 中
-func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *MessagesRequest, thinkingEnabled bool) {
+func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *MessagesRequest, thinkingEnabled bool) *StreamContext {
 	ctx := NewStreamContext(req.Model, 0, thinkingEnabled)
 	ctx.GenerateInitialEvents() // 初始化状态
 
-	events := readKiroEvents(resp)
+	events, readErr := readKiroEvents(resp)
 
 	var fullText strings.Builder
 	var fullThinking strings.Builder
@@ -339,6 +430,19 @@ func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *Me
 		}
 	}
 
+	// 上游报错或中途断开：返回错误而不是不完整的内容
+	if readErr != nil {
+		ctx.Fail(common.StreamInterrupted(readErr))
+	}
+	if streamErr := ctx.Err(); streamErr != nil {
+		logger.WarnFields(logger.CatResponse, "上游流异常终止", logger.F{
+			"error_type": streamErr.Type,
+			"message":    streamErr.Message,
+		})
+		common.WriteError(w, streamErr.Status, streamErr.Type, streamErr.Message)
+		return ctx
+	}
+
 	// Flush StreamContext 中残留的 thinking buffer
 	for _, sseEvent := range ctx.GenerateFinalEvents() {
 		if sseEvent.Event == "content_block_delta" {
@@ -409,6 +513,7 @@ func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *Me
 		"id": "msg_" + uuid.New().String()[:24], "type": "message", "role": "assistant",
 		"content": content, "model": req.Model,
 		"stop_reason": stopReason, "stop_sequence": nil,
-		"usage": map[string]int{"input_tokens": finalInputTokens, "output_tokens": ctx.OutputTokens},
+		"usage": ctx.Usage(finalInputTokens, ctx.OutputTokens),
 	})
+	return ctx
 }
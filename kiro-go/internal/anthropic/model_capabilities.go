@@ -0,0 +1,54 @@
+package anthropic
+
+import (
+	"kiro-go/internal/common"
+	"kiro-go/internal/kiro"
+	"kiro-go/internal/logger"
+)
+
+// CheckModelCapabilities 按模型目录（ListAvailableModels）校验请求，在转换为 Kiro 请求前调用
+//   - 文本模型收到图片 → 400
+//   - max_tokens 超过 maxOutputTokens → 截断到上限
+//   - 预估输入 tokens 超过 maxInputTokens → 400
+//   - 缓存检查点数量按 maximumCacheCheckpointsPerRequest 收紧，不支持缓存的模型不映射
+//
+// info 为 nil（目录尚未加载或模型不在目录中）时不做校验，交给上游判断
+func CheckModelCapabilities(req *MessagesRequest, info *kiro.ModelInfo) error {
+	if info == nil {
+		return nil
+	}
+
+	if !info.SupportsImages() && requestHasImages(req) {
+		return common.NewMessage(common.MsgModelNoImages, info.ModelID)
+	}
+
+	if limit := info.TokenLimits.MaxOutputTokens; limit != nil && *limit > 0 && req.MaxTokens > *limit {
+		logger.Infof(logger.CatRequest, "max_tokens %d 超过模型 %s 上限，截断为 %d", req.MaxTokens, info.ModelID, *limit)
+		req.MaxTokens = *limit
+	}
+
+	if req.CacheCheckpoints > 0 {
+		if !info.PromptCaching.SupportsPromptCaching {
+			req.CacheCheckpoints = 0
+		} else if limit := info.PromptCaching.MaximumCacheCheckpointsPerRequest; limit > 0 && limit < req.CacheCheckpoints {
+			req.CacheCheckpoints = limit
+		}
+	}
+
+	if limit := info.TokenLimits.MaxInputTokens; limit > 0 {
+		if estimated := CountInputTokens(req); estimated > limit {
+			return common.NewMessage(common.MsgContextTooLong, estimated, info.ModelID, limit)
+		}
+	}
+	return nil
+}
+
+// requestHasImages 任一消息包含图片块
+func requestHasImages(req *MessagesRequest) bool {
+	for _, msg := range req.Messages {
+		if len(extractImages(msg.Content)) > 0 {
+			return true
+		}
+	}
+	return false
+}
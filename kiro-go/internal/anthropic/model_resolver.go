@@ -1,8 +1,14 @@
 package anthropic
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"sync/atomic"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/kiro"
+	"kiro-go/internal/model"
 )
 
 // 预编译正则（避免每次调用重新编译）
@@ -59,46 +65,195 @@ func NormalizeModelName(name string) string {
 	return lower
 }
 
-// ResolveModel 解析模型名并映射到 Kiro 内部 ID
-// 先标准化名称，再查找映射
-func ResolveModel(model string) (string, bool) {
-	normalized := NormalizeModelName(model)
+// 内置别名（未配置 modelRouting 或 replaceBuiltin=false 时生效）
+var builtinModelAliases = map[string]string{
+	"claude-sonnet-4.5": "claude-sonnet-4.5",
+	"claude-sonnet-4":   "claude-sonnet-4.5",
+	"claude-opus-4.5":   "claude-opus-4.5",
+	"claude-opus-4.6":   "claude-opus-4.6",
+	"claude-haiku-4.5":  "claude-haiku-4.5",
+	"claude-3.7-sonnet": "claude-3.7-sonnet",
+	"claude-3.5-sonnet": "claude-sonnet-4.5",
+	"claude-3.5-haiku":  "claude-haiku-4.5",
+}
+
+// 内置模糊规则：包含关键字；非 Claude 模型直通（让 Kiro 决定）
+var builtinModelRules = []model.ModelRule{
+	{Pattern: `sonnet`, Target: "claude-sonnet-4.5"},
+	{Pattern: `opus.*4[.-]5`, Target: "claude-opus-4.5"},
+	{Pattern: `opus`, Target: "claude-opus-4.6"},
+	{Pattern: `haiku`, Target: "claude-haiku-4.5"},
+	{Pattern: `^(deepseek|minimax|qwen)`, Target: ""},
+}
+
+type compiledModelRule struct {
+	re     *regexp.Regexp
+	target string
+}
+
+// modelRouter 编译后的路由表，整体替换（热加载时不加锁读）
+type modelRouter struct {
+	aliases  map[string]string
+	rules    []compiledModelRule
+	fallback string
+	reject   []*regexp.Regexp
+	known    map[string]bool // 路由表中写明的目标模型 ID（别名值、固定目标、默认模型）
+}
+
+var currentModelRouter atomic.Pointer[modelRouter]
+
+func init() {
+	r, _ := compileModelRouting(nil)
+	currentModelRouter.Store(r)
+}
 
-	// 已知模型映射
-	knownModels := map[string]string{
-		"claude-sonnet-4.5": "claude-sonnet-4.5",
-		"claude-sonnet-4":   "claude-sonnet-4.5",
-		"claude-opus-4.5":   "claude-opus-4.5",
-		"claude-opus-4.6":   "claude-opus-4.6",
-		"claude-haiku-4.5":  "claude-haiku-4.5",
-		"claude-3.7-sonnet": "claude-3.7-sonnet",
-		"claude-3.5-sonnet": "claude-sonnet-4.5",
-		"claude-3.5-haiku":  "claude-haiku-4.5",
+// compileModelRouting 合并内置表与配置并编译正则，任一正则无效则整体返回错误
+func compileModelRouting(cfg *model.ModelRoutingConfig) (*modelRouter, error) {
+	r := &modelRouter{aliases: make(map[string]string)}
+	var rules []model.ModelRule
+
+	if cfg == nil || !cfg.ReplaceBuiltin {
+		for k, v := range builtinModelAliases {
+			r.aliases[k] = v
+		}
+	}
+	if cfg != nil {
+		for k, v := range cfg.Aliases {
+			r.aliases[strings.ToLower(strings.TrimSpace(k))] = v
+		}
+		rules = append(rules, cfg.Rules...)
+		r.fallback = cfg.Default
+		for _, p := range cfg.Reject {
+			re, err := regexp.Compile("(?i)" + p)
+			if err != nil {
+				return nil, fmt.Errorf("reject 正则无效 %q: %w", p, err)
+			}
+			r.reject = append(r.reject, re)
+		}
+	}
+	if cfg == nil || !cfg.ReplaceBuiltin {
+		rules = append(rules, builtinModelRules...)
+	}
+	for _, rule := range rules {
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rules 正则无效 %q: %w", rule.Pattern, err)
+		}
+		r.rules = append(r.rules, compiledModelRule{re: re, target: rule.Target})
 	}
 
-	if id, ok := knownModels[normalized]; ok {
-		return id, true
+	r.known = make(map[string]bool)
+	for _, id := range r.aliases {
+		r.known[id] = true
+	}
+	for _, rule := range r.rules {
+		if rule.target != "" && !strings.Contains(rule.target, "$") {
+			r.known[rule.target] = true
+		}
 	}
+	if r.fallback != "" {
+		r.known[r.fallback] = true
+	}
+	return r, nil
+}
 
-	// 模糊匹配：包含关键字
-	lower := strings.ToLower(normalized)
-	if strings.Contains(lower, "sonnet") {
-		return "claude-sonnet-4.5", true
+// SetModelRouting 应用新的模型路由表（启动和热加载时调用）
+// 配置无效时返回错误并保留当前路由表
+func SetModelRouting(cfg *model.ModelRoutingConfig) error {
+	r, err := compileModelRouting(cfg)
+	if err != nil {
+		return err
 	}
-	if strings.Contains(lower, "opus") {
-		if strings.Contains(lower, "4-5") || strings.Contains(lower, "4.5") {
-			return "claude-opus-4.5", true
+	currentModelRouter.Store(r)
+	return nil
+}
+
+// ValidateModelRouting 只检查路由表能否编译，不替换当前路由表（配置热加载前的整体校验）
+func ValidateModelRouting(cfg *model.ModelRoutingConfig) error {
+	_, err := compileModelRouting(cfg)
+	return err
+}
+
+// ModelAliases 返回当前生效的别名表（别名 → Kiro 模型 ID），供 /v1/models 展示
+func ModelAliases() map[string]string {
+	r := currentModelRouter.Load()
+	out := make(map[string]string, len(r.aliases))
+	for k, v := range r.aliases {
+		out[k] = v
+	}
+	return out
+}
+
+// CheckModel 解析模型名并映射到 Kiro 内部 ID
+// 失败时返回 *common.Message（model_rejected / model_not_supported）
+func CheckModel(name string) (string, error) {
+	r := currentModelRouter.Load()
+	raw := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), "-thinking")
+	normalized := NormalizeModelName(name)
+
+	for _, re := range r.reject {
+		if re.MatchString(raw) || re.MatchString(normalized) {
+			return "", common.NewMessage(common.MsgModelRejected, name)
+		}
+	}
+
+	// 原名优先，便于配置 gpt-4o 这类非 Claude 名称
+	if id, ok := r.aliases[raw]; ok {
+		return id, nil
+	}
+	if id, ok := r.aliases[normalized]; ok {
+		return id, nil
+	}
+
+	for _, rule := range r.rules {
+		m := rule.re.FindStringSubmatchIndex(normalized)
+		if m == nil {
+			continue
 		}
-		return "claude-opus-4.6", true
+		if rule.target == "" {
+			return normalized, nil
+		}
+		return string(rule.re.ExpandString(nil, rule.target, normalized, m)), nil
 	}
-	if strings.Contains(lower, "haiku") {
-		return "claude-haiku-4.5", true
+
+	if r.fallback != "" {
+		return r.fallback, nil
 	}
+	return "", common.NewMessage(common.MsgModelNotSupported, name)
+}
 
-	// 非 Claude 模型直通（让 Kiro 决定）
-	if strings.HasPrefix(lower, "deepseek") || strings.HasPrefix(lower, "minimax") || strings.HasPrefix(lower, "qwen") {
-		return normalized, true
+// CheckScopedModel 按解析后的 Kiro 模型 ID 检查调用方（具名 API Key / 客户端令牌）的模型范围；
+// scope 为 nil 表示不限制，有限制时空模型名或无法解析的模型名一律拒绝
+func CheckScopedModel(scope model.ModelScope, name string) *common.Message {
+	if scope == nil {
+		return nil
+	}
+	id := ""
+	if strings.TrimSpace(name) != "" {
+		id, _ = CheckModel(name)
 	}
+	if id == "" || !scope.AllowsModel(id) {
+		return common.NewMessage(common.MsgAPIKeyModelDenied, name)
+	}
+	return nil
+}
 
-	return "", false
+// ResolveModel 解析模型名并映射到 Kiro 内部 ID
+// 先标准化名称，再查找映射
+func ResolveModel(model string) (string, bool) {
+	id, err := CheckModel(model)
+	return id, err == nil
+}
+
+// ModelLabel 指标的 model 标签：解析后的模型 ID 写在路由表中或出现在模型目录中时使用该 ID，否则为 other
+// 直通规则与正则捕获得到的 ID 由客户端决定，不在目录中时同样归为 other，避免标签取值无限增长
+func ModelLabel(catalog *kiro.ModelCatalog, name string) string {
+	id, err := CheckModel(name)
+	if err != nil {
+		return "other"
+	}
+	if currentModelRouter.Load().known[id] || (catalog != nil && catalog.Has(id)) {
+		return id
+	}
+	return "other"
 }
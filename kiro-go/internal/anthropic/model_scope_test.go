@@ -0,0 +1,67 @@
+package anthropic
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+func TestCheckScopedModel(t *testing.T) {
+	if err := SetModelRouting(&model.ModelRoutingConfig{
+		Aliases: map[string]string{"fast": "claude-haiku-4.5", "smart": "claude-opus-4.5"},
+		Default: "claude-opus-4.5",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetModelRouting(nil) })
+
+	scope := &model.APIKeyEntry{Name: "ci", AllowedModels: []string{"claude-haiku-4.5"}}
+	if err := scope.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		model string
+		allow bool
+	}{
+		{"范围内的模型", "claude-haiku-4.5", true},
+		{"解析到范围内的别名", "fast", true},
+		{"解析到范围外的别名", "smart", false},
+		{"靠默认模型兜底的未知名称", "whatever", false},
+		{"空模型名", "", false},
+		{"空白模型名", "  ", false},
+	}
+	for _, c := range cases {
+		msg := CheckScopedModel(scope, c.model)
+		if (msg == nil) != c.allow {
+			t.Errorf("%s (%q): 期望允许=%v，得到 %v", c.name, c.model, c.allow, msg)
+		}
+		if msg != nil && msg.Code != common.MsgAPIKeyModelDenied {
+			t.Errorf("%s: 错误码 %s", c.name, msg.Code)
+		}
+	}
+
+	if msg := CheckScopedModel(nil, ""); msg != nil {
+		t.Fatalf("没有范围限制时不检查: %v", msg)
+	}
+}
+
+func TestCheckScopedModelAfterRouting(t *testing.T) {
+	scope := &model.TokenScope{Models: []string{"claude-haiku-4.5"}}
+	rules := []model.RoutingRule{{Name: "big", Models: []string{"claude-haiku.*"}, Model: "claude-opus-4.5"}}
+
+	req := &MessagesRequest{Model: "claude-haiku-4.5", Messages: []MessageItem{{Role: "user", Content: []byte(`"hi"`)}}}
+	if msg := CheckScopedModel(scope, req.Model); msg != nil {
+		t.Fatalf("路由前的模型在范围内: %v", msg)
+	}
+	ApplyRoutingRules(httptest.NewRecorder(), req, "", rules)
+	if req.Model != "claude-opus-4.5" {
+		t.Fatalf("路由规则未生效: %s", req.Model)
+	}
+	if msg := CheckScopedModel(scope, req.Model); msg == nil {
+		t.Fatal("路由改写到范围外的模型时应拒绝")
+	}
+}
@@ -0,0 +1,110 @@
+package anthropic
+
+import (
+	"encoding/json"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+// defaultCacheCheckpoints 模型目录未声明上限时每个请求最多保留的缓存检查点（与 Anthropic 一致）
+const defaultCacheCheckpoints = 4
+
+// EnablePromptCaching 按配置开启 cache_control → Kiro 缓存检查点映射
+// 需在 CheckModelCapabilities 之前调用，之后再按模型目录收紧上限
+func EnablePromptCaching(req *MessagesRequest, cfg *model.Config) {
+	if cfg.PromptCaching {
+		req.CacheCheckpoints = defaultCacheCheckpoints
+	}
+}
+
+// hasCacheControl content 块数组中是否有块带 cache_control
+func hasCacheControl(raw json.RawMessage) bool {
+	var blocks []map[string]json.RawMessage
+	if json.Unmarshal(raw, &blocks) != nil {
+		return false
+	}
+	for _, b := range blocks {
+		if _, ok := b["cache_control"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// systemHasCacheControl system / tools 上是否声明了缓存断点
+// system 合并进第一条消息，tools 放在当前消息中，因此两者共用第一个检查点
+func systemHasCacheControl(req *MessagesRequest) bool {
+	if hasCacheControl(req.System) {
+		return true
+	}
+	for _, t := range req.Tools {
+		var tool map[string]json.RawMessage
+		if json.Unmarshal(t, &tool) == nil {
+			if _, ok := tool["cache_control"]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheBreakpoints 计算带 cache_control 的条目下标（按出现顺序，已去重），需在 system 合并前调用
+// 下标对应规范化后的消息：i < len(history) 为 history[i]，否则为当前消息
+// system / tools 的断点对应第一条消息
+func cacheBreakpoints(req *MessagesRequest, messages []MessageItem) []int {
+	var indexes []int
+	seen := make(map[int]bool)
+	add := func(i int) {
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	if systemHasCacheControl(req) {
+		add(0)
+	}
+	for i, msg := range messages {
+		if hasCacheControl(msg.Content) {
+			add(i)
+		}
+	}
+	return indexes
+}
+
+// applyCacheCheckpoints 将 cache_control 断点映射为 Kiro cachePoint
+// 超过 limit 时保留最后 limit 个（靠后的断点覆盖的前缀更长，命中收益更大）
+func applyCacheCheckpoints(indexes []int, history []interface{}, current map[string]interface{}, limit int) {
+	if len(indexes) == 0 || limit <= 0 {
+		return
+	}
+	if len(indexes) > limit {
+		logger.Debugf(logger.CatProxy, "缓存断点 %d 个，超过上限 %d，仅保留最后 %d 个", len(indexes), limit, limit)
+		indexes = indexes[len(indexes)-limit:]
+	}
+	for _, i := range indexes {
+		target := current
+		if i < len(history) {
+			target = historyEntryMessage(history[i])
+		}
+		if target != nil {
+			target["cachePoint"] = map[string]interface{}{"type": "default"}
+		}
+	}
+	logger.Debugf(logger.CatProxy, "映射缓存检查点: %d 个", len(indexes))
+}
+
+// historyEntryMessage 取 history 条目中的 userInputMessage / assistantResponseMessage
+func historyEntryMessage(entry interface{}) map[string]interface{} {
+	m, ok := entry.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if msg, ok := m["userInputMessage"].(map[string]interface{}); ok {
+		return msg
+	}
+	if msg, ok := m["assistantResponseMessage"].(map[string]interface{}); ok {
+		return msg
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package anthropic
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+// RouteDecision 路由规则命中结果
+type RouteDecision struct {
+	Rule string
+	From string
+	To   string
+}
+
+// Header 响应头 x-kiro-route 的值
+func (d *RouteDecision) Header() string {
+	return fmt.Sprintf("%s; %s -> %s", d.Rule, d.From, d.To)
+}
+
+// ApplyRoutingRules 按 config.routingRules 改写请求模型，首条命中的规则生效
+// 命中时写入 x-kiro-route 响应头并返回决策，未命中返回 nil
+func ApplyRoutingRules(w http.ResponseWriter, req *MessagesRequest, actCode string, rules []model.RoutingRule) *RouteDecision {
+	if len(rules) == 0 {
+		return nil
+	}
+	features := newRequestFeatures(req)
+	for i, rule := range rules {
+		if rule.Model == "" || !features.matches(&rule, actCode) {
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i+1)
+		}
+		decision := &RouteDecision{Rule: name, From: req.Model, To: rule.Model}
+		logger.InfoFields(logger.CatRequest, "路由规则命中，改写模型", logger.F{
+			"rule":        name,
+			"from":        decision.From,
+			"to":          decision.To,
+			"input_chars": features.inputChars,
+			"messages":    features.messages,
+			"tools":       features.hasTools,
+		})
+		req.Model = rule.Model
+		w.Header().Set("x-kiro-route", decision.Header())
+		return decision
+	}
+	return nil
+}
+
+// requestFeatures 规则匹配所需的请求特征（每个请求只计算一次）
+type requestFeatures struct {
+	model      string
+	hasTools   bool
+	inputChars int
+	messages   int
+	system     string
+	userID     string
+}
+
+func newRequestFeatures(req *MessagesRequest) *requestFeatures {
+	f := &requestFeatures{
+		model:    req.Model,
+		hasTools: len(req.Tools) > 0,
+		messages: len(req.Messages),
+		system:   extractSystemPrompt(req.System),
+	}
+	f.inputChars = len([]rune(f.system))
+	for _, msg := range req.Messages {
+		f.inputChars += len([]rune(extractTextContent(msg.Content)))
+	}
+	if req.Metadata != nil {
+		f.userID = req.Metadata.UserID
+	}
+	return f
+}
+
+func (f *requestFeatures) matches(rule *model.RoutingRule, actCode string) bool {
+	if !rule.MatchesModel(f.model) {
+		return false
+	}
+	if rule.NoTools != nil && *rule.NoTools == f.hasTools {
+		return false
+	}
+	if rule.MaxInputChars > 0 && f.inputChars > rule.MaxInputChars {
+		return false
+	}
+	if rule.MaxMessages > 0 && f.messages > rule.MaxMessages {
+		return false
+	}
+	if !rule.MatchesSystem(f.system) || !rule.MatchesMetadata(f.userID) {
+		return false
+	}
+	if len(rule.ActivationCodes) > 0 {
+		matched := false
+		for _, c := range rule.ActivationCodes {
+			if strings.EqualFold(strings.TrimPrefix(strings.ToUpper(c), "ACT-"), actCode) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
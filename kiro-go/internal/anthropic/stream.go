@@ -7,6 +7,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"kiro-go/internal/common"
 	"kiro-go/internal/kiro"
 
 	"github.com/google/uuid"
@@ -131,7 +132,7 @@ func (m *sseStateManager) handleContentBlockStop(index int) *SSEEvent {
 	return &SSEEvent{Event: "content_block_stop", Data: map[string]interface{}{"type": "content_block_stop", "index": index}}
 }
 
-func (m *sseStateManager) generateFinalEvents(inputTokens, outputTokens int) []*SSEEvent {
+func (m *sseStateManager) generateFinalEvents(usage map[string]int) []*SSEEvent {
 	var events []*SSEEvent
 
 	// 关闭所有未关闭的块
@@ -147,7 +148,7 @@ func (m *sseStateManager) generateFinalEvents(inputTokens, outputTokens int) []*
 		events = append(events, &SSEEvent{Event: "message_delta", Data: map[string]interface{}{
 			"type":  "message_delta",
 			"delta": map[string]interface{}{"stop_reason": m.getStopReason(), "stop_sequence": nil},
-			"usage": map[string]int{"input_tokens": inputTokens, "output_tokens": outputTokens},
+			"usage": usage,
 		}})
 	}
 
@@ -173,6 +174,12 @@ type StreamContext struct {
 	OutputTokens     int
 	ThinkingEnabled  bool
 
+	// prompt caching 用量（从 metering / metadata 事件累计）
+	CacheReadTokens  int
+	CacheWriteTokens int
+	UpstreamInput    *int    // 上游上报的未命中缓存的 input tokens
+	Credits          float64 // 上游 metering 上报的 credit 用量
+
 	// thinking 状态
 	thinkingBuffer              string
 	inThinkingBlock             bool
@@ -183,6 +190,9 @@ type StreamContext struct {
 
 	// tool 块索引映射
 	toolBlockIndices map[string]int
+
+	// 上游流错误（非 nil 表示已发送 error 事件，不再输出 message_stop）
+	err *common.UpstreamError
 }
 
 func NewStreamContext(model string, inputTokens int, thinkingEnabled bool) *StreamContext {
@@ -196,6 +206,25 @@ func NewStreamContext(model string, inputTokens int, thinkingEnabled bool) *Stre
 	}
 }
 
+// Usage 生成 Anthropic usage 字段
+// inputTokens 为估算的总输入（含缓存部分）；Anthropic 的 input_tokens 不含缓存读写，因此扣除
+// 上游直接上报了未命中缓存的 input tokens 时以上游为准
+func (ctx *StreamContext) Usage(inputTokens, outputTokens int) map[string]int {
+	input := inputTokens - ctx.CacheReadTokens - ctx.CacheWriteTokens
+	if ctx.UpstreamInput != nil {
+		input = *ctx.UpstreamInput
+	}
+	if input < 0 {
+		input = 0
+	}
+	return map[string]int{
+		"input_tokens":                input,
+		"output_tokens":               outputTokens,
+		"cache_creation_input_tokens": ctx.CacheWriteTokens,
+		"cache_read_input_tokens":     ctx.CacheReadTokens,
+	}
+}
+
 // GenerateInitialEvents 生成初始事件
 func (ctx *StreamContext) GenerateInitialEvents() []*SSEEvent {
 	var events []*SSEEvent
@@ -206,7 +235,7 @@ func (ctx *StreamContext) GenerateInitialEvents() []*SSEEvent {
 			"id": ctx.MessageID, "type": "message", "role": "assistant",
 			"content": []interface{}{}, "model": ctx.Model,
 			"stop_reason": nil, "stop_sequence": nil,
-			"usage": map[string]int{"input_tokens": ctx.InputTokens, "output_tokens": 1},
+			"usage": ctx.Usage(ctx.InputTokens, 1),
 		},
 	})
 	if msgStart != nil {
@@ -231,6 +260,9 @@ func (ctx *StreamContext) GenerateInitialEvents() []*SSEEvent {
 
 // ProcessKiroEvent 处理 Kiro 事件
 func (ctx *StreamContext) ProcessKiroEvent(event *kiro.Event) []*SSEEvent {
+	if ctx.err != nil {
+		return nil
+	}
 	switch event.Type {
 	case "assistant_response":
 		return ctx.processAssistantResponse(event.Content)
@@ -243,16 +275,43 @@ func (ctx *StreamContext) ProcessKiroEvent(event *kiro.Event) []*SSEEvent {
 			ctx.stateMgr.stopReason = "model_context_window_exceeded"
 		}
 		return nil
-	case "exception":
+	case "metering":
+		ctx.Credits += event.MeteringUsage
+		ctx.CacheReadTokens += event.CacheReadInputTokens
+		ctx.CacheWriteTokens += event.CacheWriteInputTokens
+		if event.InputTokens > 0 {
+			input := event.InputTokens
+			ctx.UpstreamInput = &input
+		}
+		return nil
+	case "error", "exception":
 		if event.ExceptionType == "ContentLengthExceededException" {
 			ctx.stateMgr.stopReason = "max_tokens"
+			return nil
 		}
-		return nil
+		return ctx.Fail(common.ClassifyStreamException(event.ExceptionType, event.ErrorCode, event.ErrorMessage))
 	default:
 		return nil
 	}
 }
 
+// Fail 标记流异常终止，返回需要发送给客户端的 Anthropic error 事件
+// 之后 GenerateFinalEvents 不再输出 message_delta / message_stop，客户端据此判定应重试
+func (ctx *StreamContext) Fail(e *common.UpstreamError) []*SSEEvent {
+	if ctx.err != nil || e == nil {
+		return nil
+	}
+	ctx.err = e
+	ctx.stateMgr.messageDeltaSent = true
+	ctx.stateMgr.messageEnded = true
+	return []*SSEEvent{{Event: "error", Data: e.AnthropicErrorBody()}}
+}
+
+// Err 返回上游流错误（nil 表示正常）
+func (ctx *StreamContext) Err() *common.UpstreamError {
+	return ctx.err
+}
+
 func (ctx *StreamContext) processAssistantResponse(content string) []*SSEEvent {
 	if content == "" {
 		return nil
@@ -570,6 +629,10 @@ func (ctx *StreamContext) HasToolUse() bool {
 
 // GenerateFinalEvents 生成最终事件
 func (ctx *StreamContext) GenerateFinalEvents() []*SSEEvent {
+	if ctx.err != nil {
+		return nil
+	}
+
 	var events []*SSEEvent
 
 	// Flush thinking buffer
@@ -623,6 +686,6 @@ func (ctx *StreamContext) GenerateFinalEvents() []*SSEEvent {
 		finalInputTokens = *ctx.ContextInputToks
 	}
 
-	events = append(events, ctx.stateMgr.generateFinalEvents(finalInputTokens, ctx.OutputTokens)...)
+	events = append(events, ctx.stateMgr.generateFinalEvents(ctx.Usage(finalInputTokens, ctx.OutputTokens))...)
 	return events
 }
@@ -0,0 +1,85 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"strings"
+
+	"kiro-go/internal/common"
+)
+
+// UsageRecord 本次 Kiro 响应的用量（上报到用量账本）
+// input 优先使用 contextUsageEvent 计算的值，与返回给客户端的 usage 一致
+func (ctx *StreamContext) UsageRecord(model string) common.Usage {
+	input := ctx.InputTokens
+	if ctx.ContextInputToks != nil {
+		input = *ctx.ContextInputToks
+	}
+	usage := ctx.Usage(input, ctx.OutputTokens)
+	return common.Usage{
+		Model:            model,
+		Backend:          "kiro",
+		InputTokens:      usage["input_tokens"],
+		OutputTokens:     usage["output_tokens"],
+		CacheReadTokens:  usage["cache_read_input_tokens"],
+		CacheWriteTokens: usage["cache_creation_input_tokens"],
+		Credits:          ctx.Credits,
+	}
+}
+
+// anthropicUsage Anthropic 响应中的 usage 字段
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// merge 合并 message_start / message_delta 的 usage（后者的 output_tokens 为累计值）
+func (u *anthropicUsage) merge(o anthropicUsage) {
+	if o.InputTokens > 0 {
+		u.InputTokens = o.InputTokens
+	}
+	if o.OutputTokens > u.OutputTokens {
+		u.OutputTokens = o.OutputTokens
+	}
+	if o.CacheCreationInputTokens > 0 {
+		u.CacheCreationInputTokens = o.CacheCreationInputTokens
+	}
+	if o.CacheReadInputTokens > 0 {
+		u.CacheReadInputTokens = o.CacheReadInputTokens
+	}
+}
+
+func (u anthropicUsage) record(model string) common.Usage {
+	return common.Usage{
+		Model:            model,
+		Backend:          "anthropic",
+		InputTokens:      u.InputTokens,
+		OutputTokens:     u.OutputTokens,
+		CacheReadTokens:  u.CacheReadInputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+	}
+}
+
+// mergeSSE 从 Anthropic SSE 的 data 行提取 usage（message_start 在 message.usage，message_delta 在 usage）
+func (u *anthropicUsage) mergeSSE(line string) {
+	data := strings.TrimPrefix(line, "data: ")
+	if len(data) == len(line) || !strings.Contains(data, `"usage"`) {
+		return
+	}
+	var event struct {
+		Usage   *anthropicUsage `json:"usage"`
+		Message *struct {
+			Usage *anthropicUsage `json:"usage"`
+		} `json:"message"`
+	}
+	if json.Unmarshal([]byte(data), &event) != nil {
+		return
+	}
+	if event.Message != nil && event.Message.Usage != nil {
+		u.merge(*event.Message.Usage)
+	}
+	if event.Usage != nil {
+		u.merge(*event.Usage)
+	}
+}
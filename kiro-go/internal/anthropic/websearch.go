@@ -114,10 +114,10 @@ func stripSearchPrefix(text string) string {
 }
 
 // HandleWebSearchRequest 处理 WebSearch 请求
-func HandleWebSearchRequest(w http.ResponseWriter, req *MessagesRequest, provider *kiro.Provider) {
+func HandleWebSearchRequest(w http.ResponseWriter, r *http.Request, req *MessagesRequest, provider *kiro.Provider) {
 	query := ExtractSearchQuery(req)
 	if query == "" {
-		common.WriteError(w, http.StatusBadRequest, "invalid_request_error", "无法从消息中提取搜索查询")
+		common.WriteErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgWebSearchQueryMissing)
 		return
 	}
 
@@ -145,7 +145,7 @@ func HandleWebSearchRequest(w http.ResponseWriter, req *MessagesRequest, provide
 	// 生成 SSE 响应
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		common.WriteError(w, http.StatusInternalServerError, "api_error", "Streaming not supported")
+		common.WriteErrorCode(w, r, http.StatusInternalServerError, "api_error", common.MsgStreamingUnsupported)
 		return
 	}
 
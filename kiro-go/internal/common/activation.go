@@ -0,0 +1,255 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro-go/internal/logger"
+)
+
+// 激活码验证服务不可达时的处理策略
+const (
+	ActivationFailOpen   = "fail-open"   // 放行（旧行为，需显式配置）
+	ActivationFailClosed = "fail-closed" // 拒绝，除非持有有效许可证
+	ActivationGrace      = "grace"       // 宽限期内曾验证通过的激活码放行（默认）
+
+	defaultActivationGrace = 24 * time.Hour
+	// 离线判定的缓存时间，验证服务恢复后尽快重新在线验证
+	offlineDecisionTTL = 30 * time.Second
+)
+
+// 激活码验证缓存
+type actCodeCache struct {
+	mu       sync.RWMutex
+	cache    map[string]actCodeCacheEntry
+	lastGood map[string]time.Time // code:machineId → 最近一次在线验证通过的时间（宽限期判断）
+	licenses map[string]string    // 激活码 → 验证服务最近返回的许可证
+}
+
+type actCodeCacheEntry struct {
+	valid    bool
+	code     string // 验证服务返回的稳定错误码（旧版服务没有）
+	message  string
+	expireAt time.Time
+}
+
+var actCache = &actCodeCache{
+	cache:    make(map[string]actCodeCacheEntry),
+	lastGood: make(map[string]time.Time),
+	licenses: make(map[string]string),
+}
+
+func (c *actCodeCache) get(code string) (actCodeCacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.cache[code]
+	if !ok || time.Now().After(entry.expireAt) {
+		return actCodeCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *actCodeCache) set(code string, entry actCodeCacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.expireAt = time.Now().Add(ttl)
+	c.cache[code] = entry
+}
+
+// remember 记录一次在线验证通过
+func (c *actCodeCache) remember(cacheKey, code, license string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastGood[cacheKey] = time.Now()
+	if license != "" {
+		c.licenses[code] = license
+	}
+}
+
+// lastValid 最近一次在线验证通过的时间
+func (c *actCodeCache) lastValid(cacheKey string) (time.Time, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.lastGood[cacheKey]
+	return t, ok
+}
+
+func (c *actCodeCache) license(code string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.licenses[code]
+}
+
+// ActivationResult 激活码验证结果
+type ActivationResult struct {
+	Valid   bool
+	Code    string // 稳定错误码（旧版 app.js 没有）
+	Message string
+	License string // 签名许可证（验证方启用签发时）
+}
+
+// ActivationValidator 激活码验证方式；返回 error 表示验证服务不可达或响应无效，结果不可信
+type ActivationValidator interface {
+	Validate(code, machineId string) (ActivationResult, error)
+}
+
+// HTTPActivationValidator 调用远程验证服务（app.js 或另一个 kiro-go 的 /api/code/validate）
+type HTTPActivationValidator struct {
+	ServerURL string
+}
+
+func (v *HTTPActivationValidator) Validate(code, machineId string) (ActivationResult, error) {
+	return validateActivationCode(v.ServerURL, code, machineId)
+}
+
+// activationValidator 生效的验证方式：显式注入的优先，否则配置了 activationServerUrl 时走 HTTP，都没有返回 nil（不验证）
+func (am *AuthMiddleware) activationValidator() ActivationValidator {
+	if am.Validator != nil {
+		return am.Validator
+	}
+	if url := am.Config.Load().ActivationServerURL; url != "" {
+		return &HTTPActivationValidator{ServerURL: url}
+	}
+	return nil
+}
+
+// InvalidateActivationCache 清除激活码的验证缓存、宽限记录与许可证（管理端删除 / 重置 / 新增后调用）
+func InvalidateActivationCache(codes ...string) {
+	actCache.mu.Lock()
+	defer actCache.mu.Unlock()
+	for _, code := range codes {
+		upper := strings.ToUpper(strings.TrimSpace(code))
+		prefix := upper + ":"
+		for key := range actCache.cache {
+			if strings.HasPrefix(key, prefix) {
+				delete(actCache.cache, key)
+			}
+		}
+		for key := range actCache.lastGood {
+			if strings.HasPrefix(key, prefix) {
+				delete(actCache.lastGood, key)
+			}
+		}
+		delete(actCache.licenses, upper)
+	}
+}
+
+// checkActivation 验证激活码并缓存结果；验证服务不可达或响应无效时按许可证与 activationPolicy 判定
+func (am *AuthMiddleware) checkActivation(v ActivationValidator, code, machineId, licenseHeader string) actCodeCacheEntry {
+	cacheKey := code + ":" + machineId
+	result, err := v.Validate(code, machineId)
+	entry, license := actCodeCacheEntry{valid: result.Valid, code: result.Code, message: result.Message}, result.License
+	if err == nil {
+		ttl := 1 * time.Minute
+		if entry.valid {
+			ttl = 5 * time.Minute
+			actCache.remember(cacheKey, code, license)
+		}
+		actCache.set(cacheKey, entry, ttl)
+		return entry
+	}
+
+	entry = am.offlineActivation(cacheKey, code, machineId, licenseHeader)
+	logger.WarnFields(logger.CatAuth, "激活码验证服务不可用，按离线策略判定", logger.F{
+		"error":  err.Error(),
+		"code":   logger.MaskKey(code),
+		"policy": activationPolicyName(am.Config.Load().ActivationPolicy),
+		"valid":  entry.valid,
+		"reason": entry.message,
+	})
+	actCache.set(cacheKey, entry, offlineDecisionTTL)
+	return entry
+}
+
+// offlineActivation 验证服务不可达时的判定：有效许可证（请求头 X-Kiro-License 或上次在线验证返回的）优先，否则按策略
+func (am *AuthMiddleware) offlineActivation(cacheKey, code, machineId, licenseHeader string) actCodeCacheEntry {
+	cfg := am.Config.Load()
+	if cfg.LicensePublicKey != "" {
+		for _, blob := range []string{licenseHeader, actCache.license(code)} {
+			if blob == "" {
+				continue
+			}
+			lic, err := VerifyLicense(blob, cfg.LicensePublicKey, code, machineId)
+			if err != nil {
+				logger.Debugf(logger.CatAuth, "许可证校验失败: %v", err)
+				continue
+			}
+			return actCodeCacheEntry{valid: true, message: "license valid until " + time.Unix(lic.ValidUntil, 0).Format(time.RFC3339)}
+		}
+	}
+
+	unavailable := actCodeCacheEntry{code: string(MsgActivationUnavailable), message: "activation server unavailable"}
+	switch cfg.ActivationPolicy {
+	case ActivationFailOpen:
+		return actCodeCacheEntry{valid: true, message: "fail-open"}
+	case "", ActivationGrace:
+		// 未配置时按 grace：屏蔽验证服务不能让从未验证通过的激活码直接放行
+		grace := defaultActivationGrace
+		if cfg.ActivationGraceSeconds > 0 {
+			grace = time.Duration(cfg.ActivationGraceSeconds) * time.Second
+		}
+		if t, ok := actCache.lastValid(cacheKey); ok && time.Since(t) <= grace {
+			return actCodeCacheEntry{valid: true, message: "grace since " + t.Format(time.RFC3339)}
+		}
+		return unavailable
+	default:
+		// fail-closed，未知取值按最严格处理
+		return unavailable
+	}
+}
+
+// activationPolicyName 返回生效的策略名，未配置时为 grace
+func activationPolicyName(policy string) string {
+	if policy == "" {
+		return ActivationGrace
+	}
+	return policy
+}
+
+// validateActivationCode 调用验证服务的 /api/code/validate
+// 返回 err 表示服务不可达或响应无效（5xx、非 JSON）
+func validateActivationCode(serverURL, code, machineId string) (ActivationResult, error) {
+	payload, _ := json.Marshal(map[string]string{
+		"code":      code,
+		"machineId": machineId,
+	})
+
+	// 使用 /api/code/validate 仅检查激活码有效性（不要求 machineId 和穿透权限）
+	// 旧版使用 /api/tunnel/check 会因为 machineId 不匹配或无穿透权限而拒绝合法用户
+	validateURL := serverURL + "/api/code/validate"
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(validateURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return ActivationResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return ActivationResult{}, fmt.Errorf("验证服务返回 %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success bool   `json:"success"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		License string `json:"license"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ActivationResult{}, fmt.Errorf("验证响应解析失败（HTTP %d）: %v", resp.StatusCode, err)
+	}
+
+	if !result.Success {
+		logger.WarnFields(logger.CatAuth, "激活码验证失败", logger.F{
+			"code":    logger.MaskKey(code),
+			"message": result.Message,
+			"url":     validateURL,
+		})
+	}
+
+	return ActivationResult{Valid: result.Success, Code: result.Code, Message: result.Message, License: result.License}, nil
+}
@@ -1,14 +1,12 @@
 package common
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"kiro-go/internal/logger"
@@ -20,42 +18,9 @@ type contextKey string
 const CredsContextKey contextKey = "kiro_credentials"
 const ActCodeContextKey contextKey = "activation_code"
 const RequestIDContextKey contextKey = "request_id"
-
-// 激活码验证缓存
-type actCodeCache struct {
-	mu    sync.RWMutex
-	cache map[string]actCodeCacheEntry
-}
-
-type actCodeCacheEntry struct {
-	valid    bool
-	message  string
-	expireAt time.Time
-}
-
-var actCache = &actCodeCache{
-	cache: make(map[string]actCodeCacheEntry),
-}
-
-func (c *actCodeCache) get(code string) (bool, string, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	entry, ok := c.cache[code]
-	if !ok || time.Now().After(entry.expireAt) {
-		return false, "", false
-	}
-	return entry.valid, entry.message, true
-}
-
-func (c *actCodeCache) set(code string, valid bool, message string, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cache[code] = actCodeCacheEntry{
-		valid:    valid,
-		message:  message,
-		expireAt: time.Now().Add(ttl),
-	}
-}
+const APIKeyNameContextKey contextKey = "api_key_name"
+const CredentialPinContextKey contextKey = "credential_pin"
+const ModelScopeContextKey contextKey = "model_scope"
 
 // ExtractAPIKey 从请求中提取 API Key
 func ExtractAPIKey(r *http.Request) string {
@@ -92,19 +57,75 @@ func GetRequestIDFromContext(r *http.Request) string {
 	return ""
 }
 
+// GetAPIKeyNameFromContext 从 context 中获取具名 API Key 的名称
+func GetAPIKeyNameFromContext(r *http.Request) string {
+	if name, ok := r.Context().Value(APIKeyNameContextKey).(string); ok {
+		return name
+	}
+	return ""
+}
+
+// GetPinFromContext 从 context 中获取 API Key 固定的凭据范围
+func GetPinFromContext(r *http.Request) *model.CredentialPin {
+	if pin, ok := r.Context().Value(CredentialPinContextKey).(*model.CredentialPin); ok {
+		return pin
+	}
+	return nil
+}
+
+// GetModelScopeFromContext 从 context 中获取具名 API Key / 客户端令牌的模型范围，不限制时为 nil
+func GetModelScopeFromContext(r *http.Request) model.ModelScope {
+	if scope, ok := r.Context().Value(ModelScopeContextKey).(model.ModelScope); ok {
+		return scope
+	}
+	return nil
+}
+
+// CheckModelScope 调用方是否允许使用 modelID（路由解析后实际请求的模型），不允许时返回 api_key_model_denied
+func CheckModelScope(scope model.ModelScope, modelID string) *Message {
+	if scope == nil || scope.AllowsModel(modelID) {
+		return nil
+	}
+	return NewMessage(MsgAPIKeyModelDenied, modelID)
+}
+
 // WriteError 写入错误响应
 func WriteError(w http.ResponseWriter, status int, errType, message string) {
+	writeErrorBody(w, status, errType, "", message)
+}
+
+// WriteErrorCode 写入本地化错误响应（语言取自 Accept-Language，error.code 为稳定错误码）
+func WriteErrorCode(w http.ResponseWriter, r *http.Request, status int, errType string, code MsgCode, args ...interface{}) {
+	writeErrorBody(w, status, errType, string(code), T(r, code, args...))
+}
+
+// WriteErrorFrom 写入 error 对应的错误响应，*Message 按请求语言渲染
+func WriteErrorFrom(w http.ResponseWriter, r *http.Request, status int, errType string, err error) {
+	code, message := LocalizeError(r, err)
+	writeErrorBody(w, status, errType, code, message)
+}
+
+func writeErrorBody(w http.ResponseWriter, status int, errType, code, message string) {
+	errObj := map[string]string{
+		"type":    errType,
+		"message": message,
+	}
+	if code != "" {
+		errObj["code"] = code
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"type": "error",
-		"error": map[string]string{
-			"type":    errType,
-			"message": message,
-		},
+		"type":  "error",
+		"error": errObj,
 	})
 }
 
+// IsOpenAIPath 是否为 OpenAI 兼容端点（/v1/chat/completions、/anthropic/v1/chat/completions），错误体需用 OpenAI 格式
+func IsOpenAIPath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), "/chat/completions")
+}
+
 // WriteJSON 写入 JSON 响应
 func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -132,79 +153,222 @@ func DecodeCredsKey(key string) (*model.KiroCredentials, error) {
 	return &creds, nil
 }
 
-func validateActivationCode(serverURL, code, machineId string) (bool, string) {
-	if serverURL == "" {
-		return true, ""
+// writeActivationRejected 写入激活码验证失败响应
+// 验证服务返回了已登记的错误码时按请求语言渲染，否则透传服务端文案
+func writeActivationRejected(w http.ResponseWriter, r *http.Request, entry actCodeCacheEntry) {
+	if entry.code == string(MsgActivationUnavailable) {
+		WriteErrorCode(w, r, http.StatusServiceUnavailable, "api_error", MsgActivationUnavailable)
+		return
+	}
+	if IsMsgCode(entry.code) {
+		WriteErrorCode(w, r, http.StatusForbidden, "authentication_error", MsgCode(entry.code))
+		return
+	}
+	if entry.message == "" {
+		WriteErrorCode(w, r, http.StatusForbidden, "authentication_error", MsgCodeInvalid)
+		return
 	}
+	WriteError(w, http.StatusForbidden, "authentication_error", entry.message)
+}
 
-	payload, _ := json.Marshal(map[string]string{
-		"code":      code,
-		"machineId": machineId,
-	})
+// AuthMiddleware 认证中间件
+type AuthMiddleware struct {
+	Config                  *model.ConfigRef
+	GetUserCreds            func(code string) *model.KiroCredentials
+	GetUserCredsWithExpiry  func(code string) (*model.KiroCredentials, bool, string)
+	GetUserCredsAutoRefresh func(code string) (*model.KiroCredentials, error)
+	GetCodeExpiresDate      func(code string) (expiresDate string, expired bool) // 从 codes.json 获取过期日期
+	GetCodeRateLimit        func(code string) *model.RateLimit                   // 激活码自身的限流配置（覆盖默认值）
+	Limiter                 *RateLimiter                                         // nil 表示不限流
+	CheckQuota              func(code string) *RateLimitError                    // 激活码用量配额检查，超额返回原因
+	RecordUsage             func(entry UsageEntry)                               // 请求结束后写入用量账本
+	LookupAPIKey            func(key string) *model.APIKeyEntry                  // 具名 API Key（api_keys.json），未找到返回 nil
+	ClientTokens            *ClientTokenSigner                                   // kt- 客户端令牌，nil 表示未启用
+	IsTokenRevoked          func(claims *ClientTokenClaims) bool                 // 令牌吊销列表
+	Validator               ActivationValidator                                  // 激活码验证方式，nil 时按 activationServerUrl 走 HTTP
+	CheckDevice             func(code, machineId string) *Message                // 设备绑定策略检查，通过返回 nil
+}
 
-	// 使用 /api/code/validate 仅检查激活码有效性（不要求 machineId 和穿透权限）
-	// 旧版使用 /api/tunnel/check 会因为 machineId 不匹配或无穿透权限而拒绝合法用户
-	validateURL := serverURL + "/api/code/validate"
+// accessScope 具名 API Key / 客户端令牌的端点与模型限制
+type accessScope interface {
+	model.ModelScope
+	AllowsEndpoint(endpoint string) bool
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Post(validateURL, "application/json", bytes.NewReader(payload))
-	if err != nil {
-		// 验证服务不可用时，尝试回退到本地验证（如果 ActivationServerURL 指向自身）
-		logger.WarnFields(logger.CatAuth, "激活码验证服务不可用，放行请求", logger.F{
-			"error": err.Error(),
-			"url":   validateURL,
-		})
-		return true, ""
+// allowScope 检查请求的端点类别是否在范围内，不允许时写入 403 并返回 false；
+// 模型在路由规则、别名解析之后由 handler 按最终模型 ID 检查（CheckModelScope），此处只放入 context
+func (am *AuthMiddleware) allowScope(w http.ResponseWriter, r *http.Request, log *logger.ContextLogger, scope accessScope) bool {
+	endpoint := "kiro"
+	if strings.HasPrefix(r.URL.Path, "/anthropic/") {
+		endpoint = "anthropic"
+	}
+	if !scope.AllowsEndpoint(endpoint) {
+		log.Warn("不允许访问该端点", logger.F{"path": r.URL.Path})
+		WriteErrorCode(w, r, http.StatusForbidden, "permission_error", MsgAPIKeyEndpointDenied)
+		return false
 	}
-	defer resp.Body.Close()
+	return true
+}
 
-	var result struct {
-		Success bool   `json:"success"`
-		Message string `json:"message"`
+// verifyCode 激活码与其签发的 kt- 令牌共用的校验：激活验证（带缓存）、过期日期、设备绑定策略；拒绝时已写响应
+func (am *AuthMiddleware) verifyCode(w http.ResponseWriter, r *http.Request, log *logger.ContextLogger, upperCode string) bool {
+	rid := GetRequestIDFromContext(r)
+	machineId := r.Header.Get("X-Machine-Id")
+
+	// 4a. 验证激活码（本进程 CodesManager 或远程 app.js）
+	if validator := am.activationValidator(); validator != nil {
+		cacheKey := upperCode + ":" + machineId
+		if entry, cached := actCache.get(cacheKey); cached {
+			if !entry.valid {
+				log.Warn("激活码验证失败(缓存)", logger.F{"reason": entry.message})
+				writeActivationRejected(w, r, entry)
+				return false
+			}
+			log.Debug("激活码验证通过(缓存)")
+		} else {
+			log.Debug("验证激活码", logger.F{
+				"server":     am.Config.Load().ActivationServerURL,
+				"machine_id": logger.MaskKey(machineId),
+			})
+			entry := am.checkActivation(validator, upperCode, machineId, r.Header.Get("X-Kiro-License"))
+			if !entry.valid {
+				log.Warn("激活码验证失败", logger.F{"reason": entry.message})
+				writeActivationRejected(w, r, entry)
+				return false
+			}
+			log.Info("激活码验证通过")
+		}
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		logger.WarnFields(logger.CatAuth, "激活码验证响应解析失败，放行请求", logger.F{
-			"error":  err.Error(),
-			"url":    validateURL,
-			"status": resp.StatusCode,
-		})
-		return true, ""
+
+	// 4b. 先检查激活码本身的过期日期（从 codes.json）
+	if am.GetCodeExpiresDate != nil {
+		codeExpiresDate, codeExpired := am.GetCodeExpiresDate(upperCode)
+		if codeExpired {
+			logger.LogAuthResult(rid, logger.MaskKey(upperCode), "code_expired", logger.F{
+				"code_expires_date": codeExpiresDate,
+			})
+			WriteErrorCode(w, r, http.StatusForbidden, "authentication_error", MsgCodeExpired, codeExpiresDate)
+			return false
+		}
 	}
 
-	if !result.Success {
-		logger.WarnFields(logger.CatAuth, "激活码验证失败", logger.F{
-			"code":    logger.MaskKey(code),
-			"message": result.Message,
-			"url":     validateURL,
-		})
+	// 4c. 设备绑定策略（最多设备数 / 白名单 / 换绑冷却）
+	if am.CheckDevice != nil {
+		if msg := am.CheckDevice(upperCode, machineId); msg != nil {
+			logger.LogAuthResult(rid, logger.MaskKey(upperCode), "device_rejected", logger.F{
+				"machine_id": logger.MaskKey(machineId),
+				"reason":     string(msg.Code),
+			})
+			WriteErrorCode(w, r, http.StatusForbidden, "permission_error", msg.Code, msg.Args...)
+			return false
+		}
 	}
 
-	return result.Success, result.Message
+	return true
 }
 
-// AuthMiddleware 认证中间件
-type AuthMiddleware struct {
-	Config                  *model.Config
-	GetUserCreds            func(code string) *model.KiroCredentials
-	GetUserCredsWithExpiry  func(code string) (*model.KiroCredentials, bool, string)
-	GetUserCredsAutoRefresh func(code string) (*model.KiroCredentials, error)
-	GetCodeExpiresDate      func(code string) (expiresDate string, expired bool) // 从 codes.json 获取过期日期
+// serveCode 激活码请求：先检查用量配额，再按激活码限流
+func (am *AuthMiddleware) serveCode(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc, code string) {
+	if am.CheckQuota != nil {
+		if exceeded := am.CheckQuota(code); exceeded != nil {
+			logger.WarnFields(logger.CatAuth, "激活码用量超出配额", logger.F{
+				"request_id": GetRequestIDFromContext(r),
+				"code":       logger.MaskKey(code),
+				"reason":     string(exceeded.Code),
+				"limit":      exceeded.Limit,
+			})
+			writeRateLimited(w, r, exceeded)
+			return
+		}
+	}
+	limit := am.codeRateLimit(code)
+	if claims := GetClientTokenFromContext(r); claims != nil && claims.Scope.RateClass != "" {
+		if class, ok := am.Config.Load().RateClasses[claims.Scope.RateClass]; ok {
+			limit = limit.Narrow(&class) // 令牌的档位只能收紧激活码自身的限流
+		}
+	}
+	am.serveLimited(w, r, handler, "act-"+code, limit)
+}
+
+// serveAPIKey 具名 API Key：检查吊销 / 过期 / 端点 / 模型后，按 Key 限流并固定凭据范围
+func (am *AuthMiddleware) serveAPIKey(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc, entry *model.APIKeyEntry) {
+	log := logger.NewContext(logger.CatAuth, GetRequestIDFromContext(r), entry.Name)
+	if entry.Disabled {
+		log.Warn("API Key 已吊销")
+		WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgAPIKeyRevoked)
+		return
+	}
+	if entry.Expired(time.Now()) {
+		log.Warn("API Key 已过期", logger.F{"expires_at": entry.ExpiresAt})
+		WriteErrorCode(w, r, http.StatusForbidden, "permission_error", MsgAPIKeyExpired, entry.ExpiresAt)
+		return
+	}
+	if !am.allowScope(w, r, log, entry) {
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), APIKeyNameContextKey, entry.Name)
+	if len(entry.AllowedModels) > 0 {
+		ctx = context.WithValue(ctx, ModelScopeContextKey, model.ModelScope(entry))
+	}
+	if entry.Credential != nil {
+		ctx = context.WithValue(ctx, CredentialPinContextKey, entry.Credential)
+	}
+	am.serveLimited(w, r.WithContext(ctx), handler, "key-"+entry.Name, am.Config.Load().APIKeyRateLimit.Merge(entry.RateLimit))
+}
+
+// serveLimited 按调用方限流后调用 handler；超限返回 429 + Retry-After
+func (am *AuthMiddleware) serveLimited(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc, key string, limit model.RateLimit) {
+	if am.Limiter == nil || !limited(limit) {
+		handler(w, r)
+		return
+	}
+	release, rejected := am.Limiter.Acquire(key, limit, estimateRequestTokens(r))
+	if rejected != nil {
+		logger.WarnFields(logger.CatAuth, "请求被限流", logger.F{
+			"request_id":  GetRequestIDFromContext(r),
+			"caller":      logger.MaskKey(key),
+			"reason":      string(rejected.Code),
+			"limit":       rejected.Limit,
+			"retry_after": rejected.RetryAfter.String(),
+		})
+		writeRateLimited(w, r, rejected)
+		return
+	}
+	defer release()
+	handler(w, r)
+}
+
+// codeRateLimit 激活码的生效限流配置：codes.json 中的字段覆盖 config 默认值
+func (am *AuthMiddleware) codeRateLimit(code string) model.RateLimit {
+	var override *model.RateLimit
+	if am.GetCodeRateLimit != nil {
+		override = am.GetCodeRateLimit(code)
+	}
+	return am.Config.Load().RateLimit.Merge(override)
 }
 
 // Wrap 包装 handler
 func (am *AuthMiddleware) Wrap(handler http.HandlerFunc) http.HandlerFunc {
+	handler = am.withUsage(handler)
 	return func(w http.ResponseWriter, r *http.Request) {
 		rid := GenerateRequestID()
 		ctx := context.WithValue(r.Context(), RequestIDContextKey, rid)
 		r = r.WithContext(ctx)
 		log := logger.NewContext(logger.CatAuth, rid, "")
+		cfg := am.Config.Load()
 
 		// 1. X-Kiro-Credentials header
 		if h := r.Header.Get("x-kiro-credentials"); h != "" {
+			if cfg.DisablePlaintextCreds {
+				log.Warn("明文凭证已禁用，拒绝 X-Kiro-Credentials")
+				WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgPlaintextCredsDisabled)
+				return
+			}
 			var creds model.KiroCredentials
 			if err := json.Unmarshal([]byte(h), &creds); err != nil {
 				log.Warn("X-Kiro-Credentials 解析失败", logger.F{"error": err.Error()})
-				WriteError(w, http.StatusUnauthorized, "authentication_error", "Invalid X-Kiro-Credentials")
+				WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgInvalidCredentialsHeader)
 				return
 			}
 			ctx := context.WithValue(r.Context(), CredsContextKey, &creds)
@@ -222,62 +386,29 @@ func (am *AuthMiddleware) Wrap(handler http.HandlerFunc) http.HandlerFunc {
 		})
 		if key == "" {
 			log.Warn("缺少 API Key")
-			WriteError(w, http.StatusUnauthorized, "authentication_error", "Missing API key")
+			WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgMissingAPIKey)
+			return
+		}
+
+		// 3. kt- 短期签名令牌
+		if strings.HasPrefix(key, ClientTokenPrefix) {
+			am.serveClientToken(w, r, handler, key)
 			return
 		}
 
-		// 3. act- 激活码
+		// 4. act- 激活码
 		if strings.HasPrefix(key, "act-") {
 			rawCode := strings.TrimPrefix(key, "act-")
 			upperCode := strings.ToUpper(rawCode)
 			log = logger.NewContext(logger.CatAuth, rid, logger.MaskKey(upperCode))
 			log.Info("激活码认证", logger.F{"code": logger.MaskKey(upperCode)})
 
-			// 3a. 调 app.js 验证激活码
-			machineId := r.Header.Get("X-Machine-Id")
-			if am.Config.ActivationServerURL != "" {
-				cacheKey := upperCode + ":" + machineId
-				if valid, msg, cached := actCache.get(cacheKey); cached {
-					if !valid {
-						log.Warn("激活码验证失败(缓存)", logger.F{"reason": msg})
-						WriteError(w, http.StatusForbidden, "authentication_error", msg)
-						return
-					}
-					log.Debug("激活码验证通过(缓存)")
-				} else {
-					log.Debug("调用 app.js 验证", logger.F{
-						"server":     am.Config.ActivationServerURL,
-						"machine_id": logger.MaskKey(machineId),
-					})
-					ok, msg := validateActivationCode(am.Config.ActivationServerURL, upperCode, machineId)
-					ttl := 1 * time.Minute
-					if ok {
-						ttl = 5 * time.Minute
-					}
-					actCache.set(cacheKey, ok, msg, ttl)
-					if !ok {
-						log.Warn("激活码验证失败", logger.F{"reason": msg})
-						WriteError(w, http.StatusForbidden, "authentication_error", msg)
-						return
-					}
-					log.Info("激活码验证通过")
-				}
-			}
-
-			// 3b. 先检查激活码本身的过期日期（从 codes.json）
-			if am.GetCodeExpiresDate != nil {
-				codeExpiresDate, codeExpired := am.GetCodeExpiresDate(upperCode)
-				if codeExpired {
-					logger.LogAuthResult(rid, logger.MaskKey(upperCode), "code_expired", logger.F{
-						"code_expires_date": codeExpiresDate,
-					})
-					WriteError(w, http.StatusForbidden, "authentication_error",
-						fmt.Sprintf("您的激活码已过期（过期日期：%s），请联系管理员续期", codeExpiresDate))
-					return
-				}
+			// 4a-4c. 验证激活码、过期日期与设备绑定策略
+			if !am.verifyCode(w, r, log, upperCode) {
+				return
 			}
 
-			// 3c. 获取用户凭证（优先使用 auto-refresh）
+			// 4d. 获取用户凭证（优先使用 auto-refresh）
 			var creds *model.KiroCredentials
 			var matchedKey string // 记录匹配到的 key 格式
 
@@ -322,7 +453,7 @@ func (am *AuthMiddleware) Wrap(handler http.HandlerFunc) http.HandlerFunc {
 						logger.MaskKey(upperCode), logger.MaskKey(key), logger.MaskKey(rawCode)),
 				})
 				ctx := context.WithValue(r.Context(), ActCodeContextKey, upperCode)
-				handler(w, r.WithContext(ctx))
+				am.serveCode(w, r.WithContext(ctx), handler, upperCode)
 				return
 			}
 
@@ -334,16 +465,39 @@ func (am *AuthMiddleware) Wrap(handler http.HandlerFunc) http.HandlerFunc {
 			})
 			ctx := context.WithValue(r.Context(), CredsContextKey, creds)
 			ctx = context.WithValue(ctx, ActCodeContextKey, upperCode)
+			am.serveCode(w, r.WithContext(ctx), handler, upperCode)
+			return
+		}
+
+		// 5. relay- 加密凭证（AES-256-GCM，kiro-launcher GenerateRelayKey 生成）
+		if strings.HasPrefix(key, "relay-") {
+			if cfg.RelaySecret == "" {
+				log.Warn("未配置 relaySecret，拒绝 relay key")
+				WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgRelayNotConfigured)
+				return
+			}
+			creds, err := DecodeRelayKey(key, cfg.RelaySecret)
+			if err != nil {
+				log.Warn("relay key 解密失败", logger.F{"error": err.Error()})
+				WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgInvalidRelayKey, err.Error())
+				return
+			}
+			ctx := context.WithValue(r.Context(), CredsContextKey, creds)
 			handler(w, r.WithContext(ctx))
 			return
 		}
 
-		// 4. creds- base64 凭证
+		// 6. creds- base64 凭证
 		if strings.HasPrefix(key, "creds-") {
+			if cfg.DisablePlaintextCreds {
+				log.Warn("明文凭证已禁用，拒绝 creds key")
+				WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgPlaintextCredsDisabled)
+				return
+			}
 			creds, err := DecodeCredsKey(key)
 			if err != nil {
 				log.Warn("creds key 解码失败", logger.F{"error": err.Error()})
-				WriteError(w, http.StatusUnauthorized, "authentication_error", "Invalid creds key: "+err.Error())
+				WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgInvalidCredsKey, err.Error())
 				return
 			}
 			ctx := context.WithValue(r.Context(), CredsContextKey, creds)
@@ -351,12 +505,20 @@ func (am *AuthMiddleware) Wrap(handler http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		// 5. 普通 API Key
-		if key != am.Config.APIKey {
+		// 7. 具名 API Key
+		if am.LookupAPIKey != nil {
+			if entry := am.LookupAPIKey(key); entry != nil {
+				am.serveAPIKey(w, r, handler, entry)
+				return
+			}
+		}
+
+		// 8. 普通 API Key
+		if key != cfg.APIKey {
 			log.Warn("API Key 无效")
-			WriteError(w, http.StatusUnauthorized, "authentication_error", "Invalid API key")
+			WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgInvalidAPIKey)
 			return
 		}
-		handler(w, r)
+		am.serveLimited(w, r, handler, "apikey", cfg.APIKeyRateLimit.Merge(nil))
 	}
 }
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+const (
+	ClientTokenPrefix                   = "kt-"
+	ClientTokenContextKey    contextKey = "client_token"
+	defaultClientTokenTTL               = time.Hour
+	defaultClientTokenMaxTTL            = 7 * 24 * time.Hour
+	clientTokenAlgHS256                 = "HS256"
+	clientTokenAlgEdDSA                 = "EdDSA"
+)
+
+// ClientTokenClaims 令牌内容（JWT claims）
+type ClientTokenClaims struct {
+	ID        string           `json:"jti"`
+	Subject   string           `json:"sub"` // 激活码
+	IssuedAt  int64            `json:"iat"`
+	ExpiresAt int64            `json:"exp"`
+	Scope     model.TokenScope `json:"scope"`
+}
+
+// ClientTokenSigner 签发与无状态校验 kt- 令牌（kt- + JWT，HS256 或 EdDSA）
+type ClientTokenSigner struct {
+	alg    string
+	secret []byte
+	priv   ed25519.PrivateKey
+	pub    ed25519.PublicKey
+	ttl    time.Duration
+	maxTTL time.Duration
+}
+
+// NewClientTokenSigner cfg 为 nil 时返回 nil（未启用）
+func NewClientTokenSigner(cfg *model.ClientTokenConfig) (*ClientTokenSigner, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("clientTokens.secret 不能为空")
+	}
+	s := &ClientTokenSigner{ttl: defaultClientTokenTTL, maxTTL: defaultClientTokenMaxTTL}
+	if cfg.TTL > 0 {
+		s.ttl = time.Duration(cfg.TTL) * time.Second
+	}
+	if cfg.MaxTTL > 0 {
+		s.maxTTL = time.Duration(cfg.MaxTTL) * time.Second
+	}
+	switch strings.ToLower(cfg.Algorithm) {
+	case "", "hs256":
+		s.alg = clientTokenAlgHS256
+		s.secret = []byte(cfg.Secret)
+	case "ed25519", "eddsa":
+		s.alg = clientTokenAlgEdDSA
+		seed := sha256.Sum256([]byte("kiro-token-v1:" + cfg.Secret))
+		s.priv = ed25519.NewKeyFromSeed(seed[:])
+		s.pub = s.priv.Public().(ed25519.PublicKey)
+	default:
+		return nil, fmt.Errorf("不支持的 clientTokens.algorithm: %s", cfg.Algorithm)
+	}
+	return s, nil
+}
+
+// Algorithm JWT alg
+func (s *ClientTokenSigner) Algorithm() string {
+	return s.alg
+}
+
+// PublicKey EdDSA 公钥（base64），HS256 返回空
+func (s *ClientTokenSigner) PublicKey() string {
+	if s.pub == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(s.pub)
+}
+
+// MaxTTL 允许的最长有效期
+func (s *ClientTokenSigner) MaxTTL() time.Duration {
+	return s.maxTTL
+}
+
+// Issue 为激活码签发令牌；ttl <= 0 使用默认有效期，超过上限时截断
+func (s *ClientTokenSigner) Issue(code string, scope model.TokenScope, ttl time.Duration) (string, *ClientTokenClaims, error) {
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	if ttl > s.maxTTL {
+		ttl = s.maxTTL
+	}
+	now := time.Now()
+	claims := &ClientTokenClaims{
+		ID:        GenerateRequestID(),
+		Subject:   code,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Scope:     scope,
+	}
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := base64.RawURLEncoding.EncodeToString(s.sign([]byte(signingInput)))
+	return ClientTokenPrefix + signingInput + "." + sig, claims, nil
+}
+
+func (s *ClientTokenSigner) sign(input []byte) []byte {
+	if s.alg == clientTokenAlgEdDSA {
+		return ed25519.Sign(s.priv, input)
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+// Verify 校验签名与有效期（不查吊销列表）
+func (s *ClientTokenSigner) Verify(token string) (*ClientTokenClaims, error) {
+	parts := strings.Split(strings.TrimPrefix(token, ClientTokenPrefix), ".")
+	if len(parts) != 3 {
+		return nil, NewMessage(MsgClientTokenInvalid, "malformed")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, NewMessage(MsgClientTokenInvalid, "malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	// 只接受本服务器配置的算法，避免算法混淆
+	if json.Unmarshal(headerJSON, &header) != nil || header.Alg != s.alg {
+		return nil, NewMessage(MsgClientTokenInvalid, "unexpected alg")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, NewMessage(MsgClientTokenInvalid, "malformed signature")
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	var valid bool
+	if s.alg == clientTokenAlgEdDSA {
+		valid = ed25519.Verify(s.pub, signingInput, sig)
+	} else {
+		valid = hmac.Equal(sig, s.sign(signingInput))
+	}
+	if !valid {
+		return nil, NewMessage(MsgClientTokenInvalid, "bad signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, NewMessage(MsgClientTokenInvalid, "malformed payload")
+	}
+	var claims ClientTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" || claims.ID == "" {
+		return nil, NewMessage(MsgClientTokenInvalid, "malformed claims")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, NewMessage(MsgClientTokenExpired, time.Unix(claims.ExpiresAt, 0).Format(time.RFC3339))
+	}
+	return &claims, nil
+}
+
+// GetClientTokenFromContext 从 context 中获取客户端令牌 claims（非令牌认证时为 nil）
+func GetClientTokenFromContext(r *http.Request) *ClientTokenClaims {
+	if claims, ok := r.Context().Value(ClientTokenContextKey).(*ClientTokenClaims); ok {
+		return claims
+	}
+	return nil
+}
+
+// serveClientToken kt- 令牌：校验签名与有效期，查吊销列表，对令牌所属激活码做与 act- 相同的验证与设备检查，
+// 按 scope 检查后以该激活码的身份处理请求
+func (am *AuthMiddleware) serveClientToken(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc, token string) {
+	log := logger.NewContext(logger.CatAuth, GetRequestIDFromContext(r), "")
+	if am.ClientTokens == nil {
+		log.Warn("未启用客户端令牌")
+		WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgClientTokenDisabled)
+		return
+	}
+	claims, err := am.ClientTokens.Verify(token)
+	if err != nil {
+		log.Warn("令牌校验失败", logger.F{"error": err.Error()})
+		WriteErrorFrom(w, r, http.StatusUnauthorized, "authentication_error", err)
+		return
+	}
+	code := claims.Subject
+	log = logger.NewContext(logger.CatAuth, GetRequestIDFromContext(r), logger.MaskKey(code))
+	if am.IsTokenRevoked != nil && am.IsTokenRevoked(claims) {
+		log.Warn("令牌已吊销", logger.F{"jti": claims.ID})
+		WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", MsgClientTokenRevoked)
+		return
+	}
+	// 激活码被删除 / 重置 / 停用或换了设备后，已签发的令牌随之失效（验证结果有缓存，不会每个请求都调验证服务）
+	if !am.verifyCode(w, r, log, strings.ToUpper(code)) {
+		return
+	}
+	if !am.allowScope(w, r, log, &claims.Scope) {
+		return
+	}
+
+	var creds *model.KiroCredentials
+	if am.GetUserCredsAutoRefresh != nil {
+		creds, _ = am.GetUserCredsAutoRefresh(code)
+	} else if am.GetUserCreds != nil {
+		creds = am.GetUserCreds(code)
+	}
+	log.Info("令牌认证通过", logger.F{"jti": claims.ID, "has_creds": creds != nil})
+
+	ctx := context.WithValue(r.Context(), ActCodeContextKey, code)
+	ctx = context.WithValue(ctx, ClientTokenContextKey, claims)
+	if len(claims.Scope.Models) > 0 {
+		ctx = context.WithValue(ctx, ModelScopeContextKey, model.ModelScope(&claims.Scope))
+	}
+	if creds != nil {
+		ctx = context.WithValue(ctx, CredsContextKey, creds)
+	}
+	am.serveCode(w, r.WithContext(ctx), handler, code)
+}
@@ -0,0 +1,392 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 支持的语言
+const (
+	LocaleZH = "zh"
+	LocaleEN = "en"
+)
+
+// MsgCode 面向客户端的稳定错误码（随响应一起返回，文案可变，code 不变）
+type MsgCode string
+
+const (
+	// 通用请求错误
+	MsgMethodNotAllowed      MsgCode = "method_not_allowed"
+	MsgReadBodyFailed        MsgCode = "read_body_failed"
+	MsgInvalidJSON           MsgCode = "invalid_json"
+	MsgInvalidRequest        MsgCode = "invalid_request"
+	MsgMissingParameters     MsgCode = "missing_parameters"
+	MsgStreamingUnsupported  MsgCode = "streaming_unsupported"
+	MsgCreateRequestFailed   MsgCode = "create_request_failed"
+	MsgReadResponseFailed    MsgCode = "read_response_failed"
+	MsgInvalidResponseJSON   MsgCode = "invalid_response_json"
+	MsgDirectNotConfigured   MsgCode = "direct_backend_not_configured"
+	MsgModelNotSupported     MsgCode = "model_not_supported"
+	MsgModelRejected         MsgCode = "model_rejected"
+	MsgModelNoImages         MsgCode = "model_image_unsupported"
+	MsgContextTooLong        MsgCode = "context_length_exceeded"
+	MsgMessagesEmpty         MsgCode = "messages_empty"
+	MsgWebSearchQueryMissing MsgCode = "websearch_query_missing"
+
+	// 认证
+	MsgInvalidCredentialsHeader MsgCode = "invalid_credentials_header"
+	MsgMissingAPIKey            MsgCode = "missing_api_key"
+	MsgInvalidAPIKey            MsgCode = "invalid_api_key"
+	MsgInvalidCredsKey          MsgCode = "invalid_creds_key"
+	MsgInvalidRelayKey          MsgCode = "invalid_relay_key"
+	MsgRelayNotConfigured       MsgCode = "relay_not_configured"
+	MsgPlaintextCredsDisabled   MsgCode = "plaintext_creds_disabled"
+	MsgAPIKeyRevoked            MsgCode = "api_key_revoked"
+	MsgClientTokenDisabled      MsgCode = "client_token_disabled"
+	MsgClientTokenInvalid       MsgCode = "client_token_invalid"
+	MsgClientTokenExpired       MsgCode = "client_token_expired"
+	MsgClientTokenRevoked       MsgCode = "client_token_revoked"
+	MsgAPIKeyExpired            MsgCode = "api_key_expired"
+	MsgAPIKeyEndpointDenied     MsgCode = "api_key_endpoint_denied"
+	MsgAPIKeyModelDenied        MsgCode = "api_key_model_denied"
+
+	// 限流
+	MsgRateLimitRPM         MsgCode = "rate_limit_requests"
+	MsgRateLimitConcurrency MsgCode = "rate_limit_concurrency"
+	MsgRateLimitTPM         MsgCode = "rate_limit_tokens"
+
+	// 用量配额
+	MsgQuotaDailyRequests   MsgCode = "quota_daily_requests"
+	MsgQuotaMonthlyRequests MsgCode = "quota_monthly_requests"
+	MsgQuotaDailyTokens     MsgCode = "quota_daily_tokens"
+	MsgQuotaMonthlyTokens   MsgCode = "quota_monthly_tokens"
+	MsgQuotaMonthlyCredits  MsgCode = "quota_monthly_credits"
+
+	// 激活码
+	MsgMissingActivationCode MsgCode = "missing_activation_code"
+	MsgMissingMachineID      MsgCode = "missing_machine_id"
+	MsgCodeInvalid           MsgCode = "activation_code_invalid"
+	MsgCodeInactive          MsgCode = "activation_code_inactive"
+	MsgCodeInUse             MsgCode = "activation_code_in_use"
+	MsgCodeExpired           MsgCode = "activation_code_expired"
+	MsgCodeAlreadyActive     MsgCode = "activation_code_already_active"
+	MsgCodeActivated         MsgCode = "activation_code_activated"
+	MsgActivationUnavailable MsgCode = "activation_server_unavailable"
+	MsgDeviceIDRequired      MsgCode = "device_machine_id_required"
+	MsgDeviceNotAllowed      MsgCode = "device_not_allowed"
+	MsgDeviceLimit           MsgCode = "device_limit_reached"
+	MsgDeviceCooldown        MsgCode = "device_rebind_cooldown"
+
+	// 内网穿透
+	MsgTunnelDeviceMismatch MsgCode = "tunnel_device_mismatch"
+	MsgTunnelNotPermitted   MsgCode = "tunnel_not_permitted"
+	MsgTunnelActivatedAtBad MsgCode = "tunnel_activation_time_invalid"
+	MsgTunnelExpired        MsgCode = "tunnel_expired"
+	MsgTunnelOK             MsgCode = "tunnel_ok"
+
+	// 卡密管理
+	MsgCodesCountRequired  MsgCode = "codes_count_required"
+	MsgCodesDeleteRequired MsgCode = "codes_delete_list_required"
+	MsgCodesTunnelDaysReq  MsgCode = "codes_tunnel_days_required"
+	MsgCodesResetRequired  MsgCode = "codes_reset_list_required"
+	MsgCodesListRequired   MsgCode = "codes_list_required"
+	MsgCodesAdded          MsgCode = "codes_added"
+	MsgCodesDeleted        MsgCode = "codes_deleted"
+	MsgCodesUpdated        MsgCode = "codes_updated"
+	MsgCodesReset          MsgCode = "codes_reset"
+	MsgCodeNotFound        MsgCode = "activation_code_not_found"
+	MsgDevicesUnbound      MsgCode = "devices_unbound"
+	MsgStoreDisabled       MsgCode = "store_disabled"
+	MsgAdminKeyRequired    MsgCode = "admin_key_required"
+
+	// 用户凭证共享组
+	MsgSharingGroupReserved MsgCode = "sharing_group_reserved"
+
+	// 指标
+	MsgMetricsDisabled MsgCode = "metrics_disabled"
+
+	// API Key 管理
+	MsgAPIKeyNameRequired MsgCode = "api_key_name_required"
+	MsgAPIKeyNotFound     MsgCode = "api_key_not_found"
+	MsgAPIKeyDuplicate    MsgCode = "api_key_duplicate"
+	MsgAPIKeySaved        MsgCode = "api_key_saved"
+	MsgAPIKeyRevokedOK    MsgCode = "api_key_revoked_ok"
+	MsgAPIKeyDeleted      MsgCode = "api_key_deleted"
+	MsgAPIKeyInvalid      MsgCode = "api_key_invalid"
+
+	// 主凭证池管理
+	MsgPoolIDRequired    MsgCode = "pool_id_required"
+	MsgPoolNotFound      MsgCode = "pool_credential_not_found"
+	MsgPoolDuplicateID   MsgCode = "pool_credential_duplicate_id"
+	MsgPoolTokenRequired MsgCode = "pool_credential_token_required"
+	MsgPoolAdded         MsgCode = "pool_credential_added"
+	MsgPoolRemoved       MsgCode = "pool_credential_removed"
+	MsgPoolEnabled       MsgCode = "pool_credential_enabled"
+	MsgPoolDisabled      MsgCode = "pool_credential_disabled"
+	MsgPoolPrioritySet   MsgCode = "pool_credential_priority_set"
+	MsgPoolRefreshed     MsgCode = "pool_credential_refreshed"
+
+	// 客户端令牌签发 / 吊销
+	MsgTokenNeedsCode    MsgCode = "client_token_needs_activation_code"
+	MsgTokenNoReissue    MsgCode = "client_token_no_reissue"
+	MsgRateClassUnknown  MsgCode = "rate_class_unknown"
+	MsgTokenRevokeTarget MsgCode = "client_token_revoke_target_required"
+	MsgTokenRevokedOK    MsgCode = "client_token_revoked_ok"
+	MsgCodeTokensRevoked MsgCode = "client_tokens_revoked_for_code"
+)
+
+// catalog 文案表：code → locale → 格式串（fmt 风格）
+var catalog = map[MsgCode]map[string]string{
+	MsgMethodNotAllowed:      {LocaleZH: "不支持的请求方法", LocaleEN: "Method not allowed"},
+	MsgReadBodyFailed:        {LocaleZH: "读取请求体失败", LocaleEN: "Failed to read request body"},
+	MsgInvalidJSON:           {LocaleZH: "JSON 格式无效: %s", LocaleEN: "Invalid JSON: %s"},
+	MsgInvalidRequest:        {LocaleZH: "无效请求", LocaleEN: "Invalid request"},
+	MsgMissingParameters:     {LocaleZH: "缺少参数", LocaleEN: "Missing parameters"},
+	MsgStreamingUnsupported:  {LocaleZH: "当前连接不支持流式输出", LocaleEN: "Streaming not supported"},
+	MsgCreateRequestFailed:   {LocaleZH: "构建上游请求失败: %s", LocaleEN: "Failed to create request: %s"},
+	MsgReadResponseFailed:    {LocaleZH: "读取上游响应失败: %s", LocaleEN: "Failed to read response: %s"},
+	MsgInvalidResponseJSON:   {LocaleZH: "上游响应 JSON 无效", LocaleEN: "Invalid response JSON"},
+	MsgDirectNotConfigured:   {LocaleZH: "Anthropic 直连未配置 (需要 anthropicApiKey)", LocaleEN: "Anthropic direct backend is not configured (anthropicApiKey required)"},
+	MsgModelNotSupported:     {LocaleZH: "模型不支持: %s", LocaleEN: "Model not supported: %s"},
+	MsgModelRejected:         {LocaleZH: "模型已被禁用: %s", LocaleEN: "Model is not allowed: %s"},
+	MsgModelNoImages:         {LocaleZH: "模型 %s 不支持图片输入", LocaleEN: "Model %s does not accept image input"},
+	MsgContextTooLong:        {LocaleZH: "输入约 %d tokens，超过模型 %s 的上限 %d", LocaleEN: "Input is about %d tokens, exceeding the %s limit of %d"},
+	MsgMessagesEmpty:         {LocaleZH: "消息列表为空", LocaleEN: "messages must not be empty"},
+	MsgWebSearchQueryMissing: {LocaleZH: "无法从消息中提取搜索查询", LocaleEN: "Could not extract a search query from the messages"},
+
+	MsgInvalidCredentialsHeader: {LocaleZH: "X-Kiro-Credentials 无效", LocaleEN: "Invalid X-Kiro-Credentials"},
+	MsgMissingAPIKey:            {LocaleZH: "缺少 API Key", LocaleEN: "Missing API key"},
+	MsgInvalidAPIKey:            {LocaleZH: "API Key 无效", LocaleEN: "Invalid API key"},
+	MsgInvalidCredsKey:          {LocaleZH: "creds key 无效: %s", LocaleEN: "Invalid creds key: %s"},
+	MsgInvalidRelayKey:          {LocaleZH: "relay key 无效: %s", LocaleEN: "Invalid relay key: %s"},
+	MsgRelayNotConfigured:       {LocaleZH: "服务器未配置 relaySecret，不接受 relay key", LocaleEN: "relaySecret is not configured on this server; relay keys are not accepted"},
+	MsgPlaintextCredsDisabled:   {LocaleZH: "服务器已禁用明文凭证（creds- / X-Kiro-Credentials），请改用 relay key", LocaleEN: "Plaintext credentials (creds- / X-Kiro-Credentials) are disabled on this server; use a relay key instead"},
+	MsgClientTokenDisabled:      {LocaleZH: "服务器未启用客户端令牌", LocaleEN: "Client tokens are not enabled on this server"},
+	MsgClientTokenInvalid:       {LocaleZH: "令牌无效: %s", LocaleEN: "Invalid token: %s"},
+	MsgClientTokenExpired:       {LocaleZH: "令牌已过期（%s）", LocaleEN: "Token expired (%s)"},
+	MsgClientTokenRevoked:       {LocaleZH: "令牌已吊销", LocaleEN: "Token has been revoked"},
+	MsgAPIKeyRevoked:            {LocaleZH: "API Key 已吊销", LocaleEN: "API key has been revoked"},
+	MsgAPIKeyExpired:            {LocaleZH: "API Key 已过期（%s）", LocaleEN: "API key expired (%s)"},
+	MsgAPIKeyEndpointDenied:     {LocaleZH: "该 API Key 不允许访问此端点", LocaleEN: "This API key is not allowed to access this endpoint"},
+	MsgAPIKeyModelDenied:        {LocaleZH: "该 API Key 不允许使用模型 %s", LocaleEN: "This API key is not allowed to use model %s"},
+
+	MsgRateLimitRPM:         {LocaleZH: "请求过于频繁（每分钟上限 %d 次），请稍后重试", LocaleEN: "Too many requests (limit %d per minute); please retry later"},
+	MsgRateLimitConcurrency: {LocaleZH: "并发请求过多（上限 %d 个），请等待进行中的请求完成", LocaleEN: "Too many concurrent requests (limit %d); wait for in-flight requests to finish"},
+	MsgRateLimitTPM:         {LocaleZH: "token 用量过快（每分钟上限 %d），请稍后重试", LocaleEN: "Token rate limit exceeded (%d per minute); please retry later"},
+
+	MsgQuotaDailyRequests:   {LocaleZH: "今日请求次数已达配额上限（%v 次）", LocaleEN: "Daily request quota exhausted (%v requests)"},
+	MsgQuotaMonthlyRequests: {LocaleZH: "本月请求次数已达配额上限（%v 次）", LocaleEN: "Monthly request quota exhausted (%v requests)"},
+	MsgQuotaDailyTokens:     {LocaleZH: "今日 token 用量已达配额上限（%v）", LocaleEN: "Daily token quota exhausted (%v tokens)"},
+	MsgQuotaMonthlyTokens:   {LocaleZH: "本月 token 用量已达配额上限（%v）", LocaleEN: "Monthly token quota exhausted (%v tokens)"},
+	MsgQuotaMonthlyCredits:  {LocaleZH: "本月 credit 用量已达配额上限（%v）", LocaleEN: "Monthly credit quota exhausted (%v credits)"},
+
+	MsgMissingActivationCode: {LocaleZH: "缺少激活码", LocaleEN: "Missing activation code"},
+	MsgMissingMachineID:      {LocaleZH: "缺少机器码", LocaleEN: "Missing machine ID"},
+	MsgCodeInvalid:           {LocaleZH: "激活码无效", LocaleEN: "Invalid activation code"},
+	MsgCodeInactive:          {LocaleZH: "激活码未激活", LocaleEN: "Activation code has not been activated"},
+	MsgCodeInUse:             {LocaleZH: "该激活码已被其他设备使用", LocaleEN: "Activation code is already in use on another device"},
+	MsgCodeExpired:           {LocaleZH: "您的激活码已过期（过期日期：%s），请联系管理员续期", LocaleEN: "Your activation code expired on %s; please contact the administrator to renew it"},
+	MsgCodeAlreadyActive:     {LocaleZH: "已激活", LocaleEN: "Already activated"},
+	MsgActivationUnavailable: {LocaleZH: "激活码验证服务暂不可用，请稍后重试", LocaleEN: "Activation server is unavailable; please retry later"},
+	MsgDeviceIDRequired:      {LocaleZH: "该激活码已启用设备绑定，请求需携带 X-Machine-Id", LocaleEN: "This activation code is device-bound; requests must include X-Machine-Id"},
+	MsgDeviceNotAllowed:      {LocaleZH: "该设备未被授权使用此激活码", LocaleEN: "This device is not authorized to use this activation code"},
+	MsgDeviceLimit:           {LocaleZH: "该激活码最多绑定 %d 台设备，请联系管理员解绑", LocaleEN: "This activation code is limited to %d devices; contact the administrator to unbind one"},
+	MsgDeviceCooldown:        {LocaleZH: "设备解绑后需等到 %s 才能绑定新设备", LocaleEN: "A new device cannot be bound until %s after an unbind"},
+	MsgCodeActivated:         {LocaleZH: "激活成功", LocaleEN: "Activated successfully"},
+
+	MsgTunnelDeviceMismatch: {LocaleZH: "激活码未激活或设备不匹配", LocaleEN: "Activation code is not activated or the device does not match"},
+	MsgTunnelNotPermitted:   {LocaleZH: "您的账户暂无内网穿透权限，请联系管理员开通", LocaleEN: "Your account has no tunnel permission; please contact the administrator"},
+	MsgTunnelActivatedAtBad: {LocaleZH: "激活时间异常", LocaleEN: "Invalid activation time"},
+	MsgTunnelExpired:        {LocaleZH: "内网穿透权限已过期（过期时间：%s）", LocaleEN: "Tunnel permission expired at %s"},
+	MsgTunnelOK:             {LocaleZH: "验证通过", LocaleEN: "Verified"},
+
+	MsgCodesCountRequired:  {LocaleZH: "请提供 count 或 customCodes", LocaleEN: "count or customCodes is required"},
+	MsgCodesDeleteRequired: {LocaleZH: "请提供 codesToDelete 数组", LocaleEN: "codesToDelete array is required"},
+	MsgCodesTunnelDaysReq:  {LocaleZH: "请提供 tunnelDays", LocaleEN: "tunnelDays is required"},
+	MsgCodesResetRequired:  {LocaleZH: "请提供 codesToReset 数组", LocaleEN: "codesToReset array is required"},
+	MsgCodesListRequired:   {LocaleZH: "请提供 codes 数组", LocaleEN: "codes array is required"},
+	MsgCodesAdded:          {LocaleZH: "成功添加 %d 个卡密", LocaleEN: "Added %d codes"},
+	MsgCodesDeleted:        {LocaleZH: "成功删除 %d 个卡密", LocaleEN: "Deleted %d codes"},
+	MsgCodesUpdated:        {LocaleZH: "成功更新 %d 个卡密", LocaleEN: "Updated %d codes"},
+	MsgCodesReset:          {LocaleZH: "成功重置 %d 个卡密", LocaleEN: "Reset %d codes"},
+	MsgDevicesUnbound:      {LocaleZH: "已解绑 %d 台设备", LocaleEN: "Unbound %d devices"},
+	MsgCodeNotFound:        {LocaleZH: "激活码不存在: %s", LocaleEN: "Activation code not found: %s"},
+	MsgStoreDisabled:       {LocaleZH: "未启用嵌入式存储（config.storePath）", LocaleEN: "Embedded store is not enabled (config.storePath)"},
+	MsgAdminKeyRequired:    {LocaleZH: "未配置 adminApiKey，该管理端点不可用", LocaleEN: "This admin endpoint requires adminApiKey to be configured"},
+
+	MsgSharingGroupReserved: {LocaleZH: "%q 为保留名称，不能作为共享组", LocaleEN: "%q is reserved and cannot be used as a sharing group"},
+
+	MsgMetricsDisabled: {LocaleZH: "未配置 adminApiKey，/metrics 不可用（可改用 metricsListen 独立端口）", LocaleEN: "/metrics requires adminApiKey (or use a separate metricsListen address)"},
+
+	MsgAPIKeyNameRequired: {LocaleZH: "请提供 name", LocaleEN: "name is required"},
+	MsgAPIKeyNotFound:     {LocaleZH: "API Key 不存在: %s", LocaleEN: "API key not found: %s"},
+	MsgAPIKeyDuplicate:    {LocaleZH: "API Key 已被 %s 使用", LocaleEN: "API key is already used by %s"},
+	MsgAPIKeySaved:        {LocaleZH: "API Key %s 已保存", LocaleEN: "API key %s saved"},
+	MsgAPIKeyRevokedOK:    {LocaleZH: "API Key %s 已吊销", LocaleEN: "API key %s revoked"},
+	MsgAPIKeyDeleted:      {LocaleZH: "API Key %s 已删除", LocaleEN: "API key %s deleted"},
+	MsgAPIKeyInvalid:      {LocaleZH: "API Key %s 配置无效: %s", LocaleEN: "Invalid API key %s: %s"},
+
+	MsgPoolIDRequired:    {LocaleZH: "请提供 id", LocaleEN: "id is required"},
+	MsgPoolNotFound:      {LocaleZH: "凭据不存在: id=%d", LocaleEN: "Credential not found: id=%d"},
+	MsgPoolDuplicateID:   {LocaleZH: "凭据 id 已存在: %d", LocaleEN: "Credential id already exists: %d"},
+	MsgPoolTokenRequired: {LocaleZH: "请提供 accessToken 或 refreshToken", LocaleEN: "accessToken or refreshToken is required"},
+	MsgPoolAdded:         {LocaleZH: "凭据 id=%d 已添加", LocaleEN: "Credential id=%d added"},
+	MsgPoolRemoved:       {LocaleZH: "凭据 id=%d 已移除", LocaleEN: "Credential id=%d removed"},
+	MsgPoolEnabled:       {LocaleZH: "凭据 id=%d 已启用", LocaleEN: "Credential id=%d enabled"},
+	MsgPoolDisabled:      {LocaleZH: "凭据 id=%d 已禁用", LocaleEN: "Credential id=%d disabled"},
+	MsgPoolPrioritySet:   {LocaleZH: "凭据 id=%d 优先级已更新", LocaleEN: "Credential id=%d priority updated"},
+	MsgPoolRefreshed:     {LocaleZH: "凭据 id=%d 已刷新，有效期至 %s", LocaleEN: "Credential id=%d refreshed, valid until %s"},
+
+	MsgTokenNeedsCode:    {LocaleZH: "请使用激活码（act-）申请令牌", LocaleEN: "An activation code (act-) is required to request a token"},
+	MsgTokenNoReissue:    {LocaleZH: "不能用令牌申请新令牌", LocaleEN: "A token cannot be used to request another token"},
+	MsgRateClassUnknown:  {LocaleZH: "未知的限流档位: %s", LocaleEN: "Unknown rate class: %s"},
+	MsgTokenRevokeTarget: {LocaleZH: "请提供 token、jti 或 code", LocaleEN: "token, jti or code is required"},
+	MsgTokenRevokedOK:    {LocaleZH: "令牌 %s 已吊销", LocaleEN: "Token %s revoked"},
+	MsgCodeTokensRevoked: {LocaleZH: "激活码 %s 此前签发的令牌已全部吊销", LocaleEN: "All tokens previously issued for activation code %s have been revoked"},
+}
+
+var (
+	localeMu      sync.RWMutex
+	defaultLocale = LocaleZH
+)
+
+// SetDefaultLocale 设置默认语言（config.json 的 locale，请求未带可识别的 Accept-Language 时使用）
+func SetDefaultLocale(locale string) {
+	if l := normalizeLocale(locale); l != "" {
+		localeMu.Lock()
+		defaultLocale = l
+		localeMu.Unlock()
+	}
+}
+
+// DefaultLocale 当前默认语言
+func DefaultLocale() string {
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+	return defaultLocale
+}
+
+// normalizeLocale 将 zh-CN / en_US 等归一为支持的语言，不支持返回空
+func normalizeLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	switch tag {
+	case LocaleZH, LocaleEN:
+		return tag
+	}
+	return ""
+}
+
+// RequestLocale 按 Accept-Language（含 q 权重）选择语言，无可识别语言时返回默认语言
+func RequestLocale(r *http.Request) string {
+	if r == nil {
+		return DefaultLocale()
+	}
+	header := r.Header.Get("Accept-Language")
+	if header == "" {
+		return DefaultLocale()
+	}
+
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := normalizeLocale(fields[0])
+		if locale == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLocale()
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// Localize 按指定语言渲染文案，缺失时回退到中文，再回退到 code 本身
+func Localize(locale string, code MsgCode, args ...interface{}) string {
+	texts, ok := catalog[code]
+	if !ok {
+		return string(code)
+	}
+	format, ok := texts[locale]
+	if !ok {
+		format = texts[LocaleZH]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// T 按请求语言渲染文案
+func T(r *http.Request, code MsgCode, args ...interface{}) string {
+	return Localize(RequestLocale(r), code, args...)
+}
+
+// IsMsgCode 判断字符串是否为已登记的错误码
+func IsMsgCode(s string) bool {
+	_, ok := catalog[MsgCode(s)]
+	return ok
+}
+
+// Message 可本地化的消息：稳定的 Code + 格式化参数
+// 同时实现 error，方便在 ConvertToKiroRequest 等内部函数中返回，由 handler 按请求语言渲染
+type Message struct {
+	Code MsgCode
+	Args []interface{}
+}
+
+// NewMessage 创建可本地化消息
+func NewMessage(code MsgCode, args ...interface{}) *Message {
+	return &Message{Code: code, Args: args}
+}
+
+// Error 以默认语言渲染（用于日志）
+func (m *Message) Error() string {
+	return Localize(DefaultLocale(), m.Code, m.Args...)
+}
+
+// In 按指定语言渲染
+func (m *Message) In(locale string) string {
+	return Localize(locale, m.Code, m.Args...)
+}
+
+// For 按请求语言渲染，nil 返回空串
+func (m *Message) For(r *http.Request) string {
+	if m == nil {
+		return ""
+	}
+	return m.In(RequestLocale(r))
+}
+
+// LocalizeError 将 error 渲染为 (code, message)；非 *Message 的错误原样返回 message，code 为空
+func LocalizeError(r *http.Request, err error) (string, string) {
+	var m *Message
+	if errors.As(err, &m) {
+		return string(m.Code), m.For(r)
+	}
+	return "", err.Error()
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	LicensePrefix     = "lic-"
+	defaultLicenseTTL = 7 * 24 * time.Hour
+)
+
+// License 激活码许可证：由卡密服务（CodesManager）签发，可用公钥离线校验
+// 激活码验证服务不可达时，AuthMiddleware 凭有效的许可证放行
+type License struct {
+	Code        string `json:"code"`
+	MachineID   string `json:"machineId,omitempty"`   // 激活时绑定的设备，空表示不限设备
+	ExpiresDate string `json:"expiresDate,omitempty"` // 激活码自身的有效期（YYYY-MM-DD）
+	IssuedAt    int64  `json:"iat"`
+	ValidUntil  int64  `json:"exp"` // 许可证本身的有效期，到期需重新在线验证
+}
+
+// LicenseSigner 用 Ed25519 签发许可证，私钥由 secret 派生
+type LicenseSigner struct {
+	priv ed25519.PrivateKey
+	ttl  time.Duration
+}
+
+// NewLicenseSigner secret 为空时返回 nil（不签发）
+func NewLicenseSigner(secret string, ttlSeconds int) *LicenseSigner {
+	if secret == "" {
+		return nil
+	}
+	seed := sha256.Sum256([]byte("kiro-license-v1:" + secret))
+	s := &LicenseSigner{priv: ed25519.NewKeyFromSeed(seed[:]), ttl: defaultLicenseTTL}
+	if ttlSeconds > 0 {
+		s.ttl = time.Duration(ttlSeconds) * time.Second
+	}
+	return s
+}
+
+// PublicKey 校验方配置的公钥（base64）
+func (s *LicenseSigner) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.priv.Public().(ed25519.PublicKey))
+}
+
+// Issue 签发许可证；激活码有效期早于许可证有效期时以激活码为准
+func (s *LicenseSigner) Issue(code, machineID, expiresDate string) string {
+	now := time.Now()
+	lic := License{
+		Code:        code,
+		MachineID:   machineID,
+		ExpiresDate: expiresDate,
+		IssuedAt:    now.Unix(),
+		ValidUntil:  now.Add(s.ttl).Unix(),
+	}
+	if t, err := time.ParseInLocation("2006-01-02", expiresDate, time.Local); err == nil {
+		if end := t.AddDate(0, 0, 1).Unix(); end < lic.ValidUntil {
+			lic.ValidUntil = end
+		}
+	}
+	payload, _ := json.Marshal(lic)
+	sig := ed25519.Sign(s.priv, payload)
+	return LicensePrefix + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// VerifyLicense 用公钥（base64）校验许可证签名与有效期，并检查激活码与设备是否匹配
+func VerifyLicense(blob, publicKey, code, machineID string) (*License, error) {
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid license public key")
+	}
+	parts := strings.Split(strings.TrimPrefix(blob, LicensePrefix), ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed license")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed license")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), payload, sig) {
+		return nil, fmt.Errorf("bad license signature")
+	}
+	var lic License
+	if err := json.Unmarshal(payload, &lic); err != nil {
+		return nil, fmt.Errorf("malformed license")
+	}
+	if !strings.EqualFold(lic.Code, code) {
+		return nil, fmt.Errorf("license issued for another code")
+	}
+	// 绑定设备的许可证必须由同一设备出示；请求不带 X-Machine-Id 时同样拒绝，泄露的许可证不能在其他机器上使用
+	if lic.MachineID != "" && lic.MachineID != machineID {
+		if machineID == "" {
+			return nil, fmt.Errorf("license bound to a device, machine id required")
+		}
+		return nil, fmt.Errorf("license bound to another device")
+	}
+	if time.Now().Unix() >= lic.ValidUntil {
+		return nil, fmt.Errorf("license expired")
+	}
+	return &lic, nil
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"kiro-go/internal/model"
+)
+
+const (
+	concurrencyRetryAfter = 1 * time.Second // 并发超限时建议的重试间隔（无法预知进行中的请求何时结束）
+	rateStateIdleTTL      = 5 * time.Minute // 空闲超过该时间且无进行中请求的状态被清理（令牌桶早已补满，与新建等价）
+	rateStatePruneEvery   = 1 * time.Minute // 清理的最短间隔
+)
+
+// tokenBucket 令牌桶，容量为每分钟额度，按秒平滑补充
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill 按经过的时间补充令牌，不超过容量
+func (b *tokenBucket) refill(capacity float64, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * capacity / 60
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+	}
+	b.last = now
+}
+
+// wait 取出 n 个令牌前需要等待的时间（0 表示可以立即取出）
+func (b *tokenBucket) wait(capacity, n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) * 60 / capacity * float64(time.Second))
+}
+
+// rateState 单个调用方（激活码 / apiKey）的限流状态
+type rateState struct {
+	requests tokenBucket
+	tokens   tokenBucket
+	inFlight int
+	lastUsed time.Time
+}
+
+// RateLimiter 按调用方的令牌桶限流（RPM、并发、每分钟预估 tokens）
+type RateLimiter struct {
+	mu        sync.Mutex
+	states    map[string]*rateState
+	lastPrune time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{states: make(map[string]*rateState)}
+}
+
+// RateLimitError 超限原因（限流与用量配额共用）
+type RateLimitError struct {
+	Code       MsgCode
+	Limit      interface{} // 对应文案中的上限值
+	RetryAfter time.Duration
+}
+
+// Acquire 尝试放行一个请求；成功时返回的 release 须在请求结束后调用以释放并发名额
+// estimatedTokens 按请求体预估，单个请求超过 TPM 容量时只要求桶是满的
+func (rl *RateLimiter) Acquire(key string, limit model.RateLimit, estimatedTokens int) (release func(), rejected *RateLimitError) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.pruneLocked(now)
+	st := rl.states[key]
+	if st == nil {
+		st = &rateState{}
+		rl.states[key] = st
+	}
+	st.lastUsed = now
+
+	if limit.MaxConcurrent > 0 && st.inFlight >= limit.MaxConcurrent {
+		return nil, &RateLimitError{Code: MsgRateLimitConcurrency, Limit: limit.MaxConcurrent, RetryAfter: concurrencyRetryAfter}
+	}
+	if limit.RPM > 0 {
+		capacity := float64(limit.RPM)
+		st.requests.refill(capacity, now)
+		if d := st.requests.wait(capacity, 1); d > 0 {
+			return nil, &RateLimitError{Code: MsgRateLimitRPM, Limit: limit.RPM, RetryAfter: d}
+		}
+	}
+	need := float64(estimatedTokens)
+	if limit.TPM > 0 {
+		capacity := float64(limit.TPM)
+		if need > capacity {
+			need = capacity
+		}
+		st.tokens.refill(capacity, now)
+		if d := st.tokens.wait(capacity, need); d > 0 {
+			return nil, &RateLimitError{Code: MsgRateLimitTPM, Limit: limit.TPM, RetryAfter: d}
+		}
+	}
+
+	// 全部通过后再扣减，避免部分扣减
+	if limit.RPM > 0 {
+		st.requests.tokens--
+	}
+	if limit.TPM > 0 {
+		st.tokens.tokens -= need
+	}
+	st.inFlight++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rl.mu.Lock()
+			st.inFlight--
+			rl.mu.Unlock()
+		})
+	}, nil
+}
+
+// pruneLocked 清理长时间空闲的调用方状态，避免激活码 / Key 越来越多时 states 无限增长
+func (rl *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(rl.lastPrune) < rateStatePruneEvery {
+		return
+	}
+	rl.lastPrune = now
+	for key, st := range rl.states {
+		if st.inFlight == 0 && now.Sub(st.lastUsed) > rateStateIdleTTL {
+			delete(rl.states, key)
+		}
+	}
+}
+
+// limited 是否配置了任一限制
+func limited(limit model.RateLimit) bool {
+	return limit.RPM > 0 || limit.MaxConcurrent > 0 || limit.TPM > 0
+}
+
+// estimateRequestTokens 按请求体大小粗略预估输入 tokens（约 4 字节 / token），读取后还原 Body
+func estimateRequestTokens(r *http.Request) int {
+	if r.Body == nil {
+		return 0
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return 0
+	}
+	return len(body) / 4
+}
+
+// writeRateLimited 写入 429，按请求路径选择 Anthropic 或 OpenAI 错误格式
+func writeRateLimited(w http.ResponseWriter, r *http.Request, e *RateLimitError) {
+	ue := &UpstreamError{
+		Status:     http.StatusTooManyRequests,
+		Type:       "rate_limit_error",
+		Code:       string(e.Code),
+		Message:    T(r, e.Code, e.Limit),
+		RetryAfter: e.RetryAfter,
+	}
+	ue.SetRetryHeaders(w)
+	if IsOpenAIPath(r.URL.Path) {
+		WriteJSON(w, ue.Status, ue.OpenAIErrorBody())
+		return
+	}
+	writeErrorBody(w, ue.Status, ue.Type, ue.Code, ue.Message)
+}
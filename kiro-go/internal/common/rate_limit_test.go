@@ -0,0 +1,219 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro-go/internal/model"
+)
+
+func TestRateLimiterRPM(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := model.RateLimit{RPM: 2}
+
+	for i := 0; i < 2; i++ {
+		release, rejected := rl.Acquire("a", limit, 0)
+		if rejected != nil {
+			t.Fatalf("第 %d 个请求不应被拒绝: %+v", i+1, rejected)
+		}
+		release()
+	}
+	_, rejected := rl.Acquire("a", limit, 0)
+	if rejected == nil || rejected.Code != MsgRateLimitRPM {
+		t.Fatalf("超出 RPM 应被拒绝: %+v", rejected)
+	}
+	if rejected.RetryAfter <= 0 || rejected.RetryAfter > 30*time.Second {
+		t.Fatalf("RPM 2 时一个令牌 30 秒补充，RetryAfter = %v", rejected.RetryAfter)
+	}
+
+	// 其他调用方独立计数
+	if _, rejected := rl.Acquire("b", limit, 0); rejected != nil {
+		t.Fatalf("不同 key 不应共享令牌桶: %+v", rejected)
+	}
+
+	// 30 秒后补充一个令牌
+	rl.mu.Lock()
+	rl.states["a"].requests.last = rl.states["a"].requests.last.Add(-31 * time.Second)
+	rl.mu.Unlock()
+	if _, rejected := rl.Acquire("a", limit, 0); rejected != nil {
+		t.Fatalf("补充后应放行: %+v", rejected)
+	}
+	if _, rejected := rl.Acquire("a", limit, 0); rejected == nil {
+		t.Fatal("只补充了一个令牌")
+	}
+}
+
+func TestRateLimiterTPM(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := model.RateLimit{TPM: 1000}
+
+	if _, rejected := rl.Acquire("a", limit, 600); rejected != nil {
+		t.Fatalf("首个请求不应被拒绝: %+v", rejected)
+	}
+	_, rejected := rl.Acquire("a", limit, 600)
+	if rejected == nil || rejected.Code != MsgRateLimitTPM {
+		t.Fatalf("超出 TPM 应被拒绝: %+v", rejected)
+	}
+
+	// 单个请求超过容量时只要求桶是满的
+	if _, rejected := rl.Acquire("b", limit, 5000); rejected != nil {
+		t.Fatalf("超大请求在桶满时应放行: %+v", rejected)
+	}
+	if _, rejected := rl.Acquire("b", limit, 1); rejected == nil {
+		t.Fatal("超大请求应耗尽整个桶")
+	}
+}
+
+func TestRateLimiterRejectDoesNotConsume(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := model.RateLimit{RPM: 10, TPM: 100}
+
+	if _, rejected := rl.Acquire("a", limit, 100); rejected != nil {
+		t.Fatalf("首个请求不应被拒绝: %+v", rejected)
+	}
+	if _, rejected := rl.Acquire("a", limit, 100); rejected == nil || rejected.Code != MsgRateLimitTPM {
+		t.Fatalf("应因 TPM 拒绝: %+v", rejected)
+	}
+	rl.mu.Lock()
+	remaining := rl.states["a"].requests.tokens
+	rl.mu.Unlock()
+	if remaining < 8.5 || remaining > 9.5 {
+		t.Fatalf("被拒绝的请求不应扣减 RPM 令牌，剩余 %v", remaining)
+	}
+}
+
+func TestRateLimiterConcurrencyRelease(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := model.RateLimit{MaxConcurrent: 1}
+
+	release, rejected := rl.Acquire("a", limit, 0)
+	if rejected != nil {
+		t.Fatalf("首个请求不应被拒绝: %+v", rejected)
+	}
+	if _, rejected := rl.Acquire("a", limit, 0); rejected == nil || rejected.Code != MsgRateLimitConcurrency {
+		t.Fatalf("并发超限应被拒绝: %+v", rejected)
+	}
+	release()
+	release() // 重复释放不应多还名额
+
+	second, rejected := rl.Acquire("a", limit, 0)
+	if rejected != nil {
+		t.Fatalf("释放后应放行: %+v", rejected)
+	}
+	if _, rejected := rl.Acquire("a", limit, 0); rejected == nil {
+		t.Fatal("重复释放导致并发名额多于上限")
+	}
+	second()
+}
+
+func TestRateLimiterConcurrentAcquire(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := model.RateLimit{MaxConcurrent: 3}
+
+	var active, peak, served int32
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				release, rejected := rl.Acquire("a", limit, 0)
+				if rejected != nil {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				n := atomic.AddInt32(&active, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(100 * time.Microsecond)
+				atomic.AddInt32(&active, -1)
+				atomic.AddInt32(&served, 1)
+				release()
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	if served != 64 {
+		t.Fatalf("全部请求最终都应放行，served = %d", served)
+	}
+	if peak > 3 {
+		t.Fatalf("同时进行的请求数 %d 超过上限 3", peak)
+	}
+	rl.mu.Lock()
+	inFlight := rl.states["a"].inFlight
+	rl.mu.Unlock()
+	if inFlight != 0 {
+		t.Fatalf("全部释放后 inFlight = %d", inFlight)
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := model.RateLimit{RPM: 10}
+
+	idle, _ := rl.Acquire("idle", limit, 0)
+	idle()
+	busy, _ := rl.Acquire("busy", limit, 0)
+	defer busy()
+
+	rl.mu.Lock()
+	old := time.Now().Add(-2 * rateStateIdleTTL)
+	rl.states["idle"].lastUsed = old
+	rl.states["busy"].lastUsed = old
+	rl.lastPrune = time.Time{}
+	rl.mu.Unlock()
+
+	rl.Acquire("other", limit, 0)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if _, ok := rl.states["idle"]; ok {
+		t.Fatal("空闲的状态应被清理")
+	}
+	if _, ok := rl.states["busy"]; !ok {
+		t.Fatal("有进行中请求的状态不能清理")
+	}
+}
+
+func TestWriteRateLimitedFormat(t *testing.T) {
+	cases := []struct {
+		path   string
+		openAI bool
+	}{
+		{"/v1/chat/completions", true},
+		{"/anthropic/v1/chat/completions", true},
+		{"/v1/messages", false},
+		{"/anthropic/v1/messages", false},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, c.path, nil)
+		writeRateLimited(w, r, &RateLimitError{Code: MsgRateLimitRPM, Limit: 10, RetryAfter: 3 * time.Second})
+
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: status = %d", c.path, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: 缺少 Retry-After", c.path)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		_, anthropicShape := body["type"]
+		if anthropicShape == c.openAI {
+			t.Fatalf("%s: 错误格式不对（openAI=%v）: %s", c.path, c.openAI, w.Body.String())
+		}
+	}
+}
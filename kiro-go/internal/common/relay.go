@@ -0,0 +1,59 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"kiro-go/internal/model"
+)
+
+// deriveRelayKey 从任意长度的 secret 派生 32 字节 AES key
+// 与 kiro-launcher deriveRelayKey、Rust 端 relay::crypto::derive_key 保持一致
+func deriveRelayKey(secret string) []byte {
+	h := sha256.New()
+	h.Write([]byte("kiro-relay-v1:"))
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// DecodeRelayKey 解密 relay- 前缀的 API Key
+// 格式：relay- + base64url(nonce(12) || AES-256-GCM 密文 || tag)，明文为凭证 JSON
+func DecodeRelayKey(key, secret string) (*model.KiroCredentials, error) {
+	encoded := strings.TrimRight(strings.TrimPrefix(key, "relay-"), "=")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		payload, err = base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("base64 decode failed")
+		}
+	}
+
+	block, err := aes.NewCipher(deriveRelayKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("payload too short")
+	}
+	nonce, ciphertext := payload[:gcm.NonceSize()], payload[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		// 密钥不匹配或内容被篡改
+		return nil, fmt.Errorf("decrypt failed")
+	}
+
+	var creds model.KiroCredentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
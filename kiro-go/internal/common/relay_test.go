@@ -0,0 +1,82 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// launcherRelayKey 按 kiro-launcher GenerateRelayKey 的格式生成 relay key：
+// relay- + base64url 无 padding(nonce(12) || AES-256-GCM 密文 || tag)，key 为 sha256("kiro-relay-v1:" + secret)
+func launcherRelayKey(t *testing.T, secret, plaintext string) string {
+	t.Helper()
+	key := sha256.Sum256([]byte("kiro-relay-v1:" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	payload := append(nonce, gcm.Seal(nil, nonce, []byte(plaintext), nil)...)
+	return "relay-" + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// launcherCredentials kiro-launcher CredentialsFile 序列化后的凭证
+const launcherCredentials = `{"accessToken":"aoa-access","refreshToken":"aor-refresh","expiresAt":"2030-01-01T00:00:00Z","authMethod":"social","region":"us-east-1"}`
+
+func TestDecodeRelayKeyRoundTrip(t *testing.T) {
+	key := launcherRelayKey(t, "shared-secret", launcherCredentials)
+
+	creds, err := DecodeRelayKey(key, "shared-secret")
+	if err != nil {
+		t.Fatalf("解密 launcher 生成的 relay key 失败: %v", err)
+	}
+	if creds.AccessToken != "aoa-access" || creds.RefreshToken != "aor-refresh" ||
+		creds.ExpiresAt != "2030-01-01T00:00:00Z" || creds.AuthMethod != "social" {
+		t.Fatalf("解密后的凭证不一致: %+v", creds)
+	}
+
+	// 兼容带 padding 的标准 base64
+	payload, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, "relay-"))
+	std := "relay-" + base64.StdEncoding.EncodeToString(payload)
+	if _, err := DecodeRelayKey(std, "shared-secret"); err != nil {
+		t.Fatalf("标准 base64 编码应可解密: %v", err)
+	}
+}
+
+func TestDecodeRelayKeyRejects(t *testing.T) {
+	key := launcherRelayKey(t, "shared-secret", launcherCredentials)
+
+	if _, err := DecodeRelayKey(key, "other-secret"); err == nil {
+		t.Fatal("密钥不匹配时应解密失败")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, "relay-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pos := range map[string]int{"篡改 tag": len(payload) - 1, "篡改密文": 12, "篡改 nonce": 0} {
+		tampered := append([]byte(nil), payload...)
+		tampered[pos] ^= 0x01
+		if _, err := DecodeRelayKey("relay-"+base64.RawURLEncoding.EncodeToString(tampered), "shared-secret"); err == nil {
+			t.Fatalf("%s 后应解密失败", name)
+		}
+	}
+
+	if _, err := DecodeRelayKey("relay-"+base64.RawURLEncoding.EncodeToString(payload[:20]), "shared-secret"); err == nil {
+		t.Fatal("过短的 payload 应被拒绝")
+	}
+	if _, err := DecodeRelayKey("relay-!!!", "shared-secret"); err == nil {
+		t.Fatal("非 base64 应被拒绝")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"kiro-go/internal/metrics"
 )
 
 // TruncationState 管理截断恢复状态
@@ -50,6 +52,7 @@ func SaveToolTruncation(toolCallID, toolName string, truncationInfo map[string]i
 		Timestamp:      time.Now().Unix(),
 	}
 	toolTruncationCache[toolCallID] = info
+	metrics.Truncations.Inc("tool")
 	log.Printf("[Truncation] Saved tool truncation for %s (%s)", toolCallID, toolName)
 }
 
@@ -96,6 +99,7 @@ func SaveContentTruncation(content string) string {
 		Timestamp:      time.Now().Unix(),
 	}
 	contentTruncationCache[messageHash] = info
+	metrics.Truncations.Inc("content")
 	log.Printf("[Truncation] Saved content truncation with hash %s", messageHash)
 
 	return messageHash
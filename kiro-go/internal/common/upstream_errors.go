@@ -0,0 +1,294 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 客户端重试建议间隔（上游未给出 Retry-After 时使用）
+const (
+	defaultThrottleRetryAfter   = 5 * time.Second
+	defaultOverloadedRetryAfter = 10 * time.Second
+	defaultQuotaRetryAfter      = 1 * time.Hour
+)
+
+// UpstreamError 上游（Kiro / AWS）错误的归类结果
+// Type 为 Anthropic 错误类型，OpenAI 侧的 type/code 由 OpenAIType / OpenAICode 推导
+type UpstreamError struct {
+	Status     int    // 返回给客户端的 HTTP 状态码
+	Type       string // Anthropic 错误类型（overloaded_error、rate_limit_error 等）
+	Code       string // OpenAI error code（为空时按 Type 推导）
+	Message    string
+	RetryAfter time.Duration // >0 时写入 Retry-After header
+	NoRetry    bool          // 重试无意义（如额度用尽），写入 x-should-retry: false
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Type, e.Message)
+}
+
+// upstreamPattern 上游错误归类规则：上游状态码或错误文本（小写的响应体 / 异常类型 + payload）任一命中即采用
+type upstreamPattern struct {
+	onStatus func(status int) bool
+	needles  []string
+	status   int    // 返回给客户端的状态码，0 表示沿用上游状态码
+	typ      string // Anthropic 错误类型
+	code     string // OpenAI error code（为空时按 typ 推导）
+	retry    time.Duration
+	noRetry  bool
+}
+
+func statusIn(codes ...int) func(int) bool {
+	return func(status int) bool {
+		for _, c := range codes {
+			if status == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// upstreamPatterns HTTP 响应和流中异常帧共用的归类表，按顺序匹配
+// 覆盖：额度用尽、限流、模型不可用、模型无效、内容过长、认证过期、权限不足
+var upstreamPatterns = []upstreamPattern{
+	// 额度用尽：402 / MONTHLY_REQUEST_COUNT / ServiceQuotaExceeded
+	{onStatus: statusIn(http.StatusPaymentRequired), needles: []string{"monthly_request_count", "servicequotaexceeded"},
+		status: http.StatusTooManyRequests, typ: "rate_limit_error", code: "insufficient_quota", retry: defaultQuotaRetryAfter, noRetry: true},
+	// 限流
+	{onStatus: statusIn(http.StatusTooManyRequests), needles: []string{"throttling", "toomanyrequests"},
+		status: http.StatusTooManyRequests, typ: "rate_limit_error", retry: defaultThrottleRetryAfter},
+	// 模型暂时不可用 / 服务过载
+	{onStatus: statusIn(http.StatusServiceUnavailable, 529),
+		needles: []string{"model_temporarily_unavailable", "insufficientmodelcapacity", "serviceunavailable", "overloaded"},
+		status:  529, typ: "overloaded_error", retry: defaultOverloadedRetryAfter},
+	// 模型无效
+	{needles: []string{"invalid_model_id", "invalid model", "model not found"},
+		status: http.StatusNotFound, typ: "not_found_error", code: "model_not_found"},
+	// 输入过长
+	{needles: []string{"content_length_exceeds_threshold", "contentlengthexceeded", "input is too long", "prompt is too long", "too many tokens"},
+		status: http.StatusBadRequest, typ: "invalid_request_error", code: "context_length_exceeded"},
+	// 认证过期 / token 无效
+	{onStatus: statusIn(http.StatusUnauthorized),
+		needles: []string{"expiredtoken", "bearer token included in the request is invalid", "unrecognizedclient"},
+		status:  http.StatusUnauthorized, typ: "authentication_error"},
+	{onStatus: statusIn(http.StatusForbidden), needles: []string{"accessdenied"},
+		status: http.StatusForbidden, typ: "permission_error"},
+	{onStatus: statusIn(http.StatusNotFound), needles: []string{"resourcenotfound"},
+		status: http.StatusNotFound, typ: "not_found_error"},
+	{onStatus: statusIn(http.StatusRequestEntityTooLarge),
+		status: http.StatusRequestEntityTooLarge, typ: "request_too_large"},
+	{onStatus: func(status int) bool { return status >= 400 && status < 500 }, needles: []string{"validationexception"},
+		status: http.StatusBadRequest, typ: "invalid_request_error"},
+	// 上游网关错误 / 超时
+	{onStatus: statusIn(http.StatusBadGateway, http.StatusGatewayTimeout),
+		typ: "api_error", retry: defaultOverloadedRetryAfter},
+}
+
+// classifyUpstream 按 upstreamPatterns 归类；status 为 0 表示流中的异常帧（只按文本匹配）
+func classifyUpstream(status int, text, message string, retryAfter time.Duration) *UpstreamError {
+	lower := strings.ToLower(text)
+	for _, p := range upstreamPatterns {
+		matched := status != 0 && p.onStatus != nil && p.onStatus(status)
+		for _, needle := range p.needles {
+			if matched {
+				break
+			}
+			matched = strings.Contains(lower, needle)
+		}
+		if !matched {
+			continue
+		}
+		e := &UpstreamError{Status: p.status, Type: p.typ, Code: p.code, Message: message, NoRetry: p.noRetry}
+		if e.Status == 0 {
+			e.Status = status
+		}
+		if p.retry > 0 {
+			e.RetryAfter = retryAfter
+			if e.RetryAfter <= 0 {
+				e.RetryAfter = p.retry
+			}
+		}
+		return e
+	}
+	return &UpstreamError{Status: http.StatusInternalServerError, Type: "api_error", Message: message}
+}
+
+// ClassifyUpstreamResponse 将 Kiro / AWS 的非 2xx 响应映射为客户端可理解的错误
+func ClassifyUpstreamResponse(status int, body string, header http.Header) *UpstreamError {
+	message := extractUpstreamMessage(body)
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return classifyUpstream(status, body, message, parseRetryAfter(header))
+}
+
+// ClassifyError 将 provider 返回的 error 归类
+// 已归类的 *UpstreamError 原样返回，请求校验失败（*Message）视为 400，
+// 其余（网络错误、无可用凭据等）视为 502 api_error
+func ClassifyError(err error) *UpstreamError {
+	var ue *UpstreamError
+	if errors.As(err, &ue) {
+		return ue
+	}
+	var msg *Message
+	if errors.As(err, &msg) {
+		return &UpstreamError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: string(msg.Code), Message: msg.Error()}
+	}
+	return &UpstreamError{Status: http.StatusBadGateway, Type: "api_error", Message: err.Error()}
+}
+
+// SetRetryHeaders 写入 Retry-After / x-should-retry（须在 WriteHeader 之前调用）
+func (e *UpstreamError) SetRetryHeaders(w http.ResponseWriter) {
+	if e.RetryAfter > 0 {
+		secs := int((e.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	if e.NoRetry {
+		w.Header().Set("x-should-retry", "false")
+	}
+}
+
+// WriteUpstreamError 以 Anthropic 格式写入归类后的上游错误
+func WriteUpstreamError(w http.ResponseWriter, e *UpstreamError) {
+	e.SetRetryHeaders(w)
+	WriteJSON(w, e.Status, e.AnthropicErrorBody())
+}
+
+// parseRetryAfter 解析上游 Retry-After（秒数或 HTTP 日期）
+func parseRetryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// ClassifyStreamException 将 Kiro 流中的 error / exception 帧映射为 Anthropic 错误类型（与 HTTP 响应共用归类表）
+// exceptionType 来自 :exception-type header，errorCode 来自 error-code header
+func ClassifyStreamException(exceptionType, errorCode, payload string) *UpstreamError {
+	message := extractUpstreamMessage(payload)
+	kind := exceptionType
+	if kind == "" {
+		kind = errorCode
+	}
+	if message == "" {
+		message = kind
+	}
+	if message == "" {
+		message = "Upstream stream error"
+	}
+	return classifyUpstream(0, kind+" "+payload, message, 0)
+}
+
+// StreamInterrupted 上游连接在流式输出途中断开
+func StreamInterrupted(err error) *UpstreamError {
+	msg := "Upstream stream interrupted"
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	return &UpstreamError{Status: http.StatusBadGateway, Type: "api_error", Message: msg}
+}
+
+// OpenAIType 对应的 OpenAI error.type
+func (e *UpstreamError) OpenAIType() string {
+	if e.Code == "insufficient_quota" {
+		return "insufficient_quota"
+	}
+	switch e.Type {
+	case "invalid_request_error", "authentication_error", "permission_error", "not_found_error", "request_too_large":
+		return "invalid_request_error"
+	case "rate_limit_error":
+		return "rate_limit_error"
+	default:
+		return "server_error"
+	}
+}
+
+// OpenAICode 对应的 OpenAI error.code（无对应时返回空）
+func (e *UpstreamError) OpenAICode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	switch e.Type {
+	case "authentication_error":
+		return "invalid_api_key"
+	case "permission_error":
+		return "permission_denied"
+	case "not_found_error":
+		return "model_not_found"
+	case "rate_limit_error":
+		return "rate_limit_exceeded"
+	case "overloaded_error":
+		return "server_overloaded"
+	default:
+		return ""
+	}
+}
+
+// AnthropicErrorBody Anthropic 格式错误体（HTTP 响应和 SSE error 事件共用）
+func (e *UpstreamError) AnthropicErrorBody() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    e.Type,
+			"message": e.Message,
+		},
+	}
+}
+
+// OpenAIErrorBody OpenAI 格式错误体（HTTP 响应和流式 error chunk 共用）
+func (e *UpstreamError) OpenAIErrorBody() map[string]interface{} {
+	var code interface{}
+	if c := e.OpenAICode(); c != "" {
+		code = c
+	}
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": e.Message,
+			"type":    e.OpenAIType(),
+			"param":   nil,
+			"code":    code,
+		},
+	}
+}
+
+// extractUpstreamMessage 从上游错误 payload 中提取 message 字段
+func extractUpstreamMessage(payload string) string {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return ""
+	}
+	var v map[string]interface{}
+	if json.Unmarshal([]byte(payload), &v) != nil {
+		return payload
+	}
+	for _, key := range []string{"message", "Message", "errorMessage"} {
+		if msg, ok := v[key].(string); ok && msg != "" {
+			return msg
+		}
+	}
+	// Anthropic 格式：{"type":"error","error":{"type":...,"message":...}}
+	if errObj, ok := v["error"].(map[string]interface{}); ok {
+		if msg, ok := errObj["message"].(string); ok && msg != "" {
+			return msg
+		}
+	}
+	return payload
+}
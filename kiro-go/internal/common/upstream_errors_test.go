@@ -0,0 +1,120 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyUpstreamResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   int
+		typ    string
+		code   string
+	}{
+		{"额度用尽 402", http.StatusPaymentRequired, `{"message":"no credits"}`, 429, "rate_limit_error", "insufficient_quota"},
+		{"额度用尽 body", 400, `{"reason":"MONTHLY_REQUEST_COUNT"}`, 429, "rate_limit_error", "insufficient_quota"},
+		{"限流 429", http.StatusTooManyRequests, ``, 429, "rate_limit_error", ""},
+		{"限流 body", 400, `{"__type":"ThrottlingException"}`, 429, "rate_limit_error", ""},
+		{"模型暂不可用", 500, `{"reason":"MODEL_TEMPORARILY_UNAVAILABLE"}`, 529, "overloaded_error", ""},
+		{"服务不可用", http.StatusServiceUnavailable, ``, 529, "overloaded_error", ""},
+		{"模型无效", 400, `{"message":"Invalid model. Please select a different model"}`, 404, "not_found_error", "model_not_found"},
+		{"模型 ID 无效", 400, `{"reason":"INVALID_MODEL_ID"}`, 404, "not_found_error", "model_not_found"},
+		{"输入过长", 400, `{"reason":"CONTENT_LENGTH_EXCEEDS_THRESHOLD"}`, 400, "invalid_request_error", "context_length_exceeded"},
+		{"认证 401", http.StatusUnauthorized, ``, 401, "authentication_error", ""},
+		{"token 过期", 403, `{"__type":"ExpiredTokenException"}`, 401, "authentication_error", ""},
+		{"权限不足", http.StatusForbidden, `{"message":"denied"}`, 403, "permission_error", ""},
+		{"未找到", http.StatusNotFound, ``, 404, "not_found_error", ""},
+		{"请求过大", http.StatusRequestEntityTooLarge, ``, 413, "request_too_large", ""},
+		{"其他 4xx", http.StatusConflict, ``, 400, "invalid_request_error", ""},
+		{"网关超时", http.StatusGatewayTimeout, ``, 504, "api_error", ""},
+		{"其他 5xx", http.StatusInternalServerError, `boom`, 500, "api_error", ""},
+	}
+	for _, c := range cases {
+		e := ClassifyUpstreamResponse(c.status, c.body, nil)
+		if e.Status != c.want || e.Type != c.typ || e.Code != c.code {
+			t.Errorf("%s: 得到 %d %s %q，期望 %d %s %q", c.name, e.Status, e.Type, e.Code, c.want, c.typ, c.code)
+		}
+	}
+}
+
+func TestClassifyStreamException(t *testing.T) {
+	cases := []struct {
+		name          string
+		exceptionType string
+		payload       string
+		typ           string
+		code          string
+	}{
+		{"限流", "ThrottlingException", `{"message":"slow down"}`, "rate_limit_error", ""},
+		{"额度用尽", "ServiceQuotaExceededException", ``, "rate_limit_error", "insufficient_quota"},
+		{"过载", "InternalServerException", `{"message":"Model is overloaded"}`, "overloaded_error", ""},
+		{"模型无效", "ValidationException", `{"message":"Invalid model"}`, "not_found_error", "model_not_found"},
+		{"输入过长", "ValidationException", `{"reason":"CONTENT_LENGTH_EXCEEDS_THRESHOLD"}`, "invalid_request_error", "context_length_exceeded"},
+		{"token 过期", "ExpiredTokenException", ``, "authentication_error", ""},
+		{"校验失败", "ValidationException", `{"message":"bad input"}`, "invalid_request_error", ""},
+		{"权限不足", "AccessDeniedException", ``, "permission_error", ""},
+		{"未找到", "ResourceNotFoundException", ``, "not_found_error", ""},
+		{"未知", "SomethingException", ``, "api_error", ""},
+	}
+	for _, c := range cases {
+		e := ClassifyStreamException(c.exceptionType, "", c.payload)
+		if e.Type != c.typ || e.Code != c.code {
+			t.Errorf("%s: 得到 %s %q，期望 %s %q", c.name, e.Type, e.Code, c.typ, c.code)
+		}
+	}
+
+	// 流中与 HTTP 响应归类一致
+	for _, text := range []string{"ThrottlingException", "CONTENT_LENGTH_EXCEEDS_THRESHOLD", "ExpiredTokenException", "INVALID_MODEL_ID"} {
+		stream := ClassifyStreamException(text, "", "")
+		resp := ClassifyUpstreamResponse(http.StatusBadRequest, text, nil)
+		if stream.Type != resp.Type || stream.Code != resp.Code {
+			t.Errorf("%s: 流 %s/%q 与响应 %s/%q 不一致", text, stream.Type, stream.Code, resp.Type, resp.Code)
+		}
+	}
+}
+
+func TestClassifyUpstreamMessageAndRetry(t *testing.T) {
+	e := ClassifyUpstreamResponse(http.StatusTooManyRequests, `{"message":"Rate exceeded"}`, http.Header{"Retry-After": {"7"}})
+	if e.Message != "Rate exceeded" || e.RetryAfter != 7*time.Second {
+		t.Fatalf("应使用上游的 message 和 Retry-After: %+v", e)
+	}
+	if e := ClassifyUpstreamResponse(http.StatusTooManyRequests, ``, nil); e.RetryAfter != defaultThrottleRetryAfter || e.Message != "Too Many Requests" {
+		t.Fatalf("缺省 Retry-After / message: %+v", e)
+	}
+	if e := ClassifyUpstreamResponse(http.StatusPaymentRequired, ``, nil); !e.NoRetry || e.RetryAfter != defaultQuotaRetryAfter {
+		t.Fatalf("额度用尽不应重试: %+v", e)
+	}
+	if e := ClassifyUpstreamResponse(http.StatusBadRequest, `{"message":"bad"}`, http.Header{"Retry-After": {"7"}}); e.RetryAfter != 0 {
+		t.Fatalf("不可重试的错误不应带 Retry-After: %+v", e)
+	}
+	if e := ClassifyStreamException("", "", ""); e.Message != "Upstream stream error" {
+		t.Fatalf("缺省流错误信息: %+v", e)
+	}
+	if e := ClassifyStreamException("", "ThrottlingException", `{"Message":"wait"}`); e.Type != "rate_limit_error" || e.Message != "wait" {
+		t.Fatalf("应回退到 error-code 并提取 Message: %+v", e)
+	}
+}
+
+func TestUpstreamErrorOpenAIMapping(t *testing.T) {
+	cases := []struct {
+		e         *UpstreamError
+		typ, code string
+	}{
+		{&UpstreamError{Type: "rate_limit_error", Code: "insufficient_quota"}, "insufficient_quota", "insufficient_quota"},
+		{&UpstreamError{Type: "rate_limit_error"}, "rate_limit_error", "rate_limit_exceeded"},
+		{&UpstreamError{Type: "authentication_error"}, "invalid_request_error", "invalid_api_key"},
+		{&UpstreamError{Type: "not_found_error", Code: "model_not_found"}, "invalid_request_error", "model_not_found"},
+		{&UpstreamError{Type: "invalid_request_error", Code: "context_length_exceeded"}, "invalid_request_error", "context_length_exceeded"},
+		{&UpstreamError{Type: "overloaded_error"}, "server_error", "server_overloaded"},
+		{&UpstreamError{Type: "api_error"}, "server_error", ""},
+	}
+	for _, c := range cases {
+		if typ, code := c.e.OpenAIType(), c.e.OpenAICode(); typ != c.typ || code != c.code {
+			t.Errorf("%s/%s: 得到 %s %q，期望 %s %q", c.e.Type, c.e.Code, typ, code, c.typ, c.code)
+		}
+	}
+}
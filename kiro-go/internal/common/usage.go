@@ -0,0 +1,112 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"kiro-go/internal/metrics"
+)
+
+const UsageContextKey contextKey = "usage"
+
+// Usage 一次请求的用量，由 handler 上报（同一请求多次上报时累加，如自动续写）
+type Usage struct {
+	Model            string  `json:"model"`
+	Backend          string  `json:"backend"`          // "kiro" | "anthropic"
+	Region           string  `json:"region,omitempty"` // 实际响应的 Kiro API 区域
+	InputTokens      int     `json:"input_tokens"`
+	OutputTokens     int     `json:"output_tokens"`
+	CacheReadTokens  int     `json:"cache_read_input_tokens,omitempty"`
+	CacheWriteTokens int     `json:"cache_creation_input_tokens,omitempty"`
+	Credits          float64 `json:"credits,omitempty"` // Kiro 计费 credit（上游未上报时按模型 rateMultiplier 估算）
+}
+
+// TotalTokens 输入（含缓存读写）+ 输出
+func (u Usage) TotalTokens() int {
+	return u.InputTokens + u.OutputTokens + u.CacheReadTokens + u.CacheWriteTokens
+}
+
+// UsageEntry 用量账本中的一条记录
+type UsageEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Code      string    `json:"code,omitempty"` // 激活码，静态 apiKey / 自带凭证时为空
+	Auth      string    `json:"auth"`           // act | token | apikey | creds
+	Key       string    `json:"key,omitempty"`  // 具名 API Key 的名称
+	Path      string    `json:"path"`
+	Usage
+}
+
+// usageSlot 请求级用量收集器，由 AuthMiddleware 放入 context
+type usageSlot struct {
+	mu       sync.Mutex
+	usage    Usage
+	reported bool
+}
+
+// RecordUsage handler 上报本次请求的用量（计入指标；请求未经过 AuthMiddleware 时不写账本）
+func RecordUsage(r *http.Request, u Usage) {
+	metrics.SetModel(r, u.Model)
+	metrics.ObserveUsage(u.Backend, u.Model, u.InputTokens, u.OutputTokens, u.CacheReadTokens, u.CacheWriteTokens, u.Credits)
+
+	slot, ok := r.Context().Value(UsageContextKey).(*usageSlot)
+	if !ok {
+		return
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if u.Model != "" {
+		slot.usage.Model = u.Model
+	}
+	if u.Backend != "" {
+		slot.usage.Backend = u.Backend
+	}
+	if u.Region != "" {
+		slot.usage.Region = u.Region
+	}
+	slot.usage.InputTokens += u.InputTokens
+	slot.usage.OutputTokens += u.OutputTokens
+	slot.usage.CacheReadTokens += u.CacheReadTokens
+	slot.usage.CacheWriteTokens += u.CacheWriteTokens
+	slot.usage.Credits += u.Credits
+	slot.reported = true
+}
+
+// withUsage 请求结束后把 handler 上报的用量交给 RecordUsage 回调写入账本
+func (am *AuthMiddleware) withUsage(handler http.HandlerFunc) http.HandlerFunc {
+	if am.RecordUsage == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		slot := &usageSlot{}
+		r = r.WithContext(context.WithValue(r.Context(), UsageContextKey, slot))
+		handler(w, r)
+
+		slot.mu.Lock()
+		defer slot.mu.Unlock()
+		if !slot.reported {
+			return
+		}
+		entry := UsageEntry{
+			Time:      time.Now(),
+			RequestID: GetRequestIDFromContext(r),
+			Code:      GetActCodeFromContext(r),
+			Key:       GetAPIKeyNameFromContext(r),
+			Path:      r.URL.Path,
+			Usage:     slot.usage,
+		}
+		switch {
+		case GetClientTokenFromContext(r) != nil:
+			entry.Auth = "token"
+		case entry.Code != "":
+			entry.Auth = "act"
+		case GetCredsFromContext(r) != nil:
+			entry.Auth = "creds"
+		default:
+			entry.Auth = "apikey"
+		}
+		am.RecordUsage(entry)
+	}
+}
@@ -0,0 +1,61 @@
+package kiro
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+// LocalActivationValidator 本进程即激活码服务时，直接查 CodesManager，省去回环 HTTP 请求
+type LocalActivationValidator struct {
+	Codes *CodesManager
+}
+
+func (v *LocalActivationValidator) Validate(code, machineId string) (common.ActivationResult, error) {
+	ok, msg := v.Codes.IsValidCode(code, machineId)
+	result := common.ActivationResult{Valid: ok}
+	if msg != nil {
+		result.Code = string(msg.Code)
+		result.Message = msg.Error()
+	}
+	if ok {
+		result.License = v.Codes.IssueLicense(code)
+	}
+	return result, nil
+}
+
+// IsLocalActivationServer activationServerUrl 是否指向本进程：
+// 显式配置为 "local"，或主机为回环地址 / 本机监听地址且端口与 config.port 相同
+func IsLocalActivationServer(cfg *model.Config) bool {
+	raw := strings.TrimSpace(cfg.ActivationServerURL)
+	if raw == "" {
+		return false
+	}
+	if strings.EqualFold(raw, "local") {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if port != strconv.Itoa(cfg.Port) {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || host == cfg.Host {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
@@ -0,0 +1,237 @@
+package kiro
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+// APIKeysManager 具名静态 API Key（api_keys.json）
+// 文件被外部修改时由 Watch 自动重新加载，吊销无需重启
+type APIKeysManager struct {
+	filePath string
+	mu       sync.RWMutex
+	keys     []model.APIKeyEntry
+	modTime  time.Time
+}
+
+func NewAPIKeysManager(filePath string) *APIKeysManager {
+	mgr := &APIKeysManager{filePath: filePath}
+	if err := mgr.reload(); err != nil {
+		logger.Errorf(logger.CatAdmin, "解析 API Key 文件失败: %v", err)
+	}
+	return mgr
+}
+
+// reload 读取文件；文件不存在视为空列表，解析或校验失败时保留现有列表
+func (m *APIKeysManager) reload() error {
+	info, err := os.Stat(m.filePath)
+	if err != nil {
+		m.mu.Lock()
+		m.keys = nil
+		m.modTime = time.Time{}
+		m.mu.Unlock()
+		return nil
+	}
+	data, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return err
+	}
+	var keys []model.APIKeyEntry
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := keys[i].Compile(); err != nil {
+			return fmt.Errorf("API Key %s: %w", keys[i].Name, err)
+		}
+	}
+	m.mu.Lock()
+	m.keys = keys
+	m.modTime = info.ModTime()
+	m.mu.Unlock()
+	return nil
+}
+
+// saveLocked 写回文件，调用方需持有写锁
+func (m *APIKeysManager) saveLocked() error {
+	data, err := json.MarshalIndent(m.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.filePath), 0755); err != nil {
+		return err
+	}
+	// 含明文 key，仅所有者可读
+	if err := os.WriteFile(m.filePath, data, 0600); err != nil {
+		return err
+	}
+	if info, err := os.Stat(m.filePath); err == nil {
+		m.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Watch 定期检查文件修改时间，变化时重新加载
+func (m *APIKeysManager) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		var modTime time.Time
+		if info, err := os.Stat(m.filePath); err == nil {
+			modTime = info.ModTime()
+		}
+		m.mu.RLock()
+		changed := !modTime.Equal(m.modTime)
+		m.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := m.reload(); err != nil {
+			logger.Errorf(logger.CatAdmin, "API Key 文件已修改但解析失败，保留原配置: %v", err)
+			continue
+		}
+		logger.Infof(logger.CatAdmin, "API Key 文件已重新加载: %d 个", m.Count())
+	}
+}
+
+// Lookup 按 key 查找（常量时间比较），返回副本；未找到返回 nil
+func (m *APIKeysManager) Lookup(key string) *model.APIKeyEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.keys {
+		if m.keys[i].Key != "" && subtle.ConstantTimeCompare([]byte(m.keys[i].Key), []byte(key)) == 1 {
+			entry := m.keys[i]
+			return &entry
+		}
+	}
+	return nil
+}
+
+// Count 已配置的 Key 数量
+func (m *APIKeysManager) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.keys)
+}
+
+// List 返回全部条目（key 已脱敏）
+func (m *APIKeysManager) List() []model.APIKeyEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make([]model.APIKeyEntry, len(m.keys))
+	for i, e := range m.keys {
+		e.Key = logger.MaskKey(e.Key)
+		result[i] = e
+	}
+	return result
+}
+
+func (m *APIKeysManager) findLocked(name string) int {
+	for i := range m.keys {
+		if m.keys[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// reservedKeyPrefixes 其他认证方式使用的前缀，AuthMiddleware 会在具名 Key 之前按这些前缀识别，具名 Key 不能以此开头
+var reservedKeyPrefixes = []string{"act-", "creds-", "relay-", "kt-"}
+
+// Save 新增或按 name 更新一个 Key；key 为空时新增会自动生成、更新则保留原 key
+// 返回保存后的完整条目（含明文 key，仅在创建时展示给管理员）
+func (m *APIKeysManager) Save(entry model.APIKeyEntry) (*model.APIKeyEntry, error) {
+	entry.Name = strings.TrimSpace(entry.Name)
+	if entry.Name == "" {
+		return nil, common.NewMessage(common.MsgAPIKeyNameRequired)
+	}
+	if err := entry.Compile(); err != nil {
+		return nil, common.NewMessage(common.MsgAPIKeyInvalid, entry.Name, err.Error())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx := m.findLocked(entry.Name)
+	if entry.Key == "" {
+		if idx >= 0 {
+			entry.Key = m.keys[idx].Key
+		} else {
+			entry.Key = generateAPIKey()
+		}
+	}
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(entry.Key, prefix) {
+			return nil, common.NewMessage(common.MsgInvalidRequest)
+		}
+	}
+	for i := range m.keys {
+		if i != idx && m.keys[i].Key == entry.Key {
+			return nil, common.NewMessage(common.MsgAPIKeyDuplicate, m.keys[i].Name)
+		}
+	}
+
+	if idx >= 0 {
+		entry.CreatedAt = m.keys[idx].CreatedAt
+		m.keys[idx] = entry
+	} else {
+		entry.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		m.keys = append(m.keys, entry)
+	}
+	if err := m.saveLocked(); err != nil {
+		return nil, err
+	}
+	logger.Infof(logger.CatAdmin, "API Key 已保存: %s", entry.Name)
+	return &entry, nil
+}
+
+// SetDisabled 吊销 / 恢复
+func (m *APIKeysManager) SetDisabled(name string, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	idx := m.findLocked(name)
+	if idx < 0 {
+		return common.NewMessage(common.MsgAPIKeyNotFound, name)
+	}
+	m.keys[idx].Disabled = disabled
+	if err := m.saveLocked(); err != nil {
+		return err
+	}
+	logger.Infof(logger.CatAdmin, "API Key %s disabled=%v", name, disabled)
+	return nil
+}
+
+// Delete 删除
+func (m *APIKeysManager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	idx := m.findLocked(name)
+	if idx < 0 {
+		return common.NewMessage(common.MsgAPIKeyNotFound, name)
+	}
+	m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
+	if err := m.saveLocked(); err != nil {
+		return err
+	}
+	logger.Infof(logger.CatAdmin, "API Key 已删除: %s", name)
+	return nil
+}
+
+// generateAPIKey 生成随机 Key（sk-kiro- + 48 位十六进制）
+func generateAPIKey() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand: %v", err))
+	}
+	return "sk-kiro-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,87 @@
+package kiro
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+// errorResult 构造失败的 {success, message, code} 响应
+func errorResult(r *http.Request, err error) map[string]interface{} {
+	code, message := common.LocalizeError(r, err)
+	resp := map[string]interface{}{"success": false, "message": message}
+	if code != "" {
+		resp["code"] = code
+	}
+	return resp
+}
+
+// HandleAdminAPIKeys /api/admin/api-keys
+// GET 列出全部 Key（脱敏）；POST 按 name 新增或更新，响应中返回完整 key
+func HandleAdminAPIKeys(w http.ResponseWriter, r *http.Request, km *APIKeysManager) {
+	switch r.Method {
+	case http.MethodGet:
+		common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"keys":    km.List(),
+		})
+	case http.MethodPost:
+		var req model.APIKeyEntry
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgInvalidRequest)))
+			return
+		}
+		entry, err := km.Save(req)
+		if err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, errorResult(r, err))
+			return
+		}
+		common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": common.T(r, common.MsgAPIKeySaved, entry.Name),
+			"key":     entry,
+		})
+	default:
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAdminRevokeAPIKey POST /api/admin/api-keys/revoke {"name": "...", "restore": false}
+// 立即生效：下一个请求起即被拒绝
+func HandleAdminRevokeAPIKey(w http.ResponseWriter, r *http.Request, km *APIKeysManager) {
+	var req struct {
+		Name    string `json:"name"`
+		Restore bool   `json:"restore"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgAPIKeyNameRequired)))
+		return
+	}
+	if err := km.SetDisabled(req.Name, !req.Restore); err != nil {
+		common.WriteJSON(w, http.StatusNotFound, errorResult(r, err))
+		return
+	}
+	msg := common.NewMessage(common.MsgAPIKeyRevokedOK, req.Name)
+	if req.Restore {
+		msg = common.NewMessage(common.MsgAPIKeySaved, req.Name)
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, msg))
+}
+
+// HandleAdminDeleteAPIKey POST /api/admin/api-keys/delete {"name": "..."}
+func HandleAdminDeleteAPIKey(w http.ResponseWriter, r *http.Request, km *APIKeysManager) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgAPIKeyNameRequired)))
+		return
+	}
+	if err := km.Delete(req.Name); err != nil {
+		common.WriteJSON(w, http.StatusNotFound, errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgAPIKeyDeleted, req.Name)))
+}
@@ -0,0 +1,132 @@
+package kiro
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+// HandleIssueClientToken POST /api/token（经 AuthMiddleware，需以 act- 激活码认证）
+// 请求体可选：{"ttl": 秒, "models": [...], "endpoints": [...], "rateClass": "..."}
+func HandleIssueClientToken(w http.ResponseWriter, r *http.Request, signer *common.ClientTokenSigner, cfg *model.Config) {
+	if r.Method != http.MethodPost {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if signer == nil {
+		common.WriteJSON(w, http.StatusNotFound, codeResult(r, false, common.NewMessage(common.MsgClientTokenDisabled)))
+		return
+	}
+	if common.GetClientTokenFromContext(r) != nil {
+		common.WriteJSON(w, http.StatusForbidden, codeResult(r, false, common.NewMessage(common.MsgTokenNoReissue)))
+		return
+	}
+	code := common.GetActCodeFromContext(r)
+	if code == "" {
+		common.WriteJSON(w, http.StatusForbidden, codeResult(r, false, common.NewMessage(common.MsgTokenNeedsCode)))
+		return
+	}
+
+	var req struct {
+		TTL int `json:"ttl"`
+		model.TokenScope
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgInvalidRequest)))
+			return
+		}
+	}
+	if req.RateClass != "" {
+		if _, ok := cfg.RateClasses[req.RateClass]; !ok {
+			common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgRateClassUnknown, req.RateClass)))
+			return
+		}
+	}
+
+	token, claims, err := signer.Issue(code, req.TokenScope, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		common.WriteJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"token":      token,
+		"jti":        claims.ID,
+		"expires_at": time.Unix(claims.ExpiresAt, 0).Format(time.RFC3339),
+		"scope":      claims.Scope,
+	})
+}
+
+// HandleClientTokenPublicKey GET /api/token/public-key（EdDSA 时供第三方离线校验）
+func HandleClientTokenPublicKey(w http.ResponseWriter, r *http.Request, signer *common.ClientTokenSigner) {
+	if signer == nil {
+		common.WriteJSON(w, http.StatusNotFound, codeResult(r, false, common.NewMessage(common.MsgClientTokenDisabled)))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"algorithm": signer.Algorithm(),
+		"publicKey": signer.PublicKey(),
+	})
+}
+
+// HandleAdminRevokedTokens GET /api/admin/tokens/revoked
+func HandleAdminRevokedTokens(w http.ResponseWriter, r *http.Request, deny *TokenDenyList) {
+	tokens, codes := deny.Snapshot()
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tokens":  tokens,
+		"codes":   codes,
+	})
+}
+
+// HandleAdminRevokeToken POST /api/admin/tokens/revoke
+// {"token": "kt-..."} 或 {"jti": "..."} 吊销单个令牌；{"code": "..."} 吊销激活码此前签发的全部令牌
+func HandleAdminRevokeToken(w http.ResponseWriter, r *http.Request, deny *TokenDenyList, signer *common.ClientTokenSigner) {
+	var req struct {
+		Token string `json:"token"`
+		JTI   string `json:"jti"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Token == "" && req.JTI == "" && req.Code == "") {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgTokenRevokeTarget)))
+		return
+	}
+
+	if req.Code != "" {
+		if err := deny.RevokeCode(req.Code); err != nil {
+			common.WriteJSON(w, http.StatusInternalServerError, errorResult(r, err))
+			return
+		}
+		common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgCodeTokensRevoked, req.Code)))
+		return
+	}
+
+	// 只有 jti 时不知道令牌的过期时间，按最长有效期保留
+	jti := req.JTI
+	expiresAt := time.Now().Add(7 * 24 * time.Hour).Unix()
+	if signer != nil {
+		expiresAt = time.Now().Add(signer.MaxTTL()).Unix()
+	}
+	if req.Token != "" {
+		if signer == nil {
+			common.WriteJSON(w, http.StatusNotFound, codeResult(r, false, common.NewMessage(common.MsgClientTokenDisabled)))
+			return
+		}
+		claims, err := signer.Verify(req.Token)
+		if err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, errorResult(r, err))
+			return
+		}
+		jti, expiresAt = claims.ID, claims.ExpiresAt
+	}
+	if err := deny.RevokeToken(jti, expiresAt); err != nil {
+		common.WriteJSON(w, http.StatusInternalServerError, errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgTokenRevokedOK, jti)))
+}
@@ -7,12 +7,24 @@ import (
 	"strings"
 
 	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
 )
 
+// codeResult 构造 {success, message, code} 响应，message 按请求语言渲染
+// code 为稳定错误码，供远程验证方（AuthMiddleware）按自己的请求语言重新渲染
+func codeResult(r *http.Request, ok bool, msg *common.Message) map[string]interface{} {
+	resp := map[string]interface{}{"success": ok, "message": msg.For(r)}
+	if msg != nil {
+		resp["code"] = string(msg.Code)
+	}
+	return resp
+}
+
 // HandleActivate POST /api/activate
 func HandleActivate(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 	var req struct {
@@ -20,19 +32,30 @@ func HandleActivate(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
 		MachineID string `json:"machineId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "无效请求"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgInvalidRequest)))
 		return
 	}
 	if req.Code == "" {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "缺少激活码"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgMissingActivationCode)))
 		return
 	}
 	if req.MachineID == "" {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "缺少机器码"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgMissingMachineID)))
 		return
 	}
 	ok, msg := cm.Activate(req.Code, req.MachineID)
-	common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": ok, "message": msg})
+	resp := codeResult(r, ok, msg)
+	if ok {
+		withLicense(resp, cm, req.Code)
+	}
+	common.WriteJSON(w, http.StatusOK, resp)
+}
+
+// withLicense 验证通过时附带签名许可证，供代理在验证服务不可达时离线校验
+func withLicense(resp map[string]interface{}, cm *CodesManager, code string) {
+	if license := cm.IssueLicense(code); license != "" {
+		resp["license"] = license
+	}
 }
 
 // HandleCodeValidate POST /api/code/validate
@@ -40,7 +63,7 @@ func HandleActivate(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
 // 用于 API 访问鉴权（Cursor 等客户端不发送 machineId）
 func HandleCodeValidate(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 	var req struct {
@@ -48,22 +71,41 @@ func HandleCodeValidate(w http.ResponseWriter, r *http.Request, cm *CodesManager
 		MachineID string `json:"machineId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": false, "message": "缺少参数"})
+		common.WriteJSON(w, http.StatusOK, codeResult(r, false, common.NewMessage(common.MsgMissingParameters)))
 		return
 	}
 	if req.Code == "" {
-		common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": false, "message": "缺少激活码"})
+		common.WriteJSON(w, http.StatusOK, codeResult(r, false, common.NewMessage(common.MsgMissingActivationCode)))
 		return
 	}
 	// 仅检查激活码是否有效（存在且已激活），machineId 为空时跳过设备检查
 	ok, msg := cm.IsValidCode(req.Code, req.MachineID)
-	common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": ok, "message": msg})
+	resp := codeResult(r, ok, msg)
+	if ok {
+		withLicense(resp, cm, req.Code)
+	}
+	common.WriteJSON(w, http.StatusOK, resp)
+}
+
+// HandleLicensePublicKey GET /api/license/public-key
+// 代理方把返回的 publicKey 配置为 licensePublicKey
+func HandleLicensePublicKey(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	signer := cm.LicenseSigner()
+	if signer == nil {
+		common.WriteJSON(w, http.StatusNotFound, map[string]interface{}{"success": false})
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"algorithm": "Ed25519",
+		"publicKey": signer.PublicKey(),
+	})
 }
 
 // HandleTunnelCheck POST /api/tunnel/check
 func HandleTunnelCheck(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 	var req struct {
@@ -71,15 +113,15 @@ func HandleTunnelCheck(w http.ResponseWriter, r *http.Request, cm *CodesManager)
 		MachineID string `json:"machineId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": false, "message": "缺少参数"})
+		common.WriteJSON(w, http.StatusOK, codeResult(r, false, common.NewMessage(common.MsgMissingParameters)))
 		return
 	}
 	if req.Code == "" || req.MachineID == "" {
-		common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": false, "message": "缺少参数"})
+		common.WriteJSON(w, http.StatusOK, codeResult(r, false, common.NewMessage(common.MsgMissingParameters)))
 		return
 	}
 	ok, msg, tunnelDays, expiresAt := cm.CheckTunnel(req.Code, req.MachineID)
-	resp := map[string]interface{}{"success": ok, "message": msg}
+	resp := codeResult(r, ok, msg)
 	if ok {
 		resp["tunnelDays"] = tunnelDays
 		resp["expiresAt"] = expiresAt
@@ -143,34 +185,39 @@ func HandleAdminAddCodes(w http.ResponseWriter, r *http.Request, cm *CodesManage
 		TunnelDays  int      `json:"tunnelDays"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "无效请求"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgInvalidRequest)))
 		return
 	}
 	if len(req.CustomCodes) == 0 && req.Count <= 0 {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "请提供 count 或 customCodes"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesCountRequired)))
 		return
 	}
 	added := cm.AddCodes(req.CustomCodes, req.Count, req.TunnelDays)
+	// 之前按"无效"缓存的自定义激活码立即生效
+	common.InvalidateActivationCache(added...)
 	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"message": fmt.Sprintf("成功添加 %d 个卡密", len(added)),
+		"message": common.T(r, common.MsgCodesAdded, len(added)),
 		"added":   added,
 	})
 }
 
 // HandleAdminDeleteCodes POST /api/admin/codes/delete
-func HandleAdminDeleteCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+// 同时吊销这些激活码已签发的 kt- 令牌
+func HandleAdminDeleteCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager, deny *TokenDenyList) {
 	var req struct {
 		CodesToDelete []string `json:"codesToDelete"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.CodesToDelete) == 0 {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "请提供 codesToDelete 数组"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesDeleteRequired)))
 		return
 	}
 	count := cm.DeleteCodes(req.CodesToDelete)
+	common.InvalidateActivationCache(req.CodesToDelete...)
+	revokeCodeTokens(deny, req.CodesToDelete)
 	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"message": fmt.Sprintf("成功删除 %d 个卡密", count),
+		"message": common.T(r, common.MsgCodesDeleted, count),
 	})
 }
 
@@ -181,29 +228,140 @@ func HandleAdminUpdateCodes(w http.ResponseWriter, r *http.Request, cm *CodesMan
 		TunnelDays    *int     `json:"tunnelDays"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TunnelDays == nil {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "请提供 tunnelDays"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesTunnelDaysReq)))
 		return
 	}
 	count := cm.UpdateTunnelDays(req.CodesToUpdate, *req.TunnelDays)
 	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"message": fmt.Sprintf("成功更新 %d 个卡密", count),
+		"message": common.T(r, common.MsgCodesUpdated, count),
+	})
+}
+
+// HandleAdminRateLimitCodes POST /api/admin/codes/rate-limit
+// rateLimit 为 null 时清除激活码自身的配置，回到 config.rateLimit 默认值
+func HandleAdminRateLimitCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	var req struct {
+		Codes     []string         `json:"codes"`
+		RateLimit *model.RateLimit `json:"rateLimit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Codes) == 0 {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesListRequired)))
+		return
+	}
+	count, err := cm.SetRateLimit(req.Codes, req.RateLimit)
+	if err != nil {
+		common.WriteJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": common.T(r, common.MsgCodesUpdated, count),
+	})
+}
+
+// HandleAdminQuotaCodes POST /api/admin/codes/quota
+// quota 为 null 时清除激活码自身的配置，回到 config.usageQuota 默认值
+func HandleAdminQuotaCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	var req struct {
+		Codes []string          `json:"codes"`
+		Quota *model.UsageQuota `json:"quota"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Codes) == 0 {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesListRequired)))
+		return
+	}
+	count, err := cm.SetQuota(req.Codes, req.Quota)
+	if err != nil {
+		common.WriteJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": common.T(r, common.MsgCodesUpdated, count),
+	})
+}
+
+// HandleAdminCodeDevices GET /api/admin/codes/devices?code=
+func HandleAdminCodeDevices(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	code := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("code")))
+	if code == "" {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgMissingActivationCode)))
+		return
+	}
+	devices, policy, lastUnbindAt, ok := cm.ListDevices(code)
+	if !ok {
+		common.WriteJSON(w, http.StatusNotFound, codeResult(r, false, common.NewMessage(common.MsgCodeNotFound, code)))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"code":         code,
+		"devices":      devices,
+		"policy":       policy,
+		"lastUnbindAt": lastUnbindAt,
+	})
+}
+
+// HandleAdminUnbindDevices POST /api/admin/codes/devices/unbind {"code": "...", "machineId": "..."}
+// machineId 为空时解绑全部设备
+func HandleAdminUnbindDevices(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	var req struct {
+		Code      string `json:"code"`
+		MachineID string `json:"machineId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgMissingActivationCode)))
+		return
+	}
+	count, err := cm.UnbindDevices(req.Code, req.MachineID)
+	if err != nil {
+		common.WriteJSON(w, http.StatusNotFound, errorResult(r, err))
+		return
+	}
+	common.InvalidateActivationCache(req.Code)
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgDevicesUnbound, count)))
+}
+
+// HandleAdminDevicePolicyCodes POST /api/admin/codes/device-policy
+// devicePolicy 为 null 时清除激活码自身的配置，回到 config.devicePolicy 默认值
+func HandleAdminDevicePolicyCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	var req struct {
+		Codes        []string            `json:"codes"`
+		DevicePolicy *model.DevicePolicy `json:"devicePolicy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Codes) == 0 {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesListRequired)))
+		return
+	}
+	count, err := cm.SetDevicePolicy(req.Codes, req.DevicePolicy)
+	if err != nil {
+		common.WriteJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	common.InvalidateActivationCache(req.Codes...)
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": common.T(r, common.MsgCodesUpdated, count),
 	})
 }
 
 // HandleAdminResetCodes POST /api/admin/codes/reset
-func HandleAdminResetCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+// 重置前签发的 kt- 令牌一并吊销，重新激活后可再签发
+func HandleAdminResetCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager, deny *TokenDenyList) {
 	var req struct {
 		CodesToReset []string `json:"codesToReset"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.CodesToReset) == 0 {
-		common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "请提供 codesToReset 数组"})
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesResetRequired)))
 		return
 	}
 	count := cm.ResetCodes(req.CodesToReset)
+	common.InvalidateActivationCache(req.CodesToReset...)
+	revokeCodeTokens(deny, req.CodesToReset)
 	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"message": fmt.Sprintf("成功重置 %d 个卡密", count),
+		"message": common.T(r, common.MsgCodesReset, count),
 	})
 }
 
@@ -227,6 +385,18 @@ func HandleAdminCodesRouter(w http.ResponseWriter, r *http.Request, cm *CodesMan
 	case http.MethodPost:
 		HandleAdminAddCodes(w, r, cm)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// revokeCodeTokens 吊销激活码已签发的全部令牌；写吊销列表失败只记日志，不影响删除 / 重置结果
+func revokeCodeTokens(deny *TokenDenyList, codes []string) {
+	if deny == nil {
+		return
+	}
+	for _, code := range codes {
+		if err := deny.RevokeCode(strings.TrimSpace(code)); err != nil {
+			logger.Errorf(logger.CatAdmin, "吊销激活码 %s 的令牌失败: %v", logger.MaskKey(code), err)
+		}
 	}
 }
@@ -10,8 +10,11 @@ import (
 	"sync"
 	"time"
 
+	"kiro-go/internal/common"
 	"kiro-go/internal/logger"
 	"kiro-go/internal/model"
+	"kiro-go/internal/secrets"
+	"kiro-go/internal/store"
 )
 
 // CodeEntry 卡密条目（兼容 app.js 的 codes.json 格式）
@@ -24,6 +27,14 @@ type CodeEntry struct {
 	ExpiresDate string                 `json:"expiresDate,omitempty"` // API 使用有效期（YYYY-MM-DD 格式）
 	UserName    string                 `json:"userName,omitempty"`    // 用户名
 	Credentials *model.KiroCredentials `json:"credentials,omitempty"` // Kiro 用户凭证（AccessToken、RefreshToken 等）
+	RateLimit   *model.RateLimit       `json:"rateLimit,omitempty"`   // 限流配置，非 0 字段覆盖 config.rateLimit
+	Quota       *model.UsageQuota      `json:"quota,omitempty"`       // 用量配额，非 0 字段覆盖 config.usageQuota
+	// 设备绑定：策略非 0 字段覆盖 config.devicePolicy；devices 为 API 请求绑定过的设备
+	DevicePolicy *model.DevicePolicy `json:"devicePolicy,omitempty"`
+	Devices      []BoundDevice       `json:"devices,omitempty"`
+	LastUnbindAt string              `json:"lastUnbindAt,omitempty"` // 最近一次解绑时间（RFC3339），用于换绑冷却
+	// 用户凭证共享组：额度用尽时按 config.sharingGroups 中该组的规则借用凭证，空表示未加入任何组
+	SharingGroup string `json:"sharingGroup,omitempty"`
 }
 
 // CodesManager 卡密管理器
@@ -31,6 +42,10 @@ type CodesManager struct {
 	filePath string
 	codes    []CodeEntry
 	mu       sync.RWMutex
+	license  *common.LicenseSigner // 非 nil 时验证通过的响应附带签名许可证
+	// 默认设备策略（config.devicePolicy）
+	devicePolicy *model.DevicePolicy
+	store        *store.Store // 非 nil 时写入嵌入式存储而不是 codes.json
 }
 
 func NewCodesManager(filePath string) *CodesManager {
@@ -46,6 +61,10 @@ func (m *CodesManager) loadFromFile() {
 		m.codes = []CodeEntry{}
 		return
 	}
+	// 解密失败时不能继续（否则下次保存会用空列表覆盖文件）
+	if data, err = secrets.OpenJSON(data); err != nil {
+		logger.Fatalf(logger.CatAdmin, "解密卡密文件失败 %s: %v", m.filePath, err)
+	}
 	if err := json.Unmarshal(data, &m.codes); err != nil {
 		logger.Errorf(logger.CatAdmin, "解析卡密文件失败: %v", err)
 		m.codes = []CodeEntry{}
@@ -53,10 +72,16 @@ func (m *CodesManager) loadFromFile() {
 }
 
 func (m *CodesManager) saveToFile() error {
+	if m.store != nil {
+		return m.saveToStore()
+	}
 	data, err := json.MarshalIndent(m.codes, "", "  ")
 	if err != nil {
 		return err
 	}
+	if data, err = secrets.SealJSON(data); err != nil {
+		return err
+	}
 	// 确保父目录存在
 	dir := filepath.Dir(m.filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -77,77 +102,107 @@ func (m *CodesManager) FindByCode(code string) *CodeEntry {
 }
 
 // Activate 激活码激活（绑定机器）
-func (m *CodesManager) Activate(code, machineId string) (bool, string) {
+func (m *CodesManager) Activate(code, machineId string) (bool, *common.Message) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	trimmed := strings.ToUpper(strings.TrimSpace(code))
 	entry := m.FindByCode(trimmed)
 	if entry == nil {
-		return false, "激活码无效"
+		return false, common.NewMessage(common.MsgCodeInvalid)
 	}
 	if entry.Active && entry.MachineID != nil && *entry.MachineID != machineId {
-		return false, "该激活码已被其他设备使用"
+		return false, common.NewMessage(common.MsgCodeInUse)
 	}
 	if entry.Active && entry.MachineID != nil && *entry.MachineID == machineId {
-		return true, "已激活"
+		return true, common.NewMessage(common.MsgCodeAlreadyActive)
 	}
 	entry.Active = true
 	entry.MachineID = &machineId
 	now := time.Now().UTC().Format(time.RFC3339)
 	entry.ActivatedAt = &now
 	m.saveToFile()
-	return true, "激活成功"
+	return true, common.NewMessage(common.MsgCodeActivated)
 }
 
 // CheckTunnel 检查穿透权限
-func (m *CodesManager) CheckTunnel(code, machineId string) (bool, string, int, string) {
+func (m *CodesManager) CheckTunnel(code, machineId string) (bool, *common.Message, int, string) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	trimmed := strings.ToUpper(strings.TrimSpace(code))
 	entry := m.FindByCode(trimmed)
 	if entry == nil {
-		return false, "激活码无效", 0, ""
+		return false, common.NewMessage(common.MsgCodeInvalid), 0, ""
 	}
 	if !entry.Active || entry.MachineID == nil || *entry.MachineID != machineId {
-		return false, "激活码未激活或设备不匹配", 0, ""
+		return false, common.NewMessage(common.MsgTunnelDeviceMismatch), 0, ""
 	}
 	if entry.TunnelDays <= 0 {
-		return false, "您的账户暂无内网穿透权限，请联系管理员开通", 0, ""
+		return false, common.NewMessage(common.MsgTunnelNotPermitted), 0, ""
 	}
 	if entry.ActivatedAt == nil {
-		return false, "激活时间异常", 0, ""
+		return false, common.NewMessage(common.MsgTunnelActivatedAtBad), 0, ""
 	}
 	activatedAt, err := time.Parse(time.RFC3339, *entry.ActivatedAt)
 	if err != nil {
-		return false, "激活时间解析失败", 0, ""
+		return false, common.NewMessage(common.MsgTunnelActivatedAtBad), 0, ""
 	}
 	expiresAt := activatedAt.Add(time.Duration(entry.TunnelDays) * 24 * time.Hour)
 	if time.Now().After(expiresAt) {
-		return false, fmt.Sprintf("内网穿透权限已过期（过期时间：%s）", expiresAt.Format("2006-01-02 15:04:05")), 0, ""
+		return false, common.NewMessage(common.MsgTunnelExpired, expiresAt.Format("2006-01-02 15:04:05")), 0, ""
 	}
-	return true, "验证通过", entry.TunnelDays, expiresAt.Format(time.RFC3339)
+	return true, common.NewMessage(common.MsgTunnelOK), entry.TunnelDays, expiresAt.Format(time.RFC3339)
 }
 
 // IsValidCode 检查激活码是否有效（不检查穿透权限，仅检查激活状态）
-func (m *CodesManager) IsValidCode(code, machineId string) (bool, string) {
+// 有效时返回的 *common.Message 为 nil
+func (m *CodesManager) IsValidCode(code, machineId string) (bool, *common.Message) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	trimmed := strings.ToUpper(strings.TrimSpace(code))
 	entry := m.FindByCode(trimmed)
 	if entry == nil {
-		return false, "激活码无效"
+		return false, common.NewMessage(common.MsgCodeInvalid)
 	}
 	if !entry.Active {
-		return false, "激活码未激活"
+		return false, common.NewMessage(common.MsgCodeInactive)
 	}
 	// machineId 为空时跳过设备检查（兼容无 machineId 的场景）
-	if machineId != "" && entry.MachineID != nil && *entry.MachineID != machineId {
-		return false, "激活码已被其他设备使用"
+	// 启用设备策略时多设备绑定由 CheckDevice 负责，这里不再按激活时的单一设备拒绝
+	if machineId != "" && entry.MachineID != nil && *entry.MachineID != machineId && !m.devicePolicyLocked(entry).Enforced() {
+		return false, common.NewMessage(common.MsgCodeInUse)
+	}
+	return true, nil
+}
+
+// SetLicenseSigner 启用许可证签发
+func (m *CodesManager) SetLicenseSigner(signer *common.LicenseSigner) {
+	m.license = signer
+}
+
+// LicenseSigner 当前的许可证签发器（未启用为 nil）
+func (m *CodesManager) LicenseSigner() *common.LicenseSigner {
+	return m.license
+}
+
+// IssueLicense 为有效的激活码签发离线许可证（绑定激活时的设备与激活码有效期），未启用签发时返回空
+func (m *CodesManager) IssueLicense(code string) string {
+	if m.license == nil {
+		return ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry := m.FindByCode(code)
+	if entry == nil || !entry.Active {
+		return ""
+	}
+	machineID := ""
+	if entry.MachineID != nil {
+		machineID = *entry.MachineID
 	}
-	return true, ""
+	return m.license.Issue(entry.Code, machineID, entry.ExpiresDate)
 }
 
 // GetAll 获取所有卡密
@@ -296,7 +351,7 @@ func (m *CodesManager) SetCredentials(code, userName string, credentials *model.
 
 	entry := m.FindByCode(code)
 	if entry == nil {
-		return fmt.Errorf("激活码不存在: %s", code)
+		return common.NewMessage(common.MsgCodeNotFound, code)
 	}
 
 	entry.Credentials = credentials
@@ -322,6 +377,84 @@ func (m *CodesManager) GetCredentials(code string) *model.KiroCredentials {
 	return entry.Credentials
 }
 
+// GetRateLimit 获取激活码自身的限流配置（未配置返回 nil）
+func (m *CodesManager) GetRateLimit(code string) *model.RateLimit {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry := m.FindByCode(strings.TrimPrefix(strings.ToUpper(code), "ACT-"))
+	if entry == nil || entry.RateLimit == nil {
+		return nil
+	}
+	limit := *entry.RateLimit
+	return &limit
+}
+
+// SetRateLimit 批量设置激活码的限流配置，limit 为 nil 时清除（使用默认值）
+func (m *CodesManager) SetRateLimit(codes []string, limit *model.RateLimit) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := 0
+	for _, code := range codes {
+		entry := m.FindByCode(code)
+		if entry == nil {
+			continue
+		}
+		if limit != nil {
+			copied := *limit
+			entry.RateLimit = &copied
+		} else {
+			entry.RateLimit = nil
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		return updated, m.saveToFile()
+	}
+	return 0, nil
+}
+
+// GetQuota 获取激活码自身的用量配额（未配置返回 nil）
+func (m *CodesManager) GetQuota(code string) *model.UsageQuota {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry := m.FindByCode(strings.TrimPrefix(strings.ToUpper(code), "ACT-"))
+	if entry == nil || entry.Quota == nil {
+		return nil
+	}
+	quota := *entry.Quota
+	return &quota
+}
+
+// SetQuota 批量设置激活码的用量配额，quota 为 nil 时清除（使用默认值）
+func (m *CodesManager) SetQuota(codes []string, quota *model.UsageQuota) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := 0
+	for _, code := range codes {
+		entry := m.FindByCode(code)
+		if entry == nil {
+			continue
+		}
+		if quota != nil {
+			copied := *quota
+			entry.Quota = &copied
+		} else {
+			entry.Quota = nil
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		return updated, m.saveToFile()
+	}
+	return 0, nil
+}
+
 // UpdateTunnelDays 批量更新穿透天数
 func (m *CodesManager) UpdateTunnelDays(codesToUpdate []string, tunnelDays int) int {
 	m.mu.Lock()
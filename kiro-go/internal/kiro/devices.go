@@ -0,0 +1,214 @@
+package kiro
+
+import (
+	"strings"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+// deviceSeenInterval lastSeen 的最小更新间隔，避免每个请求都写 codes.json
+const deviceSeenInterval = time.Hour
+
+// BoundDevice 激活码绑定的设备
+type BoundDevice struct {
+	MachineID string `json:"machineId"`
+	FirstSeen string `json:"firstSeen"`
+	LastSeen  string `json:"lastSeen"`
+}
+
+// seedDevicesLocked 旧数据只有激活时绑定的 machineId，作为第一台设备
+func seedDevicesLocked(entry *CodeEntry) {
+	if len(entry.Devices) == 0 && entry.MachineID != nil && *entry.MachineID != "" {
+		at := time.Now().UTC().Format(time.RFC3339)
+		if entry.ActivatedAt != nil {
+			at = *entry.ActivatedAt
+		}
+		entry.Devices = []BoundDevice{{MachineID: *entry.MachineID, FirstSeen: at, LastSeen: at}}
+	}
+}
+
+// SetDefaultDevicePolicy 设置默认设备策略（config.devicePolicy）
+func (m *CodesManager) SetDefaultDevicePolicy(policy *model.DevicePolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devicePolicy = policy
+}
+
+// devicePolicyLocked 激活码的生效设备策略
+func (m *CodesManager) devicePolicyLocked(entry *CodeEntry) model.DevicePolicy {
+	return m.devicePolicy.Merge(entry.DevicePolicy)
+}
+
+// CheckDevice 按设备策略检查 API 请求的 machineId，新设备在名额内自动绑定；通过返回 nil
+// 激活码不在本地 codes.json（远程验证模式）或未启用设备限制时不检查。
+// 先在读锁下检查，只有需要绑定新设备、补齐旧数据或更新过期的 lastSeen 时才取写锁
+func (m *CodesManager) CheckDevice(code, machineId string) *common.Message {
+	m.mu.RLock()
+	msg, needWrite := m.checkDeviceLocked(code, machineId, false)
+	m.mu.RUnlock()
+	if !needWrite {
+		return msg
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, _ = m.checkDeviceLocked(code, machineId, true)
+	return msg
+}
+
+// checkDeviceLocked CheckDevice 的检查逻辑；write 为 false 时只读（调用方持有读锁），
+// 需要修改时返回 needWrite，由调用方在写锁下以 write 为 true 重新检查
+func (m *CodesManager) checkDeviceLocked(code, machineId string, write bool) (msg *common.Message, needWrite bool) {
+	entry := m.FindByCode(strings.TrimPrefix(strings.ToUpper(code), "ACT-"))
+	if entry == nil {
+		return nil, false
+	}
+	policy := m.devicePolicyLocked(entry)
+	if !policy.Enforced() {
+		return nil, false
+	}
+	if machineId == "" {
+		return common.NewMessage(common.MsgDeviceIDRequired), false
+	}
+	if len(policy.AllowedMachineIDs) > 0 && !containsString(policy.AllowedMachineIDs, machineId) {
+		return common.NewMessage(common.MsgDeviceNotAllowed), false
+	}
+
+	if len(entry.Devices) == 0 && entry.MachineID != nil && *entry.MachineID != "" {
+		if !write {
+			return nil, true
+		}
+		seedDevicesLocked(entry)
+	}
+	now := time.Now().UTC()
+	for i := range entry.Devices {
+		d := &entry.Devices[i]
+		if d.MachineID != machineId {
+			continue
+		}
+		if last, err := time.Parse(time.RFC3339, d.LastSeen); err != nil || now.Sub(last) > deviceSeenInterval {
+			if !write {
+				return nil, true
+			}
+			d.LastSeen = now.Format(time.RFC3339)
+			m.saveToFile()
+		}
+		return nil, false
+	}
+
+	if policy.MaxDevices > 0 && len(entry.Devices) >= policy.MaxDevices {
+		return common.NewMessage(common.MsgDeviceLimit, policy.MaxDevices), false
+	}
+	if policy.RebindCooldown > 0 && entry.LastUnbindAt != "" {
+		if unbound, err := time.Parse(time.RFC3339, entry.LastUnbindAt); err == nil {
+			if until := unbound.Add(time.Duration(policy.RebindCooldown) * time.Second); now.Before(until) {
+				return common.NewMessage(common.MsgDeviceCooldown, until.Local().Format("2006-01-02 15:04:05")), false
+			}
+		}
+	}
+	if !write {
+		return nil, true
+	}
+
+	stamp := now.Format(time.RFC3339)
+	entry.Devices = append(entry.Devices, BoundDevice{MachineID: machineId, FirstSeen: stamp, LastSeen: stamp})
+	m.saveToFile()
+	logger.InfoFields(logger.CatAdmin, "激活码绑定新设备", logger.F{
+		"code":       logger.MaskKey(entry.Code),
+		"machine_id": logger.MaskKey(machineId),
+		"devices":    len(entry.Devices),
+	})
+	return nil, false
+}
+
+// ListDevices 激活码绑定的设备与生效策略
+func (m *CodesManager) ListDevices(code string) ([]BoundDevice, model.DevicePolicy, string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.FindByCode(code)
+	if entry == nil {
+		return nil, model.DevicePolicy{}, "", false
+	}
+	seedDevicesLocked(entry)
+	devices := make([]BoundDevice, len(entry.Devices))
+	copy(devices, entry.Devices)
+	return devices, m.devicePolicyLocked(entry), entry.LastUnbindAt, true
+}
+
+// UnbindDevices 解绑设备，machineId 为空时解绑全部；激活时绑定的设备被解绑后可重新激活
+func (m *CodesManager) UnbindDevices(code, machineId string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.FindByCode(code)
+	if entry == nil {
+		return 0, common.NewMessage(common.MsgCodeNotFound, code)
+	}
+	seedDevicesLocked(entry)
+	kept := entry.Devices[:0]
+	removed := 0
+	for _, d := range entry.Devices {
+		if machineId == "" || d.MachineID == machineId {
+			removed++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	entry.Devices = kept
+	if removed == 0 {
+		return 0, nil
+	}
+	if entry.MachineID != nil && (machineId == "" || *entry.MachineID == machineId) {
+		if len(kept) > 0 {
+			next := kept[0].MachineID
+			entry.MachineID = &next
+		} else {
+			entry.MachineID = nil
+		}
+	}
+	entry.LastUnbindAt = time.Now().UTC().Format(time.RFC3339)
+	logger.InfoFields(logger.CatAdmin, "激活码解绑设备", logger.F{
+		"code":    logger.MaskKey(entry.Code),
+		"removed": removed,
+	})
+	return removed, m.saveToFile()
+}
+
+// SetDevicePolicy 批量设置激活码的设备策略，policy 为 nil 时清除（使用默认值）
+func (m *CodesManager) SetDevicePolicy(codes []string, policy *model.DevicePolicy) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := 0
+	for _, code := range codes {
+		entry := m.FindByCode(code)
+		if entry == nil {
+			continue
+		}
+		if policy != nil {
+			copied := *policy
+			entry.DevicePolicy = &copied
+		} else {
+			entry.DevicePolicy = nil
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		return updated, m.saveToFile()
+	}
+	return 0, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -24,6 +24,13 @@ type Event struct {
 	// ContextUsage
 	ContextUsagePercentage float64
 
+	// Metering / Metadata（上游上报的用量，字段缺失时为 0）
+	MeteringUsage         float64 // 本次请求消耗的 credit
+	InputTokens           int
+	OutputTokens          int
+	CacheReadInputTokens  int
+	CacheWriteInputTokens int
+
 	// Error/Exception
 	ErrorCode     string
 	ErrorMessage  string
@@ -91,11 +98,61 @@ func parseEventFrame(frame *parser.Frame) (*Event, error) {
 		}
 		return &Event{Type: "context_usage", ContextUsagePercentage: payload.ContextUsagePercentage}, nil
 
-	case "meteringEvent":
-		return &Event{Type: "metering"}, nil
+	case "meteringEvent", "metadataEvent":
+		return parseUsageEvent(frame.Payload), nil
 
 	default:
 		logger.Debugf(logger.CatStream, "未知事件类型: %s", eventType)
 		return &Event{Type: "unknown"}, nil
 	}
 }
+
+// usagePayload meteringEvent / metadataEvent 中的用量字段
+// token 用量可能在顶层，也可能嵌套在 tokenUsage / usage 中
+type usagePayload struct {
+	Usage                 json.RawMessage `json:"usage"`
+	TokenUsage            *usagePayload   `json:"tokenUsage"`
+	InputTokens           int             `json:"inputTokens"`
+	UncachedInputTokens   int             `json:"uncachedInputTokens"`
+	OutputTokens          int             `json:"outputTokens"`
+	CacheReadInputTokens  int             `json:"cacheReadInputTokens"`
+	CacheWriteInputTokens int             `json:"cacheWriteInputTokens"`
+	CacheCreationTokens   int             `json:"cacheCreationInputTokens"`
+}
+
+// parseUsageEvent 解析用量事件；payload 格式不固定，解析失败时返回空的 metering 事件
+func parseUsageEvent(data []byte) *Event {
+	event := &Event{Type: "metering"}
+	var payload usagePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		logger.Debugf(logger.CatStream, "解析用量事件失败: %v", err)
+		return event
+	}
+	// usage 为数字时是 credit 用量，为对象时是 token 用量
+	if len(payload.Usage) > 0 {
+		var credits float64
+		if json.Unmarshal(payload.Usage, &credits) == nil {
+			event.MeteringUsage = credits
+		} else {
+			var nested usagePayload
+			if json.Unmarshal(payload.Usage, &nested) == nil && payload.TokenUsage == nil {
+				payload.TokenUsage = &nested
+			}
+		}
+	}
+	tokens := &payload
+	if payload.TokenUsage != nil {
+		tokens = payload.TokenUsage
+	}
+	event.InputTokens = tokens.InputTokens
+	if event.InputTokens == 0 {
+		event.InputTokens = tokens.UncachedInputTokens
+	}
+	event.OutputTokens = tokens.OutputTokens
+	event.CacheReadInputTokens = tokens.CacheReadInputTokens
+	event.CacheWriteInputTokens = tokens.CacheWriteInputTokens
+	if event.CacheWriteInputTokens == 0 {
+		event.CacheWriteInputTokens = tokens.CacheCreationTokens
+	}
+	return event
+}
@@ -0,0 +1,309 @@
+package kiro
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+const (
+	defaultModelCatalogTTL = 10 * time.Minute
+	catalogRetryInterval   = 1 * time.Minute // 拉取失败后的最短重试间隔
+)
+
+// ModelInfo ListAvailableModels 返回的单个模型
+type ModelInfo struct {
+	ModelID             string   `json:"modelId"`
+	ModelName           string   `json:"modelName"`
+	Description         string   `json:"description"`
+	RateMultiplier      float64  `json:"rateMultiplier"`
+	RateUnit            string   `json:"rateUnit"`
+	SupportedInputTypes []string `json:"supportedInputTypes"`
+	TokenLimits         struct {
+		MaxInputTokens  int  `json:"maxInputTokens"`
+		MaxOutputTokens *int `json:"maxOutputTokens"`
+	} `json:"tokenLimits"`
+	PromptCaching struct {
+		SupportsPromptCaching             bool `json:"supportsPromptCaching"`
+		MaximumCacheCheckpointsPerRequest int  `json:"maximumCacheCheckpointsPerRequest"`
+		MinimumTokensPerCacheCheckpoint   int  `json:"minimumTokensPerCacheCheckpoint"`
+	} `json:"promptCaching"`
+}
+
+// SupportsImages 是否接受图片输入（上游未声明输入类型时视为支持）
+func (m *ModelInfo) SupportsImages() bool {
+	if len(m.SupportedInputTypes) == 0 {
+		return true
+	}
+	for _, t := range m.SupportedInputTypes {
+		if t == "IMAGE" {
+			return true
+		}
+	}
+	return false
+}
+
+// ToMap 转换为 /v1/models 格式（保留所有原始字段）
+func (m *ModelInfo) ToMap() map[string]interface{} {
+	maxTokens := 200000
+	if m.TokenLimits.MaxInputTokens > 0 {
+		maxTokens = m.TokenLimits.MaxInputTokens
+	}
+	tokenLimits := map[string]interface{}{
+		"maxInputTokens": m.TokenLimits.MaxInputTokens,
+	}
+	if m.TokenLimits.MaxOutputTokens != nil {
+		tokenLimits["maxOutputTokens"] = *m.TokenLimits.MaxOutputTokens
+	} else {
+		tokenLimits["maxOutputTokens"] = nil
+	}
+	return map[string]interface{}{
+		"id":           m.ModelID,
+		"object":       "model",
+		"created":      time.Now().Unix(),
+		"owned_by":     "anthropic",
+		"display_name": m.ModelName,
+		"type":         "chat",
+		"max_tokens":   maxTokens,
+		// 保留原始 API 字段
+		"modelId":             m.ModelID,
+		"modelName":           m.ModelName,
+		"description":         m.Description,
+		"rateMultiplier":      m.RateMultiplier,
+		"rateUnit":            m.RateUnit,
+		"supportedInputTypes": m.SupportedInputTypes,
+		"tokenLimits":         tokenLimits,
+		"promptCaching": map[string]interface{}{
+			"supportsPromptCaching":             m.PromptCaching.SupportsPromptCaching,
+			"maximumCacheCheckpointsPerRequest": m.PromptCaching.MaximumCacheCheckpointsPerRequest,
+			"minimumTokensPerCacheCheckpoint":   m.PromptCaching.MinimumTokensPerCacheCheckpoint,
+		},
+	}
+}
+
+// catalogEntry 某个 profile 的模型目录
+type catalogEntry struct {
+	list       []*ModelInfo
+	byID       map[string]*ModelInfo
+	fetchedAt  time.Time
+	attemptAt  time.Time
+	cred       *model.KiroCredentials // 用户凭证目录：最近一次使用的凭证
+	pool       bool                   // 主凭证池目录：刷新时从池中取 profileArn 相同的凭据
+	profileArn string
+	done       chan struct{} // 非 nil 表示正在刷新，刷新结束时关闭
+	err        error         // 最近一次刷新的错误
+}
+
+// catalogTarget 一次查询对应的目录与用于拉取的凭据
+type catalogTarget struct {
+	key   string
+	cred  *model.KiroCredentials
+	token string
+	pool  bool
+}
+
+// ModelCatalog 按凭证 profile 缓存的模型目录（TTL 过期后后台刷新，不阻塞请求）
+// 不同账号（Pro / Free、不同 profileArn）可用模型不同，因此按 profileArn + region 分开缓存；
+// 主凭证池按下一个将被使用的凭据的 profileArn 取目录
+type ModelCatalog struct {
+	p       *Provider
+	mu      sync.Mutex
+	entries map[string]*catalogEntry
+}
+
+func newModelCatalog(p *Provider) *ModelCatalog {
+	return &ModelCatalog{p: p, entries: make(map[string]*catalogEntry)}
+}
+
+func (c *ModelCatalog) ttl() time.Duration {
+	if v := c.p.Config.Load().ModelCatalogTTL; v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultModelCatalogTTL
+}
+
+// target cred 为 nil 时（主凭证池）取下一个将被使用的池凭据（不占用轮询位置）
+func (c *ModelCatalog) target(cred *model.KiroCredentials) (catalogTarget, error) {
+	cfg := c.p.Config.Load()
+	if cred != nil {
+		return catalogTarget{key: cred.ProfileArn + "@" + cred.EffectiveRegion(cfg), cred: cred, token: cred.AccessToken}, nil
+	}
+	pooled, token, err := c.p.TokenMgr.Peek()
+	if err != nil {
+		return catalogTarget{}, fmt.Errorf("获取凭证和 token 失败: %w", err)
+	}
+	return catalogTarget{key: "pool:" + pooled.ProfileArn + "@" + pooled.EffectiveRegion(cfg), cred: pooled, token: token, pool: true}, nil
+}
+
+// List 返回模型目录；缓存过期时同步刷新（已有刷新在进行时等待其结束），刷新失败但有旧数据时返回旧数据
+func (c *ModelCatalog) List(cred *model.KiroCredentials) ([]*ModelInfo, error) {
+	t, err := c.target(cred)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	entry := c.entries[t.key]
+	fresh := entry != nil && time.Since(entry.fetchedAt) < c.ttl()
+	c.mu.Unlock()
+	if fresh {
+		return entry.list, nil
+	}
+
+	if err := c.refresh(t); err != nil {
+		if entry != nil && len(entry.list) > 0 {
+			logger.Warnf(logger.CatSystem, "刷新模型目录失败，使用缓存: %v", err)
+			return entry.list, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if list := c.entries[t.key].list; len(list) > 0 {
+		return list, nil
+	}
+	return nil, fmt.Errorf("模型目录为空")
+}
+
+// Lookup 查询模型信息（不阻塞）：目录缺失或过期时触发后台刷新，本次返回已有数据（可能为 nil）
+func (c *ModelCatalog) Lookup(cred *model.KiroCredentials, modelID string) *ModelInfo {
+	t, err := c.target(cred)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	entry := c.entries[t.key]
+	stale := entry == nil ||
+		(time.Since(entry.fetchedAt) >= c.ttl() && time.Since(entry.attemptAt) >= catalogRetryInterval && entry.done == nil)
+	var info *ModelInfo
+	if entry != nil {
+		info = entry.byID[modelID]
+		if !t.pool {
+			entry.cred = cred // 用户凭证 token 会刷新，后台刷新使用最新的凭证
+		}
+	}
+	c.mu.Unlock()
+
+	if stale {
+		go func() {
+			if err := c.refresh(t); err != nil {
+				logger.Warnf(logger.CatSystem, "后台刷新模型目录失败: %v", err)
+			}
+		}()
+	}
+	return info
+}
+
+// Has 任一已缓存的目录中是否有该模型（不触发刷新）
+func (c *ModelCatalog) Has(modelID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.byID[modelID] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh 拉取并替换某个 profile 的目录；同一 profile 同时只有一个刷新在进行，其余调用等待它的结果
+func (c *ModelCatalog) refresh(t catalogTarget) error {
+	c.mu.Lock()
+	entry := c.entries[t.key]
+	if entry == nil {
+		entry = &catalogEntry{pool: t.pool}
+		c.entries[t.key] = entry
+	}
+	if done := entry.done; done != nil {
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return entry.err
+	}
+	done := make(chan struct{})
+	entry.done = done
+	entry.attemptAt = time.Now()
+	if t.cred != nil {
+		entry.profileArn = t.cred.ProfileArn
+		if !t.pool {
+			entry.cred = t.cred
+		}
+	}
+	c.mu.Unlock()
+
+	var models []*ModelInfo
+	var err error
+	if t.cred == nil {
+		err = fmt.Errorf("没有可用于拉取模型目录的凭证")
+	} else if t.token == "" {
+		err = fmt.Errorf("没有可用的 accessToken")
+	} else {
+		models, err = c.p.fetchModels(t.cred, t.token)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.done = nil
+	entry.err = err
+	close(done)
+	if err != nil {
+		return err
+	}
+	entry.list = models
+	entry.byID = make(map[string]*ModelInfo, len(models))
+	for _, m := range models {
+		entry.byID[m.ModelID] = m
+	}
+	entry.fetchedAt = time.Now()
+	logger.Debugf(logger.CatSystem, "模型目录已刷新 [%s]: %d 个模型", t.key, len(models))
+	return nil
+}
+
+// refreshTargets 定时刷新的目标：用户凭证目录用最近一次的凭证，主凭证池目录从池中取 profileArn 相同的凭据
+func (c *ModelCatalog) refreshTargets() []catalogTarget {
+	c.mu.Lock()
+	type snapshot struct {
+		key, profileArn string
+		cred            *model.KiroCredentials
+		pool            bool
+	}
+	snapshots := make([]snapshot, 0, len(c.entries))
+	for key, entry := range c.entries {
+		snapshots = append(snapshots, snapshot{key, entry.profileArn, entry.cred, entry.pool})
+	}
+	c.mu.Unlock()
+
+	targets := make([]catalogTarget, 0, len(snapshots))
+	for _, s := range snapshots {
+		t := catalogTarget{key: s.key, cred: s.cred, pool: s.pool}
+		if s.pool {
+			t.cred, t.token = c.p.TokenMgr.ForProfile(s.profileArn)
+		} else if s.cred != nil {
+			t.token = s.cred.AccessToken
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// StartBackgroundRefresh 启动时预热主凭证池的目录，之后按 TTL 周期刷新所有已缓存的目录
+func (c *ModelCatalog) StartBackgroundRefresh() {
+	go func() {
+		if t, err := c.target(nil); err != nil {
+			logger.Warnf(logger.CatSystem, "预热模型目录失败: %v", err)
+		} else if err := c.refresh(t); err != nil {
+			logger.Warnf(logger.CatSystem, "预热模型目录失败: %v", err)
+		}
+		for {
+			time.Sleep(c.ttl())
+			for _, t := range c.refreshTargets() {
+				if err := c.refresh(t); err != nil {
+					logger.Warnf(logger.CatSystem, "定时刷新模型目录失败 [%s]: %v", t.key, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package kiro
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
+	"kiro-go/internal/model"
+)
+
+// CallWithFallback 调用 Kiro API；重试耗尽后仍是模型不可用 / 5xx 时，
+// 按 config.modelFallbacks 依次切换到备用模型重新请求
+// 返回实际使用的模型 ID（未切换时为请求体中的原模型）
+// pin 为 API Key 固定的主凭证池范围，sessionID 为客户端会话（用于凭据亲和），二者仅在 cred 为 nil 时生效
+// scope 为调用方的模型范围（nil 表示不限制），不在范围内的备用模型跳过
+func (p *Provider) CallWithFallback(body []byte, cred *model.KiroCredentials, activationCode string, pin *model.CredentialPin, sessionID string, scope model.ModelScope) (*http.Response, string, error) {
+	modelID := RequestModelID(body)
+	resp, err := p.callOnce(body, cred, activationCode, pin, sessionID)
+
+	for _, next := range p.Config.Load().ModelFallbacks[modelID] {
+		reason := fallbackReason(resp, err)
+		if reason == nil {
+			break
+		}
+		if scope != nil && !scope.AllowsModel(next) {
+			logger.Debugf(logger.CatProxy, "备用模型 %s 不在调用方的模型范围内，跳过", next)
+			continue
+		}
+		nextBody, rerr := RewriteModelID(body, next)
+		if rerr != nil {
+			logger.Warnf(logger.CatProxy, "模型降级失败，无法改写请求体: %v", rerr)
+			break
+		}
+		logger.WarnFields(logger.CatProxy, "模型不可用，切换备用模型", logger.F{
+			"from":   modelID,
+			"to":     next,
+			"status": reason.Status,
+			"reason": reason.Message,
+		})
+		if resp != nil {
+			resp.Body.Close()
+		}
+		metrics.Fallbacks.Inc("model")
+		resp, err = p.callOnce(nextBody, cred, activationCode, pin, sessionID)
+		modelID = next
+	}
+	return resp, modelID, err
+}
+
+// callOnce 按认证方式选择调用路径（各自带重试）
+func (p *Provider) callOnce(body []byte, cred *model.KiroCredentials, activationCode string, pin *model.CredentialPin, sessionID string) (*http.Response, error) {
+	if cred != nil {
+		return p.CallWithCredentials(body, cred, activationCode)
+	}
+	resp, _, err := p.CallWithTokenManager(body, pin, sessionID)
+	return resp, err
+}
+
+// fallbackReason 判断本次结果是否应切换备用模型，返回 nil 表示不切换
+// 只对上游明确返回的过载 / 5xx 切换；网络错误、限流、4xx 与模型无关，不切换
+func fallbackReason(resp *http.Response, err error) *common.UpstreamError {
+	if err != nil {
+		var ue *common.UpstreamError
+		if errors.As(err, &ue) && isModelUnavailable(ue) {
+			return ue
+		}
+		return nil
+	}
+	if resp == nil || resp.StatusCode < 400 {
+		return nil
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	ue := common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header)
+	if isModelUnavailable(ue) {
+		return ue
+	}
+	return nil
+}
+
+func isModelUnavailable(ue *common.UpstreamError) bool {
+	return ue.Type == "overloaded_error" || ue.Status >= 500
+}
+
+// RequestModelID 读取 Kiro 请求体中当前消息的 modelId
+func RequestModelID(body []byte) string {
+	var req struct {
+		ConversationState struct {
+			CurrentMessage struct {
+				UserInputMessage struct {
+					ModelID string `json:"modelId"`
+				} `json:"userInputMessage"`
+			} `json:"currentMessage"`
+		} `json:"conversationState"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return req.ConversationState.CurrentMessage.UserInputMessage.ModelID
+}
+
+// RewriteModelID 将 Kiro 请求体中所有 userInputMessage.modelId（含 history）替换为 modelID
+func RewriteModelID(body []byte, modelID string) ([]byte, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	replaceModelID(req, modelID)
+	return json.Marshal(req)
+}
+
+func replaceModelID(v interface{}, modelID string) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if k == "modelId" {
+				if _, ok := child.(string); ok {
+					node[k] = modelID
+				}
+				continue
+			}
+			replaceModelID(child, modelID)
+		}
+	case []interface{}:
+		for _, child := range node {
+			replaceModelID(child, modelID)
+		}
+	}
+}
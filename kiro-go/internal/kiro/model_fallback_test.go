@@ -0,0 +1,70 @@
+package kiro
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kiro-go/internal/model"
+)
+
+// fakeUpstream 按请求体中的 modelId 返回预设状态码，并记录请求过的模型
+type fakeUpstream struct {
+	status map[string]int
+	called []string
+}
+
+func (f *fakeUpstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	id := RequestModelID(body)
+	f.called = append(f.called, id)
+	status := f.status[id]
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(`{"message":"` + http.StatusText(status) + `"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestCallWithFallbackSkipsModelsOutOfScope(t *testing.T) {
+	cfg := model.NewConfigRef(&model.Config{
+		ModelFallbacks: map[string][]string{"claude-opus-4.5": {"claude-sonnet-4.5", "claude-haiku-4.5"}},
+	})
+	p := NewProvider(cfg, NewTokenManager(cfg, nil))
+	upstream := &fakeUpstream{status: map[string]int{"claude-opus-4.5": http.StatusServiceUnavailable}}
+	p.Client = &http.Client{Transport: upstream}
+
+	cred := &model.KiroCredentials{AccessToken: "aoa-test", ExpiresAt: "2099-01-01T00:00:00Z"}
+	body := []byte(`{"conversationState":{"currentMessage":{"userInputMessage":{"content":"hi","modelId":"claude-opus-4.5"}}}}`)
+
+	scope := &model.TokenScope{Models: []string{"claude-opus-4.5", "claude-haiku-4.5"}}
+	resp, used, err := p.CallWithFallback(body, cred, "", nil, "", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if used != "claude-haiku-4.5" || resp.StatusCode != http.StatusOK {
+		t.Fatalf("应降级到范围内的 haiku，实际 %s (%d)", used, resp.StatusCode)
+	}
+	for _, id := range upstream.called {
+		if id == "claude-sonnet-4.5" {
+			t.Fatalf("不应请求范围外的备用模型: %v", upstream.called)
+		}
+	}
+
+	// 不限制范围时按顺序使用第一个备用模型
+	upstream.called = nil
+	resp, used, err = p.CallWithFallback(body, cred, "", nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if used != "claude-sonnet-4.5" {
+		t.Fatalf("未限制时应降级到 sonnet，实际 %s（%v）", used, upstream.called)
+	}
+}
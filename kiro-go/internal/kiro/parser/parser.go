@@ -225,3 +225,8 @@ func (d *Decoder) Decode() ([]*Frame, error) {
 	}
 	return frames, nil
 }
+
+// Buffered 返回尚未组成完整帧的字节数（流结束时非 0 表示最后一帧被截断）
+func (d *Decoder) Buffered() int {
+	return len(d.buf)
+}
@@ -0,0 +1,422 @@
+package kiro
+
+import (
+	"fmt"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+// 主凭证池的运行时管理：健康状态、禁用原因、优先级，以及管理接口的增删改。
+// 管理接口直接修改当前凭证池（不重置轮询位置和会话粘性），修改后通过 persist 写回凭证文件 / 存储
+
+// 禁用原因
+const (
+	DisabledManual         = "manual"
+	DisabledQuotaExhausted = "quota_exhausted"
+)
+
+// CredentialHealth 凭据最近的调用结果
+type CredentialHealth struct {
+	LastSuccess         string `json:"lastSuccess,omitempty"`
+	LastFailure         string `json:"lastFailure,omitempty"`
+	LastStatus          int    `json:"lastStatus,omitempty"` // 最近一次上游状态码，0 表示请求未发出 / 网络错误
+	LastError           string `json:"lastError,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+// PoolEntry 管理接口展示的凭据（token 脱敏）
+type PoolEntry struct {
+	ID             int               `json:"id"`
+	Index          int               `json:"index"`
+	AuthMethod     string            `json:"authMethod,omitempty"`
+	Region         string            `json:"region"`
+	Regions        []string          `json:"regions,omitempty"` // 故障转移区域列表（多于一个时）
+	Group          string            `json:"group,omitempty"`
+	Priority       int               `json:"priority"`
+	AccessToken    string            `json:"accessToken"`
+	RefreshToken   string            `json:"refreshToken"`
+	ExpiresAt      string            `json:"expiresAt,omitempty"`
+	Expired        bool              `json:"expired"`
+	Disabled       bool              `json:"disabled"`
+	DisabledReason string            `json:"disabledReason,omitempty"`
+	Status         string            `json:"status"` // healthy | near_limit | failing | expired | disabled | no_token
+	Health         *CredentialHealth `json:"health,omitempty"`
+	Quota          *UsageLimits      `json:"quota,omitempty"`
+}
+
+// unhealthyFailures 连续失败达到该次数时状态显示为 failing
+const unhealthyFailures = 3
+
+func credentialPriority(c *model.KiroCredentials) int {
+	if c.Priority == nil {
+		return 0
+	}
+	return *c.Priority
+}
+
+// assignCredentialIDs 为没有 id 的凭据分配 id：优先沿用 old 中 refreshToken 相同的凭据的 id，否则取最大值 + 1。
+// 只修改内存，下次写回时持久化
+func assignCredentialIDs(list, old []*model.KiroCredentials) {
+	used := make(map[int]bool)
+	next := 1
+	for _, c := range list {
+		if c.ID != nil {
+			used[*c.ID] = true
+			if *c.ID >= next {
+				next = *c.ID + 1
+			}
+		}
+	}
+	for _, c := range list {
+		if c.ID != nil {
+			continue
+		}
+		for _, o := range old {
+			if o.ID != nil && !used[*o.ID] && o.RefreshToken != "" && o.RefreshToken == c.RefreshToken {
+				id := *o.ID
+				c.ID = &id
+				break
+			}
+		}
+		if c.ID == nil {
+			id := next
+			c.ID = &id
+		}
+		used[*c.ID] = true
+		if *c.ID >= next {
+			next = *c.ID + 1
+		}
+	}
+}
+
+// SetPersist 设置管理接口修改凭证池后的写回（写凭证文件或存储）
+func (tm *TokenManager) SetPersist(fn func(list []*model.KiroCredentials) error) {
+	tm.persistMu.Lock()
+	defer tm.persistMu.Unlock()
+	tm.persist = fn
+}
+
+// replaceLocked 整体替换凭证池（热加载）；没有变化时保留轮询位置、会话粘性和健康状态，调用方需持有 tm.mu
+func (tm *TokenManager) replaceLocked(creds []*model.KiroCredentials) (added, removed, updated []string) {
+	assignCredentialIDs(creds, tm.Credentials)
+	added, removed, updated = diffCredentials(tm.Credentials, creds)
+	if len(added) == 0 && len(removed) == 0 && len(updated) == 0 {
+		return
+	}
+	// 内容未变的凭据保留健康状态和额度
+	before := make(map[int]*model.KiroCredentials, len(tm.Credentials))
+	for _, c := range tm.Credentials {
+		before[*c.ID] = c
+	}
+	keep := make(map[int]bool)
+	for _, c := range creds {
+		if prev := before[*c.ID]; prev != nil && credentialsEqual(prev, c) {
+			keep[*c.ID] = true
+		}
+	}
+	for id := range tm.health {
+		if !keep[id] {
+			delete(tm.health, id)
+		}
+	}
+	for id := range tm.quota {
+		if !keep[id] {
+			delete(tm.quota, id)
+		}
+	}
+	for id := range tm.suspended {
+		if !keep[id] {
+			delete(tm.suspended, id)
+		}
+	}
+	tm.Credentials = creds
+	tm.current = 0
+	tm.affinity.reset()
+	return
+}
+
+// RecordResult 记录一次上游调用结果；status 为 0 表示请求未发出或网络错误
+func (tm *TokenManager) RecordResult(cred *model.KiroCredentials, status int, err error) {
+	if cred == nil || cred.ID == nil {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	h := tm.health[*cred.ID]
+	if h == nil {
+		h = &CredentialHealth{}
+		tm.health[*cred.ID] = h
+	}
+	now := time.Now().Format(time.RFC3339)
+	h.LastStatus = status
+	if err == nil && status < 400 {
+		h.LastSuccess = now
+		h.LastError = ""
+		h.ConsecutiveFailures = 0
+		return
+	}
+	h.LastFailure = now
+	h.ConsecutiveFailures++
+	if err != nil {
+		h.LastError = err.Error()
+	} else {
+		h.LastError = fmt.Sprintf("HTTP %d", status)
+	}
+}
+
+// DisableCredential 运行时暂停凭据（如额度用尽）：只记录在 tm.suspended 中，不写回文件
+func (tm *TokenManager) DisableCredential(cred *model.KiroCredentials, reason string) {
+	if cred == nil || cred.ID == nil {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.suspended[*cred.ID] = reason
+}
+
+// PoolEntries 当前凭证池（脱敏）
+func (tm *TokenManager) PoolEntries() []PoolEntry {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	entries := make([]PoolEntry, 0, len(tm.Credentials))
+	for i, c := range tm.Credentials {
+		disabled, reason := tm.disabledReasonLocked(c)
+		e := PoolEntry{
+			Index:          i,
+			AuthMethod:     c.AuthMethod,
+			Region:         c.EffectiveRegion(tm.Config.Load()),
+			Group:          c.Group,
+			Priority:       credentialPriority(c),
+			AccessToken:    logger.MaskKey(c.AccessToken),
+			RefreshToken:   logger.MaskKey(c.RefreshToken),
+			ExpiresAt:      c.ExpiresAt,
+			Expired:        IsTokenExpired(c),
+			Disabled:       disabled,
+			DisabledReason: reason,
+		}
+		if regions := c.EffectiveRegions(tm.Config.Load()); len(regions) > 1 {
+			e.Regions = regions
+		}
+		if c.ID != nil {
+			e.ID = *c.ID
+			if h := tm.health[*c.ID]; h != nil {
+				copied := *h
+				e.Health = &copied
+			}
+			if q := tm.quota[*c.ID]; q != nil {
+				copied := *q
+				e.Quota = &copied
+			}
+		}
+		switch {
+		case disabled:
+			e.Status = "disabled"
+		case c.AccessToken == "":
+			e.Status = "no_token"
+		case e.Expired:
+			e.Status = "expired"
+		case e.Health != nil && e.Health.ConsecutiveFailures >= unhealthyFailures:
+			e.Status = "failing"
+		case e.Quota != nil && e.Quota.NearLimit:
+			e.Status = "near_limit"
+		default:
+			e.Status = "healthy"
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// findLocked 按 id 查找凭据下标，调用方需持有 tm.mu
+func (tm *TokenManager) findLocked(id int) int {
+	for i, c := range tm.Credentials {
+		if c.ID != nil && *c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// credentialCopy 返回凭据副本（用于在锁外刷新 / 测试）
+func (tm *TokenManager) credentialCopy(id int) (*model.KiroCredentials, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	idx := tm.findLocked(id)
+	if idx < 0 {
+		return nil, common.NewMessage(common.MsgPoolNotFound, id)
+	}
+	c := *tm.Credentials[idx]
+	return &c, nil
+}
+
+// modify 在锁内执行 fn 修改凭证池，成功后写回；写回失败时返回错误（内存中的修改保留，下次写回时一并保存）
+func (tm *TokenManager) modify(fn func() error) error {
+	tm.persistMu.Lock()
+	defer tm.persistMu.Unlock()
+
+	tm.mu.Lock()
+	if err := fn(); err != nil {
+		tm.mu.Unlock()
+		return err
+	}
+	snapshot := make([]*model.KiroCredentials, len(tm.Credentials))
+	for i, c := range tm.Credentials {
+		copied := *c
+		snapshot[i] = &copied
+	}
+	tm.mu.Unlock()
+
+	if tm.persist == nil {
+		return nil
+	}
+	if err := tm.persist(snapshot); err != nil {
+		logger.Errorf(logger.CatCreds, "写回主凭证池失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// AddCredential 追加凭据（未指定 id 时自动分配），返回分配的 id
+func (tm *TokenManager) AddCredential(cred model.KiroCredentials) (int, error) {
+	if cred.AccessToken == "" && cred.RefreshToken == "" {
+		return 0, common.NewMessage(common.MsgPoolTokenRequired)
+	}
+	c := &cred
+	err := tm.modify(func() error {
+		if c.ID != nil && tm.findLocked(*c.ID) >= 0 {
+			return common.NewMessage(common.MsgPoolDuplicateID, *c.ID)
+		}
+		list := append(tm.Credentials, c)
+		assignCredentialIDs(list, nil)
+		tm.Credentials = list
+		logger.InfoFields(logger.CatAdmin, "主凭证池新增凭据", logger.F{"id": *c.ID, "total": len(list)})
+		return nil
+	})
+	if c.ID == nil {
+		return 0, err
+	}
+	return *c.ID, err
+}
+
+// RemoveCredential 移除凭据；固定到它的会话解除，其余会话保持
+func (tm *TokenManager) RemoveCredential(id int) error {
+	return tm.modify(func() error {
+		idx := tm.findLocked(id)
+		if idx < 0 {
+			return common.NewMessage(common.MsgPoolNotFound, id)
+		}
+		tm.Credentials = append(tm.Credentials[:idx:idx], tm.Credentials[idx+1:]...)
+		tm.affinity.removeIndex(idx)
+		if tm.current > idx {
+			tm.current--
+		}
+		if tm.current >= len(tm.Credentials) {
+			tm.current = 0
+		}
+		delete(tm.health, id)
+		delete(tm.quota, id)
+		delete(tm.suspended, id)
+		logger.InfoFields(logger.CatAdmin, "主凭证池移除凭据", logger.F{"id": id, "total": len(tm.Credentials)})
+		return nil
+	})
+}
+
+// SetCredentialDisabled 启用 / 禁用凭据；禁用时 reason 为空记为 manual
+func (tm *TokenManager) SetCredentialDisabled(id int, disabled bool, reason string) error {
+	return tm.modify(func() error {
+		idx := tm.findLocked(id)
+		if idx < 0 {
+			return common.NewMessage(common.MsgPoolNotFound, id)
+		}
+		c := tm.Credentials[idx]
+		c.Disabled = disabled
+		c.DisabledReason = ""
+		if disabled {
+			c.DisabledReason = reason
+			if reason == "" {
+				c.DisabledReason = DisabledManual
+			}
+		} else {
+			delete(tm.health, id)
+			delete(tm.suspended, id)
+		}
+		logger.InfoFields(logger.CatAdmin, "主凭证池凭据状态变更", logger.F{"id": id, "disabled": disabled, "reason": c.DisabledReason})
+		return nil
+	})
+}
+
+// SetCredentialPriority 设置优先级（nil 恢复默认 0）
+func (tm *TokenManager) SetCredentialPriority(id int, priority *int) error {
+	return tm.modify(func() error {
+		idx := tm.findLocked(id)
+		if idx < 0 {
+			return common.NewMessage(common.MsgPoolNotFound, id)
+		}
+		tm.Credentials[idx].Priority = priority
+		logger.InfoFields(logger.CatAdmin, "主凭证池凭据优先级变更", logger.F{"id": id, "priority": credentialPriority(tm.Credentials[idx])})
+		return nil
+	})
+}
+
+// RefreshCredential 立即刷新凭据的 token 并写回，返回新的过期时间
+func (tm *TokenManager) RefreshCredential(id int) (string, error) {
+	cred, err := tm.credentialCopy(id)
+	if err != nil {
+		return "", err
+	}
+	refreshed, err := RefreshToken(cred, tm.Config.Load())
+	if err != nil {
+		tm.RecordResult(cred, 0, err)
+		return "", err
+	}
+	err = tm.modify(func() error {
+		idx := tm.findLocked(id)
+		if idx < 0 {
+			return common.NewMessage(common.MsgPoolNotFound, id)
+		}
+		c := tm.Credentials[idx]
+		c.AccessToken = refreshed.AccessToken
+		c.RefreshToken = refreshed.RefreshToken
+		c.ProfileArn = refreshed.ProfileArn
+		c.ExpiresAt = refreshed.ExpiresAt
+		return nil
+	})
+	return refreshed.ExpiresAt, err
+}
+
+// PoolTestResult 凭据测试调用结果
+type PoolTestResult struct {
+	ID        int    `json:"id"`
+	OK        bool   `json:"ok"`
+	Region    string `json:"region"`
+	LatencyMs int64  `json:"latencyMs"`
+	Models    int    `json:"models,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// TestPoolCredential 用凭据调用 ListAvailableModels（不消耗额度），结果计入健康状态
+func (p *Provider) TestPoolCredential(id int) (*PoolTestResult, error) {
+	cred, err := p.TokenMgr.credentialCopy(id)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	models, err := p.fetchModels(cred, cred.AccessToken)
+	result := &PoolTestResult{
+		ID:        id,
+		OK:        err == nil,
+		Region:    cred.EffectiveRegion(p.Config.Load()),
+		LatencyMs: time.Since(start).Milliseconds(),
+		Models:    len(models),
+	}
+	status := 200
+	if err != nil {
+		result.Error = err.Error()
+		status = 0
+	}
+	p.TokenMgr.RecordResult(cred, status, err)
+	return result, nil
+}
@@ -0,0 +1,168 @@
+package kiro
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+// poolRequest 凭证池操作的请求体
+type poolRequest struct {
+	ID       *int   `json:"id"`
+	Reason   string `json:"reason,omitempty"`
+	Priority *int   `json:"priority"`
+}
+
+// decodePoolRequest 解析请求体，缺少 id 时写入 400 并返回 false
+func decodePoolRequest(w http.ResponseWriter, r *http.Request) (poolRequest, bool) {
+	var req poolRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == nil {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgPoolIDRequired)))
+		return req, false
+	}
+	return req, true
+}
+
+// poolStatus 操作失败时的状态码：凭据不存在 404，参数错误 400，其余（如写回失败）500
+func poolStatus(err error) int {
+	var m *common.Message
+	switch {
+	case !errors.As(err, &m):
+		return http.StatusInternalServerError
+	case m.Code == common.MsgPoolNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// HandleAdminPool /api/admin/pool
+// GET 列出主凭证池（token 脱敏，含过期时间、健康状态、禁用原因）；POST 新增凭据（body 为凭据对象）
+func HandleAdminPool(w http.ResponseWriter, r *http.Request, tm *TokenManager) {
+	switch r.Method {
+	case http.MethodGet:
+		common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"success":     true,
+			"credentials": tm.PoolEntries(),
+		})
+	case http.MethodPost:
+		var cred model.KiroCredentials
+		if err := json.NewDecoder(r.Body).Decode(&cred); err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgInvalidRequest)))
+			return
+		}
+		id, err := tm.AddCredential(cred)
+		if err != nil {
+			common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+			return
+		}
+		resp := codeResult(r, true, common.NewMessage(common.MsgPoolAdded, id))
+		resp["id"] = id
+		common.WriteJSON(w, http.StatusOK, resp)
+	default:
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAdminPoolRemove POST /api/admin/pool/remove {"id": 1}
+func HandleAdminPoolRemove(w http.ResponseWriter, r *http.Request, tm *TokenManager) {
+	req, ok := decodePoolRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := tm.RemoveCredential(*req.ID); err != nil {
+		common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgPoolRemoved, *req.ID)))
+}
+
+// HandleAdminPoolEnable POST /api/admin/pool/enable {"id": 1}
+func HandleAdminPoolEnable(w http.ResponseWriter, r *http.Request, tm *TokenManager) {
+	req, ok := decodePoolRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := tm.SetCredentialDisabled(*req.ID, false, ""); err != nil {
+		common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgPoolEnabled, *req.ID)))
+}
+
+// HandleAdminPoolDisable POST /api/admin/pool/disable {"id": 1, "reason": "..."}
+func HandleAdminPoolDisable(w http.ResponseWriter, r *http.Request, tm *TokenManager) {
+	req, ok := decodePoolRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := tm.SetCredentialDisabled(*req.ID, true, req.Reason); err != nil {
+		common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgPoolDisabled, *req.ID)))
+}
+
+// HandleAdminPoolPriority POST /api/admin/pool/priority {"id": 1, "priority": -1}，priority 为 null 恢复默认
+func HandleAdminPoolPriority(w http.ResponseWriter, r *http.Request, tm *TokenManager) {
+	req, ok := decodePoolRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := tm.SetCredentialPriority(*req.ID, req.Priority); err != nil {
+		common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgPoolPrioritySet, *req.ID)))
+}
+
+// HandleAdminPoolRefresh POST /api/admin/pool/refresh {"id": 1}，立即刷新 token
+func HandleAdminPoolRefresh(w http.ResponseWriter, r *http.Request, tm *TokenManager) {
+	req, ok := decodePoolRequest(w, r)
+	if !ok {
+		return
+	}
+	expiresAt, err := tm.RefreshCredential(*req.ID)
+	if err != nil {
+		status := poolStatus(err)
+		if status == http.StatusInternalServerError {
+			status = http.StatusBadGateway // 刷新接口返回错误
+		}
+		common.WriteJSON(w, status, errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgPoolRefreshed, *req.ID, expiresAt)))
+}
+
+// HandleAdminPoolTest POST /api/admin/pool/test {"id": 1}，用该凭据调用 ListAvailableModels
+func HandleAdminPoolTest(w http.ResponseWriter, r *http.Request, p *Provider) {
+	req, ok := decodePoolRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := p.TestPoolCredential(*req.ID)
+	if err != nil {
+		common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": result.OK, "result": result})
+}
+
+// HandleAdminRegions GET /api/admin/regions，各 API 区域的健康状态（连续失败次数、冷却截止时间）
+func HandleAdminRegions(w http.ResponseWriter, r *http.Request, p *Provider) {
+	if r.Method != http.MethodGet {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"regions": p.Regions.Snapshot(),
+	})
+}
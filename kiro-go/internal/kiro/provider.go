@@ -7,10 +7,13 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"kiro-go/internal/common"
 	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
 	"kiro-go/internal/model"
 
 	"github.com/google/uuid"
@@ -23,49 +26,61 @@ const (
 
 // Provider 负责与 Kiro API 通信
 type Provider struct {
-	Config       *model.Config
+	Config       *model.ConfigRef
 	TokenMgr     *TokenManager
 	UserCredsMgr *UserCredentialsManager
+	Codes        *CodesManager // 共享组成员与 codes.json 中的用户凭证
+	Audit        *SharingAudit // 借用凭证的审计记录
 	Client       *http.Client
+	Catalog      *ModelCatalog
+	Quota        *QuotaMonitor
+	Regions      *RegionTracker
 }
 
-func NewProvider(cfg *model.Config, tm *TokenManager) *Provider {
-	return &Provider{
+func NewProvider(cfg *model.ConfigRef, tm *TokenManager) *Provider {
+	p := &Provider{
 		Config:   cfg,
 		TokenMgr: tm,
 		Client:   &http.Client{Timeout: 720 * time.Second},
+		Regions:  newRegionTracker(),
 	}
+	p.Catalog = newModelCatalog(p)
+	p.Quota = newQuotaMonitor(p)
+	return p
 }
 
-// GetModels 从 Kiro API 获取模型列表
+// GetModels 模型列表（/v1/models 格式，保留上游原始字段），来自模型目录缓存
 func (p *Provider) GetModels() ([]map[string]interface{}, error) {
-	// 获取一个可用的凭证和 token
-	cred, token, err := p.TokenMgr.AcquireContext()
-	if err != nil {
-		return nil, fmt.Errorf("获取凭证和 token 失败: %w", err)
-	}
-
-	// 构建请求
-	region := cred.EffectiveRegion(p.Config)
-	url := fmt.Sprintf("https://q.%s.amazonaws.com/ListAvailableModels?origin=AI_EDITOR&profileArn=arn%%3Aaws%%3Acodewhisperer%%3A%s%%3A143353052107%%3Aprofile%%2FMQECWP49CVEW", region, region)
-
-	req, err := http.NewRequest("GET", url, nil)
+	infos, err := p.Catalog.List(nil)
 	if err != nil {
 		return nil, err
 	}
+	models := make([]map[string]interface{}, 0, len(infos))
+	for _, m := range infos {
+		models = append(models, m.ToMap())
+	}
+	return models, nil
+}
 
-	// 设置请求头
-	mid := GenerateMachineID(cred, p.Config)
-	kv := p.Config.KiroVersion
-	req.Header.Set("User-Agent", fmt.Sprintf("aws-sdk-js/1.0.0 ua/2.1 os/%s lang/js md/nodejs#%s api/codewhispererruntime#1.0.0 m/N,E KiroIDE-%s-%s", p.Config.SystemVersion, p.Config.NodeVersion, kv, mid))
-	req.Header.Set("x-amz-user-agent", fmt.Sprintf("aws-sdk-js/1.0.0 KiroIDE-%s-%s", kv, mid))
-	req.Header.Set("amz-sdk-invocation-id", uuid.New().String())
-	req.Header.Set("amz-sdk-request", "attempt=1; max=1")
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Connection", "close")
+// fetchModels 调用 ListAvailableModels 获取指定凭证可用的模型
+// profileArn 使用凭证自身的值（IdC / Builder ID 凭证没有 profileArn 时不带该参数）
+func (p *Provider) fetchModels(cred *model.KiroCredentials, token string) ([]*ModelInfo, error) {
+	query := url.Values{}
+	query.Set("origin", "AI_EDITOR")
+	if cred.ProfileArn != "" {
+		query.Set("profileArn", cred.ProfileArn)
+	}
 
-	// 发送请求
-	resp, err := p.Client.Do(req)
+	// 发送请求（区域故障时切换到下一个区域）
+	resp, err := p.doRegional(cred, func(region string) (*http.Response, error) {
+		apiURL := fmt.Sprintf("https://q.%s.amazonaws.com/ListAvailableModels?%s", region, query.Encode())
+		req, err := http.NewRequest("GET", apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.setRuntimeHeaders(req, cred, token)
+		return p.Client.Do(req)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("请求失败: %w", err)
 	}
@@ -78,91 +93,41 @@ func (p *Provider) GetModels() ([]map[string]interface{}, error) {
 
 	// 解析响应
 	var result struct {
-		Models []struct {
-			ModelID             string   `json:"modelId"`
-			ModelName           string   `json:"modelName"`
-			Description         string   `json:"description"`
-			RateMultiplier      float64  `json:"rateMultiplier"`
-			RateUnit            string   `json:"rateUnit"`
-			SupportedInputTypes []string `json:"supportedInputTypes"`
-			TokenLimits         struct {
-				MaxInputTokens  int  `json:"maxInputTokens"`
-				MaxOutputTokens *int `json:"maxOutputTokens"`
-			} `json:"tokenLimits"`
-			PromptCaching struct {
-				SupportsPromptCaching             bool `json:"supportsPromptCaching"`
-				MaximumCacheCheckpointsPerRequest int  `json:"maximumCacheCheckpointsPerRequest"`
-				MinimumTokensPerCacheCheckpoint   int  `json:"minimumTokensPerCacheCheckpoint"`
-			} `json:"promptCaching"`
-		} `json:"models"`
+		Models []*ModelInfo `json:"models"`
 	}
-
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("解析响应失败: %w", err)
 	}
+	return result.Models, nil
+}
 
-	// 转换为标准格式（保留所有原始字段）
-	models := []map[string]interface{}{}
-	for _, m := range result.Models {
-		maxTokens := 200000
-		if m.TokenLimits.MaxInputTokens > 0 {
-			maxTokens = m.TokenLimits.MaxInputTokens
-		}
-
-		model := map[string]interface{}{
-			"id":           m.ModelID,
-			"object":       "model",
-			"created":      time.Now().Unix(),
-			"owned_by":     "anthropic",
-			"display_name": m.ModelName,
-			"type":         "chat",
-			"max_tokens":   maxTokens,
-			// 保留原始 API 字段
-			"modelId":             m.ModelID,
-			"modelName":           m.ModelName,
-			"description":         m.Description,
-			"rateMultiplier":      m.RateMultiplier,
-			"rateUnit":            m.RateUnit,
-			"supportedInputTypes": m.SupportedInputTypes,
-		}
-
-		// 添加 tokenLimits
-		tokenLimits := map[string]interface{}{
-			"maxInputTokens": m.TokenLimits.MaxInputTokens,
-		}
-		if m.TokenLimits.MaxOutputTokens != nil {
-			tokenLimits["maxOutputTokens"] = *m.TokenLimits.MaxOutputTokens
-		} else {
-			tokenLimits["maxOutputTokens"] = nil
-		}
-		model["tokenLimits"] = tokenLimits
-
-		// 添加 promptCaching
-		model["promptCaching"] = map[string]interface{}{
-			"supportsPromptCaching":             m.PromptCaching.SupportsPromptCaching,
-			"maximumCacheCheckpointsPerRequest": m.PromptCaching.MaximumCacheCheckpointsPerRequest,
-			"minimumTokensPerCacheCheckpoint":   m.PromptCaching.MinimumTokensPerCacheCheckpoint,
-		}
-
-		models = append(models, model)
-	}
-
-	return models, nil
+// setRuntimeHeaders 设置 q.{region}.amazonaws.com 查询类接口（ListAvailableModels / getUsageLimits）的请求头
+func (p *Provider) setRuntimeHeaders(req *http.Request, cred *model.KiroCredentials, token string) {
+	cfg := p.Config.Load()
+	mid := GenerateMachineID(cred, cfg)
+	kv := cfg.KiroVersion
+	req.Header.Set("User-Agent", fmt.Sprintf("aws-sdk-js/1.0.0 ua/2.1 os/%s lang/js md/nodejs#%s api/codewhispererruntime#1.0.0 m/N,E KiroIDE-%s-%s", cfg.SystemVersion, cfg.NodeVersion, kv, mid))
+	req.Header.Set("x-amz-user-agent", fmt.Sprintf("aws-sdk-js/1.0.0 KiroIDE-%s-%s", kv, mid))
+	req.Header.Set("amz-sdk-invocation-id", uuid.New().String())
+	req.Header.Set("amz-sdk-request", "attempt=1; max=1")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Connection", "close")
 }
 
-func (p *Provider) BaseURL(cred *model.KiroCredentials) string {
-	return fmt.Sprintf("https://q.%s.amazonaws.com/generateAssistantResponse", cred.EffectiveRegion(p.Config))
+func (p *Provider) BaseURL(region string) string {
+	return fmt.Sprintf("https://q.%s.amazonaws.com/generateAssistantResponse", region)
 }
 
-func (p *Provider) BaseDomain(cred *model.KiroCredentials) string {
-	return fmt.Sprintf("q.%s.amazonaws.com", cred.EffectiveRegion(p.Config))
+func (p *Provider) BaseDomain(region string) string {
+	return fmt.Sprintf("q.%s.amazonaws.com", region)
 }
 
-func (p *Provider) BuildHeaders(cred *model.KiroCredentials, token string) http.Header {
-	mid := GenerateMachineID(cred, p.Config)
-	kv := p.Config.KiroVersion
-	osName := p.Config.SystemVersion
-	nv := p.Config.NodeVersion
+func (p *Provider) BuildHeaders(cred *model.KiroCredentials, token, region string) http.Header {
+	cfg := p.Config.Load()
+	mid := GenerateMachineID(cred, cfg)
+	kv := cfg.KiroVersion
+	osName := cfg.SystemVersion
+	nv := cfg.NodeVersion
 
 	h := http.Header{}
 	h.Set("Content-Type", "application/json")
@@ -170,7 +135,7 @@ func (p *Provider) BuildHeaders(cred *model.KiroCredentials, token string) http.
 	h.Set("x-amzn-kiro-agent-mode", "vibe")
 	h.Set("x-amz-user-agent", fmt.Sprintf("aws-sdk-js/1.0.27 KiroIDE-%s-%s", kv, mid))
 	h.Set("User-Agent", fmt.Sprintf("aws-sdk-js/1.0.27 ua/2.1 os/%s lang/js md/nodejs#%s api/codewhispererstreaming#1.0.27 m/E KiroIDE-%s-%s", osName, nv, kv, mid))
-	h.Set("Host", p.BaseDomain(cred))
+	h.Set("Host", p.BaseDomain(region))
 	h.Set("amz-sdk-invocation-id", uuid.New().String())
 	h.Set("amz-sdk-request", "attempt=1; max=3")
 	h.Set("Authorization", "Bearer "+token)
@@ -178,13 +143,20 @@ func (p *Provider) BuildHeaders(cred *model.KiroCredentials, token string) http.
 	return h
 }
 
-// CallAPI 发送 API 请求
+// CallAPI 发送 API 请求，按凭据的区域列表故障转移（实际区域见 ServedRegion）
 func (p *Provider) CallAPI(body []byte, cred *model.KiroCredentials, token string) (*http.Response, error) {
-	req, err := http.NewRequest("POST", p.BaseURL(cred), bytes.NewReader(body))
+	return p.doRegional(cred, func(region string) (*http.Response, error) {
+		return p.callRegion(body, cred, token, region)
+	})
+}
+
+// callRegion 向指定区域发送一次 API 请求
+func (p *Provider) callRegion(body []byte, cred *model.KiroCredentials, token, region string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", p.BaseURL(region), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	req.Header = p.BuildHeaders(cred, token)
+	req.Header = p.BuildHeaders(cred, token, region)
 
 	// 记录上游请求
 	rid := req.Header.Get("amz-sdk-invocation-id")
@@ -219,7 +191,10 @@ func (p *Provider) CallAPI(body []byte, cred *model.KiroCredentials, token strin
 }
 
 // CallWithTokenManager 使用 TokenManager 获取凭证并调用（带重试和故障转移）
-func (p *Provider) CallWithTokenManager(body []byte) (*http.Response, *model.KiroCredentials, error) {
+// sessionID 非空（客户端在 metadata.user_id 中携带会话）时同一会话的请求固定到同一凭据，凭据失败时解除固定并切换；
+// 为空时不固定，避免每个一次性请求的随机 conversationId 占满亲和表
+// pin 非 nil 时只使用限定的凭据 / 分组
+func (p *Provider) CallWithTokenManager(body []byte, pin *model.CredentialPin, sessionID string) (*http.Response, *model.KiroCredentials, error) {
 	totalCreds := len(p.TokenMgr.Credentials)
 	maxRetries := totalCreds * maxRetriesPerCredential
 	if maxRetries > maxTotalRetries {
@@ -230,18 +205,26 @@ func (p *Provider) CallWithTokenManager(body []byte) (*http.Response, *model.Kir
 	}
 
 	var lastErr error
+	retryReason := "" // 上一次失败的原因，计入重试指标
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		cred, token, err := p.TokenMgr.AcquireContext()
+		if attempt > 0 {
+			metrics.UpstreamRetries.Inc(retryReason)
+		}
+		cred, token, err := p.TokenMgr.AcquireForSession(sessionID, pin)
 		if err != nil {
-			lastErr = err
+			lastErr, retryReason = err, "no_credential"
 			continue
 		}
 
 		resp, err := p.CallAPI(body, cred, token)
+		if err != nil || isCredentialFailure(resp.StatusCode) {
+			p.TokenMgr.ReleaseSession(sessionID, cred)
+		}
 		if err != nil {
+			p.TokenMgr.RecordResult(cred, 0, err)
 			logger.Warnf(logger.CatProxy, "API 请求发送失败（尝试 %d/%d）: %v", attempt+1, maxRetries, err)
-			lastErr = err
+			lastErr, retryReason = err, "network"
 			if attempt+1 < maxRetries {
 				time.Sleep(retryDelay(attempt))
 			}
@@ -252,6 +235,7 @@ func (p *Provider) CallWithTokenManager(body []byte) (*http.Response, *model.Kir
 
 		// 成功
 		if status >= 200 && status < 300 {
+			p.TokenMgr.RecordResult(cred, status, nil)
 			return resp, cred, nil
 		}
 
@@ -260,30 +244,35 @@ func (p *Provider) CallWithTokenManager(body []byte) (*http.Response, *model.Kir
 		resp.Body.Close()
 		bodyStr := string(respBody)
 
+		upstreamErr := common.ClassifyUpstreamResponse(status, bodyStr, resp.Header)
+		if isCredentialFailure(status) {
+			p.TokenMgr.RecordResult(cred, status, upstreamErr)
+		}
+
 		// 402 额度用尽
 		if status == 402 && isMonthlyRequestLimit(bodyStr) {
 			logger.Warnf(logger.CatProxy, "API 请求失败（额度已用尽，尝试 %d/%d）: %d %s", attempt+1, maxRetries, status, bodyStr)
-			cred.Disabled = true
-			lastErr = fmt.Errorf("API 请求失败: %d %s", status, bodyStr)
+			p.TokenMgr.DisableCredential(cred, DisabledQuotaExhausted)
+			lastErr, retryReason = upstreamErr, "quota_exhausted"
 			continue
 		}
 
 		// 400 Bad Request - 不重试
 		if status == 400 {
-			return nil, nil, fmt.Errorf("API 请求失败: %d %s", status, bodyStr)
+			return nil, nil, upstreamErr
 		}
 
 		// 401/403 凭据问题 - 切换凭据重试
 		if status == 401 || status == 403 {
 			logger.Warnf(logger.CatProxy, "API 请求失败（凭据错误，尝试 %d/%d）: %d %s", attempt+1, maxRetries, status, bodyStr)
-			lastErr = fmt.Errorf("API 请求失败: %d %s", status, bodyStr)
+			lastErr, retryReason = upstreamErr, "auth"
 			continue
 		}
 
 		// 408/429/5xx 瞬态错误 - 重试
 		if status == 408 || status == 429 || status >= 500 {
 			logger.Warnf(logger.CatProxy, "API 请求失败（瞬态错误，尝试 %d/%d）: %d %s", attempt+1, maxRetries, status, bodyStr)
-			lastErr = fmt.Errorf("API 请求失败: %d %s", status, bodyStr)
+			lastErr, retryReason = upstreamErr, "transient"
 			if attempt+1 < maxRetries {
 				time.Sleep(retryDelay(attempt))
 			}
@@ -292,11 +281,11 @@ func (p *Provider) CallWithTokenManager(body []byte) (*http.Response, *model.Kir
 
 		// 其他 4xx - 不重试
 		if status >= 400 && status < 500 {
-			return nil, nil, fmt.Errorf("API 请求失败: %d %s", status, bodyStr)
+			return nil, nil, upstreamErr
 		}
 
 		// 兜底
-		lastErr = fmt.Errorf("API 请求失败: %d %s", status, bodyStr)
+		lastErr, retryReason = upstreamErr, "other"
 		if attempt+1 < maxRetries {
 			time.Sleep(retryDelay(attempt))
 		}
@@ -311,94 +300,150 @@ func (p *Provider) CallWithTokenManager(body []byte) (*http.Response, *model.Kir
 // CallWithCredentials 使用指定凭证调用（act- 模式）
 // 不在请求路径上刷新 token，直接使用现有 token（即使过期，Kiro API 仍可接受）
 // Token 刷新由 kiro-launcher 负责
-// 402 额度用尽时自动切换到下一个可用用户凭证
+// 402 额度用尽时标记凭证不可用，按激活码所在共享组的规则借用凭证（见 borrowCredentials）
 func (p *Provider) CallWithCredentials(body []byte, cred *model.KiroCredentials, activationCode string) (*http.Response, error) {
 	if cred.AccessToken == "" {
 		return nil, fmt.Errorf("没有可用的 accessToken")
 	}
 
-	resp, err := p.CallAPI(body, cred, cred.AccessToken)
+	resp, err := p.callUserCredentials(body, cred)
 	if err != nil {
 		return nil, err
 	}
 
-	// 500/429 瞬态错误：自动重试最多 3 次（如 MODEL_TEMPORARILY_UNAVAILABLE）
-	if resp.StatusCode == 500 || resp.StatusCode == 429 {
-		maxRetries := 3
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			resp.Body.Close()
-			delay := time.Duration(attempt*2) * time.Second
-			logger.Warnf(logger.CatProxy, "收到 %d，等待 %v 后重试 (%d/%d)", resp.StatusCode, delay, attempt, maxRetries)
-			time.Sleep(delay)
-			retryResp, retryErr := p.CallAPI(body, cred, cred.AccessToken)
-			if retryErr != nil {
-				return nil, retryErr
+	// 402 额度用尽：标记当前凭证不可用，按共享组规则借用；不允许借用时原样返回 402
+	if resp.StatusCode == 402 && activationCode != "" {
+		// 重新包装 body，非额度用尽的 402 仍交给调用方按原样归类
+		if isMonthlyRequestLimit(rewrapBody(resp)) {
+			logger.Warnf(logger.CatCreds, "用户 %s 额度已用尽", logger.MaskKey(activationCode))
+			if p.UserCredsMgr != nil {
+				p.UserCredsMgr.MarkDisabled(activationCode)
 			}
-			if retryResp.StatusCode != 500 && retryResp.StatusCode != 429 {
-				return retryResp, nil
+			if borrowed, ok := p.borrowCredentials(body, activationCode); ok {
+				resp.Body.Close()
+				return borrowed, nil
 			}
-			resp = retryResp
 		}
-		logger.Warnf(logger.CatProxy, "重试 %d 次后仍然返回 %d，放弃", maxRetries, resp.StatusCode)
-		return resp, nil
 	}
 
-	// 402 额度用尽：标记当前凭证不可用，尝试自动换号
-	if resp.StatusCode == 402 && p.UserCredsMgr != nil && activationCode != "" {
-		respBody, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		bodyStr := string(respBody)
-
-		if isMonthlyRequestLimit(bodyStr) {
-			logger.Warnf(logger.CatCreds, "用户 %s 额度已用尽，尝试自动换号", logger.MaskKey(activationCode))
-			p.UserCredsMgr.MarkDisabled(activationCode)
+	return resp, nil
+}
 
-			// 尝试下一个可用凭证
-			nextCode, nextCred := p.UserCredsMgr.GetNextAvailable(activationCode)
-			if nextCode != "" && nextCred != nil {
-				logger.Infof(logger.CatCreds, "自动切换到用户凭证: %s", logger.MaskKey(nextCode))
-				return p.CallWithCredentials(body, nextCred, nextCode)
-			}
+// callUserCredentials 使用用户凭证调用，500/429 瞬态错误自动重试最多 3 次（如 MODEL_TEMPORARILY_UNAVAILABLE）
+func (p *Provider) callUserCredentials(body []byte, cred *model.KiroCredentials) (*http.Response, error) {
+	resp, err := p.CallAPI(body, cred, cred.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 500 && resp.StatusCode != 429 {
+		return resp, nil
+	}
 
-			// 没有其他用户凭证，回退到主凭证池
-			logger.Warnf(logger.CatCreds, "没有其他可用用户凭证，回退到主凭证池")
-			mainResp, _, mainErr := p.CallWithTokenManager(body)
-			if mainErr != nil {
-				return nil, fmt.Errorf("所有凭证额度已用尽: %s", bodyStr)
-			}
-			return mainResp, nil
+	maxRetries := 3
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		resp.Body.Close()
+		delay := time.Duration(attempt*2) * time.Second
+		logger.Warnf(logger.CatProxy, "收到 %d，等待 %v 后重试 (%d/%d)", resp.StatusCode, delay, attempt, maxRetries)
+		time.Sleep(delay)
+		metrics.UpstreamRetries.Inc("transient")
+		retryResp, retryErr := p.CallAPI(body, cred, cred.AccessToken)
+		if retryErr != nil {
+			return nil, retryErr
+		}
+		if retryResp.StatusCode != 500 && retryResp.StatusCode != 429 {
+			return retryResp, nil
 		}
+		resp = retryResp
 	}
-
+	logger.Warnf(logger.CatProxy, "重试 %d 次后仍然返回 %d，放弃", maxRetries, resp.StatusCode)
 	return resp, nil
 }
 
 // MCPURL 获取 MCP API URL
-func (p *Provider) MCPURL(cred *model.KiroCredentials) string {
-	return fmt.Sprintf("https://q.%s.amazonaws.com/mcp", cred.EffectiveRegion(p.Config))
+func (p *Provider) MCPURL(region string) string {
+	return fmt.Sprintf("https://q.%s.amazonaws.com/mcp", region)
 }
 
-// CallMCP 调用 MCP API（用于 WebSearch 等工具）
+// CallMCP 调用 MCP API（用于 WebSearch 等工具），按凭据的区域列表故障转移
 func (p *Provider) CallMCP(body []byte) (*http.Response, error) {
 	cred, token, err := p.TokenMgr.AcquireContext()
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", p.MCPURL(cred), bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header = p.BuildHeaders(cred, token)
-	return p.Client.Do(req)
+	return p.doRegional(cred, func(region string) (*http.Response, error) {
+		req, err := http.NewRequest("POST", p.MCPURL(region), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = p.BuildHeaders(cred, token, region)
+		return p.Client.Do(req)
+	})
 }
 
-// ReloadCredentials 重新加载凭据（用于账号切换时清除缓存）
+// ReloadCredentials 重新加载凭据（用于账号切换时清除缓存），在 TokenManager 锁内整体替换凭证池并记录变化；
+// 内容没有变化时（如管理接口写回后触发的文件监听）保留运行时状态
 func (p *Provider) ReloadCredentials(creds []*model.KiroCredentials) {
 	p.TokenMgr.mu.Lock()
 	defer p.TokenMgr.mu.Unlock()
-	p.TokenMgr.Credentials = creds
-	p.TokenMgr.current = 0
-	logger.Infof(logger.CatCreds, "凭据已重新加载，共 %d 个", len(creds))
+	added, removed, updated := p.TokenMgr.replaceLocked(creds)
+	if len(added) == 0 && len(removed) == 0 && len(updated) == 0 {
+		logger.Infof(logger.CatCreds, "凭据已重新读取，无变化（共 %d 个）", len(creds))
+		return
+	}
+	logger.InfoFields(logger.CatCreds, "凭据已重新加载", logger.F{
+		"total":   len(creds),
+		"added":   added,
+		"removed": removed,
+		"updated": updated,
+	})
+}
+
+// diffCredentials 按凭据标识（id，无 id 时用脱敏的 refreshToken）比较新旧凭证池，返回新增 / 移除 / 修改的标识
+func diffCredentials(old, cur []*model.KiroCredentials) (added, removed, updated []string) {
+	key := func(c *model.KiroCredentials) string {
+		if c.ID != nil {
+			return fmt.Sprintf("id=%d", *c.ID)
+		}
+		if c.RefreshToken != "" {
+			return logger.MaskKey(c.RefreshToken)
+		}
+		return logger.MaskKey(c.AccessToken)
+	}
+	before := make(map[string]*model.KiroCredentials, len(old))
+	for _, c := range old {
+		before[key(c)] = c
+	}
+	seen := make(map[string]bool, len(cur))
+	for _, c := range cur {
+		k := key(c)
+		seen[k] = true
+		prev, ok := before[k]
+		if !ok {
+			added = append(added, k)
+			continue
+		}
+		if !credentialsEqual(prev, c) {
+			updated = append(updated, k)
+		}
+	}
+	for _, c := range old {
+		if k := key(c); !seen[k] {
+			removed = append(removed, k)
+		}
+	}
+	return
+}
+
+// credentialsEqual 两个凭据内容相同（含运行时修改的禁用状态）
+func credentialsEqual(a, b *model.KiroCredentials) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+// isCredentialFailure 该状态码说明当前凭据不可用或瞬态失败，需要换凭据重试
+func isCredentialFailure(status int) bool {
+	return status == 401 || status == 402 || status == 403 || status == 408 || status == 429 || status >= 500
 }
 
 // retryDelay 指数退避 + 抖动
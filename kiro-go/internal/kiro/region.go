@@ -0,0 +1,178 @@
+package kiro
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
+	"kiro-go/internal/model"
+)
+
+const (
+	// regionFailureThreshold 连续 5xx 达到该次数视为区域故障
+	regionFailureThreshold = 3
+	// regionCooldown 区域故障后的冷却时间，期间排在其他区域之后
+	regionCooldown = time.Minute
+)
+
+// RegionHealth 上游区域的健康状态（所有凭据共享，只在内存中）
+type RegionHealth struct {
+	Region              string `json:"region"`
+	Healthy             bool   `json:"healthy"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastSuccess         string `json:"lastSuccess,omitempty"`
+	LastFailure         string `json:"lastFailure,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+	CooldownUntil       string `json:"cooldownUntil,omitempty"`
+
+	cooldownUntil time.Time
+}
+
+// RegionTracker 记录各区域的调用结果：连接错误立即进入冷却，5xx 连续达到阈值后进入冷却
+type RegionTracker struct {
+	mu      sync.Mutex
+	regions map[string]*RegionHealth
+}
+
+func newRegionTracker() *RegionTracker {
+	return &RegionTracker{regions: make(map[string]*RegionHealth)}
+}
+
+// getLocked 调用方需持有 t.mu
+func (t *RegionTracker) getLocked(region string) *RegionHealth {
+	h := t.regions[region]
+	if h == nil {
+		h = &RegionHealth{Region: region}
+		t.regions[region] = h
+	}
+	return h
+}
+
+// order 候选区域的尝试顺序：未冷却的保持配置顺序在前，冷却中的排在最后（全部冷却时仍会尝试）
+func (t *RegionTracker) order(regions []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	ordered := make([]string, 0, len(regions))
+	var cooling []string
+	for _, r := range regions {
+		if h := t.regions[r]; h != nil && now.Before(h.cooldownUntil) {
+			cooling = append(cooling, r)
+			continue
+		}
+		ordered = append(ordered, r)
+	}
+	return append(ordered, cooling...)
+}
+
+func (t *RegionTracker) recordSuccess(region string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.getLocked(region)
+	h.ConsecutiveFailures = 0
+	h.LastSuccess = time.Now().Format(time.RFC3339)
+	h.cooldownUntil = time.Time{}
+}
+
+// recordFailure 记录区域失败（status 为 0 表示连接错误），返回区域是否进入冷却（应切换到下一个区域）
+func (t *RegionTracker) recordFailure(region string, status int, err error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	h := t.getLocked(region)
+	h.ConsecutiveFailures++
+	h.LastFailure = now.Format(time.RFC3339)
+	if err != nil {
+		h.LastError = err.Error()
+	} else {
+		h.LastError = fmt.Sprintf("HTTP %d", status)
+	}
+	if status != 0 && h.ConsecutiveFailures < regionFailureThreshold {
+		return false
+	}
+	h.cooldownUntil = now.Add(regionCooldown)
+	return true
+}
+
+// Snapshot 各区域当前的健康状态（按区域名排序）
+func (t *RegionTracker) Snapshot() []RegionHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	list := make([]RegionHealth, 0, len(t.regions))
+	for _, h := range t.regions {
+		copied := *h
+		copied.Healthy = !now.Before(h.cooldownUntil)
+		if !copied.Healthy {
+			copied.CooldownUntil = h.cooldownUntil.Format(time.RFC3339)
+		}
+		list = append(list, copied)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Region < list[j].Region })
+	return list
+}
+
+// doRegional 按凭据的区域列表依次调用：连接错误或 5xx 使区域进入冷却时切换到下一个区域，
+// 其余结果（含单次 5xx）直接返回，由调用方按原有规则重试
+func (p *Provider) doRegional(cred *model.KiroCredentials, call func(region string) (*http.Response, error)) (*http.Response, error) {
+	regions := p.Regions.order(cred.EffectiveRegions(p.Config.Load()))
+	lastErr := fmt.Errorf("没有可用的 API 区域")
+	for i, region := range regions {
+		next := ""
+		if i+1 < len(regions) {
+			next = regions[i+1]
+		}
+		resp, err := call(region)
+		metrics.UpstreamResponses.Inc(credentialLabel(cred), region, metrics.UpstreamStatus(resp, err))
+		if err != nil {
+			p.Regions.recordFailure(region, 0, err)
+			lastErr = err
+			if next != "" {
+				logger.Warnf(logger.CatProxy, "区域 %s 请求失败，切换到 %s: %v", region, next, err)
+				metrics.Fallbacks.Inc("region")
+			}
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			if p.Regions.recordFailure(region, resp.StatusCode, nil) && next != "" {
+				resp.Body.Close()
+				logger.Warnf(logger.CatProxy, "区域 %s 连续返回 5xx（%d），切换到 %s", region, resp.StatusCode, next)
+				metrics.Fallbacks.Inc("region")
+				continue
+			}
+			return resp, nil
+		}
+		p.Regions.recordSuccess(region)
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// credentialLabel 指标中的凭据标识：主凭证池为 pool:ID，用户 / 激活码凭证不区分账号统一为 user
+func credentialLabel(cred *model.KiroCredentials) string {
+	if cred != nil && cred.ID != nil {
+		return fmt.Sprintf("pool:%d", *cred.ID)
+	}
+	return "user"
+}
+
+// ServedRegion 响应来自的上游区域（从请求地址 q.{region}.amazonaws.com 解析），无法判断时为空
+func ServedRegion(resp *http.Response) string {
+	if resp == nil || resp.Request == nil || resp.Request.URL == nil {
+		return ""
+	}
+	host, ok := strings.CutPrefix(resp.Request.URL.Hostname(), "q.")
+	if !ok {
+		return ""
+	}
+	region, ok := strings.CutSuffix(host, ".amazonaws.com")
+	if !ok {
+		return ""
+	}
+	return region
+}
@@ -0,0 +1,135 @@
+package kiro
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionAffinityTTL = 30 * time.Minute
+	defaultSessionAffinityMax = 10000
+)
+
+// affinityEntry 会话固定到的凭据
+type affinityEntry struct {
+	index    int
+	lastUsed time.Time
+}
+
+// sessionAffinity 会话 → 主凭证池下标的有界映射（TTL + 超出容量时淘汰最久未用）
+// 同一会话的连续请求落在同一个 Kiro 账号上，便于上游缓存命中、按账号统计用量
+// 凭据重新加载后下标失效，整体清空
+type sessionAffinity struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*affinityEntry
+}
+
+func newSessionAffinity(ttlSeconds, max int) *sessionAffinity {
+	if ttlSeconds < 0 {
+		return nil // 禁用
+	}
+	ttl := defaultSessionAffinityTTL
+	if ttlSeconds > 0 {
+		ttl = time.Duration(ttlSeconds) * time.Second
+	}
+	if max <= 0 {
+		max = defaultSessionAffinityMax
+	}
+	return &sessionAffinity{ttl: ttl, max: max, entries: make(map[string]*affinityEntry)}
+}
+
+// get 返回会话固定的凭据下标，未固定或已过期返回 -1
+func (a *sessionAffinity) get(sessionID string) int {
+	if a == nil || sessionID == "" {
+		return -1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := a.entries[sessionID]
+	if e == nil {
+		return -1
+	}
+	if time.Since(e.lastUsed) > a.ttl {
+		delete(a.entries, sessionID)
+		return -1
+	}
+	e.lastUsed = time.Now()
+	return e.index
+}
+
+// pin 将会话固定到凭据下标
+func (a *sessionAffinity) pin(sessionID string, index int) {
+	if a == nil || sessionID == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e := a.entries[sessionID]; e != nil {
+		e.index = index
+		e.lastUsed = time.Now()
+		return
+	}
+	if len(a.entries) >= a.max {
+		a.evictLocked()
+	}
+	a.entries[sessionID] = &affinityEntry{index: index, lastUsed: time.Now()}
+}
+
+// release 凭据请求失败时解除固定（仅当会话仍固定在该下标上），下次请求重新选择
+func (a *sessionAffinity) release(sessionID string, index int) {
+	if a == nil || sessionID == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e := a.entries[sessionID]; e != nil && e.index == index {
+		delete(a.entries, sessionID)
+	}
+}
+
+// reset 清空所有固定（凭据列表变化后调用）
+func (a *sessionAffinity) reset() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = make(map[string]*affinityEntry)
+}
+
+// removeIndex 凭据从池中移除后调整下标：固定到该凭据的会话解除，之后的下标前移
+func (a *sessionAffinity) removeIndex(index int) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, e := range a.entries {
+		switch {
+		case e.index == index:
+			delete(a.entries, k)
+		case e.index > index:
+			e.index--
+		}
+	}
+}
+
+// evictLocked 先清理过期条目，仍然满时淘汰最久未用的一条
+func (a *sessionAffinity) evictLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range a.entries {
+		if time.Since(e.lastUsed) > a.ttl {
+			delete(a.entries, k)
+			continue
+		}
+		if oldestKey == "" || e.lastUsed.Before(oldest) {
+			oldestKey, oldest = k, e.lastUsed
+		}
+	}
+	if len(a.entries) >= a.max && oldestKey != "" {
+		delete(a.entries, oldestKey)
+	}
+}
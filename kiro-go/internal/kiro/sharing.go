@@ -0,0 +1,273 @@
+package kiro
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
+	"kiro-go/internal/model"
+)
+
+// 用户凭证共享组：激活码的个人 Kiro 账号额度用尽时，只按其所在组（codes.json 的 sharingGroup）
+// 在 config.sharingGroups 中配置的规则借用凭证，每次借用都写入审计记录
+
+// SharingAuditEntry 一次借用凭证的请求
+type SharingAuditEntry struct {
+	Time   time.Time `json:"time"`
+	Code   string    `json:"code"`            // 额度用尽的激活码
+	Group  string    `json:"group,omitempty"` // 激活码所在的共享组
+	Source string    `json:"source"`          // group | main
+	Lender string    `json:"lender"`          // 借出凭证的激活码，或主凭证池凭据（pool:id=N）
+	Model  string    `json:"model,omitempty"`
+	Status int       `json:"status,omitempty"` // 上游状态码，0 表示请求未发出 / 网络错误
+	Error  string    `json:"error,omitempty"`
+}
+
+// SharingAudit 追加写入的借用审计记录（JSONL）
+type SharingAudit struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewSharingAudit(path string) *SharingAudit {
+	a := &SharingAudit{path: path}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Errorf(logger.CatSystem, "创建借用审计目录失败: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Errorf(logger.CatSystem, "打开借用审计文件失败，审计记录将只写入日志: %v", err)
+	} else {
+		a.file = f
+	}
+	return a
+}
+
+// Append 写入一条审计记录（同时写日志）
+func (a *SharingAudit) Append(e SharingAuditEntry) {
+	logger.InfoFields(logger.CatCreds, "借用凭证", logger.F{
+		"code":   logger.MaskKey(e.Code),
+		"group":  e.Group,
+		"source": e.Source,
+		"lender": e.Lender,
+		"status": e.Status,
+		"error":  e.Error,
+	})
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		logger.Errorf(logger.CatSystem, "写入借用审计失败: %v", err)
+	}
+}
+
+// Records 最新的 limit 条记录，code 非空时只取该激活码借用或借出的
+func (a *SharingAudit) Records(code string, limit int) []SharingAuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	records := []SharingAuditEntry{}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return records
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e SharingAuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if code != "" && !strings.EqualFold(e.Code, code) && !strings.EqualFold(e.Lender, code) {
+			continue
+		}
+		records = append(records, e)
+		if limit > 0 && len(records) > limit {
+			records = records[1:]
+		}
+	}
+	return records
+}
+
+// SetSharingGroup 批量设置激活码的共享组，group 为空时退出共享组
+func (m *CodesManager) SetSharingGroup(codes []string, group string) (int, error) {
+	group = strings.TrimSpace(group)
+	if group == model.DefaultSharingGroup {
+		return 0, common.NewMessage(common.MsgSharingGroupReserved, group)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := 0
+	for _, code := range codes {
+		entry := m.FindByCode(code)
+		if entry == nil {
+			continue
+		}
+		entry.SharingGroup = group
+		updated++
+	}
+	if updated == 0 {
+		return 0, nil
+	}
+	logger.InfoFields(logger.CatAdmin, "激活码共享组变更", logger.F{"group": group, "count": updated})
+	return updated, m.saveToFile()
+}
+
+// SharingGroupOf 激活码所在的共享组
+func (m *CodesManager) SharingGroupOf(code string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if entry := m.FindByCode(strings.TrimPrefix(strings.ToUpper(code), "ACT-")); entry != nil {
+		return entry.SharingGroup
+	}
+	return ""
+}
+
+// SharingMembers 各共享组的成员激活码
+func (m *CodesManager) SharingMembers() map[string][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	members := make(map[string][]string)
+	for _, entry := range m.codes {
+		if entry.SharingGroup != "" {
+			members[entry.SharingGroup] = append(members[entry.SharingGroup], entry.Code)
+		}
+	}
+	return members
+}
+
+// sharingPolicy 激活码所在的组与生效的 fallback 规则：
+// 未加入组时使用 "default" 规则（未配置为 main）；加入了未配置规则的组时为 group + main
+func sharingPolicy(cfg *model.Config, group string) []string {
+	name := group
+	if name == "" {
+		name = model.DefaultSharingGroup
+	}
+	if g, ok := cfg.SharingGroups[name]; ok {
+		return g.Fallback
+	}
+	if group == "" {
+		return []string{model.SharingFallbackMain}
+	}
+	return []string{model.SharingFallbackGroup, model.SharingFallbackMain}
+}
+
+// lenderCredentials 借出方激活码的可用凭证（用户凭证优先，其次 codes.json），不可用时返回 nil
+func (p *Provider) lenderCredentials(code string) *model.KiroCredentials {
+	var cred *model.KiroCredentials
+	if p.UserCredsMgr != nil {
+		cred = p.UserCredsMgr.GetCredentials(code)
+	}
+	if cred == nil && p.Codes != nil {
+		if c := p.Codes.GetCredentials(code); c != nil {
+			copied := *c
+			cred = &copied
+		}
+	}
+	if cred == nil || cred.Disabled || cred.AccessToken == "" {
+		return nil
+	}
+	return cred
+}
+
+// borrowCredentials 激活码额度用尽后按共享组规则借用凭证重新请求，每次借用写入审计记录；
+// 规则不允许借用或借用全部失败时返回 false
+func (p *Provider) borrowCredentials(body []byte, code string) (*http.Response, bool) {
+	var group string
+	if p.Codes != nil {
+		group = p.Codes.SharingGroupOf(code)
+	}
+	base := SharingAuditEntry{Code: code, Group: group, Model: RequestModelID(body)}
+	record := func(source, lender string, status int, err error) {
+		if p.Audit == nil {
+			return
+		}
+		e := base
+		e.Time, e.Source, e.Lender, e.Status = time.Now(), source, lender, status
+		if err != nil {
+			e.Error = err.Error()
+			var ue *common.UpstreamError
+			if errors.As(err, &ue) {
+				e.Status = ue.Status
+			}
+		}
+		p.Audit.Append(e)
+	}
+
+	for _, rule := range sharingPolicy(p.Config.Load(), group) {
+		switch rule {
+		case model.SharingFallbackGroup:
+			if group == "" || p.Codes == nil {
+				continue
+			}
+			for _, lender := range p.Codes.SharingMembers()[group] {
+				if strings.EqualFold(lender, strings.TrimPrefix(strings.ToUpper(code), "ACT-")) {
+					continue
+				}
+				cred := p.lenderCredentials(lender)
+				if cred == nil {
+					continue
+				}
+				resp, err := p.callUserCredentials(body, cred)
+				if err != nil {
+					record(rule, lender, 0, err)
+					continue
+				}
+				if resp.StatusCode == 402 {
+					respBody, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if isMonthlyRequestLimit(string(respBody)) && p.UserCredsMgr != nil {
+						p.UserCredsMgr.MarkDisabled(lender)
+					}
+					record(rule, lender, resp.StatusCode, fmt.Errorf("%s", logger.TruncateBody(string(respBody), 200)))
+					continue
+				}
+				record(rule, lender, resp.StatusCode, nil)
+				metrics.Fallbacks.Inc(rule)
+				return resp, true
+			}
+		case model.SharingFallbackMain:
+			resp, cred, err := p.CallWithTokenManager(body, nil, "")
+			lender := "pool"
+			if cred != nil && cred.ID != nil {
+				lender = fmt.Sprintf("pool:id=%d", *cred.ID)
+			}
+			if err != nil {
+				record(rule, lender, 0, err)
+				continue
+			}
+			record(rule, lender, resp.StatusCode, nil)
+			metrics.Fallbacks.Inc(rule)
+			return resp, true
+		}
+	}
+	return nil, false
+}
+
+// rewrapBody 读取响应体并重新包装，供之后再次读取
+func rewrapBody(resp *http.Response) string {
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return string(respBody)
+}
@@ -0,0 +1,72 @@
+package kiro
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+// HandleAdminSharingGroupCodes POST /api/admin/codes/sharing-group {"codes": [...], "group": "team-a"}
+// group 为空时激活码退出共享组
+func HandleAdminSharingGroupCodes(w http.ResponseWriter, r *http.Request, cm *CodesManager) {
+	var req struct {
+		Codes []string `json:"codes"`
+		Group string   `json:"group"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Codes) == 0 {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgCodesListRequired)))
+		return
+	}
+	count, err := cm.SetSharingGroup(req.Codes, req.Group)
+	if err != nil {
+		common.WriteJSON(w, poolStatus(err), errorResult(r, err))
+		return
+	}
+	common.WriteJSON(w, http.StatusOK, codeResult(r, true, common.NewMessage(common.MsgCodesUpdated, count)))
+}
+
+// HandleAdminSharing GET /api/admin/sharing，各共享组的成员与生效的 fallback 规则
+func HandleAdminSharing(w http.ResponseWriter, r *http.Request, cm *CodesManager, cfg *model.Config) {
+	if r.Method != http.MethodGet {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	members := cm.SharingMembers()
+	groups := make(map[string]interface{})
+	for name := range cfg.SharingGroups {
+		if _, ok := members[name]; !ok && name != model.DefaultSharingGroup {
+			members[name] = []string{}
+		}
+	}
+	for name, codes := range members {
+		groups[name] = map[string]interface{}{"members": codes, "fallback": sharingPolicy(cfg, name)}
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"groups":  groups,
+		"default": map[string]interface{}{"fallback": sharingPolicy(cfg, "")},
+	})
+}
+
+// HandleAdminSharingAudit GET /api/admin/sharing/audit?code=&limit=，最新 limit 条借用记录（默认 500）
+func HandleAdminSharingAudit(w http.ResponseWriter, r *http.Request, audit *SharingAudit) {
+	if r.Method != http.MethodGet {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 500
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	code := strings.TrimPrefix(strings.ToUpper(r.URL.Query().Get("code")), "ACT-")
+	records := audit.Records(code, limit)
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"count":   len(records),
+		"records": records,
+	})
+}
@@ -0,0 +1,282 @@
+package kiro
+
+import (
+	"encoding/json"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+	"kiro-go/internal/store"
+)
+
+// 启用嵌入式存储（config.storePath）后各管理器仍在内存中保存一份数据，
+// 写入时改为在 bbolt 事务中只更新有变化的记录；首次启动从旧 JSON 文件导入，旧文件保持不变。
+// 加锁顺序：UserCredentialsManager.mu → CodesManager.mu → 存储写事务
+
+// UseStore 改用嵌入式存储：首次从 codes.json 导入，之后从存储加载并写回存储
+func (m *CodesManager) UseStore(st *store.Store) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := st.Migrate(store.Codes, m.filePath, func(tx *store.Tx) (int, error) {
+		for i := range m.codes {
+			if err := tx.Put(store.Codes, m.codes[i].Code, &m.codes[i]); err != nil {
+				return 0, err
+			}
+		}
+		return len(m.codes), nil
+	})
+	if err != nil {
+		return err
+	}
+	codes := []CodeEntry{}
+	err = st.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.Codes, func(_ string, v []byte) error {
+			var e CodeEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			codes = append(codes, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	m.codes = codes
+	m.store = st
+	return nil
+}
+
+// saveToStore 把内存中的卡密写回存储（调用方持有 m.mu）
+func (m *CodesManager) saveToStore() error {
+	items := make(map[string]interface{}, len(m.codes))
+	for i := range m.codes {
+		items[m.codes[i].Code] = &m.codes[i]
+	}
+	return m.store.Update(func(tx *store.Tx) error {
+		return tx.Sync(store.Codes, items)
+	})
+}
+
+// SyncCredentials 用户凭证刷新后同步到激活码（仅当激活码已有凭证且 token 变化时，避免覆盖空数据）。
+// persist 为调用方自身的写入：启用存储时与激活码的更新在同一事务提交，两边不会出现不一致
+func (m *CodesManager) SyncCredentials(code string, creds *model.KiroCredentials, persist func(tx *store.Tx) error) error {
+	// 每个请求都会调用，先用读锁判断，没有变化时不抢写锁
+	if persist == nil {
+		m.mu.RLock()
+		changed := credentialsChanged(m.FindByCode(code), creds)
+		m.mu.RUnlock()
+		if !changed {
+			return nil
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.FindByCode(code)
+	changed := credentialsChanged(entry, creds)
+	if changed {
+		c := *creds
+		entry.Credentials = &c
+	}
+
+	if m.store == nil {
+		if persist != nil {
+			if err := persist(nil); err != nil {
+				return err
+			}
+		}
+		if changed {
+			return m.saveToFile()
+		}
+		return nil
+	}
+	return m.store.Update(func(tx *store.Tx) error {
+		if persist != nil {
+			if err := persist(tx); err != nil {
+				return err
+			}
+		}
+		if changed {
+			return tx.Put(store.Codes, entry.Code, entry)
+		}
+		return nil
+	})
+}
+
+// credentialsChanged 激活码已有凭证且 token 与 creds 不同
+func credentialsChanged(entry *CodeEntry, creds *model.KiroCredentials) bool {
+	return entry != nil && entry.Credentials != nil && creds != nil &&
+		(entry.Credentials.AccessToken != creds.AccessToken || entry.Credentials.RefreshToken != creds.RefreshToken)
+}
+
+// UseStore 改用嵌入式存储：首次从 user_credentials.json 导入，之后从存储加载并写回存储
+func (m *UserCredentialsManager) UseStore(st *store.Store) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := st.Migrate(store.UserCredentials, m.filePath, func(tx *store.Tx) (int, error) {
+		for key, entry := range m.data {
+			if err := tx.Put(store.UserCredentials, key, entry); err != nil {
+				return 0, err
+			}
+		}
+		return len(m.data), nil
+	})
+	if err != nil {
+		return err
+	}
+	data := make(map[string]*model.UserCredentialEntry)
+	err = st.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.UserCredentials, func(key string, v []byte) error {
+			var e model.UserCredentialEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			data[key] = &e
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	m.data = data
+	m.store = st
+	return nil
+}
+
+// saveToStore 把内存中的用户凭证写回存储（调用方持有 m.mu）
+func (m *UserCredentialsManager) saveToStore() error {
+	items := make(map[string]interface{}, len(m.data))
+	for key, entry := range m.data {
+		items[key] = entry
+	}
+	return m.store.Update(func(tx *store.Tx) error {
+		return tx.Sync(store.UserCredentials, items)
+	})
+}
+
+// OnCredentialsRefreshed 设置 token 刷新后的联动写入（如 CodesManager.SyncCredentials）
+func (m *UserCredentialsManager) OnCredentialsRefreshed(fn func(code string, creds *model.KiroCredentials, persist func(tx *store.Tx) error) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRefreshed = fn
+}
+
+// persistRefreshedLocked 持久化刷新后的条目，并通过 onRefreshed 同步到激活码（调用方持有 m.mu）
+func (m *UserCredentialsManager) persistRefreshedLocked(key string, entry *model.UserCredentialEntry) error {
+	persist := func(tx *store.Tx) error {
+		if tx == nil {
+			return m.saveToFile()
+		}
+		return tx.Put(store.UserCredentials, key, entry)
+	}
+	if m.onRefreshed == nil {
+		if m.store != nil {
+			return m.store.Update(persist)
+		}
+		return persist(nil)
+	}
+	creds := entry.Credentials
+	return m.onRefreshed(normalizeKey(key), &creds, persist)
+}
+
+// UsePoolStore 主凭证池改用嵌入式存储：首次从 credentials.json 导入 fileList，返回存储中的凭证
+func UsePoolStore(st *store.Store, source string, fileList []*model.KiroCredentials) ([]*model.KiroCredentials, error) {
+	err := st.Migrate(store.PoolCredentials, source, func(tx *store.Tx) (int, error) {
+		for i, c := range fileList {
+			if err := tx.Put(store.PoolCredentials, store.SeqKey(uint64(i)), c); err != nil {
+				return 0, err
+			}
+		}
+		return len(fileList), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return LoadPoolCredentials(st)
+}
+
+// LoadPoolCredentials 从存储读取主凭证池（按保存顺序）
+func LoadPoolCredentials(st *store.Store) ([]*model.KiroCredentials, error) {
+	var list []*model.KiroCredentials
+	err := st.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.PoolCredentials, func(_ string, v []byte) error {
+			var c model.KiroCredentials
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			list = append(list, &c)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// SavePoolCredentials 用 list 整体替换存储中的主凭证池（单个事务）
+func SavePoolCredentials(st *store.Store, list []*model.KiroCredentials) error {
+	items := make(map[string]interface{}, len(list))
+	for i, c := range list {
+		items[store.SeqKey(uint64(i))] = c
+	}
+	return st.Update(func(tx *store.Tx) error {
+		return tx.Sync(store.PoolCredentials, items)
+	})
+}
+
+// UseStore 用量改为写入嵌入式存储：首次导入 usage.jsonl，之后不再追加写文件
+func (l *UsageLedger) UseStore(st *store.Store) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := st.Migrate(store.Usage, l.path, func(tx *store.Tx) (int, error) {
+		count := 0
+		var err error
+		l.scan(func(e *common.UsageEntry) bool {
+			if err = tx.Append(store.Usage, e); err != nil {
+				return false
+			}
+			count++
+			return true
+		})
+		return count, err
+	})
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	l.store = st
+	l.resetRollupsLocked()
+	count := 0
+	l.scan(func(e *common.UsageEntry) bool {
+		l.rollupLocked(e)
+		count++
+		return true
+	})
+	logger.Infof(logger.CatSystem, "用量已切换到存储: %d 条记录", count)
+	return nil
+}
+
+// scanStoreUsage 顺序读取存储中的用量，fn 返回 false 时停止；损坏的记录跳过
+func scanStoreUsage(st *store.Store, fn func(e *common.UsageEntry) bool) {
+	err := st.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.Usage, func(_ string, v []byte) error {
+			var e common.UsageEntry
+			if json.Unmarshal(v, &e) != nil {
+				return nil
+			}
+			if !fn(&e) {
+				return store.ErrStop
+			}
+			return nil
+		})
+	})
+	if err != nil && err != store.ErrStop {
+		logger.Errorf(logger.CatSystem, "读取用量存储失败: %v", err)
+	}
+}
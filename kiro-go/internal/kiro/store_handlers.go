@@ -0,0 +1,31 @@
+package kiro
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/store"
+)
+
+// HandleAdminStoreExport GET /api/admin/store/export[?usage=1] 流式导出存储的一致快照（JSON），用于备份
+// 默认不含用量记录，?usage=1 时一并导出
+func HandleAdminStoreExport(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	if r.Method != http.MethodGet {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if st == nil {
+		common.WriteJSON(w, http.StatusNotFound, codeResult(r, false, common.NewMessage(common.MsgStoreDisabled)))
+		return
+	}
+	includeUsage := r.URL.Query().Get("usage") == "1"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kiro-store-%s.json"`, time.Now().Format("20060102-150405")))
+	// 已开始写出后无法再改状态码，出错时只能记录日志，客户端收到的是不完整的 JSON
+	if err := st.ExportJSON(w, includeUsage); err != nil {
+		logger.Errorf(logger.CatAdmin, "导出存储失败: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package kiro
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+)
+
+// tokenDenyListFile revoked_tokens.json 文件格式（值均为 Unix 秒）
+type tokenDenyListFile struct {
+	Tokens map[string]int64 `json:"tokens"` // jti → 令牌过期时间，过期后自动清理
+	Codes  map[string]int64 `json:"codes"`  // 激活码 → 吊销时间，此前签发的令牌全部失效
+}
+
+// TokenDenyList 客户端令牌吊销列表
+type TokenDenyList struct {
+	filePath string
+	mu       sync.RWMutex
+	data     tokenDenyListFile
+}
+
+func NewTokenDenyList(filePath string) *TokenDenyList {
+	d := &TokenDenyList{filePath: filePath}
+	if data, err := os.ReadFile(filePath); err == nil {
+		if err := json.Unmarshal(data, &d.data); err != nil {
+			logger.Errorf(logger.CatAuth, "解析令牌吊销列表失败: %v", err)
+		}
+	}
+	if d.data.Tokens == nil {
+		d.data.Tokens = make(map[string]int64)
+	}
+	if d.data.Codes == nil {
+		d.data.Codes = make(map[string]int64)
+	}
+	return d
+}
+
+// IsRevoked 令牌本身或其所属激活码在签发后被吊销
+func (d *TokenDenyList) IsRevoked(claims *common.ClientTokenClaims) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, ok := d.data.Tokens[claims.ID]; ok {
+		return true
+	}
+	if revokedAt, ok := d.data.Codes[strings.ToUpper(claims.Subject)]; ok && claims.IssuedAt <= revokedAt {
+		return true
+	}
+	return false
+}
+
+// RevokeToken 吊销单个令牌，记录保留到令牌过期
+func (d *TokenDenyList) RevokeToken(jti string, expiresAt int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data.Tokens[jti] = expiresAt
+	logger.Infof(logger.CatAuth, "令牌已吊销: %s", jti)
+	return d.saveLocked()
+}
+
+// RevokeCode 吊销激活码此前签发的全部令牌
+func (d *TokenDenyList) RevokeCode(code string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data.Codes[strings.ToUpper(code)] = time.Now().Unix()
+	logger.Infof(logger.CatAuth, "激活码 %s 的令牌已全部吊销", logger.MaskKey(code))
+	return d.saveLocked()
+}
+
+// Snapshot 当前吊销记录
+func (d *TokenDenyList) Snapshot() (tokens, codes map[string]int64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	tokens = make(map[string]int64, len(d.data.Tokens))
+	for k, v := range d.data.Tokens {
+		tokens[k] = v
+	}
+	codes = make(map[string]int64, len(d.data.Codes))
+	for k, v := range d.data.Codes {
+		codes[logger.MaskKey(k)] = v
+	}
+	return tokens, codes
+}
+
+// saveLocked 清理已过期的令牌记录后写回文件，调用方需持有写锁
+func (d *TokenDenyList) saveLocked() error {
+	now := time.Now().Unix()
+	for jti, exp := range d.data.Tokens {
+		if exp < now {
+			delete(d.data.Tokens, jti)
+		}
+	}
+	data, err := json.MarshalIndent(d.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.filePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(d.filePath, data, 0644)
+}
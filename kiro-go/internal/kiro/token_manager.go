@@ -15,14 +15,30 @@ import (
 
 // TokenManager 多凭据 Token 管理器
 type TokenManager struct {
-	Config      *model.Config
+	Config      *model.ConfigRef
 	Credentials []*model.KiroCredentials
 	mu          sync.Mutex
 	current     int
+	affinity    *sessionAffinity          // 会话粘性，nil 表示禁用
+	health      map[int]*CredentialHealth // 凭据 id → 最近调用结果（只在内存中）
+	quota       map[int]*UsageLimits      // 凭据 id → 最近一次额度查询结果（只在内存中）
+	suspended   map[int]string            // 凭据 id → 运行时暂停原因（额度用尽等，只在内存中，不写回）
+
+	persistMu sync.Mutex                                // 串行化管理接口的写回，保证文件与内存顺序一致
+	persist   func(list []*model.KiroCredentials) error // 管理接口修改凭证池后的写回
 }
 
-func NewTokenManager(cfg *model.Config, creds []*model.KiroCredentials) *TokenManager {
-	return &TokenManager{Config: cfg, Credentials: creds}
+func NewTokenManager(cfg *model.ConfigRef, creds []*model.KiroCredentials) *TokenManager {
+	assignCredentialIDs(creds, nil)
+	c := cfg.Load()
+	return &TokenManager{
+		Config:      cfg,
+		Credentials: creds,
+		affinity:    newSessionAffinity(c.SessionAffinityTTL, c.SessionAffinityMax),
+		health:      make(map[int]*CredentialHealth),
+		quota:       make(map[int]*UsageLimits),
+		suspended:   make(map[int]string),
+	}
 }
 
 // AcquireContext 获取一个可用的凭据和 token
@@ -31,38 +47,159 @@ func NewTokenManager(cfg *model.Config, creds []*model.KiroCredentials) *TokenMa
 func (tm *TokenManager) AcquireContext() (*model.KiroCredentials, string, error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
+	idx, err := tm.acquireLocked(nil)
+	if err != nil {
+		return nil, "", err
+	}
+	cred := tm.Credentials[idx]
+	return cred, cred.AccessToken, nil
+}
 
-	if len(tm.Credentials) == 0 {
-		return nil, "", fmt.Errorf("没有可用的凭据")
+// Peek 返回下一个将被 AcquireContext 选中的凭据和 token，不推进轮询位置（模型目录按它的 profile 查询）
+func (tm *TokenManager) Peek() (*model.KiroCredentials, string, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	current := tm.current
+	idx, err := tm.acquireLocked(nil)
+	tm.current = current
+	if err != nil {
+		return nil, "", err
 	}
+	cred := tm.Credentials[idx]
+	return cred, cred.AccessToken, nil
+}
 
-	// 优先选未过期且未禁用的凭据
-	for i := 0; i < len(tm.Credentials); i++ {
-		idx := (tm.current + i) % len(tm.Credentials)
-		cred := tm.Credentials[idx]
-		if cred.Disabled || cred.AccessToken == "" {
+// ForProfile 池中 profileArn 相同的可用凭据和 token（优先未过期的），没有时返回 nil
+func (tm *TokenManager) ForProfile(profileArn string) (*model.KiroCredentials, string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	var fallback *model.KiroCredentials
+	for _, cred := range tm.Credentials {
+		if cred.ProfileArn != profileArn || tm.disabledLocked(cred) || cred.AccessToken == "" {
 			continue
 		}
 		if !IsTokenExpired(cred) {
-			tm.current = (idx + 1) % len(tm.Credentials)
+			return cred, cred.AccessToken
+		}
+		if fallback == nil {
+			fallback = cred
+		}
+	}
+	if fallback == nil {
+		return nil, ""
+	}
+	return fallback, fallback.AccessToken
+}
+
+// AcquireForSession 同一会话优先使用上次的凭据；固定的凭据不可用（禁用 / 无 token），
+// 或已过期而池中有未过期的凭据时，按轮询重新选择并改为固定到新凭据。sessionID 为空时等同 AcquireContext
+// pin 非 nil 时只在限定的凭据 / 分组内选择（API Key 固定凭据）
+func (tm *TokenManager) AcquireForSession(sessionID string, pin *model.CredentialPin) (*model.KiroCredentials, string, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if idx := tm.affinity.get(sessionID); idx >= 0 && idx < len(tm.Credentials) {
+		cred := tm.Credentials[idx]
+		if pin.Matches(cred) && !tm.disabledLocked(cred) && cred.AccessToken != "" && (!IsTokenExpired(cred) || !tm.hasHealthyLocked(pin)) {
 			return cred, cred.AccessToken, nil
 		}
+		logger.Debugf(logger.CatCreds, "会话固定的凭据 #%d 不可用，重新选择", idx)
+	}
+
+	idx, err := tm.acquireLocked(pin)
+	if err != nil {
+		return nil, "", err
+	}
+	tm.affinity.pin(sessionID, idx)
+	cred := tm.Credentials[idx]
+	return cred, cred.AccessToken, nil
+}
+
+// ReleaseSession 凭据请求失败后解除会话固定，重试时换其他凭据
+func (tm *TokenManager) ReleaseSession(sessionID string, cred *model.KiroCredentials) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	for i, c := range tm.Credentials {
+		if c == cred {
+			tm.affinity.release(sessionID, i)
+			return
+		}
+	}
+}
+
+// disabledLocked 凭据是否不可用：配置中禁用，或运行时暂停（额度用尽等），调用方需持有 tm.mu
+func (tm *TokenManager) disabledLocked(cred *model.KiroCredentials) bool {
+	disabled, _ := tm.disabledReasonLocked(cred)
+	return disabled
+}
+
+// disabledReasonLocked 同 disabledLocked，并返回禁用原因（配置中的禁用优先），调用方需持有 tm.mu
+func (tm *TokenManager) disabledReasonLocked(cred *model.KiroCredentials) (bool, string) {
+	if cred.Disabled {
+		return true, cred.DisabledReason
+	}
+	if cred.ID != nil {
+		if reason, ok := tm.suspended[*cred.ID]; ok {
+			return true, reason
+		}
+	}
+	return false, ""
+}
+
+// healthyLocked 凭据可直接使用（未禁用、有未过期的 token），调用方需持有 tm.mu
+func (tm *TokenManager) healthyLocked(cred *model.KiroCredentials) bool {
+	return !tm.disabledLocked(cred) && cred.AccessToken != "" && !IsTokenExpired(cred)
+}
+
+// hasHealthyLocked 池中（限定范围内）是否有未过期的可用凭据，调用方需持有 tm.mu
+func (tm *TokenManager) hasHealthyLocked(pin *model.CredentialPin) bool {
+	for _, cred := range tm.Credentials {
+		if pin.Matches(cred) && tm.healthyLocked(cred) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireLocked 轮询选择凭据下标（pin 非 nil 时跳过范围外的凭据），调用方需持有 tm.mu
+func (tm *TokenManager) acquireLocked(pin *model.CredentialPin) (int, error) {
+	if len(tm.Credentials) == 0 {
+		return -1, fmt.Errorf("没有可用的凭据")
+	}
+
+	// 优先选未过期且未禁用的凭据：只在 priority 数值最小的一档内轮询（接近额度上限的排在最后）
+	best, found := 0, false
+	for _, cred := range tm.Credentials {
+		if pin.Matches(cred) && tm.healthyLocked(cred) && (!found || tm.effectivePriorityLocked(cred) < best) {
+			best, found = tm.effectivePriorityLocked(cred), true
+		}
+	}
+	for i := 0; found && i < len(tm.Credentials); i++ {
+		idx := (tm.current + i) % len(tm.Credentials)
+		cred := tm.Credentials[idx]
+		if pin.Matches(cred) && tm.healthyLocked(cred) && tm.effectivePriorityLocked(cred) == best {
+			tm.current = (idx + 1) % len(tm.Credentials)
+			return idx, nil
+		}
 	}
 
 	// 没有未过期的，直接用第一个有 accessToken 的凭据（不刷新，不阻塞）
 	for i := 0; i < len(tm.Credentials); i++ {
 		idx := (tm.current + i) % len(tm.Credentials)
 		cred := tm.Credentials[idx]
-		if cred.Disabled {
+		if tm.disabledLocked(cred) || !pin.Matches(cred) {
 			continue
 		}
 		if cred.AccessToken != "" {
 			tm.current = (idx + 1) % len(tm.Credentials)
-			return cred, cred.AccessToken, nil
+			return idx, nil
 		}
 	}
 
-	return nil, "", fmt.Errorf("所有凭据均无可用 AccessToken（共 %d 个）", len(tm.Credentials))
+	if pin != nil {
+		return -1, fmt.Errorf("固定的凭据（%s）均不可用", pin)
+	}
+	return -1, fmt.Errorf("所有凭据均无可用 AccessToken（共 %d 个）", len(tm.Credentials))
 }
 
 func IsTokenExpired(cred *model.KiroCredentials) bool {
@@ -0,0 +1,82 @@
+package kiro
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+// usageFilterFromQuery 解析 ?code=&model=&from=YYYY-MM-DD&to=YYYY-MM-DD
+func usageFilterFromQuery(r *http.Request) UsageFilter {
+	q := r.URL.Query()
+	return UsageFilter{
+		Code:  strings.TrimPrefix(strings.ToUpper(q.Get("code")), "ACT-"),
+		Model: q.Get("model"),
+		From:  q.Get("from"),
+		To:    q.Get("to"),
+	}
+}
+
+// HandleAdminUsage GET /api/admin/usage?code=&model=&from=&to=&group=day|month|code|model
+func HandleAdminUsage(w http.ResponseWriter, r *http.Request, ledger *UsageLedger) {
+	if r.Method != http.MethodGet {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		group = "day"
+	}
+	rows, total := ledger.Query(usageFilterFromQuery(r), group)
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"group":   group,
+		"rows":    rows,
+		"total":   total,
+	})
+}
+
+// HandleAdminUsageRecords GET /api/admin/usage/records?code=&model=&from=&to=&limit=
+// 返回满足条件的最新 limit 条明细（默认 500）
+func HandleAdminUsageRecords(w http.ResponseWriter, r *http.Request, ledger *UsageLedger) {
+	if r.Method != http.MethodGet {
+		http.Error(w, common.T(r, common.MsgMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 500
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	records := ledger.Records(usageFilterFromQuery(r), limit)
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"count":   len(records),
+		"records": records,
+	})
+}
+
+// HandleAdminCodeUsage GET /api/admin/usage/code?code= 激活码今日 / 本月用量与生效配额
+func HandleAdminCodeUsage(w http.ResponseWriter, r *http.Request, ledger *UsageLedger, cm *CodesManager, cfg *model.Config) {
+	code := strings.TrimPrefix(strings.ToUpper(r.URL.Query().Get("code")), "ACT-")
+	if code == "" {
+		common.WriteJSON(w, http.StatusBadRequest, codeResult(r, false, common.NewMessage(common.MsgMissingActivationCode)))
+		return
+	}
+	today, month := ledger.CodeUsage(code)
+	quota := cfg.UsageQuota.Merge(cm.GetQuota(code))
+	var exceeded interface{}
+	if e := ledger.CheckQuota(code, quota); e != nil {
+		exceeded = string(e.Code)
+	}
+	common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":         true,
+		"activation_code": code,
+		"today":           today,
+		"month":           month,
+		"quota":           quota,
+		"exceeded":        exceeded,
+	})
+}
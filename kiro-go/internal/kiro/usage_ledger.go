@@ -0,0 +1,330 @@
+package kiro
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+	"kiro-go/internal/store"
+)
+
+// UsageRollup 汇总值
+type UsageRollup struct {
+	Requests         int     `json:"requests"`
+	InputTokens      int     `json:"input_tokens"`
+	OutputTokens     int     `json:"output_tokens"`
+	CacheReadTokens  int     `json:"cache_read_input_tokens"`
+	CacheWriteTokens int     `json:"cache_creation_input_tokens"`
+	Credits          float64 `json:"credits"`
+}
+
+func (r *UsageRollup) add(u common.Usage) {
+	r.Requests++
+	r.InputTokens += u.InputTokens
+	r.OutputTokens += u.OutputTokens
+	r.CacheReadTokens += u.CacheReadTokens
+	r.CacheWriteTokens += u.CacheWriteTokens
+	r.Credits += u.Credits
+}
+
+func (r *UsageRollup) merge(o *UsageRollup) {
+	r.Requests += o.Requests
+	r.InputTokens += o.InputTokens
+	r.OutputTokens += o.OutputTokens
+	r.CacheReadTokens += o.CacheReadTokens
+	r.CacheWriteTokens += o.CacheWriteTokens
+	r.Credits += o.Credits
+}
+
+// TotalTokens 输入（含缓存读写）+ 输出
+func (r *UsageRollup) TotalTokens() int {
+	return r.InputTokens + r.OutputTokens + r.CacheReadTokens + r.CacheWriteTokens
+}
+
+// rollupKey 按 日期 + 激活码 + 模型 汇总
+type rollupKey struct {
+	day   string // YYYY-MM-DD（服务器本地时区）
+	code  string
+	model string
+}
+
+// UsageFilter 查询条件，字段为空表示不过滤；From / To 为 YYYY-MM-DD（含）
+type UsageFilter struct {
+	Code  string
+	Model string
+	From  string
+	To    string
+}
+
+func (f UsageFilter) match(day, code, model string) bool {
+	if f.Code != "" && !strings.EqualFold(f.Code, code) {
+		return false
+	}
+	if f.Model != "" && f.Model != model {
+		return false
+	}
+	if f.From != "" && day < f.From {
+		return false
+	}
+	if f.To != "" && day > f.To {
+		return false
+	}
+	return true
+}
+
+// UsageRow 分组汇总结果
+type UsageRow struct {
+	Period string `json:"period,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Model  string `json:"model,omitempty"`
+	UsageRollup
+}
+
+// UsageLedger 追加写入的用量账本（JSONL），启动时回放文件构建按日汇总
+type UsageLedger struct {
+	path   string
+	mu     sync.Mutex
+	file   *os.File
+	size   int64 // 已完整写入文件的字节数，明细查询只读到这里，不需要持锁扫描
+	days   map[rollupKey]*UsageRollup
+	byCode map[string]map[string]*UsageRollup // 激活码 → 日（YYYY-MM-DD）/ 月（YYYY-MM）汇总，配额检查用
+	store  *store.Store                       // 非 nil 时写入嵌入式存储而不是 JSONL 文件
+}
+
+func NewUsageLedger(path string) *UsageLedger {
+	l := &UsageLedger{path: path}
+	l.resetRollupsLocked()
+	l.load()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Errorf(logger.CatSystem, "创建用量账本目录失败: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Errorf(logger.CatSystem, "打开用量账本失败，用量将不会持久化: %v", err)
+	} else {
+		l.file = f
+		if info, err := f.Stat(); err == nil {
+			l.size = info.Size()
+		}
+	}
+	return l
+}
+
+func (l *UsageLedger) resetRollupsLocked() {
+	l.days = make(map[rollupKey]*UsageRollup)
+	l.byCode = make(map[string]map[string]*UsageRollup)
+}
+
+// load 回放账本文件
+func (l *UsageLedger) load() {
+	count := 0
+	l.scan(func(e *common.UsageEntry) bool {
+		l.rollupLocked(e)
+		count++
+		return true
+	})
+	if count > 0 {
+		logger.Infof(logger.CatSystem, "用量账本已加载: %d 条记录", count)
+	}
+}
+
+// scan 顺序读取账本，fn 返回 false 时停止；损坏的行跳过（调用方持有锁，启动 / 切换存储时使用）
+func (l *UsageLedger) scan(fn func(e *common.UsageEntry) bool) {
+	scanUsage(l.store, l.path, -1, fn)
+}
+
+// scanUsage 读取存储（st 非 nil，事务内为一致快照）或 JSONL 文件的前 limit 字节（limit < 0 读到末尾）
+// 文件只追加写入，limit 以内的内容不会再变化，因此可以在不持有账本锁的情况下读取
+func scanUsage(st *store.Store, path string, limit int64, fn func(e *common.UsageEntry) bool) {
+	if st != nil {
+		scanStoreUsage(st, fn)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e common.UsageEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if !fn(&e) {
+			return
+		}
+	}
+}
+
+func (l *UsageLedger) rollupLocked(e *common.UsageEntry) {
+	key := rollupKey{day: e.Time.Local().Format("2006-01-02"), code: e.Code, model: e.Model}
+	r := l.days[key]
+	if r == nil {
+		r = &UsageRollup{}
+		l.days[key] = r
+	}
+	r.add(e.Usage)
+
+	periods := l.byCode[e.Code]
+	if periods == nil {
+		periods = make(map[string]*UsageRollup)
+		l.byCode[e.Code] = periods
+	}
+	for _, period := range []string{key.day, key.day[:7]} {
+		p := periods[period]
+		if p == nil {
+			p = &UsageRollup{}
+			periods[period] = p
+		}
+		p.add(e.Usage)
+	}
+}
+
+// Append 写入一条记录并更新汇总
+func (l *UsageLedger) Append(e common.UsageEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.store != nil {
+		if err := l.store.Update(func(tx *store.Tx) error { return tx.Append(store.Usage, e) }); err != nil {
+			logger.Errorf(logger.CatSystem, "写入用量存储失败: %v", err)
+		}
+	} else if l.file != nil {
+		n, err := l.file.Write(append(line, '\n'))
+		if err != nil {
+			logger.Errorf(logger.CatSystem, "写入用量账本失败: %v", err)
+		} else {
+			l.size += int64(n)
+		}
+	}
+	l.rollupLocked(&e)
+}
+
+// Query 按条件汇总，groupBy: day | month | code | model
+func (l *UsageLedger) Query(filter UsageFilter, groupBy string) ([]UsageRow, UsageRollup) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	groups := make(map[UsageRow]*UsageRollup)
+	var total UsageRollup
+	for key, r := range l.days {
+		if !filter.match(key.day, key.code, key.model) {
+			continue
+		}
+		var g UsageRow
+		switch groupBy {
+		case "month":
+			g.Period = key.day[:7]
+		case "code":
+			g.Code = key.code
+		case "model":
+			g.Model = key.model
+		default:
+			g.Period = key.day
+		}
+		agg := groups[g]
+		if agg == nil {
+			agg = &UsageRollup{}
+			groups[g] = agg
+		}
+		agg.merge(r)
+		total.merge(r)
+	}
+
+	rows := make([]UsageRow, 0, len(groups))
+	for g, agg := range groups {
+		g.UsageRollup = *agg
+		rows = append(rows, g)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Period != rows[j].Period {
+			return rows[i].Period < rows[j].Period
+		}
+		if rows[i].Code != rows[j].Code {
+			return rows[i].Code < rows[j].Code
+		}
+		return rows[i].Model < rows[j].Model
+	})
+	return rows, total
+}
+
+// Records 按条件读取明细（最多 limit 条，取最新的）
+// 只在锁内记下当前位置，扫描时不持锁，不阻塞请求写入用量
+func (l *UsageLedger) Records(filter UsageFilter, limit int) []common.UsageEntry {
+	l.mu.Lock()
+	st, size := l.store, l.size
+	l.mu.Unlock()
+
+	var records []common.UsageEntry
+	scanUsage(st, l.path, size, func(e *common.UsageEntry) bool {
+		if filter.match(e.Time.Local().Format("2006-01-02"), e.Code, e.Model) {
+			records = append(records, *e)
+			if limit > 0 && len(records) > limit {
+				records = records[1:]
+			}
+		}
+		return true
+	})
+	return records
+}
+
+// CodeUsage 激活码今日与本月的汇总
+func (l *UsageLedger) CodeUsage(code string) (today, month UsageRollup) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	periods := l.byCode[code]
+	if r := periods[now.Format("2006-01-02")]; r != nil {
+		today = *r
+	}
+	if r := periods[now.Format("2006-01")]; r != nil {
+		month = *r
+	}
+	return today, month
+}
+
+// CheckQuota 检查激活码是否超出配额，未超出返回 nil
+func (l *UsageLedger) CheckQuota(code string, quota model.UsageQuota) *common.RateLimitError {
+	if quota.DailyRequests <= 0 && quota.MonthlyRequests <= 0 && quota.DailyTokens <= 0 &&
+		quota.MonthlyTokens <= 0 && quota.MonthlyCredits <= 0 {
+		return nil
+	}
+	today, month := l.CodeUsage(code)
+
+	now := time.Now()
+	untilTomorrow := time.Until(time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()))
+	untilNextMonth := time.Until(time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location()))
+
+	switch {
+	case quota.DailyRequests > 0 && today.Requests >= quota.DailyRequests:
+		return &common.RateLimitError{Code: common.MsgQuotaDailyRequests, Limit: quota.DailyRequests, RetryAfter: untilTomorrow}
+	case quota.DailyTokens > 0 && today.TotalTokens() >= quota.DailyTokens:
+		return &common.RateLimitError{Code: common.MsgQuotaDailyTokens, Limit: quota.DailyTokens, RetryAfter: untilTomorrow}
+	case quota.MonthlyRequests > 0 && month.Requests >= quota.MonthlyRequests:
+		return &common.RateLimitError{Code: common.MsgQuotaMonthlyRequests, Limit: quota.MonthlyRequests, RetryAfter: untilNextMonth}
+	case quota.MonthlyTokens > 0 && month.TotalTokens() >= quota.MonthlyTokens:
+		return &common.RateLimitError{Code: common.MsgQuotaMonthlyTokens, Limit: quota.MonthlyTokens, RetryAfter: untilNextMonth}
+	case quota.MonthlyCredits > 0 && month.Credits >= quota.MonthlyCredits:
+		return &common.RateLimitError{Code: common.MsgQuotaMonthlyCredits, Limit: quota.MonthlyCredits, RetryAfter: untilNextMonth}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package kiro
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro-go/internal/common"
+	"kiro-go/internal/model"
+)
+
+func usageEntry(at time.Time, code string, input, output int, credits float64) common.UsageEntry {
+	return common.UsageEntry{
+		Time: at,
+		Code: code,
+		Auth: "act",
+		Usage: common.Usage{
+			Model:        "claude-sonnet-4.5",
+			Backend:      "kiro",
+			InputTokens:  input,
+			OutputTokens: output,
+			Credits:      credits,
+		},
+	}
+}
+
+// lastMonth 上个月的某一时刻（不会落在本月）
+func lastMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 12, 0, 0, 0, now.Location()).AddDate(0, 0, -1)
+}
+
+func TestUsageLedgerCodeUsage(t *testing.T) {
+	l := NewUsageLedger(filepath.Join(t.TempDir(), "usage.jsonl"))
+	now := time.Now()
+
+	l.Append(usageEntry(now, "AAAA", 100, 50, 1))
+	l.Append(usageEntry(now, "AAAA", 10, 5, 0.5))
+	l.Append(usageEntry(lastMonth(now), "AAAA", 1000, 1000, 10))
+	l.Append(usageEntry(now, "BBBB", 7, 7, 7))
+
+	today, month := l.CodeUsage("AAAA")
+	if today.Requests != 2 || today.TotalTokens() != 165 || today.Credits != 1.5 {
+		t.Fatalf("today = %+v", today)
+	}
+	if month.Requests != 2 || month.TotalTokens() != 165 {
+		t.Fatalf("month 不应包含上个月的记录: %+v", month)
+	}
+	if today, month := l.CodeUsage("CCCC"); today.Requests != 0 || month.Requests != 0 {
+		t.Fatalf("未使用的激活码: %+v %+v", today, month)
+	}
+}
+
+func TestUsageLedgerCheckQuota(t *testing.T) {
+	l := NewUsageLedger(filepath.Join(t.TempDir(), "usage.jsonl"))
+	now := time.Now()
+
+	if err := l.CheckQuota("AAAA", model.UsageQuota{}); err != nil {
+		t.Fatalf("未配置配额时不应拒绝: %+v", err)
+	}
+
+	quota := model.UsageQuota{DailyRequests: 2, MonthlyTokens: 1000, MonthlyCredits: 5}
+	l.Append(usageEntry(now, "AAAA", 10, 10, 1))
+	if err := l.CheckQuota("AAAA", quota); err != nil {
+		t.Fatalf("未超出配额: %+v", err)
+	}
+	l.Append(usageEntry(now, "AAAA", 10, 10, 1))
+	err := l.CheckQuota("AAAA", quota)
+	if err == nil || err.Code != common.MsgQuotaDailyRequests || err.RetryAfter <= 0 {
+		t.Fatalf("应超出每日请求数: %+v", err)
+	}
+
+	l.Append(usageEntry(now, "BBBB", 600, 500, 0))
+	if err := l.CheckQuota("BBBB", quota); err == nil || err.Code != common.MsgQuotaMonthlyTokens {
+		t.Fatalf("应超出每月 tokens: %+v", err)
+	}
+
+	l.Append(usageEntry(now, "CCCC", 1, 1, 5))
+	if err := l.CheckQuota("CCCC", quota); err == nil || err.Code != common.MsgQuotaMonthlyCredits {
+		t.Fatalf("应超出每月 credits: %+v", err)
+	}
+
+	// 上个月的用量不计入本月
+	l.Append(usageEntry(lastMonth(now), "DDDD", 5000, 5000, 50))
+	if err := l.CheckQuota("DDDD", quota); err != nil {
+		t.Fatalf("上个月的用量不应计入: %+v", err)
+	}
+}
+
+func TestUsageLedgerReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	now := time.Now()
+	l := NewUsageLedger(path)
+	l.Append(usageEntry(now, "AAAA", 100, 50, 1))
+	l.Append(usageEntry(lastMonth(now), "AAAA", 10, 5, 0))
+
+	reloaded := NewUsageLedger(path)
+	today, _ := reloaded.CodeUsage("AAAA")
+	if today.Requests != 1 || today.TotalTokens() != 150 {
+		t.Fatalf("回放后的今日汇总: %+v", today)
+	}
+	rows, total := reloaded.Query(UsageFilter{Code: "aaaa"}, "month")
+	if len(rows) != 2 || total.Requests != 2 || total.TotalTokens() != 165 {
+		t.Fatalf("按月汇总: %+v total=%+v", rows, total)
+	}
+	if rows[0].Period != lastMonth(now).Format("2006-01") || rows[1].Period != now.Format("2006-01") {
+		t.Fatalf("按月汇总应按时间排序: %+v", rows)
+	}
+}
+
+func TestUsageLedgerRecords(t *testing.T) {
+	l := NewUsageLedger(filepath.Join(t.TempDir(), "usage.jsonl"))
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		l.Append(usageEntry(now, "AAAA", i, 0, 0))
+	}
+	l.Append(usageEntry(now, "BBBB", 99, 0, 0))
+
+	records := l.Records(UsageFilter{Code: "AAAA"}, 2)
+	if len(records) != 2 || records[0].InputTokens != 4 || records[1].InputTokens != 5 {
+		t.Fatalf("应返回最新的 2 条: %+v", records)
+	}
+	if all := l.Records(UsageFilter{}, 0); len(all) != 6 {
+		t.Fatalf("不限条数时应返回全部: %d", len(all))
+	}
+}
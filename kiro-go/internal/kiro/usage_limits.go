@@ -0,0 +1,367 @@
+package kiro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+)
+
+// 额度轮询：定期调用 getUsageLimits 查询主凭证池和用户凭证的额度，
+// 接近上限的主凭证降低选择优先级，用尽时暂停使用，到 nextDateReset 后自动恢复，
+// 不必等请求失败（402）才发现额度耗尽
+
+const (
+	defaultUsagePollInterval = 15 * time.Minute
+	defaultUsageNearLimit    = 0.9
+	// usageResetCheckInterval 检查已用尽的凭据是否到达重置时间的间隔
+	usageResetCheckInterval = time.Minute
+	// nearLimitPenalty 接近额度上限的凭据在选择时排在所有正常凭据之后
+	nearLimitPenalty = 1 << 20
+)
+
+// UsageLimits 凭据的额度（credits）
+type UsageLimits struct {
+	Subscription  string  `json:"subscription,omitempty"`
+	CurrentUsage  float64 `json:"currentUsage"`
+	UsageLimit    float64 `json:"usageLimit"`
+	Remaining     float64 `json:"remaining"`
+	NearLimit     bool    `json:"nearLimit"`
+	NextDateReset string  `json:"nextDateReset,omitempty"`
+	CheckedAt     string  `json:"checkedAt"`
+	Error         string  `json:"error,omitempty"` // 最近一次查询失败的原因，额度数据保留上次成功的结果
+
+	resetAt time.Time
+}
+
+// Exhausted 额度已用尽
+func (u *UsageLimits) Exhausted() bool {
+	return u != nil && u.UsageLimit > 0 && u.Remaining <= 0
+}
+
+// resetPassed 已过 nextDateReset
+func (u *UsageLimits) resetPassed(now time.Time) bool {
+	return u != nil && !u.resetAt.IsZero() && now.After(u.resetAt)
+}
+
+// usageLimitsResponse getUsageLimits 响应中用到的字段
+type usageLimitsResponse struct {
+	NextDateReset    float64 `json:"nextDateReset"`
+	SubscriptionInfo *struct {
+		SubscriptionTitle string `json:"subscriptionTitle"`
+	} `json:"subscriptionInfo"`
+	UsageBreakdownList []struct {
+		ResourceType              string       `json:"resourceType"`
+		UsageLimit                float64      `json:"usageLimit"`
+		UsageLimitWithPrecision   *float64     `json:"usageLimitWithPrecision"`
+		CurrentUsage              float64      `json:"currentUsage"`
+		CurrentUsageWithPrecision *float64     `json:"currentUsageWithPrecision"`
+		NextDateReset             float64      `json:"nextDateReset"`
+		FreeTrialInfo             *usageGrant  `json:"freeTrialInfo"`
+		Bonuses                   []usageGrant `json:"bonuses"`
+	} `json:"usageBreakdownList"`
+}
+
+// usageGrant 试用 / 赠送额度
+type usageGrant struct {
+	UsageLimit      float64 `json:"usageLimit"`
+	CurrentUsage    float64 `json:"currentUsage"`
+	FreeTrialStatus string  `json:"freeTrialStatus"`
+	Status          string  `json:"status"`
+}
+
+func (g *usageGrant) active() bool {
+	status := g.FreeTrialStatus
+	if status == "" {
+		status = g.Status
+	}
+	return status == "" || status == "ACTIVE"
+}
+
+// FetchUsageLimits 查询凭据额度：基础额度 + 有效的试用额度和赠送额度（优先取 CREDIT 类型的统计）
+func (p *Provider) FetchUsageLimits(cred *model.KiroCredentials) (*UsageLimits, error) {
+	if cred.AccessToken == "" {
+		return nil, fmt.Errorf("没有可用的 accessToken")
+	}
+	query := url.Values{}
+	query.Set("origin", "AI_EDITOR")
+	query.Set("resourceType", "AGENTIC_REQUEST")
+	if cred.ProfileArn != "" {
+		query.Set("profileArn", cred.ProfileArn)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := p.doRegional(cred, func(region string) (*http.Response, error) {
+		apiURL := fmt.Sprintf("https://q.%s.amazonaws.com/getUsageLimits?%s", region, query.Encode())
+		req, err := http.NewRequest("GET", apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.setRuntimeHeaders(req, cred, cred.AccessToken)
+		return client.Do(req)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API 返回错误 %d: %s", resp.StatusCode, logger.TruncateBody(string(body), 300))
+	}
+
+	var data usageLimitsResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	if len(data.UsageBreakdownList) == 0 {
+		return nil, fmt.Errorf("响应中没有额度信息")
+	}
+	b := data.UsageBreakdownList[0]
+	for _, item := range data.UsageBreakdownList {
+		if item.ResourceType == "CREDIT" {
+			b = item
+			break
+		}
+	}
+
+	limits := &UsageLimits{UsageLimit: b.UsageLimit, CurrentUsage: b.CurrentUsage}
+	if b.UsageLimitWithPrecision != nil {
+		limits.UsageLimit = *b.UsageLimitWithPrecision
+	}
+	if b.CurrentUsageWithPrecision != nil {
+		limits.CurrentUsage = *b.CurrentUsageWithPrecision
+	}
+	grants := b.Bonuses
+	if b.FreeTrialInfo != nil {
+		grants = append(grants, *b.FreeTrialInfo)
+	}
+	for _, g := range grants {
+		if g.active() {
+			limits.UsageLimit += g.UsageLimit
+			limits.CurrentUsage += g.CurrentUsage
+		}
+	}
+	limits.Remaining = limits.UsageLimit - limits.CurrentUsage
+	if data.SubscriptionInfo != nil {
+		limits.Subscription = data.SubscriptionInfo.SubscriptionTitle
+	}
+	reset := b.NextDateReset
+	if reset == 0 {
+		reset = data.NextDateReset
+	}
+	if reset > 0 {
+		limits.resetAt = time.Unix(int64(reset), 0)
+		limits.NextDateReset = limits.resetAt.Format(time.RFC3339)
+	}
+	return limits, nil
+}
+
+// QuotaMonitor 定期查询凭据额度（配置热加载后按新的间隔 / 阈值生效）
+type QuotaMonitor struct {
+	p        *Provider
+	mu       sync.Mutex
+	lastPoll time.Time
+}
+
+func newQuotaMonitor(p *Provider) *QuotaMonitor {
+	return &QuotaMonitor{p: p}
+}
+
+// interval 全量轮询间隔，0 表示禁用
+func (q *QuotaMonitor) interval() time.Duration {
+	switch v := q.p.Config.Load().UsagePollInterval; {
+	case v < 0:
+		return 0
+	case v > 0:
+		return time.Duration(v) * time.Second
+	}
+	return defaultUsagePollInterval
+}
+
+func (q *QuotaMonitor) nearLimit() float64 {
+	if v := q.p.Config.Load().UsageNearLimit; v > 0 {
+		return v
+	}
+	return defaultUsageNearLimit
+}
+
+// StartPolling 启动后立即查询一次，之后按间隔全量查询；每分钟检查已用尽的凭据是否到达重置时间
+func (q *QuotaMonitor) StartPolling() {
+	go func() {
+		for {
+			q.tick(time.Now())
+			time.Sleep(usageResetCheckInterval)
+		}
+	}()
+}
+
+// tick 到达间隔时全量查询，否则只查询已过重置时间的用尽凭据
+func (q *QuotaMonitor) tick(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	interval := q.interval()
+	if interval == 0 {
+		return
+	}
+	full := now.Sub(q.lastPoll) >= interval
+	if full {
+		q.lastPoll = now
+	}
+
+	tm := q.p.TokenMgr
+	for _, cred := range tm.quotaTargets(full, now) {
+		limits, err := q.p.FetchUsageLimits(cred)
+		// 运行时暂停由 applyQuota 在内存中解除；配置中以 quota_exhausted 禁用的需写回
+		if tm.applyQuota(*cred.ID, limits, err, q.nearLimit(), now) {
+			if err := tm.SetCredentialDisabled(*cred.ID, false, ""); err == nil {
+				logger.Infof(logger.CatCreds, "凭据 id=%d 额度已重置，恢复使用", *cred.ID)
+			}
+		}
+	}
+
+	if ucm := q.p.UserCredsMgr; ucm != nil {
+		for code, cred := range ucm.quotaTargets(full, now) {
+			cred := cred
+			limits, err := q.p.FetchUsageLimits(&cred)
+			ucm.applyQuota(code, limits, err, q.nearLimit(), now)
+		}
+	}
+}
+
+// mergeQuota 合并查询结果：失败时保留上次的额度并记录错误
+func mergeQuota(prev, limits *UsageLimits, err error, threshold float64, now time.Time) *UsageLimits {
+	if err != nil {
+		merged := &UsageLimits{}
+		if prev != nil {
+			*merged = *prev
+		}
+		merged.Error = err.Error()
+		merged.CheckedAt = now.Format(time.RFC3339)
+		return merged
+	}
+	limits.CheckedAt = now.Format(time.RFC3339)
+	limits.NearLimit = limits.UsageLimit > 0 && limits.CurrentUsage >= limits.UsageLimit*threshold
+	return limits
+}
+
+// quotaDecision 根据额度决定凭据状态：用尽时禁用；因额度禁用的凭据在额度恢复或已过重置时间时重新启用
+func quotaDecision(disabled bool, reason string, q *UsageLimits, fetchErr error, now time.Time) (disable, enable bool) {
+	if fetchErr == nil && q.Exhausted() {
+		return !disabled, false
+	}
+	if disabled && reason == DisabledQuotaExhausted {
+		return false, fetchErr == nil || q.resetPassed(now)
+	}
+	return false, false
+}
+
+// quotaTargets 需要查询额度的主凭证副本：全量时为全部有 token 的可用或因额度禁用的凭据，否则只取已过重置时间的
+func (tm *TokenManager) quotaTargets(full bool, now time.Time) []*model.KiroCredentials {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	var targets []*model.KiroCredentials
+	for _, c := range tm.Credentials {
+		disabled, reason := tm.disabledReasonLocked(c)
+		if c.ID == nil || c.AccessToken == "" || (disabled && reason != DisabledQuotaExhausted) {
+			continue
+		}
+		if full || (disabled && tm.quota[*c.ID].resetPassed(now)) {
+			copied := *c
+			targets = append(targets, &copied)
+		}
+	}
+	return targets
+}
+
+// applyQuota 记录主凭证额度，用尽时暂停使用（记在 tm.suspended，不写回文件），额度恢复时解除暂停；
+// 返回配置中以 quota_exhausted 禁用的凭据是否应重新启用（需写回）
+func (tm *TokenManager) applyQuota(id int, limits *UsageLimits, err error, threshold float64, now time.Time) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	idx := tm.findLocked(id)
+	if idx < 0 {
+		return false
+	}
+	q := mergeQuota(tm.quota[id], limits, err, threshold, now)
+	tm.quota[id] = q
+	cred := tm.Credentials[idx]
+	disabled, reason := tm.disabledReasonLocked(cred)
+	disable, enable := quotaDecision(disabled, reason, q, err, now)
+	switch {
+	case disable:
+		tm.suspended[id] = DisabledQuotaExhausted
+		logger.Warnf(logger.CatCreds, "凭据 id=%d 额度已用尽（%.1f/%.1f），暂停使用至 %s", id, q.CurrentUsage, q.UsageLimit, q.NextDateReset)
+	case enable && !cred.Disabled:
+		delete(tm.suspended, id)
+		logger.Infof(logger.CatCreds, "凭据 id=%d 额度已重置，恢复使用", id)
+		return false
+	}
+	return enable
+}
+
+// effectivePriorityLocked 选择凭据时的优先级：接近额度上限的排在所有正常凭据之后，调用方需持有 tm.mu
+func (tm *TokenManager) effectivePriorityLocked(c *model.KiroCredentials) int {
+	p := credentialPriority(c)
+	if c.ID != nil {
+		if q := tm.quota[*c.ID]; q != nil && q.NearLimit {
+			p += nearLimitPenalty
+		}
+	}
+	return p
+}
+
+// quotaTargets 需要查询额度的用户凭证（激活码 → 凭证副本），规则同主凭证池
+func (m *UserCredentialsManager) quotaTargets(full bool, now time.Time) map[string]model.KiroCredentials {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	targets := make(map[string]model.KiroCredentials)
+	for code, entry := range m.data {
+		c := entry.Credentials
+		if c.AccessToken == "" || (c.Disabled && c.DisabledReason != DisabledQuotaExhausted) {
+			continue
+		}
+		if full || (c.Disabled && m.quota[code].resetPassed(now)) {
+			targets[code] = c
+		}
+	}
+	return targets
+}
+
+// applyQuota 记录用户凭证额度：用尽时标记不可用（不再被自动换号选中），额度恢复后重新启用
+func (m *UserCredentialsManager) applyQuota(code string, limits *UsageLimits, err error, threshold float64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.data[code]
+	if !ok {
+		return
+	}
+	q := mergeQuota(m.quota[code], limits, err, threshold, now)
+	m.quota[code] = q
+	disable, enable := quotaDecision(entry.Credentials.Disabled, entry.Credentials.DisabledReason, q, err, now)
+	switch {
+	case disable:
+		entry.Credentials.Disabled = true
+		entry.Credentials.DisabledReason = DisabledQuotaExhausted
+		logger.Warnf(logger.CatCreds, "用户凭证 %s 额度已用尽，暂停使用至 %s", logger.MaskKey(code), q.NextDateReset)
+	case enable:
+		entry.Credentials.Disabled = false
+		entry.Credentials.DisabledReason = ""
+		logger.Infof(logger.CatCreds, "用户凭证 %s 额度已重置，恢复使用", logger.MaskKey(code))
+	}
+}
+
+// Quota 用户凭证最近一次查询的额度（未查询过为 nil）
+func (m *UserCredentialsManager) Quota(code string) *UsageLimits {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if q := m.quota[code]; q != nil {
+		copied := *q
+		return &copied
+	}
+	return nil
+}
@@ -10,15 +10,21 @@ import (
 
 	"kiro-go/internal/logger"
 	"kiro-go/internal/model"
+	"kiro-go/internal/secrets"
+	"kiro-go/internal/store"
 )
 
 // UserCredentialsManager 用户凭证管理器
 type UserCredentialsManager struct {
 	filePath   string
-	config     *model.Config
+	config     *model.ConfigRef
 	data       map[string]*model.UserCredentialEntry
-	refreshing map[string]bool // 正在刷新中的激活码
+	refreshing map[string]bool         // 正在刷新中的激活码
+	quota      map[string]*UsageLimits // 激活码 → 最近一次额度查询结果（只在内存中）
 	mu         sync.RWMutex
+	store      *store.Store // 非 nil 时写入嵌入式存储而不是 user_credentials.json
+	// token 刷新后的联动写入（同步到 codes），为空时只保存自身
+	onRefreshed func(code string, creds *model.KiroCredentials, persist func(tx *store.Tx) error) error
 }
 
 func NewUserCredentialsManager(filePath string) *UserCredentialsManager {
@@ -26,13 +32,14 @@ func NewUserCredentialsManager(filePath string) *UserCredentialsManager {
 		filePath:   filePath,
 		data:       make(map[string]*model.UserCredentialEntry),
 		refreshing: make(map[string]bool),
+		quota:      make(map[string]*UsageLimits),
 	}
 	mgr.loadFromFile()
 	return mgr
 }
 
 // SetConfig sets the config for token refresh
-func (m *UserCredentialsManager) SetConfig(cfg *model.Config) {
+func (m *UserCredentialsManager) SetConfig(cfg *model.ConfigRef) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.config = cfg
@@ -43,6 +50,9 @@ func (m *UserCredentialsManager) loadFromFile() {
 	if err != nil {
 		return
 	}
+	if data, err = secrets.OpenJSON(data); err != nil {
+		logger.Fatalf(logger.CatCreds, "解密用户凭证文件失败 %s: %v", m.filePath, err)
+	}
 	var entries map[string]*model.UserCredentialEntry
 	if err := json.Unmarshal(data, &entries); err != nil {
 		logger.Errorf(logger.CatCreds, "解析用户凭证文件失败: %v", err)
@@ -61,10 +71,16 @@ func (m *UserCredentialsManager) loadFromFile() {
 }
 
 func (m *UserCredentialsManager) saveToFile() error {
+	if m.store != nil {
+		return m.saveToStore()
+	}
 	data, err := json.MarshalIndent(m.data, "", "  ")
 	if err != nil {
 		return err
 	}
+	if data, err = secrets.SealJSON(data); err != nil {
+		return err
+	}
 	dir := filepath.Dir(m.filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
@@ -160,7 +176,7 @@ func (m *UserCredentialsManager) GetCredentialsAutoRefresh(activationCode string
 	m.mu.Unlock()
 
 	// 异步刷新 token
-	go m.refreshUserToken(activationCode, &cred, cfg)
+	go m.refreshUserToken(activationCode, &cred, cfg.Load())
 
 	// 返回现有 token（即使过期，Kiro API 可能仍接受）
 	return &cred, nil
@@ -201,7 +217,7 @@ func (m *UserCredentialsManager) refreshUserToken(activationCode string, cred *m
 		}
 		entry.Credentials.ExpiresAt = newCred.ExpiresAt
 		entry.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
-		if err := m.saveToFile(); err != nil {
+		if err := m.persistRefreshedLocked(activationCode, entry); err != nil {
 			logger.Errorf(logger.CatToken, "保存刷新后的用户凭证失败: %v", err)
 		} else {
 			logger.LogCredentialStatus("", logger.MaskKey(activationCode), "refresh_success", logger.F{
@@ -214,13 +230,13 @@ func (m *UserCredentialsManager) refreshUserToken(activationCode string, cred *m
 }
 
 // StartAutoRefresh 启动后台定时刷新，检查所有用户凭证的 token 有效期
-func (m *UserCredentialsManager) StartAutoRefresh(cfg *model.Config, interval time.Duration) {
+func (m *UserCredentialsManager) StartAutoRefresh(cfg *model.ConfigRef, interval time.Duration) {
 	m.SetConfig(cfg)
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 		for range ticker.C {
-			m.refreshExpiring(cfg)
+			m.refreshExpiring(cfg.Load())
 		}
 	}()
 }
@@ -429,23 +445,7 @@ func (m *UserCredentialsManager) MarkDisabled(activationCode string) {
 	defer m.mu.Unlock()
 	if entry, ok := m.data[activationCode]; ok {
 		entry.Credentials.Disabled = true
+		entry.Credentials.DisabledReason = DisabledQuotaExhausted
 		logger.Warnf(logger.CatCreds, "用户凭证已标记为不可用: %s", logger.MaskKey(activationCode))
 	}
 }
-
-// GetNextAvailable 获取下一个可用的用户凭证（跳过已禁用的）
-func (m *UserCredentialsManager) GetNextAvailable(excludeCode string) (string, *model.KiroCredentials) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	for code, entry := range m.data {
-		if code == excludeCode {
-			continue
-		}
-		if entry.Credentials.Disabled {
-			continue
-		}
-		cred := entry.Credentials
-		return code, &cred
-	}
-	return "", nil
-}
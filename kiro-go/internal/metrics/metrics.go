@@ -0,0 +1,259 @@
+// Package metrics 进程内指标（计数器、仪表、直方图），以 Prometheus 文本格式输出到 /metrics
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric 可输出的指标
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// desc 指标名、说明与标签名
+type desc struct {
+	name   string
+	help   string
+	kind   string // counter | gauge | histogram
+	labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+// labelKey 标签值拼成的 map key（值个数与标签名不一致时补空 / 截断）
+func (d *desc) labelKey(values []string) (string, []string) {
+	normalized := make([]string, len(d.labels))
+	copy(normalized, values)
+	return strings.Join(normalized, "\xff"), normalized
+}
+
+// formatLabels {a="x",b="y"}，extra 为追加的 name=value（如直方图的 le）
+func (d *desc) formatLabels(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, name := range d.labels {
+		parts = append(parts, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper 文本格式的标签值只转义反斜杠、双引号和换行（strconv.Quote 的 \u / \x 转义不合法）
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series 一组标签值对应的值
+type series struct {
+	labels []string
+	value  float64
+}
+
+// vec 计数器 / 仪表共用的按标签存储
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	v := &vec{desc: desc{name: name, help: help, kind: kind, labels: labels}, series: make(map[string]*series)}
+	register(v)
+	return v
+}
+
+func (v *vec) update(values []string, fn func(s *series)) {
+	key, normalized := v.labelKey(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.series[key]
+	if s == nil {
+		s = &series{labels: normalized}
+		v.series[key] = s
+	}
+	fn(s)
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w)
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.formatLabels(s.labels), formatValue(s.value))
+	}
+}
+
+// CounterVec 只增的计数器
+type CounterVec struct{ *vec }
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labels)}
+}
+
+// Add 增加 delta（负数忽略）
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.update(values, func(s *series) { s.value += delta })
+}
+
+func (c *CounterVec) Inc(values ...string) { c.Add(1, values...) }
+
+// GaugeVec 可增减的仪表
+type GaugeVec struct{ *vec }
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labels)}
+}
+
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.update(values, func(s *series) { s.value = v })
+}
+
+func (g *GaugeVec) Add(delta float64, values ...string) {
+	g.update(values, func(s *series) { s.value += delta })
+}
+
+func (g *GaugeVec) Inc(values ...string) { g.Add(1, values...) }
+func (g *GaugeVec) Dec(values ...string) { g.Add(-1, values...) }
+
+// GaugeFunc 抓取时才计算的仪表（如凭证池状态），fn 通过 emit 输出每组标签的值
+type GaugeFunc struct {
+	desc
+	fn func(emit func(value float64, values ...string))
+}
+
+func NewGaugeFunc(name, help string, labels []string, fn func(emit func(value float64, values ...string))) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	g.fn(func(value float64, values ...string) {
+		_, normalized := g.labelKey(values)
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.formatLabels(normalized), formatValue(value))
+	})
+}
+
+// histogramSeries 一组标签值的直方图数据
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // 与 buckets 对应（非累计）
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec 直方图，buckets 为升序的上界
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key, normalized := h.labelKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{labels: normalized, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(s.labels, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(s.labels), s.count)
+	}
+}
+
+// Handler 以 Prometheus 文本格式（0.0.4）输出全部指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		registryMu.Lock()
+		metrics := append([]metric(nil), registry...)
+		registryMu.Unlock()
+		for _, m := range metrics {
+			m.write(bw)
+		}
+		bw.Flush()
+	})
+}
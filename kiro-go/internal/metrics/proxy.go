@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 延迟直方图的桶（秒），覆盖从缓存命中到长时间流式输出
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+var (
+	Requests = NewCounterVec("kiro_requests_total",
+		"API 请求数", "endpoint", "model", "status")
+	RequestDuration = NewHistogramVec("kiro_request_duration_seconds",
+		"API 请求耗时（流式请求为整个流的时长）", latencyBuckets, "endpoint", "model", "status")
+	TimeToFirstToken = NewHistogramVec("kiro_time_to_first_token_seconds",
+		"流式请求从收到请求到向客户端写出首个数据块的时间", latencyBuckets, "endpoint", "model")
+	ActiveStreams = NewGaugeVec("kiro_active_streams",
+		"正在进行的流式响应数", "endpoint")
+
+	UpstreamResponses = NewCounterVec("kiro_upstream_responses_total",
+		"Kiro 上游响应数，credential 为脱敏后的凭据标识，status 为状态码或 error（网络错误）", "credential", "region", "status")
+	UpstreamRetries = NewCounterVec("kiro_upstream_retries_total",
+		"上游请求重试次数", "reason")
+	Fallbacks = NewCounterVec("kiro_fallbacks_total",
+		"降级次数：model 为模型降级，region 为区域切换，group / main 为按共享组规则借用凭证", "kind")
+
+	Tokens = NewCounterVec("kiro_tokens_total",
+		"累计 token 数", "backend", "model", "type")
+	Credits = NewCounterVec("kiro_credits_total",
+		"累计消耗的 Kiro credits", "model")
+
+	Compressions = NewCounterVec("kiro_context_compressions_total",
+		"上下文压缩次数", "result")
+	Truncations = NewCounterVec("kiro_truncations_total",
+		"检测到的截断次数，kind 为 tool（工具调用参数）或 content（正文）", "kind")
+)
+
+// UpstreamStatus 上游状态码标签，err 非 nil 时为 error
+func UpstreamStatus(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// modelLabeler 把客户端请求的模型名映射为有限取值的标签（见 SetModelLabeler）
+var modelLabeler atomic.Pointer[func(string) string]
+
+// SetModelLabeler 设置 model 标签的映射：客户端可以填写任意模型名，直接作为标签会让序列数无限增长，
+// 映射函数应只返回已知模型 ID 或固定的兜底值
+func SetModelLabeler(fn func(model string) string) {
+	modelLabeler.Store(&fn)
+}
+
+func modelLabel(model string) string {
+	if model == "" {
+		return "unknown"
+	}
+	if fn := modelLabeler.Load(); fn != nil {
+		return (*fn)(model)
+	}
+	return model
+}
+
+// ObserveUsage 记录一次请求的 token 与 credits 用量
+func ObserveUsage(backend, model string, inputTokens, outputTokens, cacheReadTokens, cacheCreationTokens int, credits float64) {
+	model = modelLabel(model)
+	Tokens.Add(float64(inputTokens), backend, model, "input")
+	Tokens.Add(float64(outputTokens), backend, model, "output")
+	if cacheReadTokens > 0 {
+		Tokens.Add(float64(cacheReadTokens), backend, model, "cache_read")
+	}
+	if cacheCreationTokens > 0 {
+		Tokens.Add(float64(cacheCreationTokens), backend, model, "cache_creation")
+	}
+	if credits > 0 {
+		Credits.Add(credits, model)
+	}
+}
+
+type recorderKey struct{}
+
+// recorder 记录响应状态码、模型与首个数据块时间；流式响应（text/event-stream）计入 ActiveStreams
+type recorder struct {
+	http.ResponseWriter
+	endpoint string
+	start    time.Time
+
+	mu         sync.Mutex
+	status     int
+	model      string
+	streaming  bool
+	firstToken bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.mu.Lock()
+	if rec.status == 0 {
+		rec.status = status
+		if strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+			rec.streaming = true
+			ActiveStreams.Inc(rec.endpoint)
+		}
+	}
+	rec.mu.Unlock()
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.mu.Lock()
+	started := rec.status != 0
+	rec.mu.Unlock()
+	if !started {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 访问底层 ResponseWriter
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Instrument 包装 API 处理函数，按 endpoint / model / status 记录请求数与耗时
+func Instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &recorder{ResponseWriter: w, endpoint: endpoint, start: time.Now()}
+		r = r.WithContext(context.WithValue(r.Context(), recorderKey{}, rec))
+		defer func() {
+			rec.mu.Lock()
+			status, model, streaming := rec.status, rec.model, rec.streaming
+			rec.mu.Unlock()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if model == "" {
+				model = "unknown"
+			}
+			if streaming {
+				ActiveStreams.Dec(endpoint)
+			}
+			code := strconv.Itoa(status)
+			Requests.Inc(endpoint, model, code)
+			RequestDuration.Observe(time.Since(rec.start).Seconds(), endpoint, model, code)
+		}()
+		next(rec, r)
+	}
+}
+
+// SetModel 设置请求的模型标签（首次设置生效，即客户端请求的模型，经 SetModelLabeler 映射）
+func SetModel(r *http.Request, model string) {
+	rec, _ := r.Context().Value(recorderKey{}).(*recorder)
+	if rec == nil || model == "" {
+		return
+	}
+	label := modelLabel(model)
+	rec.mu.Lock()
+	if rec.model == "" {
+		rec.model = label
+	}
+	rec.mu.Unlock()
+}
+
+// FirstToken 在向客户端写出首个数据块时调用，记录首 token 时间（只记录一次）
+func FirstToken(w http.ResponseWriter) {
+	rec, ok := w.(*recorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	if rec.firstToken {
+		rec.mu.Unlock()
+		return
+	}
+	rec.firstToken = true
+	model := rec.model
+	rec.mu.Unlock()
+	if model == "" {
+		model = "unknown"
+	}
+	TimeToFirstToken.Observe(time.Since(rec.start).Seconds(), rec.endpoint, model)
+}
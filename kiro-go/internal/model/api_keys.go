@@ -0,0 +1,133 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ModelScope 限制可用模型的调用方（具名 API Key / 客户端令牌）
+type ModelScope interface {
+	AllowsModel(modelName string) bool
+}
+
+// APIKeyEntry 具名静态 API Key 及其访问策略（api_keys.json）
+type APIKeyEntry struct {
+	Name             string         `json:"name"`
+	Key              string         `json:"key"`
+	Disabled         bool           `json:"disabled,omitempty"`         // 已吊销
+	AllowedModels    []string       `json:"allowedModels,omitempty"`    // 允许的模型（正则，匹配路由解析后实际请求的模型 ID），空表示不限制
+	AllowedEndpoints []string       `json:"allowedEndpoints,omitempty"` // "kiro"（/v1）| "anthropic"（/anthropic/v1），空表示不限制
+	ExpiresAt        string         `json:"expiresAt,omitempty"`        // YYYY-MM-DD（当天有效）或 RFC3339，空表示永久
+	RateLimit        *RateLimit     `json:"rateLimit,omitempty"`        // 非 0 字段覆盖 config.apiKeyRateLimit
+	Credential       *CredentialPin `json:"credential,omitempty"`       // 固定使用主凭证池中的凭据 / 分组
+	CreatedAt        string         `json:"createdAt,omitempty"`
+
+	models []*regexp.Regexp // Compile 预编译的 AllowedModels
+}
+
+// Compile 校验 expiresAt 与 allowedModels 并预编译正则（保存和加载 api_keys.json 时调用）
+func (e *APIKeyEntry) Compile() error {
+	if e.ExpiresAt != "" {
+		if _, ok := parseExpiresAt(e.ExpiresAt); !ok {
+			return fmt.Errorf("expiresAt %q 格式无效（应为 YYYY-MM-DD 或 RFC3339）", e.ExpiresAt)
+		}
+	}
+	models, err := compileModelPatterns(e.AllowedModels)
+	if err != nil {
+		return err
+	}
+	e.models = models
+	return nil
+}
+
+// Expired 是否已过期；无法解析的 expiresAt 视为已过期（Compile 会在保存时拒绝）
+func (e *APIKeyEntry) Expired(now time.Time) bool {
+	if e.ExpiresAt == "" {
+		return false
+	}
+	end, ok := parseExpiresAt(e.ExpiresAt)
+	return !ok || now.After(end)
+}
+
+// parseExpiresAt RFC3339 或 YYYY-MM-DD（当天有效，到次日 0 点）
+func parseExpiresAt(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), true
+	}
+	return time.Time{}, false
+}
+
+// AllowsEndpoint 是否允许访问该端点类别（"kiro" | "anthropic"）
+func (e *APIKeyEntry) AllowsEndpoint(endpoint string) bool {
+	return allowsEndpoint(e.AllowedEndpoints, endpoint)
+}
+
+// AllowsModel 是否允许使用该模型（已 Compile 时使用预编译的正则）；有限制时空模型名不允许
+func (e *APIKeyEntry) AllowsModel(modelName string) bool {
+	if len(e.models) != len(e.AllowedModels) {
+		return allowsModel(e.AllowedModels, modelName)
+	}
+	if len(e.models) == 0 {
+		return true
+	}
+	if modelName == "" {
+		return false
+	}
+	for _, re := range e.models {
+		if re.MatchString(modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsEndpoint 端点类别是否在列表中，空列表表示不限制
+func allowsEndpoint(allowed []string, endpoint string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, ep := range allowed {
+		if strings.EqualFold(ep, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileModelPatterns 编译模型正则（整串、不区分大小写），任一无效返回错误
+func compileModelPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("allowedModels 正则无效 %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// allowsModel 模型名是否匹配任一正则（整串、不区分大小写，无效的正则按字面量比较），空列表表示不限制，有限制时空模型名不允许
+func allowsModel(patterns []string, modelName string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	if modelName == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile("(?i)^(?:" + pattern + ")$"); err == nil {
+			if re.MatchString(modelName) {
+				return true
+			}
+		} else if strings.EqualFold(pattern, modelName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package model
+
+import "testing"
+
+func TestAPIKeyAllowsModel(t *testing.T) {
+	open := &APIKeyEntry{Name: "open"}
+	if err := open.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !open.AllowsModel("") || !open.AllowsModel("claude-opus-4.5") {
+		t.Fatal("未限制模型时应全部允许")
+	}
+
+	scoped := &APIKeyEntry{Name: "ci", AllowedModels: []string{"claude-sonnet-4.*", "claude-haiku-4.5"}}
+	for name, compile := range map[string]bool{"预编译": true, "未编译": false} {
+		e := *scoped
+		if compile {
+			if err := e.Compile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !e.AllowsModel("claude-sonnet-4.5") || !e.AllowsModel("CLAUDE-HAIKU-4.5") {
+			t.Fatalf("%s: 范围内的模型应允许", name)
+		}
+		if e.AllowsModel("claude-opus-4.5") || e.AllowsModel("x-claude-haiku-4.5") {
+			t.Fatalf("%s: 范围外的模型应拒绝（整串匹配）", name)
+		}
+		if e.AllowsModel("") {
+			t.Fatalf("%s: 有限制时空模型名应拒绝", name)
+		}
+	}
+}
+
+func TestTokenScopeAllowsModel(t *testing.T) {
+	if s := (&TokenScope{}); !s.AllowsModel("") {
+		t.Fatal("未限制模型时应允许")
+	}
+	s := &TokenScope{Models: []string{"claude-haiku-4.5"}}
+	if !s.AllowsModel("claude-haiku-4.5") || s.AllowsModel("claude-sonnet-4.5") || s.AllowsModel("") {
+		t.Fatal("令牌的模型范围判断不对")
+	}
+}
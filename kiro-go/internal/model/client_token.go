@@ -0,0 +1,26 @@
+package model
+
+// ClientTokenConfig 短期签名客户端令牌（kt- 前缀）配置
+type ClientTokenConfig struct {
+	Algorithm string `json:"algorithm,omitempty"` // "hs256"（默认）| "ed25519"
+	Secret    string `json:"secret"`              // HS256 密钥；ed25519 时由它派生私钥种子
+	TTL       int    `json:"ttl,omitempty"`       // 默认有效期（秒），默认 3600
+	MaxTTL    int    `json:"maxTtl,omitempty"`    // 允许申请的最长有效期（秒），默认 7 天
+}
+
+// TokenScope 令牌权限范围，字段为空表示不额外限制
+type TokenScope struct {
+	Models    []string `json:"models,omitempty"`    // 允许的模型（正则，同 APIKeyEntry.allowedModels）
+	Endpoints []string `json:"endpoints,omitempty"` // "kiro" | "anthropic"
+	RateClass string   `json:"rateClass,omitempty"` // config.rateClasses 中的限流档位
+}
+
+// AllowsEndpoint 是否允许访问该端点类别
+func (s *TokenScope) AllowsEndpoint(endpoint string) bool {
+	return allowsEndpoint(s.Endpoints, endpoint)
+}
+
+// AllowsModel 是否允许使用该模型
+func (s *TokenScope) AllowsModel(modelName string) bool {
+	return allowsModel(s.Models, modelName)
+}
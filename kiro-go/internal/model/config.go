@@ -1,8 +1,10 @@
 package model
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 )
 
@@ -12,7 +14,11 @@ type Config struct {
 	Port        int      `json:"port"`
 	APIKey      string   `json:"apiKey"`
 	AdminAPIKey string   `json:"adminApiKey"`
-	Regions     []string `json:"regions"`
+	Regions     []string `json:"regions"` // 第一个为默认 API 区域，其余为故障转移时依次尝试的区域
+
+	// Prometheus 指标的独立监听地址（如 127.0.0.1:9464），该端口的 /metrics 不做认证；
+	// 为空时只在主端口提供 /metrics，需携带 adminApiKey
+	MetricsListen string `json:"metricsListen,omitempty"`
 
 	// Kiro 伪装参数
 	KiroVersion   string `json:"kiroVersion"`
@@ -26,8 +32,29 @@ type Config struct {
 	// 卡密文件路径（空则自动推断到 config.json 同目录）
 	CodesPath string `json:"codesPath"`
 
+	// 具名 API Key 文件路径（空则自动推断到 config.json 同目录的 api_keys.json）
+	APIKeysPath string `json:"apiKeysPath"`
+
+	// relay- API Key 的共享密钥（与 kiro-launcher 生成 relay key 时输入的相同），为空表示不接受 relay key
+	RelaySecret string `json:"relaySecret,omitempty"`
+	// 禁用明文凭证认证（creds- API Key 与 X-Kiro-Credentials header），只接受加密的 relay key
+	DisablePlaintextCreds bool `json:"disablePlaintextCreds,omitempty"`
+
 	// 激活码验证服务地址（app.js，如 http://127.0.0.1:7777）
 	ActivationServerURL string `json:"activationServerUrl"`
+	// 验证服务不可达或响应无效时的策略: "grace"（默认）| "fail-closed" | "fail-open"（需显式开启）
+	ActivationPolicy string `json:"activationPolicy,omitempty"`
+	// grace 策略的宽限期（秒，默认 86400）：期间内曾在线验证通过的激活码继续放行
+	ActivationGraceSeconds int `json:"activationGraceSeconds,omitempty"`
+	// 离线校验许可证的公钥（base64，即签发方 GET /api/license/public-key 的值）
+	LicensePublicKey string `json:"licensePublicKey,omitempty"`
+	// 作为卡密服务时签发许可证的密钥（派生 Ed25519 私钥），为空表示不签发
+	LicenseSigningSecret string `json:"licenseSigningSecret,omitempty"`
+	// 许可证有效期（秒，默认 7 天），到期需重新在线验证
+	LicenseTTL int `json:"licenseTtl,omitempty"`
+
+	// 客户端错误信息默认语言: "zh" (默认) | "en"，请求带 Accept-Language 时以请求为准
+	Locale string `json:"locale"`
 
 	// 上下文压缩配置
 	ContextCompression    bool   `json:"contextCompression"`    // 是否启用上下文压缩（默认 false）
@@ -35,6 +62,61 @@ type Config struct {
 	CompressionThreshold  int    `json:"compressionThreshold"`  // 消息数阈值，超过则触发压缩（默认 8）
 	CompressionKeepRecent int    `json:"compressionKeepRecent"` // 保留最近几条消息不压缩（默认 6）
 
+	// 模型路由表（别名 / 正则规则 / 默认模型 / 拒绝列表），为空则使用内置映射
+	ModelRouting *ModelRoutingConfig `json:"modelRouting,omitempty"`
+
+	// 模型目录（ListAvailableModels）缓存时间，单位秒，默认 600
+	ModelCatalogTTL int `json:"modelCatalogTtl,omitempty"`
+
+	// 模型降级链：Kiro 模型 ID → 备用模型列表（按顺序尝试）
+	// 如 {"claude-opus-4.6": ["claude-opus-4.5", "claude-sonnet-4.5"]}，为空则不降级
+	ModelFallbacks map[string][]string `json:"modelFallbacks,omitempty"`
+
+	// Prompt caching：将客户端的 cache_control 断点映射为 Kiro 缓存检查点（默认 false，不映射）
+	// 检查点数量受模型目录 maximumCacheCheckpointsPerRequest 限制，不支持缓存的模型不映射
+	PromptCaching bool `json:"promptCaching,omitempty"`
+
+	// 会话粘性：同一 conversationId 固定使用主凭证池中的同一凭据
+	SessionAffinityTTL int `json:"sessionAffinityTtl,omitempty"` // 空闲过期时间，单位秒，默认 1800，-1 禁用
+	SessionAffinityMax int `json:"sessionAffinityMax,omitempty"` // 最多记录的会话数，默认 10000
+
+	// 额度轮询（getUsageLimits）：定期查询主凭证池与用户凭证的已用 / 剩余额度
+	UsagePollInterval int     `json:"usagePollInterval,omitempty"` // 轮询间隔，单位秒，默认 900，-1 禁用
+	UsageNearLimit    float64 `json:"usageNearLimit,omitempty"`    // 已用比例达到该值时降低凭据优先级，默认 0.9
+
+	// 用户凭证共享组：激活码额度用尽时只按所在组的 fallback 规则借用凭证。
+	// 未加入任何组的激活码使用 "default" 组的规则（未配置时为 ["main"]）
+	SharingGroups map[string]SharingGroup `json:"sharingGroups,omitempty"`
+	// 借用凭证的审计记录（JSONL，追加写入，空则放在 config.json 同目录的 sharing_audit.jsonl）
+	SharingAuditPath string `json:"sharingAuditPath"`
+
+	// 限流默认值：rateLimit 作用于每个激活码（codes.json 中的 rateLimit 按字段覆盖），
+	// apiKeyRateLimit 作用于静态 apiKey；为空或字段为 0 表示不限制
+	RateLimit       *RateLimit `json:"rateLimit,omitempty"`
+	APIKeyRateLimit *RateLimit `json:"apiKeyRateLimit,omitempty"`
+	// 具名限流档位，客户端令牌的 scope.rateClass 引用，只能按字段收紧激活码的限流
+	RateClasses map[string]RateLimit `json:"rateClasses,omitempty"`
+
+	// 短期签名客户端令牌（POST /api/token 用激活码换取），为空表示不启用
+	ClientTokens *ClientTokenConfig `json:"clientTokens,omitempty"`
+	// 已吊销令牌列表文件（空则放在 config.json 同目录的 revoked_tokens.json）
+	TokenDenyListPath string `json:"tokenDenyListPath"`
+
+	// 用量账本（JSONL，追加写入，空则放在 config.json 同目录的 usage.jsonl）
+	UsageLedgerPath string `json:"usageLedgerPath"`
+	// 嵌入式事务存储（bbolt）文件，设置后激活码、用户凭证、主凭证池和用量统一存入该文件，
+	// 首次启动自动从上面的 JSON 文件导入；为空则继续使用 JSON 文件。相对路径基于 config.json 所在目录
+	StorePath string `json:"storePath,omitempty"`
+	// 静态加密：配置主密钥后凭证中的 accessToken / refreshToken / clientSecret 加密保存
+	Secrets *SecretsConfig `json:"secrets,omitempty"`
+	// 每个激活码的默认用量配额（codes.json 中的 quota 按字段覆盖），为空表示不限制
+	UsageQuota *UsageQuota `json:"usageQuota,omitempty"`
+	// 每个激活码的默认设备策略（codes.json 中的 devicePolicy 按字段覆盖），为空表示不限制
+	DevicePolicy *DevicePolicy `json:"devicePolicy,omitempty"`
+
+	// 请求路由规则：按请求特征改写模型（如后台小请求改用 haiku），按顺序匹配，首条命中生效
+	RoutingRules []RoutingRule `json:"routingRules,omitempty"`
+
 	// Backend 选择: "kiro" (默认) | "anthropic"
 	Backend          string   `json:"backend"`
 	AnthropicAPIKey  string   `json:"anthropicApiKey"`
@@ -42,6 +124,247 @@ type Config struct {
 	AnthropicBaseURL string   `json:"anthropicBaseUrl"`
 }
 
+// RateLimit 令牌桶限流配置，0 表示沿用默认值，负数表示不限制
+type RateLimit struct {
+	RPM           int `json:"rpm,omitempty"`           // 每分钟请求数
+	MaxConcurrent int `json:"maxConcurrent,omitempty"` // 同时进行的请求数（含流式）
+	TPM           int `json:"tpm,omitempty"`           // 每分钟预估输入 tokens
+}
+
+// Merge 以 override 中非 0 的字段覆盖 r，返回新值（二者均可为 nil）
+func (r *RateLimit) Merge(override *RateLimit) RateLimit {
+	var merged RateLimit
+	if r != nil {
+		merged = *r
+	}
+	if override != nil {
+		if override.RPM != 0 {
+			merged.RPM = override.RPM
+		}
+		if override.MaxConcurrent != 0 {
+			merged.MaxConcurrent = override.MaxConcurrent
+		}
+		if override.TPM != 0 {
+			merged.TPM = override.TPM
+		}
+	}
+	return merged
+}
+
+// Narrow 按字段取 r 与 scope 中更严格的值（<= 0 视为不限制），scope 只能收紧、不能放宽 r
+func (r RateLimit) Narrow(scope *RateLimit) RateLimit {
+	if scope == nil {
+		return r
+	}
+	r.RPM = stricterLimit(r.RPM, scope.RPM)
+	r.MaxConcurrent = stricterLimit(r.MaxConcurrent, scope.MaxConcurrent)
+	r.TPM = stricterLimit(r.TPM, scope.TPM)
+	return r
+}
+
+func stricterLimit(a, b int) int {
+	if b <= 0 {
+		return a
+	}
+	if a <= 0 || b < a {
+		return b
+	}
+	return a
+}
+
+// UsageQuota 激活码用量配额（按服务器本地时区的自然日 / 自然月），0 表示沿用默认值，负数表示不限制
+// tokens 为输入（含缓存读写）+ 输出
+type UsageQuota struct {
+	DailyRequests   int     `json:"dailyRequests,omitempty"`
+	MonthlyRequests int     `json:"monthlyRequests,omitempty"`
+	DailyTokens     int     `json:"dailyTokens,omitempty"`
+	MonthlyTokens   int     `json:"monthlyTokens,omitempty"`
+	MonthlyCredits  float64 `json:"monthlyCredits,omitempty"`
+}
+
+// Merge 以 override 中非 0 的字段覆盖 q，返回新值（二者均可为 nil）
+func (q *UsageQuota) Merge(override *UsageQuota) UsageQuota {
+	var merged UsageQuota
+	if q != nil {
+		merged = *q
+	}
+	if override != nil {
+		if override.DailyRequests != 0 {
+			merged.DailyRequests = override.DailyRequests
+		}
+		if override.MonthlyRequests != 0 {
+			merged.MonthlyRequests = override.MonthlyRequests
+		}
+		if override.DailyTokens != 0 {
+			merged.DailyTokens = override.DailyTokens
+		}
+		if override.MonthlyTokens != 0 {
+			merged.MonthlyTokens = override.MonthlyTokens
+		}
+		if override.MonthlyCredits != 0 {
+			merged.MonthlyCredits = override.MonthlyCredits
+		}
+	}
+	return merged
+}
+
+// SecretsConfig 主密钥来源，按 环境变量 → keyFile → 系统钥匙串 的顺序取第一个可用的；
+// 密钥列表中第一个为当前主密钥，其余为轮换前的旧密钥（只用于解密）
+type SecretsConfig struct {
+	KeyEnv  string `json:"keyEnv,omitempty"`  // 环境变量名，默认 KIRO_MASTER_KEY
+	KeyFile string `json:"keyFile,omitempty"` // 密钥文件，每行一个密钥
+	Keyring bool   `json:"keyring,omitempty"` // 从系统钥匙串读取（service kiro-go，account master-key）
+}
+
+// 共享组的 fallback 规则
+const (
+	SharingFallbackGroup = "group" // 借用同组其他激活码的用户凭证
+	SharingFallbackMain  = "main"  // 使用主凭证池
+)
+
+// DefaultSharingGroup 未加入任何组的激活码使用的规则名（不能作为组名分配给激活码）
+const DefaultSharingGroup = "default"
+
+// SharingGroup 共享组的借用规则
+type SharingGroup struct {
+	// 依次尝试的来源：group | main，空表示不借用（额度用尽直接返回 402）
+	Fallback []string `json:"fallback"`
+}
+
+// DevicePolicy 激活码的设备绑定策略，0 / 空表示沿用默认值
+// 配置了 maxDevices 或 allowedMachineIds 后，API 请求必须携带 X-Machine-Id
+type DevicePolicy struct {
+	MaxDevices        int      `json:"maxDevices,omitempty"`        // 最多绑定的设备数，负数表示不限制
+	AllowedMachineIDs []string `json:"allowedMachineIds,omitempty"` // 设备白名单，非空时只允许这些 machineId
+	RebindCooldown    int      `json:"rebindCooldown,omitempty"`    // 解绑设备后需等待多久（秒）才能绑定新设备
+}
+
+// Merge 以 override 中非 0 / 非空的字段覆盖 p，返回新值（二者均可为 nil）
+func (p *DevicePolicy) Merge(override *DevicePolicy) DevicePolicy {
+	var merged DevicePolicy
+	if p != nil {
+		merged = *p
+	}
+	if override != nil {
+		if override.MaxDevices != 0 {
+			merged.MaxDevices = override.MaxDevices
+		}
+		if override.AllowedMachineIDs != nil {
+			merged.AllowedMachineIDs = override.AllowedMachineIDs
+		}
+		if override.RebindCooldown != 0 {
+			merged.RebindCooldown = override.RebindCooldown
+		}
+	}
+	return merged
+}
+
+// Enforced 是否启用了设备限制
+func (p DevicePolicy) Enforced() bool {
+	return p.MaxDevices > 0 || len(p.AllowedMachineIDs) > 0
+}
+
+// ModelRoutingConfig 客户端模型名 → Kiro 模型 ID 的路由表
+// 匹配顺序：reject → aliases（原名、标准化名）→ rules → default
+type ModelRoutingConfig struct {
+	Aliases        map[string]string `json:"aliases"`        // 精确别名（不区分大小写），如 "gpt-4o": "claude-sonnet-4.5"
+	Rules          []ModelRule       `json:"rules"`          // 正则规则，按顺序匹配标准化后的模型名，优先于内置规则
+	Default        string            `json:"default"`        // 都未匹配时使用的模型，空则返回"模型不支持"
+	Reject         []string          `json:"reject"`         // 拒绝列表（正则，不区分大小写），命中直接返回 400
+	ReplaceBuiltin bool              `json:"replaceBuiltin"` // true 时不再合并内置别名和规则
+}
+
+// ModelRule 正则路由规则，Target 支持 $1 等分组引用，为空表示原样透传标准化后的模型名
+type ModelRule struct {
+	Pattern string `json:"pattern"`
+	Target  string `json:"target"`
+}
+
+// RoutingRule 请求路由规则，所有已配置的条件同时满足才命中
+type RoutingRule struct {
+	Name string `json:"name"`
+
+	Models          []string `json:"models,omitempty"`          // 原始模型名正则（不区分大小写），任一匹配即可
+	NoTools         *bool    `json:"noTools,omitempty"`         // true: 请求不带 tools；false: 必须带 tools
+	MaxInputChars   int      `json:"maxInputChars,omitempty"`   // system + messages 文本总字符数不超过该值
+	MaxMessages     int      `json:"maxMessages,omitempty"`     // 消息条数不超过该值
+	SystemPattern   string   `json:"systemPattern,omitempty"`   // system prompt 正则
+	MetadataPattern string   `json:"metadataPattern,omitempty"` // metadata.user_id 正则
+	ActivationCodes []string `json:"activationCodes,omitempty"` // 仅对这些激活码生效（不区分大小写）
+
+	Model string `json:"model"` // 改写后的模型名（仍经过 modelRouting 解析）
+
+	compiled bool             // 已 Compile（随配置快照编译一次）
+	models   []*regexp.Regexp // Compile 预编译的 Models，无效正则为 nil
+	system   *regexp.Regexp
+	metadata *regexp.Regexp
+}
+
+// Compile 预编译规则中的正则（不区分大小写），返回第一个无效正则的错误；无效的正则不会命中
+// 加载和热加载配置时经 Config.CompileRoutingRules 调用，每个配置快照只编译一次
+func (r *RoutingRule) Compile() error {
+	var first error
+	compile := func(pattern string) *regexp.Regexp {
+		if pattern == "" {
+			return nil
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil && first == nil {
+			first = fmt.Errorf("正则无效 %q: %v", pattern, err)
+		}
+		return re
+	}
+	r.models = make([]*regexp.Regexp, len(r.Models))
+	for i, p := range r.Models {
+		r.models[i] = compile(p)
+	}
+	r.system = compile(r.SystemPattern)
+	r.metadata = compile(r.MetadataPattern)
+	r.compiled = true
+	return first
+}
+
+// compiledRule 已编译的规则；代码中直接构造、未 Compile 的规则临时编译一份
+func (r *RoutingRule) compiledRule() *RoutingRule {
+	if r.compiled {
+		return r
+	}
+	c := *r
+	c.Compile()
+	return &c
+}
+
+// MatchesModel 原始模型名是否匹配 Models 中任一正则，未配置时总是匹配
+func (r *RoutingRule) MatchesModel(modelName string) bool {
+	if len(r.Models) == 0 {
+		return true
+	}
+	for _, re := range r.compiledRule().models {
+		if re != nil && re.MatchString(modelName) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesSystem system prompt 是否匹配 SystemPattern，未配置时总是匹配
+func (r *RoutingRule) MatchesSystem(system string) bool {
+	if r.SystemPattern == "" {
+		return true
+	}
+	re := r.compiledRule().system
+	return re != nil && re.MatchString(system)
+}
+
+// MatchesMetadata metadata.user_id 是否匹配 MetadataPattern，未配置时总是匹配
+func (r *RoutingRule) MatchesMetadata(userID string) bool {
+	if r.MetadataPattern == "" {
+		return true
+	}
+	re := r.compiledRule().metadata
+	return re != nil && re.MatchString(userID)
+}
+
 func (c *Config) EffectiveAPIRegion() string {
 	if c.APIRegion != "" {
 		return c.APIRegion
@@ -98,9 +421,30 @@ func (c *Config) DefaultsWithDir(configDir string) {
 	if c.CodesPath == "" {
 		c.CodesPath = filepath.Join(baseDir, "codes.json")
 	}
+	if c.APIKeysPath == "" {
+		c.APIKeysPath = filepath.Join(baseDir, "api_keys.json")
+	}
+	if c.TokenDenyListPath == "" {
+		c.TokenDenyListPath = filepath.Join(baseDir, "revoked_tokens.json")
+	}
+	if c.UsageLedgerPath == "" {
+		c.UsageLedgerPath = filepath.Join(baseDir, "usage.jsonl")
+	}
+	if c.SharingAuditPath == "" {
+		c.SharingAuditPath = filepath.Join(baseDir, "sharing_audit.jsonl")
+	}
+	if c.StorePath != "" && !filepath.IsAbs(c.StorePath) {
+		c.StorePath = filepath.Join(baseDir, c.StorePath)
+	}
+	if c.Secrets != nil && c.Secrets.KeyFile != "" && !filepath.IsAbs(c.Secrets.KeyFile) {
+		c.Secrets.KeyFile = filepath.Join(baseDir, c.Secrets.KeyFile)
+	}
 	if c.Backend == "" {
 		c.Backend = "kiro"
 	}
+	if c.Locale == "" {
+		c.Locale = "zh"
+	}
 	if c.CompressionModel == "" {
 		c.CompressionModel = "claude-haiku-4.5"
 	}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// restartOnlyFields 启动时即被各组件固化的配置项（文件路径、存储、主密钥、令牌 / 许可证签名等），
+// 热加载时只提示需要重启，不修改当前值
+var restartOnlyFields = map[string]bool{
+	"userCredentialsPath":  true,
+	"codesPath":            true,
+	"apiKeysPath":          true,
+	"tokenDenyListPath":    true,
+	"usageLedgerPath":      true,
+	"sharingAuditPath":     true,
+	"metricsListen":        true,
+	"storePath":            true,
+	"secrets":              true,
+	"clientTokens":         true,
+	"activationServerUrl":  true,
+	"licenseSigningSecret": true,
+	"licenseTtl":           true,
+	"sessionAffinityTtl":   true,
+	"sessionAffinityMax":   true,
+}
+
+// secretFields 变化日志中脱敏的配置项
+var secretFields = map[string]bool{
+	"apiKey":               true,
+	"adminApiKey":          true,
+	"relaySecret":          true,
+	"licenseSigningSecret": true,
+	"anthropicApiKey":      true,
+	"anthropicApiKeys":     true,
+}
+
+// reloadMu 串行化热加载，同一时间只有一次 ApplyReload 在发布新配置
+var reloadMu sync.Mutex
+
+// ConfigChange 热加载时一个配置项的变化（Old / New 为日志用的文本，敏感字段已脱敏）
+type ConfigChange struct {
+	Field   string
+	Old     string
+	New     string
+	Restart bool // 需要重启才能生效，本次未应用
+}
+
+// Validate 检查配置取值，热加载时不通过则整体拒绝、继续使用当前配置
+func (c *Config) Validate() error {
+	var errs []string
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port 超出范围: %d", c.Port))
+	}
+	switch c.Backend {
+	case "kiro", "anthropic":
+	default:
+		errs = append(errs, fmt.Sprintf("backend 无效: %q（kiro | anthropic）", c.Backend))
+	}
+	switch c.ActivationPolicy {
+	case "", "fail-open", "fail-closed", "grace":
+	default:
+		errs = append(errs, fmt.Sprintf("activationPolicy 无效: %q（fail-open | fail-closed | grace）", c.ActivationPolicy))
+	}
+	if c.CompressionThreshold < 0 || c.CompressionKeepRecent < 0 {
+		errs = append(errs, "compressionThreshold / compressionKeepRecent 不能为负数")
+	}
+	if c.ActivationGraceSeconds < 0 || c.LicenseTTL < 0 || c.ModelCatalogTTL < 0 {
+		errs = append(errs, "activationGraceSeconds / licenseTtl / modelCatalogTtl 不能为负数")
+	}
+	if c.UsageNearLimit < 0 || c.UsageNearLimit > 1 {
+		errs = append(errs, fmt.Sprintf("usageNearLimit 应在 0 ~ 1 之间: %v", c.UsageNearLimit))
+	}
+	for name, g := range c.SharingGroups {
+		for _, f := range g.Fallback {
+			if f != SharingFallbackGroup && f != SharingFallbackMain {
+				errs = append(errs, fmt.Sprintf("sharingGroups.%s.fallback 无效: %q（group | main）", name, f))
+			}
+		}
+	}
+	if u, err := url.Parse(c.AnthropicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("anthropicBaseUrl 无效: %q", c.AnthropicBaseURL))
+	}
+	if err := c.CompileRoutingRules(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// CompileRoutingRules 预编译 routingRules 中的正则（发布配置快照之前调用），返回所有无效正则
+func (c *Config) CompileRoutingRules() error {
+	var errs []string
+	for i := range c.RoutingRules {
+		if err := c.RoutingRules[i].Compile(); err != nil {
+			errs = append(errs, fmt.Sprintf("routingRules[%d] %v", i, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ConfigRef 当前生效的配置。已发布的 *Config 不再修改，热加载时整体替换为新的快照；
+// 各组件持有同一个 ConfigRef，每次使用时 Load 取得一致的快照（一次请求内应只 Load 一次）
+type ConfigRef struct {
+	p atomic.Pointer[Config]
+}
+
+func NewConfigRef(c *Config) *ConfigRef {
+	r := &ConfigRef{}
+	r.p.Store(c)
+	return r
+}
+
+// Load 当前配置快照（只读）
+func (r *ConfigRef) Load() *Config {
+	return r.p.Load()
+}
+
+// ApplyReload 以当前配置为基础，复制 next（已填充默认值并通过 Validate）中可热加载的配置项，
+// 发布为新的快照，返回全部变化项；需要重启的配置项保持不变，只在返回值中标记
+func (r *ConfigRef) ApplyReload(next *Config) []ConfigChange {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	merged := *r.Load()
+	cur := reflect.ValueOf(&merged).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	t := cur.Type()
+	var changes []ConfigChange
+	for i := 0; i < t.NumField(); i++ {
+		oldV, newV := cur.Field(i), nv.Field(i)
+		if reflect.DeepEqual(oldV.Interface(), newV.Interface()) {
+			continue
+		}
+		name := jsonFieldName(t.Field(i))
+		change := ConfigChange{Field: name, Restart: restartOnlyFields[name]}
+		if secretFields[name] {
+			change.Old, change.New = maskConfigValue(oldV), maskConfigValue(newV)
+		} else {
+			change.Old, change.New = formatConfigValue(oldV), formatConfigValue(newV)
+		}
+		if !change.Restart {
+			oldV.Set(newV)
+		}
+		changes = append(changes, change)
+	}
+	r.p.Store(&merged)
+	return changes
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// formatConfigValue 日志用的 JSON 文本，过长时截断
+func formatConfigValue(v reflect.Value) string {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	if len(data) > 200 {
+		return string(data[:200]) + "..."
+	}
+	return string(data)
+}
+
+// maskConfigValue 敏感字段只显示是否设置（列表显示个数）
+func maskConfigValue(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		return fmt.Sprintf("[%d 个]", v.Len())
+	}
+	if v.IsZero() {
+		return "<empty>"
+	}
+	return "***"
+}
@@ -1,5 +1,7 @@
 package model
 
+import "fmt"
+
 // KiroCredentials Kiro 凭证
 type KiroCredentials struct {
 	ID           *int   `json:"id,omitempty"`
@@ -13,21 +15,46 @@ type KiroCredentials struct {
 	Region       string `json:"region,omitempty"`
 	AuthRegion   string `json:"authRegion,omitempty"`
 	APIRegion    string `json:"apiRegion,omitempty"`
-	MachineID    string `json:"machineId,omitempty"`
-	Disabled     bool   `json:"disabled,omitempty"`
-	Priority     *int   `json:"priority,omitempty"`
+	// 按顺序故障转移的 API 区域，未设置时为 apiRegion / region 加上 config.regions
+	APIRegions []string `json:"apiRegions,omitempty"`
+	MachineID  string   `json:"machineId,omitempty"`
+	Disabled   bool     `json:"disabled,omitempty"`
+	// 禁用原因：manual（管理员禁用）| quota_exhausted（额度用尽）| 自定义说明
+	DisabledReason string `json:"disabledReason,omitempty"`
+	Priority       *int   `json:"priority,omitempty"` // 数值越小越优先，未设置视为 0；同一档内轮询
+	Group          string `json:"group,omitempty"`    // 凭据分组，API Key 可固定到某个分组
 }
 
 func (c *KiroCredentials) EffectiveRegion(cfg *Config) string {
 	if c.APIRegion != "" {
 		return c.APIRegion
 	}
+	if len(c.APIRegions) > 0 {
+		return c.APIRegions[0]
+	}
 	if c.Region != "" {
 		return c.Region
 	}
 	return cfg.EffectiveAPIRegion()
 }
 
+// EffectiveRegions API 调用依次尝试的区域（去重）：apiRegions，未设置时为 EffectiveRegion 加上 config.regions
+func (c *KiroCredentials) EffectiveRegions(cfg *Config) []string {
+	list := c.APIRegions
+	if len(list) == 0 {
+		list = append([]string{c.EffectiveRegion(cfg)}, cfg.Regions...)
+	}
+	seen := make(map[string]bool, len(list))
+	regions := make([]string, 0, len(list))
+	for _, r := range list {
+		if r != "" && !seen[r] {
+			seen[r] = true
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
 func (c *KiroCredentials) EffectiveAuthRegion(cfg *Config) string {
 	if c.AuthRegion != "" {
 		return c.AuthRegion
@@ -38,6 +65,57 @@ func (c *KiroCredentials) EffectiveAuthRegion(cfg *Config) string {
 	return cfg.EffectiveAPIRegion()
 }
 
+// ValidateCredentials 检查主凭证池：每个凭据需有 accessToken 或 refreshToken，id 不能重复
+func ValidateCredentials(list []*KiroCredentials) error {
+	ids := make(map[int]bool)
+	for i, c := range list {
+		if c == nil {
+			return fmt.Errorf("第 %d 个凭据为空", i+1)
+		}
+		if c.AccessToken == "" && c.RefreshToken == "" {
+			return fmt.Errorf("第 %d 个凭据缺少 accessToken 和 refreshToken", i+1)
+		}
+		if c.ID != nil {
+			if ids[*c.ID] {
+				return fmt.Errorf("凭据 id 重复: %d", *c.ID)
+			}
+			ids[*c.ID] = true
+		}
+	}
+	return nil
+}
+
+// CredentialPin 将请求限定在主凭证池的部分凭据上（按 id 或 group），nil 表示不限定
+type CredentialPin struct {
+	ID    *int   `json:"id,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// Matches 凭据是否在限定范围内
+func (p *CredentialPin) Matches(c *KiroCredentials) bool {
+	if p == nil {
+		return true
+	}
+	if p.ID != nil && (c.ID == nil || *c.ID != *p.ID) {
+		return false
+	}
+	if p.Group != "" && c.Group != p.Group {
+		return false
+	}
+	return true
+}
+
+// String 日志用描述
+func (p *CredentialPin) String() string {
+	if p == nil {
+		return ""
+	}
+	if p.ID != nil {
+		return fmt.Sprintf("id=%d", *p.ID)
+	}
+	return "group=" + p.Group
+}
+
 // UserCredentialEntry 用户凭证条目（多用户模式）
 type UserCredentialEntry struct {
 	ActivationCode string          `json:"activation_code"`
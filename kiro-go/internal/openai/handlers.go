@@ -14,6 +14,7 @@ import (
 	"kiro-go/internal/kiro"
 	"kiro-go/internal/kiro/parser"
 	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
 	"kiro-go/internal/model"
 
 	"github.com/google/uuid"
@@ -22,20 +23,20 @@ import (
 // HandleChatCompletions POST /v1/chat/completions
 func HandleChatCompletions(w http.ResponseWriter, r *http.Request, provider *kiro.Provider) {
 	if r.Method != http.MethodPost {
-		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		writeOpenAIErrorCode(w, r, http.StatusMethodNotAllowed, "invalid_request_error", common.MsgMethodNotAllowed)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		writeOpenAIErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgReadBodyFailed)
 		return
 	}
 	defer r.Body.Close()
 
 	var openaiReq map[string]interface{}
 	if err := json.Unmarshal(body, &openaiReq); err != nil {
-		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON")
+		writeOpenAIErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgInvalidJSON, err.Error())
 		return
 	}
 
@@ -46,26 +47,42 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request, provider *kir
 	rlog.Debug("收到 OpenAI 请求", logger.F{"body_size": len(body)})
 
 	req := convertOpenAIToAnthropic(openaiReq)
+	metrics.SetModel(r, req.Model)
+
+	// 路由规则：按请求特征改写模型（如后台小请求改用 haiku）
+	anthropic.ApplyRoutingRules(w, req, actCode, provider.Config.Load().RoutingRules)
+
+	// 模型范围按路由、别名解析后的最终模型检查，备用模型在 CallWithFallback 中逐个检查
+	scope := common.GetModelScopeFromContext(r)
+	if msg := anthropic.CheckScopedModel(scope, req.Model); msg != nil {
+		rlog.Warn("不允许使用该模型", logger.F{"model": req.Model})
+		writeOpenAIErrorFrom(w, r, http.StatusForbidden, "permission_error", msg)
+		return
+	}
 
 	// 上下文压缩：消息过多时用小模型压缩历史
 	creds := common.GetCredsFromContext(r)
-	if compressed, ok := anthropic.CompressContext(req.Messages, provider.Config, provider, creds, actCode); ok {
+	pin := common.GetPinFromContext(r)
+	if compressed, ok := anthropic.CompressContext(req.Messages, provider.Config.Load(), provider, creds, actCode, pin); ok {
 		req.Messages = compressed
 	}
 
+	anthropic.EnablePromptCaching(req, provider.Config.Load())
+	if modelID, err := anthropic.CheckModel(req.Model); err == nil {
+		if err := anthropic.CheckModelCapabilities(req, provider.Catalog.Lookup(creds, modelID)); err != nil {
+			writeOpenAIErrorFrom(w, r, http.StatusBadRequest, "invalid_request_error", err)
+			return
+		}
+	}
+
 	kiroBody, err := anthropic.ConvertToKiroRequest(req)
 	if err != nil {
-		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		writeOpenAIErrorFrom(w, r, http.StatusBadRequest, "invalid_request_error", err)
 		return
 	}
 
 	start := time.Now()
-	var resp *http.Response
-	if creds != nil {
-		resp, err = provider.CallWithCredentials(kiroBody, creds, actCode)
-	} else {
-		resp, _, err = provider.CallWithTokenManager(kiroBody)
-	}
+	resp, usedModel, err := provider.CallWithFallback(kiroBody, creds, actCode, pin, anthropic.SessionID(req), scope)
 	elapsed := time.Since(start)
 	if err != nil {
 		rlog.Error("上游请求失败", logger.F{
@@ -73,16 +90,18 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request, provider *kir
 			"latency": elapsed.String(),
 			"error":   err.Error(),
 		})
-		writeOpenAIError(w, http.StatusBadGateway, "server_error", err.Error())
+		writeOpenAIUpstreamError(w, common.ClassifyError(err))
 		return
 	}
 	defer resp.Body.Close()
 
+	region := kiro.ServedRegion(resp)
 	rlog.Info("上游响应", logger.F{
 		"model":   req.Model,
 		"status":  resp.StatusCode,
 		"latency": elapsed.String(),
 		"stream":  req.Stream,
+		"region":  region,
 	})
 
 	if resp.StatusCode != 200 {
@@ -91,30 +110,93 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request, provider *kir
 			"status": resp.StatusCode,
 			"body":   logger.TruncateBody(string(respBody), 500),
 		})
-		writeOpenAIError(w, resp.StatusCode, "api_error", fmt.Sprintf("Upstream error: %d %s", resp.StatusCode, string(respBody)))
+		writeOpenAIUpstreamError(w, common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header))
 		return
 	}
 
+	// 降级到备用模型时，响应的 model 字段报告实际使用的模型
+	if usedModel != kiro.RequestModelID(kiroBody) {
+		w.Header().Set("x-kiro-fallback-model", usedModel)
+		req.Model = usedModel
+	}
+	if region != "" {
+		w.Header().Set("x-kiro-region", region)
+	}
+
+	var usage common.Usage
 	if req.Stream {
-		handleStreamResponse(w, resp, req, provider, openaiReq, creds, actCode)
+		usage = handleStreamResponse(w, resp, req, provider, openaiReq, creds, actCode)
 	} else {
-		handleNonStreamResponse(w, resp, req)
+		usage = handleNonStreamResponse(w, resp, req)
 	}
+	usage.Model = usedModel
+	usage.Region = region
+	common.RecordUsage(r, usage)
+}
+
+// kiroUsage Kiro 响应用量；OpenAI 侧自行统计的 output tokens 含自动续写部分
+func kiroUsage(streamCtx *anthropic.StreamContext, outputTokens int) common.Usage {
+	usage := streamCtx.UsageRecord(streamCtx.Model)
+	usage.OutputTokens = outputTokens
+	return usage
+}
+
+// directUsage Anthropic 直连响应用量
+func directUsage(promptTokens, outputTokens int) common.Usage {
+	return common.Usage{Backend: "anthropic", InputTokens: promptTokens, OutputTokens: outputTokens}
 }
 
 // ── OpenAI 错误格式 ──
 
 func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	writeOpenAIErrorBody(w, status, errType, "", message)
+}
+
+// writeOpenAIErrorCode 写入本地化错误（语言取自 Accept-Language，error.code 为稳定错误码）
+func writeOpenAIErrorCode(w http.ResponseWriter, r *http.Request, status int, errType string, code common.MsgCode, args ...interface{}) {
+	writeOpenAIErrorBody(w, status, errType, string(code), common.T(r, code, args...))
+}
+
+// writeOpenAIErrorFrom 写入 error 对应的错误，*common.Message 按请求语言渲染
+func writeOpenAIErrorFrom(w http.ResponseWriter, r *http.Request, status int, errType string, err error) {
+	code, message := common.LocalizeError(r, err)
+	writeOpenAIErrorBody(w, status, errType, code, message)
+}
+
+func writeOpenAIErrorBody(w http.ResponseWriter, status int, errType, code, message string) {
+	var codeValue interface{}
+	if code != "" {
+		codeValue = code
+	}
 	common.WriteJSON(w, status, map[string]interface{}{
 		"error": map[string]interface{}{
 			"message": message,
 			"type":    errType,
 			"param":   nil,
-			"code":    nil,
+			"code":    codeValue,
 		},
 	})
 }
 
+// writeOpenAIUpstreamError 以 OpenAI 格式写入归类后的上游错误（含 Retry-After）
+// OpenAI 没有 529，过载统一返回 503
+func writeOpenAIUpstreamError(w http.ResponseWriter, e *common.UpstreamError) {
+	status := e.Status
+	if status == 529 {
+		status = http.StatusServiceUnavailable
+	}
+	e.SetRetryHeaders(w)
+	common.WriteJSON(w, status, e.OpenAIErrorBody())
+}
+
+// writeOpenAIStreamError 流式输出途中上游失败：发送 OpenAI 格式 error chunk 后结束流
+// 不发送 finish_reason 和 [DONE]，SDK 会据此抛出异常而不是接受截断的回答
+func writeOpenAIStreamError(w http.ResponseWriter, flusher http.Flusher, e *common.UpstreamError) {
+	data, _ := json.Marshal(e.OpenAIErrorBody())
+	fmt.Fprintf(w, "data: %s\n\n", string(data))
+	flusher.Flush()
+}
+
 // ── OpenAI → Anthropic 请求转换 ──
 //
 // 完整处理：
@@ -657,11 +739,11 @@ func sanitizeJSONSchema(schema map[string]interface{}) map[string]interface{} {
 // - 正确的 finish_reason（stop / tool_calls）
 // - usage 统计
 
-func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest, provider *kiro.Provider, openaiReq map[string]interface{}, creds *model.KiroCredentials, actCode string) {
+func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest, provider *kiro.Provider, openaiReq map[string]interface{}, creds *model.KiroCredentials, actCode string) common.Usage {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "Streaming not supported")
-		return
+		return common.Usage{}
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -698,7 +780,7 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthr
 
 	// 流式读取，每次读取可用数据
 	buf := make([]byte, 256*1024) // 256KB 临时缓冲区
-	for {
+	for streamCtx.Err() == nil {
 		n, err := reader.Read(buf)
 		if n > 0 {
 			totalBytesRead += n
@@ -714,6 +796,9 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthr
 
 				// 通过 Anthropic StreamContext 处理（提取 thinking）
 				sseEvents := streamCtx.ProcessKiroEvent(event)
+				if len(sseEvents) > 0 || event.Type == "tool_use" {
+					metrics.FirstToken(w)
+				}
 				for _, sseEvent := range sseEvents {
 					switch sseEvent.Event {
 					case "content_block_delta":
@@ -795,10 +880,25 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthr
 			}
 		}
 		if err != nil {
+			if readErr := anthropic.StreamReadError(err, decoder); readErr != nil {
+				streamCtx.Fail(common.StreamInterrupted(readErr))
+			}
 			break
 		}
 	}
 
+	// 上游报错或中途断开：发送 error chunk，不伪装成正常结束
+	if streamErr := streamCtx.Err(); streamErr != nil {
+		logger.WarnFields(logger.CatStream, "上游流异常终止，已发送 error chunk", logger.F{
+			"error_type":    streamErr.Type,
+			"message":       streamErr.Message,
+			"output_tokens": outputTokens,
+			"bytes_read":    totalBytesRead,
+		})
+		writeOpenAIStreamError(w, flusher, streamErr)
+		return kiroUsage(streamCtx, outputTokens)
+	}
+
 	// Flush StreamContext 中的 thinking buffer 和剩余内容
 	finalSSEEvents := streamCtx.GenerateFinalEvents()
 	for _, sseEvent := range finalSSEEvents {
@@ -890,6 +990,8 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthr
 				"content": "Continue",
 			})
 			continueOpenAIReq["messages"] = messages
+			// 沿用实际使用的模型（初始请求可能已降级到备用模型）
+			continueOpenAIReq["model"] = req.Model
 
 			// 转换并发起新请求
 			continueReq := convertOpenAIToAnthropic(continueOpenAIReq)
@@ -993,6 +1095,7 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthr
 
 	fmt.Fprintf(w, "data: [DONE]\n\n")
 	flusher.Flush()
+	return kiroUsage(streamCtx, outputTokens)
 }
 
 // writeSSEChunk 写入一个 OpenAI SSE chunk
@@ -1018,20 +1121,20 @@ func writeSSEChunk(w http.ResponseWriter, flusher http.Flusher, chatID string, c
 // OpenAI 请求 → Anthropic 格式 → DirectProvider → Anthropic SSE → OpenAI SSE
 func HandleChatCompletionsDirect(w http.ResponseWriter, r *http.Request, dp *anthropic.DirectProvider) {
 	if r.Method != http.MethodPost {
-		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		writeOpenAIErrorCode(w, r, http.StatusMethodNotAllowed, "invalid_request_error", common.MsgMethodNotAllowed)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		writeOpenAIErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgReadBodyFailed)
 		return
 	}
 	defer r.Body.Close()
 
 	var openaiReq map[string]interface{}
 	if err := json.Unmarshal(body, &openaiReq); err != nil {
-		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON")
+		writeOpenAIErrorCode(w, r, http.StatusBadRequest, "invalid_request_error", common.MsgInvalidJSON, err.Error())
 		return
 	}
 
@@ -1041,6 +1144,13 @@ func HandleChatCompletionsDirect(w http.ResponseWriter, r *http.Request, dp *ant
 	})
 
 	req := convertOpenAIToAnthropic(openaiReq)
+	metrics.SetModel(r, req.Model)
+
+	// 直连按原模型名转发，模型范围直接按该名称检查
+	if msg := common.CheckModelScope(common.GetModelScopeFromContext(r), req.Model); msg != nil {
+		writeOpenAIErrorFrom(w, r, http.StatusForbidden, "permission_error", msg)
+		return
+	}
 
 	betaHeader := r.Header.Get("anthropic-beta")
 	resp, err := dp.CallAnthropic(req, betaHeader)
@@ -1048,7 +1158,7 @@ func HandleChatCompletionsDirect(w http.ResponseWriter, r *http.Request, dp *ant
 		logger.ErrorFields(logger.CatRequest, "Anthropic直连请求失败", logger.F{
 			"error": err.Error(),
 		})
-		writeOpenAIError(w, http.StatusBadGateway, "server_error", err.Error())
+		writeOpenAIUpstreamError(w, common.ClassifyError(err))
 		return
 	}
 	defer resp.Body.Close()
@@ -1059,23 +1169,26 @@ func HandleChatCompletionsDirect(w http.ResponseWriter, r *http.Request, dp *ant
 			"status": resp.StatusCode,
 			"body":   logger.TruncateBody(string(respBody), 500),
 		})
-		writeOpenAIError(w, resp.StatusCode, "api_error", fmt.Sprintf("Upstream error: %d %s", resp.StatusCode, string(respBody)))
+		writeOpenAIUpstreamError(w, common.ClassifyUpstreamResponse(resp.StatusCode, string(respBody), resp.Header))
 		return
 	}
 
+	var usage common.Usage
 	if req.Stream {
-		handleDirectStreamResponse(w, resp, req)
+		usage = handleDirectStreamResponse(w, resp, req)
 	} else {
-		handleDirectNonStreamResponse(w, resp, req)
+		usage = handleDirectNonStreamResponse(w, resp, req)
 	}
+	usage.Model = req.Model
+	common.RecordUsage(r, usage)
 }
 
 // handleDirectStreamResponse 解析 Anthropic SSE 流 → OpenAI SSE chunks
-func handleDirectStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest) {
+func handleDirectStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest) common.Usage {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "Streaming not supported")
-		return
+		return common.Usage{}
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -1124,6 +1237,10 @@ func handleDirectStreamResponse(w http.ResponseWriter, resp *http.Response, req
 			continue
 		}
 
+		if currentEventType == "content_block_delta" {
+			metrics.FirstToken(w)
+		}
+
 		switch currentEventType {
 		case "content_block_delta":
 			delta, _ := data["delta"].(map[string]interface{})
@@ -1223,9 +1340,27 @@ func handleDirectStreamResponse(w http.ResponseWriter, resp *http.Response, req
 					}
 				}
 			}
+
+		case "error":
+			// Anthropic 流中错误事件 → OpenAI error chunk
+			errObj, _ := data["error"].(map[string]interface{})
+			errType, _ := errObj["type"].(string)
+			errMsg, _ := errObj["message"].(string)
+			logger.WarnFields(logger.CatStream, "Anthropic直连流错误", logger.F{
+				"error_type": errType,
+				"message":    errMsg,
+			})
+			writeOpenAIStreamError(w, flusher, &common.UpstreamError{Type: errType, Message: errMsg})
+			return directUsage(promptTokens, outputTokens)
 		}
 	}
 
+	if err := scanner.Err(); err != nil {
+		logger.Warnf(logger.CatStream, "Anthropic直连流读取中断: %v", err)
+		writeOpenAIStreamError(w, flusher, common.StreamInterrupted(err))
+		return directUsage(promptTokens, outputTokens)
+	}
+
 	// 发送带 finish_reason 的最终 chunk
 	finishReason := "stop"
 	if hasToolUse {
@@ -1251,6 +1386,7 @@ func handleDirectStreamResponse(w http.ResponseWriter, resp *http.Response, req
 
 	fmt.Fprintf(w, "data: [DONE]\n\n")
 	flusher.Flush()
+	return directUsage(promptTokens, outputTokens)
 }
 
 // findToolIDByBlockIndex 通过 Anthropic block index 查找 tool ID
@@ -1269,17 +1405,17 @@ func findToolIDByBlockIndex(toolIndexMap map[string]int, blockIdx int) (string,
 }
 
 // handleDirectNonStreamResponse 解析 Anthropic JSON 响应 → OpenAI JSON
-func handleDirectNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest) {
+func handleDirectNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest) common.Usage {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		writeOpenAIError(w, http.StatusBadGateway, "server_error", "Failed to read response: "+err.Error())
-		return
+		return common.Usage{}
 	}
 
 	var anthropicResp map[string]interface{}
 	if err := json.Unmarshal(body, &anthropicResp); err != nil {
 		writeOpenAIError(w, http.StatusBadGateway, "server_error", "Invalid response JSON")
-		return
+		return common.Usage{}
 	}
 
 	// 提取内容
@@ -1367,11 +1503,12 @@ func handleDirectNonStreamResponse(w http.ResponseWriter, resp *http.Response, r
 			"total_tokens":      promptTokens + completionTokens,
 		},
 	})
+	return directUsage(promptTokens, completionTokens)
 }
 
 // ── 非流式响应（Kiro → OpenAI JSON）──
 
-func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest) {
+func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *anthropic.MessagesRequest) common.Usage {
 	decoder := parser.NewDecoder()
 	// 使用 bufio.Reader 进行流式读取
 	reader := bufio.NewReaderSize(resp.Body, 64*1024)
@@ -1395,7 +1532,7 @@ func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *an
 	var toolOrder []string
 
 	buf := make([]byte, 256*1024) // 256KB 临时缓冲区
-	for {
+	for streamCtx.Err() == nil {
 		n, err := reader.Read(buf)
 		if n > 0 {
 			decoder.Feed(buf[:n])
@@ -1455,10 +1592,23 @@ func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *an
 			}
 		}
 		if err != nil {
+			if readErr := anthropic.StreamReadError(err, decoder); readErr != nil {
+				streamCtx.Fail(common.StreamInterrupted(readErr))
+			}
 			break
 		}
 	}
 
+	// 上游报错或中途断开：返回错误而不是不完整的内容
+	if streamErr := streamCtx.Err(); streamErr != nil {
+		logger.WarnFields(logger.CatResponse, "上游流异常终止", logger.F{
+			"error_type": streamErr.Type,
+			"message":    streamErr.Message,
+		})
+		writeOpenAIUpstreamError(w, streamErr)
+		return kiroUsage(streamCtx, outputTokens)
+	}
+
 	// Truncation Detection（非流式）
 	// 参考 kiro-gateway streaming_openai.py
 	if common.ShouldInjectRecovery() {
@@ -1533,4 +1683,5 @@ func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, req *an
 			"total_tokens":      promptTokens + outputTokens,
 		},
 	})
+	return kiroUsage(streamCtx, outputTokens)
 }
@@ -0,0 +1,437 @@
+// Package secrets 敏感字段静态加密（信封加密）：每个值使用随机数据密钥 AES-256-GCM 加密，
+// 数据密钥再用主密钥加密后与密文一起保存。轮换主密钥只需重新包装数据密钥，不必重新加密数据
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"kiro-go/internal/model"
+)
+
+const (
+	// SealedPrefix 加密值前缀，格式 enc:v1:<主密钥 ID>:<包装后的数据密钥>:<nonce||密文>（base64url）
+	SealedPrefix = "enc:v1:"
+	// DefaultKeyEnv 默认的主密钥环境变量
+	DefaultKeyEnv = "KIRO_MASTER_KEY"
+
+	keyringService = "kiro-go"
+	keyringAccount = "master-key"
+)
+
+// ErrNoKey 遇到加密值但未配置主密钥
+var ErrNoKey = errors.New("文件包含加密字段，但未配置主密钥（secrets / " + DefaultKeyEnv + "）")
+
+// secretFields 需要加密的 JSON 字段（KiroCredentials 与 launcher 的 Account 同名）
+var secretFields = map[string]bool{
+	"accessToken":  true,
+	"refreshToken": true,
+	"clientSecret": true,
+}
+
+// Keyring 主密钥集合：第一个为当前主密钥（加密 / 轮换目标），其余只用于解密旧数据
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// ParseKeys 解析主密钥列表（逗号或换行分隔，# 开头为注释），每个密钥可为
+// 32 字节的 base64 / 64 位十六进制，或任意口令（SHA-256 派生）
+func ParseKeys(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	for _, line := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := parseKey(line)
+		id := keyID(key)
+		if k.primary == "" {
+			k.primary = id
+		}
+		k.keys[id] = key
+	}
+	if k.primary == "" {
+		return nil, errors.New("主密钥为空")
+	}
+	return k, nil
+}
+
+func parseKey(s string) []byte {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		return b
+	}
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b
+	}
+	h := sha256.Sum256([]byte("kiro-master-v1:" + s))
+	return h[:]
+}
+
+// keyID 主密钥指纹（不泄露密钥本身），写入每个加密值以便轮换后找到对应密钥
+func keyID(key []byte) string {
+	h := sha256.Sum256(key)
+	return hex.EncodeToString(h[:4])
+}
+
+// GenerateKey 生成新的随机主密钥（base64）
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Load 按 环境变量 → keyFile → 系统钥匙串 的顺序读取主密钥；都未配置时返回 nil（不加密）
+func Load(cfg *model.SecretsConfig) (*Keyring, error) {
+	var c model.SecretsConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	env := c.KeyEnv
+	if env == "" {
+		env = DefaultKeyEnv
+	}
+	if v := os.Getenv(env); strings.TrimSpace(v) != "" {
+		return ParseKeys(v)
+	}
+	if c.KeyFile != "" {
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取主密钥文件失败: %w", err)
+		}
+		return ParseKeys(string(data))
+	}
+	if c.Keyring {
+		v, err := readKeyring()
+		if err != nil {
+			return nil, err
+		}
+		return ParseKeys(v)
+	}
+	return nil, nil
+}
+
+// readKeyring 从系统钥匙串读取（service kiro-go，account master-key）
+func readKeyring() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	default:
+		return "", fmt.Errorf("当前系统不支持从钥匙串读取主密钥，请改用环境变量或 keyFile")
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("从钥匙串读取主密钥失败（service=%s account=%s）: %v", keyringService, keyringAccount, err)
+	}
+	return string(out), nil
+}
+
+// PrimaryID 当前主密钥 ID
+func (k *Keyring) PrimaryID() string {
+	return k.primary
+}
+
+func gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("密文过短")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], aad)
+}
+
+var b64 = base64.RawURLEncoding
+
+// IsSealed 是否为加密值
+func IsSealed(v string) bool {
+	return strings.HasPrefix(v, SealedPrefix)
+}
+
+// Seal 加密字段值；field 作为附加数据，密文不能被挪到其他字段使用
+func (k *Keyring) Seal(field, plaintext string) (string, error) {
+	if plaintext == "" || IsSealed(plaintext) {
+		return plaintext, nil
+	}
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.primary], dek, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dek, []byte(plaintext), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return SealedPrefix + k.primary + ":" + b64.EncodeToString(wrapped) + ":" + b64.EncodeToString(data), nil
+}
+
+// parts 拆分加密值，返回主密钥 ID、包装后的数据密钥、密文
+func parts(value string) (string, []byte, []byte, error) {
+	p := strings.Split(strings.TrimPrefix(value, SealedPrefix), ":")
+	if len(p) != 3 {
+		return "", nil, nil, errors.New("加密值格式无效")
+	}
+	wrapped, err := b64.DecodeString(p[1])
+	if err != nil {
+		return "", nil, nil, errors.New("加密值格式无效")
+	}
+	data, err := b64.DecodeString(p[2])
+	if err != nil {
+		return "", nil, nil, errors.New("加密值格式无效")
+	}
+	return p[0], wrapped, data, nil
+}
+
+// unwrap 用对应主密钥解出数据密钥
+func (k *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("缺少主密钥 %s（轮换后请保留旧密钥直到完成 rotate）", id)
+	}
+	dek, err := open(key, wrapped, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("主密钥 %s 无法解开数据密钥", id)
+	}
+	return dek, nil
+}
+
+// Open 解密字段值，明文原样返回
+func (k *Keyring) Open(field, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, wrapped, data, err := parts(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(dek, data, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("字段 %s 解密失败", field)
+	}
+	return string(plain), nil
+}
+
+// Rewrap 用当前主密钥重新包装数据密钥（密文不变），已是当前主密钥时原样返回
+func (k *Keyring) Rewrap(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, wrapped, data, err := parts(value)
+	if err != nil {
+		return "", err
+	}
+	if id == k.primary {
+		return value, nil
+	}
+	dek, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	rewrapped, err := seal(k.keys[k.primary], dek, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	return SealedPrefix + k.primary + ":" + b64.EncodeToString(rewrapped) + ":" + b64.EncodeToString(data), nil
+}
+
+// 进程级主密钥，由 main 在启动时设置；为 nil 时写入明文
+var (
+	mu      sync.RWMutex
+	current *Keyring
+)
+
+// SetDefault 设置进程使用的主密钥
+func SetDefault(k *Keyring) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = k
+}
+
+// Default 当前主密钥，未配置时为 nil
+func Default() *Keyring {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SealJSON 加密 JSON 文档中所有敏感字段（未配置主密钥时原样返回）
+func SealJSON(data []byte) ([]byte, error) {
+	k := Default()
+	if k == nil || !hasSecretField(data) {
+		return data, nil
+	}
+	return Transform(data, k.Seal)
+}
+
+func hasSecretField(data []byte) bool {
+	for field := range secretFields {
+		if bytes.Contains(data, []byte(`"`+field+`"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenJSON 解密 JSON 文档中的加密字段；存在加密值但未配置主密钥时返回 ErrNoKey
+func OpenJSON(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(SealedPrefix)) {
+		return data, nil
+	}
+	k := Default()
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	return Transform(data, k.Open)
+}
+
+// Transform 按原有字段顺序改写 JSON 文档中敏感字段（字符串值）的值，其余内容不变；
+// 没有任何值变化时返回原始数据，输入带换行时输出按两个空格缩进
+func Transform(data []byte, fn func(field, value string) (string, error)) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	type frame struct {
+		object    bool
+		expectKey bool
+		n         int
+		key       string
+	}
+	var (
+		out     bytes.Buffer
+		stack   []*frame
+		changed bool
+	)
+	// 不转义 HTML 字符，非敏感内容保持原样
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	writeJSON := func(v interface{}) {
+		enc.Encode(v)
+		out.Truncate(out.Len() - 1) // Encode 末尾的换行
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			out.WriteByte(byte(d))
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.n++
+				parent.expectKey = parent.object
+			}
+			continue
+		}
+
+		if top != nil && top.object && top.expectKey {
+			if top.n > 0 {
+				out.WriteByte(',')
+			}
+			top.key = tok.(string)
+			writeJSON(top.key)
+			out.WriteByte(':')
+			top.expectKey = false
+			continue
+		}
+		if top != nil && !top.object && top.n > 0 {
+			out.WriteByte(',')
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteByte(byte(v))
+			stack = append(stack, &frame{object: v == '{', expectKey: v == '{'})
+			continue
+		case string:
+			if top != nil && top.object && secretFields[top.key] {
+				nv, err := fn(top.key, v)
+				if err != nil {
+					return nil, err
+				}
+				if nv != v {
+					changed = true
+					v = nv
+				}
+			}
+			writeJSON(v)
+		case json.Number:
+			out.WriteString(v.String())
+		case nil:
+			out.WriteString("null")
+		default:
+			writeJSON(v)
+		}
+		if top != nil {
+			top.n++
+			top.expectKey = top.object
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	if !bytes.ContainsRune(data, '\n') {
+		return out.Bytes(), nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
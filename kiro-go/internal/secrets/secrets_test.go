@@ -0,0 +1,243 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func mustKeys(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	k, err := ParseKeys(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	k := mustKeys(t, "passphrase-one")
+
+	sealed, err := k.Seal("refreshToken", "aor-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "aor-secret") {
+		t.Fatalf("加密值格式不对或泄露明文: %s", sealed)
+	}
+	if !strings.HasPrefix(sealed, SealedPrefix+k.PrimaryID()+":") {
+		t.Fatalf("加密值应记录主密钥 ID: %s", sealed)
+	}
+	plain, err := k.Open("refreshToken", sealed)
+	if err != nil || plain != "aor-secret" {
+		t.Fatalf("解密结果 %q, %v", plain, err)
+	}
+
+	again, _ := k.Seal("refreshToken", "aor-secret")
+	if again == sealed {
+		t.Fatal("每次加密应使用新的数据密钥和 nonce")
+	}
+	if v, _ := k.Seal("refreshToken", sealed); v != sealed {
+		t.Fatal("已加密的值不应重复加密")
+	}
+	if v, _ := k.Seal("refreshToken", ""); v != "" {
+		t.Fatal("空值不加密")
+	}
+	if v, err := k.Open("refreshToken", "plain"); err != nil || v != "plain" {
+		t.Fatalf("明文应原样返回: %q, %v", v, err)
+	}
+}
+
+func TestOpenFieldMismatch(t *testing.T) {
+	k := mustKeys(t, "passphrase-one")
+	sealed, err := k.Seal("accessToken", "aoa-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Open("refreshToken", sealed); err == nil {
+		t.Fatal("密文挪到其他字段后应解密失败")
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	k := mustKeys(t, "passphrase-one")
+	sealed, _ := k.Seal("accessToken", "aoa-secret")
+
+	if _, err := k.Open("accessToken", sealed[:len(sealed)-2]+"AA"); err == nil {
+		t.Fatal("篡改后的密文应解密失败")
+	}
+	if _, err := k.Open("accessToken", SealedPrefix+"abc"); err == nil {
+		t.Fatal("格式无效的加密值应报错")
+	}
+	other := mustKeys(t, "passphrase-two")
+	forged := strings.Replace(sealed, k.PrimaryID(), other.PrimaryID(), 1)
+	if _, err := other.Open("accessToken", forged); err == nil {
+		t.Fatal("改写主密钥 ID 后不应解开数据密钥")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	oldKeys := mustKeys(t, "old-passphrase")
+	sealed, err := oldKeys.Seal("clientSecret", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustKeys(t, "new-passphrase\n# 旧密钥，rotate 完成后删除\nold-passphrase")
+	if rotated.PrimaryID() == oldKeys.PrimaryID() {
+		t.Fatal("第一个密钥应为当前主密钥")
+	}
+	if v, err := rotated.Open("clientSecret", sealed); err != nil || v != "client-secret" {
+		t.Fatalf("轮换期间应能用旧密钥解密: %q, %v", v, err)
+	}
+	rewrapped, err := rotated.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rewrapped, SealedPrefix+rotated.PrimaryID()+":") {
+		t.Fatalf("应改用当前主密钥包装: %s", rewrapped)
+	}
+	if sealed[strings.LastIndex(sealed, ":"):] != rewrapped[strings.LastIndex(rewrapped, ":"):] {
+		t.Fatal("轮换只重新包装数据密钥，密文不变")
+	}
+	if again, _ := rotated.Rewrap(rewrapped); again != rewrapped {
+		t.Fatal("已是当前主密钥时应原样返回")
+	}
+
+	newOnly := mustKeys(t, "new-passphrase")
+	if v, err := newOnly.Open("clientSecret", rewrapped); err != nil || v != "client-secret" {
+		t.Fatalf("轮换后只需新密钥: %q, %v", v, err)
+	}
+}
+
+func TestMissingOldKey(t *testing.T) {
+	oldKeys := mustKeys(t, "old-passphrase")
+	sealed, _ := oldKeys.Seal("refreshToken", "aor-secret")
+
+	newOnly := mustKeys(t, "new-passphrase")
+	_, err := newOnly.Open("refreshToken", sealed)
+	if err == nil || !strings.Contains(err.Error(), oldKeys.PrimaryID()) {
+		t.Fatalf("缺少旧密钥时应报告缺少的密钥 ID: %v", err)
+	}
+	if _, err := newOnly.Rewrap(sealed); err == nil {
+		t.Fatal("缺少旧密钥时无法轮换")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	raw, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := mustKeys(t, " "+raw+" , # 注释\n")
+	if len(k.keys) != 1 || !bytes.Equal(k.keys[k.PrimaryID()], parseKey(raw)) {
+		t.Fatal("base64 密钥应直接使用")
+	}
+	if len(parseKey(raw)) != 32 || len(parseKey(strings.Repeat("ab", 32))) != 32 || len(parseKey("口令")) != 32 {
+		t.Fatal("各种格式都应得到 32 字节密钥")
+	}
+	if _, err := ParseKeys("# 只有注释\n\n"); err == nil {
+		t.Fatal("没有密钥时应报错")
+	}
+}
+
+const credentialsDoc = `{
+  "refreshToken": "aor-secret",
+  "note": "<a & b>",
+  "expiresAt": "2030-01-01T00:00:00Z",
+  "accessToken": "aoa-secret",
+  "priority": 12345678901234567890,
+  "disabled": false,
+  "region": null,
+  "accounts": [
+    {
+      "clientSecret": "cs",
+      "tags": [
+        "x",
+        "y"
+      ]
+    },
+    {
+      "clientId": "id"
+    }
+  ]
+}`
+
+var jsonKey = regexp.MustCompile(`"(\w+)":`)
+
+// keysOf 文档中字段名的出现顺序
+func keysOf(data []byte) string {
+	var keys []string
+	for _, m := range jsonKey.FindAllSubmatch(data, -1) {
+		keys = append(keys, string(m[1]))
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestTransformPreservesContent(t *testing.T) {
+	k := mustKeys(t, "passphrase-one")
+
+	sealed, err := Transform([]byte(credentialsDoc), k.Seal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"aor-secret", "aoa-secret", `"cs"`} {
+		if bytes.Contains(sealed, []byte(secret)) {
+			t.Fatalf("敏感字段 %s 未加密:\n%s", secret, sealed)
+		}
+	}
+	for _, kept := range []string{`"<a & b>"`, "12345678901234567890", `"clientId": "id"`, `"region": null`} {
+		if !bytes.Contains(sealed, []byte(kept)) {
+			t.Fatalf("非敏感内容 %s 被改写:\n%s", kept, sealed)
+		}
+	}
+	if got, want := keysOf(sealed), keysOf([]byte(credentialsDoc)); got != want {
+		t.Fatalf("字段顺序改变: %s，原为 %s", got, want)
+	}
+
+	opened, err := Transform(sealed, k.Open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != credentialsDoc {
+		t.Fatalf("加密再解密后应与原文一致:\n%s", opened)
+	}
+
+	if same, _ := Transform([]byte(credentialsDoc), k.Open); string(same) != credentialsDoc {
+		t.Fatal("没有变化时应返回原始数据")
+	}
+	compact := []byte(`{"accessToken":"a","b":[1,2]}`)
+	out, err := Transform(compact, k.Seal)
+	if err != nil || bytes.ContainsRune(out, '\n') || !bytes.HasSuffix(out, []byte(`,"b":[1,2]}`)) {
+		t.Fatalf("单行输入应保持单行: %s, %v", out, err)
+	}
+}
+
+func TestSealOpenJSON(t *testing.T) {
+	t.Cleanup(func() { SetDefault(nil) })
+
+	SetDefault(nil)
+	if out, err := SealJSON([]byte(credentialsDoc)); err != nil || string(out) != credentialsDoc {
+		t.Fatal("未配置主密钥时应写入明文")
+	}
+
+	SetDefault(mustKeys(t, "passphrase-one"))
+	sealed, err := SealJSON([]byte(credentialsDoc))
+	if err != nil || !bytes.Contains(sealed, []byte(SealedPrefix)) {
+		t.Fatalf("应加密敏感字段: %v", err)
+	}
+	opened, err := OpenJSON(sealed)
+	if err != nil || string(opened) != credentialsDoc {
+		t.Fatalf("解密结果:\n%s\n%v", opened, err)
+	}
+
+	SetDefault(nil)
+	if _, err := OpenJSON(sealed); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("存在加密值但未配置主密钥时应返回 ErrNoKey: %v", err)
+	}
+	if out, err := OpenJSON([]byte(credentialsDoc)); err != nil || string(out) != credentialsDoc {
+		t.Fatal("明文文档应原样返回")
+	}
+}
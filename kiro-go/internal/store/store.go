@@ -0,0 +1,366 @@
+// Package store 嵌入式事务存储（bbolt），统一保存激活码、用户凭证、主凭证池和用量，
+// 取代各管理器各自整文件重写 JSON 的方式
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"kiro-go/internal/logger"
+	"kiro-go/internal/secrets"
+)
+
+// 数据桶
+const (
+	Codes           = "codes"            // key: 激活码，value: CodeEntry
+	UserCredentials = "user_credentials" // key: 激活码，value: UserCredentialEntry
+	PoolCredentials = "pool_credentials" // key: 序号，value: KiroCredentials
+	Usage           = "usage"            // key: 自增序号，value: UsageEntry
+
+	metaBucket = "meta"
+)
+
+// schemaVersion 存储结构版本，结构变化时递增并在 Open 中迁移
+const schemaVersion = 1
+
+// dataBuckets 导出顺序；导出格式与对应的旧 JSON 文件一致，便于直接恢复
+var dataBuckets = []string{Codes, UserCredentials, PoolCredentials, Usage}
+
+// exportAsObject 以对象（key → value）导出的桶，其余按 key 顺序导出为数组
+var exportAsObject = map[string]bool{UserCredentials: true}
+
+// ErrStop ForEach 回调返回它可提前结束遍历，调用方自行忽略
+var ErrStop = errors.New("store: stop iteration")
+
+// Store 嵌入式存储，可被多个管理器共享；单文件，同一时间只能被一个进程打开
+type Store struct {
+	db   *bolt.DB
+	path string
+}
+
+// Open 打开（不存在则创建）存储文件
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开存储失败（是否有其他进程正在使用 %s）: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append([]string{metaBucket}, dataBuckets...) {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket([]byte(metaBucket))
+		var version int
+		if raw := meta.Get([]byte("schema_version")); raw != nil {
+			json.Unmarshal(raw, &version)
+		}
+		if version > schemaVersion {
+			return fmt.Errorf("存储版本 %d 高于当前程序支持的 %d，请升级 kiro-go", version, schemaVersion)
+		}
+		return meta.Put([]byte("schema_version"), []byte(fmt.Sprint(schemaVersion)))
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, path: path}, nil
+}
+
+// Path 存储文件路径
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Close 关闭存储
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Update 在读写事务中执行 fn，fn 返回错误时整体回滚；同一时间只有一个读写事务
+func (s *Store) Update(fn func(tx *Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	})
+}
+
+// View 在只读事务中执行 fn，看到的是一致的快照
+func (s *Store) View(fn func(tx *Tx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	})
+}
+
+// Tx 事务，值统一以 JSON 保存；配置了主密钥时凭证的敏感字段加密保存（读取时自动解密）
+type Tx struct {
+	tx *bolt.Tx
+}
+
+func (t *Tx) bucket(name string) (*bolt.Bucket, error) {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, fmt.Errorf("未知的数据桶: %s", name)
+	}
+	return b, nil
+}
+
+// Get 读取 key 到 v，不存在时返回 false
+func (t *Tx) Get(bucket, key string, v interface{}) (bool, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return false, err
+	}
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	raw, err = secrets.OpenJSON(raw)
+	if err != nil {
+		return true, err
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Put 写入 key
+func (t *Tx) Put(bucket, key string, v interface{}) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	data, err := marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// marshal 序列化并加密敏感字段
+func marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.SealJSON(data)
+}
+
+// Delete 删除 key，不存在时不报错
+func (t *Tx) Delete(bucket, key string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+// Append 以自增序号为 key 追加一条记录（用于用量等只追加的数据）
+func (t *Tx) Append(bucket string, v interface{}) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(SeqKey(seq)), data)
+}
+
+// ForEach 按 key 顺序遍历（value 已解密），value 只在回调内有效
+func (t *Tx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.ForEach(func(k, v []byte) error {
+		v, err := secrets.OpenJSON(v)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", bucket, k, err)
+		}
+		return fn(string(k), v)
+	})
+}
+
+// Count 桶内记录数
+func (t *Tx) Count(bucket string) int {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return 0
+	}
+	return b.Stats().KeyN
+}
+
+// Sync 让桶内容与 items 一致：只写入有变化的 key，删除 items 中不存在的 key
+func (t *Tx) Sync(bucket string, items map[string]interface{}) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	var stale [][]byte
+	b.ForEach(func(k, _ []byte) error {
+		if _, ok := items[string(k)]; !ok {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	for _, k := range stale {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	for key, v := range items {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		// 加密值每次不同，按解密后的内容判断是否变化
+		if old := b.Get([]byte(key)); old != nil {
+			if plain, err := secrets.OpenJSON(old); err == nil && bytes.Equal(plain, data) {
+				continue
+			}
+		}
+		if data, err = secrets.SealJSON(data); err != nil {
+			return err
+		}
+		if err := b.Put([]byte(key), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite 在一个事务中用 fn 改写所有数据桶的原始值（用于加密 / 解密 / 轮换主密钥），返回改写条数
+func (s *Store) Rewrite(fn func(raw []byte) ([]byte, error)) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range dataBuckets {
+			b := tx.Bucket([]byte(name))
+			updates := make(map[string][]byte)
+			err := b.ForEach(func(k, v []byte) error {
+				nv, err := fn(v)
+				if err != nil {
+					return fmt.Errorf("%s/%s: %w", name, k, err)
+				}
+				if !bytes.Equal(nv, v) {
+					updates[string(k)] = append([]byte(nil), nv...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, v := range updates {
+				if err := b.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+			count += len(updates)
+		}
+		return nil
+	})
+	return count, err
+}
+
+// SeqKey 序号 key，定长十六进制保证按插入顺序遍历
+func SeqKey(seq uint64) string {
+	return fmt.Sprintf("%016x", seq)
+}
+
+// migrationRecord 迁移记录，保存在 meta 桶
+type migrationRecord struct {
+	Source string `json:"source"`
+	Count  int    `json:"count"`
+	At     string `json:"at"`
+}
+
+// Migrate 首次使用某个桶时从旧 JSON 文件导入：fn 在同一事务中写入数据并返回条数。
+// 已迁移过或桶内已有数据时跳过，导入失败整体回滚、下次启动重试；旧文件保持不变
+func (s *Store) Migrate(bucket, source string, fn func(tx *Tx) (int, error)) error {
+	key := "migrated:" + bucket
+	return s.Update(func(tx *Tx) error {
+		var done migrationRecord
+		if ok, _ := tx.Get(metaBucket, key, &done); ok {
+			return nil
+		}
+		record := migrationRecord{Source: source, At: time.Now().UTC().Format(time.RFC3339)}
+		if tx.Count(bucket) == 0 {
+			n, err := fn(tx)
+			if err != nil {
+				return fmt.Errorf("从 %s 导入 %s 失败: %w", source, bucket, err)
+			}
+			record.Count = n
+			if n > 0 {
+				logger.InfoFields(logger.CatSystem, "已从 JSON 文件导入存储", logger.F{
+					"bucket": bucket,
+					"source": source,
+					"count":  n,
+				})
+			}
+		}
+		return tx.Put(metaBucket, key, record)
+	})
+}
+
+// ExportJSON 以一致的快照导出数据（JSON），各部分格式与对应的旧 JSON 文件相同；
+// 加密字段保持加密，备份泄露不会泄露凭证。边遍历边写出，不在内存中缓存整个导出；
+// usage 桶可能很大，仅在 includeUsage 时导出
+func (s *Store) ExportJSON(w io.Writer, includeUsage bool) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "{\n  \"schemaVersion\": %d,\n  \"exportedAt\": %q", schemaVersion, time.Now().UTC().Format(time.RFC3339))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range dataBuckets {
+			if name == Usage && !includeUsage {
+				continue
+			}
+			asObject := exportAsObject[name]
+			open, close := "[", "]"
+			if asObject {
+				open, close = "{", "}"
+			}
+			fmt.Fprintf(bw, ",\n  %q: %s", name, open)
+			n := 0
+			err := tx.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+				if n > 0 {
+					bw.WriteString(",")
+				}
+				n++
+				bw.WriteString("\n    ")
+				if asObject {
+					key, _ := json.Marshal(string(k))
+					bw.Write(key)
+					bw.WriteString(": ")
+				}
+				_, err := bw.Write(v)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				bw.WriteString("\n  ")
+			}
+			bw.WriteString(close)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteString("\n}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,82 @@
+// Package watch 监听配置 / 凭证文件变化：Linux 使用 inotify，其他系统轮询修改时间
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kiro-go/internal/logger"
+)
+
+// debounce 同一文件的连续事件合并（编辑器保存常产生多个事件）
+const debounce = 500 * time.Millisecond
+
+// pollInterval 不支持 inotify 时的轮询间隔
+const pollInterval = 2 * time.Second
+
+// errUnsupported 当前系统没有文件事件通知，直接轮询
+var errUnsupported = errors.New("unsupported")
+
+// Files 监听 paths，文件被修改或替换（含“写临时文件再改名”）后以原路径回调 onChange，阻塞运行
+func Files(paths []string, onChange func(path string)) {
+	byAbs := make(map[string]string, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			abs = p
+		}
+		byAbs[abs] = p
+	}
+	notify := debounced(func(abs string) {
+		onChange(byAbs[abs])
+	})
+	err := watchNotify(byAbs, notify)
+	if err != nil && err != errUnsupported {
+		logger.Warnf(logger.CatSystem, "文件监听不可用，改为轮询: %v", err)
+	}
+	poll(byAbs, notify)
+}
+
+// debounced 每个路径在最后一个事件后 debounce 才回调一次
+func debounced(fn func(path string)) func(path string) {
+	var mu sync.Mutex
+	timers := make(map[string]*time.Timer)
+	return func(path string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if t, ok := timers[path]; ok {
+			t.Stop()
+		}
+		timers[path] = time.AfterFunc(debounce, func() { fn(path) })
+	}
+}
+
+// poll 轮询修改时间与大小
+func poll(paths map[string]string, onChange func(path string)) {
+	type stamp struct {
+		mod  time.Time
+		size int64
+	}
+	last := make(map[string]stamp, len(paths))
+	for abs := range paths {
+		if st, err := os.Stat(abs); err == nil {
+			last[abs] = stamp{st.ModTime(), st.Size()}
+		}
+	}
+	for range time.Tick(pollInterval) {
+		for abs := range paths {
+			st, err := os.Stat(abs)
+			if err != nil {
+				continue
+			}
+			cur := stamp{st.ModTime(), st.Size()}
+			if cur != last[abs] {
+				last[abs] = cur
+				onChange(abs)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+package watch
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchNotify 用 inotify 监听文件所在目录：kiro-launcher 和多数编辑器以改名方式替换文件，
+// 直接监听文件会在第一次替换后失效
+func watchNotify(paths map[string]string, onChange func(path string)) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	dirs := make(map[int]string)
+	for abs := range paths {
+		dir := filepath.Dir(abs)
+		wd, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO)
+		if err != nil {
+			return fmt.Errorf("监听 %s 失败: %w", dir, err)
+		}
+		dirs[wd] = dir
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + unix.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			if off > n {
+				break
+			}
+			dir, ok := dirs[int(ev.Wd)]
+			if !ok {
+				continue
+			}
+			full := filepath.Join(dir, strings.TrimRight(string(buf[start:off]), "\x00"))
+			if _, ok := paths[full]; ok {
+				onChange(full)
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package watch
+
+func watchNotify(paths map[string]string, onChange func(path string)) error {
+	return errUnsupported
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,8 +17,11 @@ import (
 	"kiro-go/internal/common"
 	"kiro-go/internal/kiro"
 	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
 	"kiro-go/internal/model"
 	"kiro-go/internal/openai"
+	"kiro-go/internal/secrets"
+	"kiro-go/internal/store"
 )
 
 func main() {
@@ -29,12 +34,49 @@ func main() {
 
 	configPath := flag.String("config", filepath.Join(defaultDir, "config.json"), "配置文件路径")
 	credsPath := flag.String("credentials", filepath.Join(defaultDir, "credentials.json"), "凭证文件路径")
+	secretsAction := flag.String("secrets", "", "敏感字段迁移后退出: encrypt | decrypt | rotate（处理凭证、卡密、用户凭证文件和存储，额外参数为其他文件，如 accounts.json）")
+	genMasterKey := flag.Bool("gen-master-key", false, "生成随机主密钥并退出")
 	flag.Parse()
 
+	if *genMasterKey {
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			logger.Fatalf(logger.CatSystem, "生成主密钥失败: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+
 	// 加载配置，传入配置文件目录作为数据文件基准路径
 	cfg := loadConfig(*configPath)
 	configDir := filepath.Dir(*configPath)
 	cfg.DefaultsWithDir(configDir)
+	common.SetDefaultLocale(cfg.Locale)
+	// 运行期读取配置一律通过 liveCfg（热加载时整体替换）；cfg 只用于启动阶段
+	liveCfg := model.NewConfigRef(cfg)
+
+	// 静态加密主密钥：必须在加载任何凭证文件之前设置
+	keyring, err := secrets.Load(cfg.Secrets)
+	if err != nil {
+		logger.Fatalf(logger.CatSystem, "加载主密钥失败: %v", err)
+	}
+	secrets.SetDefault(keyring)
+	if *secretsAction != "" {
+		files := append([]string{*credsPath, cfg.CodesPath, cfg.UserCredentialsPath}, flag.Args()...)
+		if err := runSecretsCommand(*secretsAction, cfg.StorePath, files); err != nil {
+			logger.Fatalf(logger.CatSystem, "%v", err)
+		}
+		return
+	}
+	if keyring != nil {
+		logger.Infof(logger.CatSystem, "凭证静态加密已启用，当前主密钥: %s", keyring.PrimaryID())
+	}
+	if err := cfg.CompileRoutingRules(); err != nil {
+		logger.Fatalf(logger.CatSystem, "路由规则配置无效: %v", err)
+	}
+	if err := anthropic.SetModelRouting(cfg.ModelRouting); err != nil {
+		logger.Fatalf(logger.CatSystem, "模型路由配置无效: %v", err)
+	}
 
 	// 启用文件日志：每个 category 写入独立文件 logs/auth-YYYY-MM-DD.log
 	logDir := filepath.Join(configDir, "logs")
@@ -45,27 +87,95 @@ func main() {
 		"config_dir":      configDir,
 		"user_creds_path": cfg.UserCredentialsPath,
 		"codes_path":      cfg.CodesPath,
+		"usage_ledger":    cfg.UsageLedgerPath,
+		"sharing_audit":   cfg.SharingAuditPath,
+		"api_keys_path":   cfg.APIKeysPath,
+		"act_policy":      cfg.ActivationPolicy,
+		"store_path":      cfg.StorePath,
 	})
 
+	// 嵌入式存储（可选）：激活码、用户凭证、主凭证池和用量统一存入一个事务文件
+	var st *store.Store
+	if cfg.StorePath != "" {
+		var err error
+		if st, err = store.Open(cfg.StorePath); err != nil {
+			logger.Fatalf(logger.CatSystem, "%v", err)
+		}
+		defer st.Close()
+		logger.Infof(logger.CatSystem, "嵌入式存储已启用: %s", cfg.StorePath)
+	}
+
 	// 加载凭证
 	credsList := loadCredentials(*credsPath)
+	if st != nil {
+		var err error
+		if credsList, err = kiro.UsePoolStore(st, *credsPath, credsList); err != nil {
+			logger.Fatalf(logger.CatSystem, "主凭证池切换到存储失败: %v", err)
+		}
+	}
 	logger.Infof(logger.CatSystem, "已加载 %d 个凭据配置", len(credsList))
 
 	// 创建核心组件
-	tokenMgr := kiro.NewTokenManager(cfg, credsList)
+	tokenMgr := kiro.NewTokenManager(liveCfg, credsList)
 	userCredsMgr := kiro.NewUserCredentialsManager(cfg.UserCredentialsPath)
-	userCredsMgr.SetConfig(cfg)
-	userCredsMgr.StartAutoRefresh(cfg, 5*60*1000000000) // 5分钟检查一次
+	userCredsMgr.SetConfig(liveCfg)
+	userCredsMgr.StartAutoRefresh(liveCfg, 5*60*1000000000) // 5分钟检查一次
 	codesMgr := kiro.NewCodesManager(cfg.CodesPath)
-	provider := kiro.NewProvider(cfg, tokenMgr)
+	codesMgr.SetLicenseSigner(common.NewLicenseSigner(cfg.LicenseSigningSecret, cfg.LicenseTTL))
+	codesMgr.SetDefaultDevicePolicy(cfg.DevicePolicy)
+	// token 刷新后在同一次写入中同步到 codes.json / 存储，两边不会不一致
+	userCredsMgr.OnCredentialsRefreshed(codesMgr.SyncCredentials)
+	// 管理接口修改主凭证池后写回：启用存储时写存储，否则写 credentials.json
+	tokenMgr.SetPersist(func(list []*model.KiroCredentials) error {
+		if st != nil {
+			return kiro.SavePoolCredentials(st, list)
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		if data, err = secrets.SealJSON(data); err != nil {
+			return err
+		}
+		return writeFileAtomic(*credsPath, data)
+	})
+	provider := kiro.NewProvider(liveCfg, tokenMgr)
 	provider.UserCredsMgr = userCredsMgr
+	provider.Codes = codesMgr
+	provider.Audit = kiro.NewSharingAudit(cfg.SharingAuditPath)
+	provider.Catalog.StartBackgroundRefresh()
+	provider.Quota.StartPolling()
+
+	usageLedger := kiro.NewUsageLedger(cfg.UsageLedgerPath)
+	if st != nil {
+		if err := codesMgr.UseStore(st); err != nil {
+			logger.Fatalf(logger.CatSystem, "卡密切换到存储失败: %v", err)
+		}
+		if err := userCredsMgr.UseStore(st); err != nil {
+			logger.Fatalf(logger.CatSystem, "用户凭证切换到存储失败: %v", err)
+		}
+		if err := usageLedger.UseStore(st); err != nil {
+			logger.Fatalf(logger.CatSystem, "用量切换到存储失败: %v", err)
+		}
+	}
+	apiKeysMgr := kiro.NewAPIKeysManager(cfg.APIKeysPath)
+	go apiKeysMgr.Watch(5 * time.Second)
+	tokenSigner, err := common.NewClientTokenSigner(cfg.ClientTokens)
+	if err != nil {
+		logger.Fatalf(logger.CatSystem, "客户端令牌配置无效: %v", err)
+	}
+	tokenDenyList := kiro.NewTokenDenyList(cfg.TokenDenyListPath)
+	if tokenSigner != nil {
+		logger.Infof(logger.CatSystem, "客户端令牌已启用 (%s)", tokenSigner.Algorithm())
+	}
 
 	logger.Infof(logger.CatSystem, "多用户模式已启用，当前用户数: %d", userCredsMgr.Count())
 	logger.Infof(logger.CatSystem, "卡密管理已启用，当前卡密数: %d", len(codesMgr.GetAll()))
+	logger.Infof(logger.CatSystem, "具名 API Key: %d 个", apiKeysMgr.Count())
 
 	// 认证中间件（使用 CodesManager + 自动刷新）
 	authMw := &common.AuthMiddleware{
-		Config: cfg,
+		Config: liveCfg,
 		GetUserCredsAutoRefresh: func(code string) (*model.KiroCredentials, error) {
 			// 去掉 act- 前缀（如果有）
 			normalizedCode := strings.TrimPrefix(strings.ToUpper(code), "ACT-")
@@ -73,16 +183,8 @@ func main() {
 			// 先从 user_credentials.json 获取并自动刷新
 			creds, err := userCredsMgr.GetCredentialsAutoRefresh(normalizedCode)
 			if creds != nil && err == nil {
-				// 刷新成功，同步到 codes.json
-				entry := codesMgr.FindByCode(normalizedCode)
-				if entry != nil && entry.Credentials != nil {
-					// 只有当 codes.json 中已有凭证时才更新（避免覆盖空数据）
-					if entry.Credentials.AccessToken != creds.AccessToken ||
-						entry.Credentials.RefreshToken != creds.RefreshToken {
-						// token 已更新，同步到 codes.json
-						codesMgr.SetCredentials(normalizedCode, entry.UserName, creds)
-					}
-				}
+				// 刷新时已同步；两边来源不一致时（如手工改过文件）以 user_credentials 为准
+				codesMgr.SyncCredentials(normalizedCode, creds, nil)
 			}
 
 			// 如果 user_credentials.json 中没有，尝试从 codes.json 获取
@@ -106,53 +208,89 @@ func main() {
 			}
 			return expiresDate, expired
 		},
+		GetCodeRateLimit: codesMgr.GetRateLimit,
+		CheckDevice:      codesMgr.CheckDevice,
+		Limiter:          common.NewRateLimiter(),
+		CheckQuota: func(code string) *common.RateLimitError {
+			return usageLedger.CheckQuota(code, liveCfg.Load().UsageQuota.Merge(codesMgr.GetQuota(code)))
+		},
+		RecordUsage: func(entry common.UsageEntry) {
+			// 上游未上报 credit 时按模型 rateMultiplier 估算（每次请求）
+			if entry.Backend == "kiro" && entry.Credits == 0 {
+				if info := provider.Catalog.Lookup(nil, entry.Model); info != nil {
+					entry.Credits = info.RateMultiplier
+				}
+			}
+			usageLedger.Append(entry)
+		},
+		LookupAPIKey:   apiKeysMgr.Lookup,
+		ClientTokens:   tokenSigner,
+		IsTokenRevoked: tokenDenyList.IsRevoked,
+	}
+
+	// 本进程即激活码服务时直接查 CodesManager，不走回环 HTTP
+	if kiro.IsLocalActivationServer(cfg) {
+		authMw.Validator = &kiro.LocalActivationValidator{Codes: codesMgr}
+		logger.Infof(logger.CatSystem, "激活码验证: 本进程 CodesManager (%s)", cfg.ActivationServerURL)
 	}
 
 	// 直连 Anthropic provider（有 apiKey 就初始化，不再要求 backend==anthropic）
 	var directProvider *anthropic.DirectProvider
 	if cfg.AnthropicAPIKey != "" || len(cfg.AnthropicAPIKeys) > 0 {
-		directProvider = anthropic.NewDirectProvider(cfg)
+		directProvider = anthropic.NewDirectProvider(liveCfg)
 		logger.Infof(logger.CatSystem, "Anthropic 直连已启用 (%s)", cfg.AnthropicBaseURL)
 	}
 
 	// 路由
 	mux := http.NewServeMux()
+	server := &liveServer{handler: corsMiddleware(mux)}
+	reload := &reloader{
+		cfg:        liveCfg,
+		configPath: *configPath,
+		credsPath:  *credsPath,
+		store:      st,
+		provider:   provider,
+		codesMgr:   codesMgr,
+		direct:     directProvider,
+		server:     server,
+	}
+	reload.credsDigest = fileDigest(*credsPath)
 
 	// ==================== 统一 API 端点（只走 Kiro）====================
 
 	// GET /v1/models - Kiro 模型列表
-	mux.HandleFunc("/v1/models", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/v1/models", metrics.Instrument("/v1/models", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		anthropic.HandleGetModels(w, r, provider)
-	}))
+	})))
 
 	// POST /v1/messages - Kiro Anthropic 格式
-	mux.HandleFunc("/v1/messages", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/v1/messages", metrics.Instrument("/v1/messages", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		anthropic.HandlePostMessages(w, r, provider)
-	}))
+	})))
 
 	// POST /v1/chat/completions - Kiro OpenAI 格式
-	mux.HandleFunc("/v1/chat/completions", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/v1/chat/completions", metrics.Instrument("/v1/chat/completions", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		openai.HandleChatCompletions(w, r, provider)
-	}))
+	})))
 
 	// ==================== /anthropic/v1/ - Anthropic 直连后端 ====================
-	mux.HandleFunc("/anthropic/v1/models", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/anthropic/v1/models", metrics.Instrument("/anthropic/v1/models", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		anthropic.HandleGetModels(w, r, provider)
-	}))
-	mux.HandleFunc("/anthropic/v1/messages", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.HandleFunc("/anthropic/v1/messages", metrics.Instrument("/anthropic/v1/messages", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		if directProvider != nil {
 			anthropic.HandlePostMessagesDirect(w, r, directProvider)
 		} else {
-			common.WriteError(w, http.StatusServiceUnavailable, "api_error", "Anthropic 直连未配置 (需要 anthropicApiKey)")
+			common.WriteErrorCode(w, r, http.StatusServiceUnavailable, "api_error", common.MsgDirectNotConfigured)
 		}
-	}))
-	mux.HandleFunc("/anthropic/v1/chat/completions", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.HandleFunc("/anthropic/v1/chat/completions", metrics.Instrument("/anthropic/v1/chat/completions", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		if directProvider != nil {
 			openai.HandleChatCompletionsDirect(w, r, directProvider)
 		} else {
-			common.WriteError(w, http.StatusServiceUnavailable, "api_error", "Anthropic 直连未配置 (需要 anthropicApiKey)")
+			common.WriteErrorCode(w, r, http.StatusServiceUnavailable, "api_error", common.MsgDirectNotConfigured)
 		}
-	}))
+	})))
 
 	// 用户凭证管理 API
 	mux.HandleFunc("/api/admin/user-credentials", func(w http.ResponseWriter, r *http.Request) {
@@ -276,15 +414,72 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		// 重新加载凭据文件
-		newCreds := loadCredentials(*credsPath)
-		provider.ReloadCredentials(newCreds)
-		logger.Infof(logger.CatCreds, "凭据已热加载，共 %d 个", len(newCreds))
+		// 重新加载凭据文件；启用存储时以文件内容替换存储中的主凭证池，文件无效时保留当前凭证池
+		n, err := reload.reloadCredentials(true)
+		if err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
 		common.WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"success": true, "message": fmt.Sprintf("凭据已重新加载，共 %d 个", len(newCreds)),
+			"success": true, "message": fmt.Sprintf("凭据已重新加载，共 %d 个", n),
 		})
 	})
 
+	// 主凭证池运行时管理（修改后写回 credentials.json / 存储）
+	mux.HandleFunc("/api/admin/pool", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPool(w, r, tokenMgr)
+	})))
+	mux.HandleFunc("/api/admin/pool/remove", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPoolRemove(w, r, tokenMgr)
+	})))
+	mux.HandleFunc("/api/admin/pool/enable", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPoolEnable(w, r, tokenMgr)
+	})))
+	mux.HandleFunc("/api/admin/pool/disable", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPoolDisable(w, r, tokenMgr)
+	})))
+	mux.HandleFunc("/api/admin/pool/priority", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPoolPriority(w, r, tokenMgr)
+	})))
+	mux.HandleFunc("/api/admin/pool/refresh", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPoolRefresh(w, r, tokenMgr)
+	})))
+	mux.HandleFunc("/api/admin/pool/test", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminPoolTest(w, r, provider)
+	})))
+	mux.HandleFunc("/api/admin/regions", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminRegions(w, r, provider)
+	})))
+
+	// 配置热加载（重新读取 config.json，与文件变化 / SIGHUP 触发的相同）
+	mux.HandleFunc("/api/admin/reload-config", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reload.reloadConfig(); err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		common.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": "配置已重新加载"})
+	})))
+
+	// 模型路由表热加载（重新读取 config.json 的 modelRouting）
+	mux.HandleFunc("/api/admin/reload-models", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n, err := reload.reloadModelRouting()
+		if err != nil {
+			common.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		common.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true, "message": fmt.Sprintf("模型路由已重新加载，共 %d 个别名", n),
+		})
+	})))
+
 	// ==================== 卡密管理 API ====================
 	// 激活码激活
 	mux.HandleFunc("/api/activate", func(w http.ResponseWriter, r *http.Request) {
@@ -294,6 +489,10 @@ func main() {
 	mux.HandleFunc("/api/code/validate", func(w http.ResponseWriter, r *http.Request) {
 		kiro.HandleCodeValidate(w, r, codesMgr)
 	})
+	// 离线许可证公钥（licenseSigningSecret 派生）
+	mux.HandleFunc("/api/license/public-key", func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleLicensePublicKey(w, r, codesMgr)
+	})
 	// 穿透权限检查（需要 machineId + tunnelDays）
 	mux.HandleFunc("/api/tunnel/check", func(w http.ResponseWriter, r *http.Request) {
 		kiro.HandleTunnelCheck(w, r, codesMgr)
@@ -309,20 +508,91 @@ func main() {
 		kiro.HandleAdminCodesRouter(w, r, codesMgr)
 	})
 	mux.HandleFunc("/api/admin/codes/delete", func(w http.ResponseWriter, r *http.Request) {
-		kiro.HandleAdminDeleteCodes(w, r, codesMgr)
+		kiro.HandleAdminDeleteCodes(w, r, codesMgr, tokenDenyList)
 	})
 	mux.HandleFunc("/api/admin/codes/update", func(w http.ResponseWriter, r *http.Request) {
 		kiro.HandleAdminUpdateCodes(w, r, codesMgr)
 	})
+	mux.HandleFunc("/api/admin/codes/rate-limit", func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminRateLimitCodes(w, r, codesMgr)
+	})
+	mux.HandleFunc("/api/admin/codes/quota", func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminQuotaCodes(w, r, codesMgr)
+	})
+	mux.HandleFunc("/api/admin/codes/devices", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminCodeDevices(w, r, codesMgr)
+	})))
+	mux.HandleFunc("/api/admin/codes/devices/unbind", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminUnbindDevices(w, r, codesMgr)
+	})))
+	mux.HandleFunc("/api/admin/codes/device-policy", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminDevicePolicyCodes(w, r, codesMgr)
+	})))
+	mux.HandleFunc("/api/admin/codes/sharing-group", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminSharingGroupCodes(w, r, codesMgr)
+	})))
+	mux.HandleFunc("/api/admin/sharing", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminSharing(w, r, codesMgr, liveCfg.Load())
+	})))
+	mux.HandleFunc("/api/admin/sharing/audit", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminSharingAudit(w, r, provider.Audit)
+	})))
 	mux.HandleFunc("/api/admin/codes/reset", func(w http.ResponseWriter, r *http.Request) {
-		kiro.HandleAdminResetCodes(w, r, codesMgr)
+		kiro.HandleAdminResetCodes(w, r, codesMgr, tokenDenyList)
 	})
 	mux.HandleFunc("/api/admin/codes/export", func(w http.ResponseWriter, r *http.Request) {
 		kiro.HandleAdminExportCodes(w, r, codesMgr)
 	})
 
-	// CORS
-	handler := corsMiddleware(mux)
+	// 用量查询
+	// 导出包含全部凭证，需携带 adminApiKey
+	mux.HandleFunc("/api/admin/store/export", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminStoreExport(w, r, st)
+	})))
+	mux.HandleFunc("/api/admin/usage", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminUsage(w, r, usageLedger)
+	})))
+	mux.HandleFunc("/api/admin/usage/records", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminUsageRecords(w, r, usageLedger)
+	})))
+	mux.HandleFunc("/api/admin/usage/code", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminCodeUsage(w, r, usageLedger, codesMgr, liveCfg.Load())
+	})))
+
+	// 客户端令牌：用激活码换取短期签名令牌
+	mux.HandleFunc("/api/token", authMw.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleIssueClientToken(w, r, tokenSigner, liveCfg.Load())
+	}))
+	mux.HandleFunc("/api/token/public-key", func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleClientTokenPublicKey(w, r, tokenSigner)
+	})
+	mux.HandleFunc("/api/admin/tokens/revoked", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminRevokedTokens(w, r, tokenDenyList)
+	})))
+	mux.HandleFunc("/api/admin/tokens/revoke", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminRevokeToken(w, r, tokenDenyList, tokenSigner)
+	})))
+
+	// 具名 API Key 管理：可以签发访问凭证，需携带 adminApiKey
+	mux.HandleFunc("/api/admin/api-keys", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminAPIKeys(w, r, apiKeysMgr)
+	})))
+	mux.HandleFunc("/api/admin/api-keys/revoke", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminRevokeAPIKey(w, r, apiKeysMgr)
+	})))
+	mux.HandleFunc("/api/admin/api-keys/delete", requireAdminKey(liveCfg, common.MsgAdminKeyRequired, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kiro.HandleAdminDeleteAPIKey(w, r, apiKeysMgr)
+	})))
+
+	// Prometheus 指标：主端口需 adminApiKey，metricsListen 配置的独立端口不做认证
+	registerPoolMetrics(tokenMgr, provider, directProvider)
+	metrics.SetModelLabeler(func(name string) string {
+		return anthropic.ModelLabel(provider.Catalog, name)
+	})
+	mux.HandleFunc("/metrics", metricsAuth(liveCfg, metrics.Handler()))
+	if cfg.MetricsListen != "" {
+		serveMetrics(cfg.MetricsListen)
+	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 	logger.InfoFields(logger.CatSystem, "启动服务器", logger.F{
@@ -332,9 +602,14 @@ func main() {
 	})
 	logger.Infof(logger.CatSystem, "路由: /v1/ (Kiro) | /anthropic/v1/ (直连) | /admin")
 
-	if err := http.ListenAndServe(addr, handler); err != nil {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
 		logger.Fatalf(logger.CatSystem, "服务器启动失败: %v", err)
 	}
+	server.serve(ln, addr)
+
+	// config.json / credentials.json 变化或收到 SIGHUP 时热加载
+	reload.watch()
 }
 
 func loadConfig(path string) *model.Config {
@@ -350,23 +625,103 @@ func loadConfig(path string) *model.Config {
 }
 
 func loadCredentials(path string) []*model.KiroCredentials {
-	data, err := os.ReadFile(path)
+	list, err := readCredentials(path)
 	if err != nil {
-		logger.Warnf(logger.CatCreds, "加载凭证失败: %v，使用空凭证列表", err)
+		logger.Warnf(logger.CatCreds, "%v，使用空凭证列表", err)
 		return nil
 	}
+	return list
+}
+
+// readCredentials 读取凭证文件（数组或单个对象，加密字段自动解密）
+func readCredentials(path string) ([]*model.KiroCredentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("加载凭证失败: %w", err)
+	}
+	if data, err = secrets.OpenJSON(data); err != nil {
+		return nil, fmt.Errorf("解密凭证失败: %w", err)
+	}
 	var list []*model.KiroCredentials
 	if json.Unmarshal(data, &list) == nil {
-		return list
+		return list, nil
 	}
 	var single model.KiroCredentials
 	if json.Unmarshal(data, &single) == nil {
-		return []*model.KiroCredentials{&single}
+		return []*model.KiroCredentials{&single}, nil
+	}
+	return nil, fmt.Errorf("凭证文件格式无效: %s", path)
+}
+
+// runSecretsCommand 敏感字段迁移：encrypt 加密明文字段，rotate 用当前主密钥重新包装数据密钥，
+// decrypt 还原为明文；文件原地改写（先写临时文件再替换），不存在的文件跳过
+func runSecretsCommand(action, storePath string, files []string) error {
+	k := secrets.Default()
+	if k == nil {
+		return fmt.Errorf("未配置主密钥（config.secrets 或环境变量 %s）", secrets.DefaultKeyEnv)
+	}
+	var fn func(field, value string) (string, error)
+	switch action {
+	case "encrypt":
+		fn = k.Seal
+	case "rotate":
+		fn = func(_, value string) (string, error) { return k.Rewrap(value) }
+	case "decrypt":
+		fn = k.Open
+	default:
+		return fmt.Errorf("未知操作 %s（encrypt | decrypt | rotate）", action)
+	}
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		out, err := secrets.Transform(data, fn)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if bytes.Equal(out, data) {
+			logger.Infof(logger.CatSystem, "%s: 无需改动", path)
+			continue
+		}
+		if err := writeFileAtomic(path, out); err != nil {
+			return err
+		}
+		logger.Infof(logger.CatSystem, "%s: %s 完成", path, action)
+	}
+
+	if _, err := os.Stat(storePath); storePath != "" && err == nil {
+		st, err := store.Open(storePath)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		n, err := st.Rewrite(func(raw []byte) ([]byte, error) { return secrets.Transform(raw, fn) })
+		if err != nil {
+			return fmt.Errorf("%s: %w", storePath, err)
+		}
+		logger.Infof(logger.CatSystem, "%s: %s 完成，改写 %d 条记录", storePath, action, n)
 	}
-	logger.Warnf(logger.CatCreds, "凭证文件格式无效: %s", path)
 	return nil
 }
 
+// writeFileAtomic 写临时文件后替换，保留原文件权限
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(path); err == nil {
+		mode = st.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 func handleListUserCredentials(w http.ResponseWriter, ucm *kiro.UserCredentialsManager) {
 	entries := ucm.ListAll()
 	var result []map[string]interface{}
@@ -376,6 +731,8 @@ func handleListUserCredentials(w http.ResponseWriter, ucm *kiro.UserCredentialsM
 			"has_credentials": true, "expires_at": e.Credentials.ExpiresAt,
 			"expires_date": e.ExpiresDate,
 			"created_at":   e.CreatedAt, "updated_at": e.UpdatedAt,
+			"disabled": e.Credentials.Disabled, "disabled_reason": e.Credentials.DisabledReason,
+			"quota": ucm.Quota(e.ActivationCode),
 		})
 	}
 	if result == nil {
@@ -416,7 +773,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, x-api-key, anthropic-version, x-kiro-credentials")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, x-api-key, anthropic-version, x-kiro-credentials, x-kiro-license")
+		w.Header().Set("Access-Control-Expose-Headers", "x-kiro-fallback-model, x-kiro-route, Retry-After")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"kiro-go/internal/anthropic"
+	"kiro-go/internal/common"
+	"kiro-go/internal/kiro"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/metrics"
+	"kiro-go/internal/model"
+)
+
+// registerPoolMetrics 抓取时读取的凭证池、区域与直连 key 状态
+func registerPoolMetrics(tokenMgr *kiro.TokenManager, provider *kiro.Provider, direct *anthropic.DirectProvider) {
+	metrics.NewGaugeFunc("kiro_pool_credentials", "主凭证池各状态的凭据数",
+		[]string{"status"}, func(emit func(float64, ...string)) {
+			counts := map[string]int{"healthy": 0, "near_limit": 0, "failing": 0, "expired": 0, "disabled": 0, "no_token": 0}
+			for _, e := range tokenMgr.PoolEntries() {
+				counts[e.Status]++
+			}
+			for _, status := range []string{"healthy", "near_limit", "failing", "expired", "disabled", "no_token"} {
+				emit(float64(counts[status]), status)
+			}
+		})
+	metrics.NewGaugeFunc("kiro_pool_credential_up", "主凭据是否可用（healthy / near_limit 为 1）",
+		[]string{"credential", "group", "status"}, func(emit func(float64, ...string)) {
+			for _, e := range tokenMgr.PoolEntries() {
+				up := 0.0
+				if e.Status == "healthy" || e.Status == "near_limit" {
+					up = 1
+				}
+				emit(up, "pool:"+strconv.Itoa(e.ID), e.Group, e.Status)
+			}
+		})
+	metrics.NewGaugeFunc("kiro_pool_credential_consecutive_failures", "主凭据连续失败次数",
+		[]string{"credential"}, func(emit func(float64, ...string)) {
+			for _, e := range tokenMgr.PoolEntries() {
+				failures := 0
+				if e.Health != nil {
+					failures = e.Health.ConsecutiveFailures
+				}
+				emit(float64(failures), "pool:"+strconv.Itoa(e.ID))
+			}
+		})
+	metrics.NewGaugeFunc("kiro_pool_credential_quota_remaining", "主凭据本月剩余额度（最近一次查询结果）",
+		[]string{"credential"}, func(emit func(float64, ...string)) {
+			for _, e := range tokenMgr.PoolEntries() {
+				if e.Quota != nil && e.Quota.CheckedAt != "" {
+					emit(e.Quota.Remaining, "pool:"+strconv.Itoa(e.ID))
+				}
+			}
+		})
+	metrics.NewGaugeFunc("kiro_region_up", "Kiro API 区域是否可用（冷却中为 0）",
+		[]string{"region"}, func(emit func(float64, ...string)) {
+			for _, h := range provider.Regions.Snapshot() {
+				up := 0.0
+				if h.Healthy {
+					up = 1
+				}
+				emit(up, h.Region)
+			}
+		})
+	if direct != nil {
+		metrics.NewGaugeFunc("kiro_direct_key_cooldown_seconds", "Anthropic 直连各 API Key（按序号）剩余冷却秒数，0 表示可用",
+			[]string{"key"}, func(emit func(float64, ...string)) {
+				for idx, d := range direct.KeyCooldowns() {
+					emit(d.Seconds(), strconv.Itoa(idx))
+				}
+			})
+	}
+}
+
+// requireAdminKey 敏感端点需携带 adminApiKey（x-api-key 或 Authorization: Bearer）；未配置 adminApiKey 时以 disabled 拒绝
+func requireAdminKey(liveCfg *model.ConfigRef, disabled common.MsgCode, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := liveCfg.Load()
+		if cfg.AdminAPIKey == "" {
+			common.WriteErrorCode(w, r, http.StatusForbidden, "permission_error", disabled)
+			return
+		}
+		key := common.ExtractAPIKey(r)
+		if key == "" {
+			common.WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", common.MsgMissingAPIKey)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.AdminAPIKey)) != 1 {
+			common.WriteErrorCode(w, r, http.StatusUnauthorized, "authentication_error", common.MsgInvalidAPIKey)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// metricsAuth 主端口的 /metrics 需携带 adminApiKey
+func metricsAuth(liveCfg *model.ConfigRef, next http.Handler) http.HandlerFunc {
+	return requireAdminKey(liveCfg, common.MsgMetricsDisabled, next)
+}
+
+// serveMetrics 在独立地址上提供不做认证的 /metrics（仅应绑定内网 / 本机地址）
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Fatalf(logger.CatSystem, "指标监听启动失败: %v", err)
+		}
+	}()
+	logger.Infof(logger.CatSystem, "Prometheus 指标独立监听: %s/metrics", addr)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"kiro-go/internal/anthropic"
+	"kiro-go/internal/common"
+	"kiro-go/internal/kiro"
+	"kiro-go/internal/logger"
+	"kiro-go/internal/model"
+	"kiro-go/internal/store"
+	"kiro-go/internal/watch"
+)
+
+// liveServer 当前的 HTTP 服务；host / port 热加载时先在新地址监听成功，再平滑关闭旧服务
+type liveServer struct {
+	mu      sync.Mutex
+	handler http.Handler
+	addr    string
+	srv     *http.Server
+}
+
+// Addr 当前监听地址（host:port）
+func (s *liveServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
+
+// serve 在 ln 上启动服务，替换并平滑关闭之前的服务（进行中的请求和流式响应处理完后才断开）
+func (s *liveServer) serve(ln net.Listener, addr string) {
+	srv := &http.Server{Handler: s.handler}
+	s.mu.Lock()
+	old, oldAddr := s.srv, s.addr
+	s.srv, s.addr = srv, addr
+	s.mu.Unlock()
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf(logger.CatSystem, "服务器启动失败: %v", err)
+		}
+	}()
+	if old != nil {
+		go func() {
+			old.Shutdown(context.Background())
+			logger.Infof(logger.CatSystem, "旧监听地址已关闭: %s", oldAddr)
+		}()
+	}
+}
+
+// reloader 热加载 config.json 与 credentials.json：文件监听、SIGHUP 和管理接口共用。
+// 新文件先完整校验，任一项不通过则整体拒绝，当前配置 / 凭证池保持不变
+type reloader struct {
+	mu         sync.Mutex
+	cfg        *model.ConfigRef
+	configPath string
+	credsPath  string
+	store      *store.Store
+	provider   *kiro.Provider
+	codesMgr   *kiro.CodesManager
+	direct     *anthropic.DirectProvider
+	server     *liveServer
+
+	// credsDigest 上次读取 / 导入时 credentials.json 的内容摘要。启用存储时运行期修改只写入存储，
+	// 文件内容未变化时不再用它覆盖存储中的主凭证池
+	credsDigest [sha256.Size]byte
+}
+
+// reloadConfig 重新读取 config.json，校验后应用可热加载的配置项并记录变化
+func (rl *reloader) reloadConfig() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	data, err := os.ReadFile(rl.configPath)
+	if err != nil {
+		return fmt.Errorf("读取配置失败: %w", err)
+	}
+	var next model.Config
+	if err := json.Unmarshal(data, &next); err != nil {
+		return fmt.Errorf("解析配置失败: %w", err)
+	}
+	next.DefaultsWithDir(filepath.Dir(rl.configPath))
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	if err := anthropic.ValidateModelRouting(next.ModelRouting); err != nil {
+		return fmt.Errorf("模型路由配置无效: %w", err)
+	}
+
+	// 监听地址变化：新地址监听成功才继续，否则整体拒绝
+	var ln net.Listener
+	addr := fmt.Sprintf("%s:%d", next.Host, next.Port)
+	if addr != rl.server.Addr() {
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return fmt.Errorf("监听新地址失败: %w", err)
+		}
+	}
+	if rl.direct != nil {
+		if err := rl.direct.ReloadKeys(&next); err != nil {
+			if ln != nil {
+				ln.Close()
+			}
+			return err
+		}
+	}
+
+	changes := rl.cfg.ApplyReload(&next)
+	if ln != nil {
+		rl.server.serve(ln, addr)
+		logger.Infof(logger.CatSystem, "服务器已切换到新地址: %s", addr)
+	}
+	cfg := rl.cfg.Load()
+	common.SetDefaultLocale(cfg.Locale)
+	anthropic.SetModelRouting(cfg.ModelRouting)
+	rl.codesMgr.SetDefaultDevicePolicy(cfg.DevicePolicy)
+	if rl.direct == nil && (cfg.AnthropicAPIKey != "" || len(cfg.AnthropicAPIKeys) > 0) {
+		logger.Warnf(logger.CatSystem, "已配置 Anthropic API Key，但直连在启动时未启用，需重启生效")
+	}
+
+	if len(changes) == 0 {
+		logger.Infof(logger.CatSystem, "配置已重新读取，无变化")
+		return nil
+	}
+	for _, c := range changes {
+		fields := logger.F{"field": c.Field, "old": c.Old, "new": c.New}
+		if c.Restart {
+			logger.WarnFields(logger.CatSystem, "配置项需重启后生效", fields)
+			continue
+		}
+		logger.InfoFields(logger.CatSystem, "配置项已更新", fields)
+	}
+	return nil
+}
+
+// reloadModelRouting 只重新读取 config.json 的 modelRouting，校验后发布到当前配置并重建路由表，返回别名数
+func (rl *reloader) reloadModelRouting() (int, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	data, err := os.ReadFile(rl.configPath)
+	if err != nil {
+		return 0, fmt.Errorf("读取配置失败: %w", err)
+	}
+	var parsed model.Config
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return 0, fmt.Errorf("解析配置失败: %w", err)
+	}
+	if err := anthropic.ValidateModelRouting(parsed.ModelRouting); err != nil {
+		return 0, fmt.Errorf("模型路由配置无效: %w", err)
+	}
+
+	next := *rl.cfg.Load()
+	next.ModelRouting = parsed.ModelRouting
+	rl.cfg.ApplyReload(&next)
+	anthropic.SetModelRouting(rl.cfg.Load().ModelRouting)
+	n := len(anthropic.ModelAliases())
+	logger.Infof(logger.CatSystem, "模型路由已热加载，共 %d 个别名", n)
+	return n, nil
+}
+
+// reloadCredentials 重新读取 credentials.json，校验后整体替换主凭证池。
+// 启用存储时存储是主凭证池的权威来源：文件自上次读取后未变化、或内容与存储一致时不做任何修改；
+// force 为 true（管理接口显式调用）时总是以文件内容替换存储中的主凭证池
+func (rl *reloader) reloadCredentials(force bool) (int, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	digest := fileDigest(rl.credsPath)
+	if rl.store != nil && !force && digest == rl.credsDigest {
+		logger.Debugf(logger.CatCreds, "credentials.json 未变化，主凭证池以存储为准")
+		return len(rl.provider.TokenMgr.PoolEntries()), nil
+	}
+
+	list, err := readCredentials(rl.credsPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := model.ValidateCredentials(list); err != nil {
+		return 0, fmt.Errorf("凭证文件无效: %w", err)
+	}
+	if rl.store != nil {
+		current, err := kiro.LoadPoolCredentials(rl.store)
+		if err != nil {
+			return 0, err
+		}
+		if !force && sameCredentials(current, list) {
+			rl.credsDigest = digest
+			logger.Infof(logger.CatCreds, "credentials.json 与存储中的主凭证池一致，无需导入")
+			return len(current), nil
+		}
+		if err := kiro.SavePoolCredentials(rl.store, list); err != nil {
+			return 0, err
+		}
+		logger.Infof(logger.CatCreds, "credentials.json 已导入存储，替换主凭证池（%d 个）", len(list))
+	}
+	rl.credsDigest = digest
+	rl.provider.ReloadCredentials(list)
+	return len(list), nil
+}
+
+// fileDigest 文件内容摘要，读取失败时为零值
+func fileDigest(path string) [sha256.Size]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// sameCredentials 两个凭证列表序列化后是否一致
+func sameCredentials(a, b []*model.KiroCredentials) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// watch 监听配置与凭证文件变化和 SIGHUP，阻塞运行
+func (rl *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Infof(logger.CatSystem, "收到 SIGHUP，重新加载配置和凭证")
+			rl.onConfigChange()
+			rl.onCredentialsChange()
+		}
+	}()
+
+	watch.Files([]string{rl.configPath, rl.credsPath}, func(path string) {
+		switch path {
+		case rl.configPath:
+			rl.onConfigChange()
+		case rl.credsPath:
+			rl.onCredentialsChange()
+		}
+	})
+}
+
+func (rl *reloader) onConfigChange() {
+	if err := rl.reloadConfig(); err != nil {
+		logger.Errorf(logger.CatSystem, "配置热加载失败，保留当前配置: %v", err)
+	}
+}
+
+func (rl *reloader) onCredentialsChange() {
+	if _, err := rl.reloadCredentials(false); err != nil {
+		logger.Errorf(logger.CatCreds, "凭据热加载失败，保留当前凭证池: %v", err)
+	}
+}
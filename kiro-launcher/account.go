@@ -84,11 +84,23 @@ func (s *AccountStore) load() {
 		s.accounts = []Account{}
 		return
 	}
+	for i := range accounts {
+		if err := openAccount(&accounts[i]); err != nil {
+			logError("解密账号凭据失败: %v", err)
+		}
+	}
 	s.accounts = accounts
 }
 
 func (s *AccountStore) save() error {
-	data, err := json.MarshalIndent(s.accounts, "", "  ")
+	sealed := make([]Account, len(s.accounts))
+	for i, a := range s.accounts {
+		var err error
+		if sealed[i], err = sealAccount(a); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(sealed, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -83,13 +83,13 @@ func (a *App) ImportCredentials(path string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("读取文件失败: %v", err)
 	}
-	var v json.RawMessage
-	if json.Unmarshal(content, &v) != nil {
-		return "", fmt.Errorf("文件不是有效 JSON")
+	sealed, err := sealCredentialsJSON(content)
+	if err != nil {
+		return "", err
 	}
 	dir, _ := getDataDir()
 	dest := filepath.Join(dir, "credentials.json")
-	if err := os.WriteFile(dest, content, 0644); err != nil {
+	if err := os.WriteFile(dest, sealed, 0600); err != nil {
 		return "", fmt.Errorf("写入凭据失败: %v", err)
 	}
 	go autoUploadToServer()
@@ -106,9 +106,13 @@ func (a *App) SaveCredentialsRaw(jsonStr string) (string, error) {
 			return "", fmt.Errorf("缺少 refreshToken 字段")
 		}
 	}
+	sealed, err := sealCredentialsJSON([]byte(jsonStr))
+	if err != nil {
+		return "", err
+	}
 	dir, _ := getDataDir()
 	dest := filepath.Join(dir, "credentials.json")
-	if err := os.WriteFile(dest, []byte(jsonStr), 0644); err != nil {
+	if err := os.WriteFile(dest, sealed, 0600); err != nil {
 		return "", fmt.Errorf("写入失败: %v", err)
 	}
 	go autoUploadToServer()
@@ -316,19 +320,27 @@ func saveCredentialsFile(creds *CredentialsFile) error {
 		return err
 	}
 	path := filepath.Join(dir, "credentials.json")
-	data, _ := json.MarshalIndent(creds, "", "  ")
-	return os.WriteFile(path, data, 0644)
+	sealed, err := sealCredentialsFile(creds)
+	if err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(sealed, "", "  ")
+	return os.WriteFile(path, data, 0600)
 }
 
 // saveCredentialsFileSmart 智能写入 credentials.json
 //
 // 如果现有文件是数组格式（多凭据），则替换第一个凭据（保留其他凭据不变）。
 // 如果现有文件是单对象格式或不存在，则直接写入单对象。
+// 配置了主密钥时敏感字段加密后写入，与 kiro-go -secrets encrypt 的格式一致。
 func saveCredentialsFileSmart(creds *CredentialsFile) error {
 	dir, err := getDataDir()
 	if err != nil {
 		return err
 	}
+	if creds, err = sealCredentialsFile(creds); err != nil {
+		return err
+	}
 	path := filepath.Join(dir, "credentials.json")
 
 	// 尝试读取现有文件，判断格式
@@ -358,19 +370,68 @@ func saveCredentialsFileSmart(creds *CredentialsFile) error {
 				}
 
 				data, _ := json.MarshalIndent(arr, "", "  ")
-				return os.WriteFile(path, data, 0644)
+				return os.WriteFile(path, data, 0600)
 			}
 		}
 	}
 
 	// 单对象格式或文件不存在：直接写入
 	data, _ := json.MarshalIndent(creds, "", "  ")
-	return os.WriteFile(path, data, 0644)
+	return os.WriteFile(path, data, 0600)
+}
+
+// sealCredentialsJSON 加密导入 / 手动保存的 credentials.json 内容（单对象或数组）
+//
+// 逐条经 sealCredentialsFile 加密敏感字段，其余字段（id、priority 等）原样保留。
+func sealCredentialsJSON(content []byte) ([]byte, error) {
+	trimmed := strings.TrimSpace(string(content))
+	var entries []map[string]json.RawMessage
+	isArray := len(trimmed) > 0 && trimmed[0] == '['
+	if isArray {
+		if err := json.Unmarshal(content, &entries); err != nil {
+			return nil, fmt.Errorf("文件不是有效 JSON: %v", err)
+		}
+	} else {
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, fmt.Errorf("文件不是有效 JSON: %v", err)
+		}
+		entries = []map[string]json.RawMessage{entry}
+	}
+
+	for i, entry := range entries {
+		if entry == nil {
+			return nil, fmt.Errorf("凭据第 %d 条不是 JSON 对象", i+1)
+		}
+		raw, _ := json.Marshal(entry)
+		var cf CredentialsFile
+		if err := json.Unmarshal(raw, &cf); err != nil {
+			return nil, fmt.Errorf("解析凭据失败: %v", err)
+		}
+		sealed, err := sealCredentialsFile(&cf)
+		if err != nil {
+			return nil, fmt.Errorf("加密凭据失败: %v", err)
+		}
+		if sealed.RefreshToken != "" {
+			entries[i]["refreshToken"], _ = json.Marshal(sealed.RefreshToken)
+		}
+		if sealed.AccessToken != nil {
+			entries[i]["accessToken"], _ = json.Marshal(*sealed.AccessToken)
+		}
+		if sealed.ClientSecret != nil {
+			entries[i]["clientSecret"], _ = json.Marshal(*sealed.ClientSecret)
+		}
+	}
+
+	if isArray {
+		return json.MarshalIndent(entries, "", "  ")
+	}
+	return json.MarshalIndent(entries[0], "", "  ")
 }
 
 // readFirstCredential 从 credentials.json 读取第一个凭据
 //
-// 支持单对象和数组格式。数组格式时返回第一个元素。
+// 支持单对象和数组格式。数组格式时返回第一个元素。已加密的字段解密后返回。
 func readFirstCredential(path string) (*CredentialsFile, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -391,6 +452,9 @@ func readFirstCredential(path string) (*CredentialsFile, error) {
 		if len(arr) == 0 {
 			return nil, fmt.Errorf("凭据数组为空")
 		}
+		if err := openCredentialsFile(&arr[0]); err != nil {
+			return nil, err
+		}
 		return &arr[0], nil
 	}
 
@@ -399,5 +463,8 @@ func readFirstCredential(path string) (*CredentialsFile, error) {
 	if err := json.Unmarshal(data, &cf); err != nil {
 		return nil, fmt.Errorf("解析凭据失败: %v", err)
 	}
+	if err := openCredentialsFile(&cf); err != nil {
+		return nil, err
+	}
 	return &cf, nil
 }
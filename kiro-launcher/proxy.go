@@ -116,6 +116,10 @@ func (a *App) startProxyInternal(configPath, credsPath string) error {
 
 	cmd := exec.Command(binary, "-config", configPath, "-credentials", credsPath)
 	cmd.Env = os.Environ()
+	// launcher 从 master.key / 钥匙串读到的主密钥传给 kiro-go，使其能解密 launcher 写出的 credentials.json
+	if k := getMasterKeys(); k != nil && os.Getenv(masterKeyEnv) == "" {
+		cmd.Env = append(cmd.Env, masterKeyEnv+"="+k.envSpec())
+	}
 
 	stdout, _ := cmd.StdoutPipe()
 	stderr, _ := cmd.StderrPipe()
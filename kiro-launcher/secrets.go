@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ── Secrets at Rest ──
+// accounts.json 与 credentials.json 中的 accessToken / refreshToken / clientSecret 信封加密保存。
+// 格式与 kiro-go internal/secrets 一致：enc:v1:<主密钥 ID>:<包装后的数据密钥>:<nonce||密文>，
+// 可用 kiro-go -secrets encrypt|rotate|decrypt <accounts.json> <credentials.json> 批量迁移 / 轮换
+
+const (
+	sealedPrefix   = "enc:v1:"
+	masterKeyEnv   = "KIRO_MASTER_KEY"
+	keyringService = "kiro-go"
+	keyringAccount = "master-key"
+)
+
+// masterKeys 主密钥集合：第一个为当前主密钥，其余只用于解密轮换前的数据
+type masterKeys struct {
+	primary string
+	keys    map[string][]byte
+}
+
+var (
+	masterKeysOnce  sync.Once
+	masterKeysCache *masterKeys
+)
+
+// getMasterKeys 按 环境变量 → 数据目录 master.key → 系统钥匙串 的顺序读取主密钥，都没有时返回 nil（明文保存）
+func getMasterKeys() *masterKeys {
+	masterKeysOnce.Do(func() {
+		spec := os.Getenv(masterKeyEnv)
+		if strings.TrimSpace(spec) == "" {
+			if dir, err := getDataDir(); err == nil {
+				if data, err := os.ReadFile(filepath.Join(dir, "master.key")); err == nil {
+					spec = string(data)
+				}
+			}
+		}
+		if strings.TrimSpace(spec) == "" {
+			spec = readKeyringMasterKey()
+		}
+		masterKeysCache = parseMasterKeys(spec)
+		if masterKeysCache != nil {
+			logInfo("账号凭据静态加密已启用，当前主密钥: %s", masterKeysCache.primary)
+		}
+	})
+	return masterKeysCache
+}
+
+// readKeyringMasterKey 从系统钥匙串读取（service kiro-go，account master-key），失败返回空
+func readKeyringMasterKey() string {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	default:
+		return ""
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func parseMasterKeys(spec string) *masterKeys {
+	k := &masterKeys{keys: make(map[string][]byte)}
+	for _, line := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var key []byte
+		if b, err := base64.StdEncoding.DecodeString(line); err == nil && len(b) == 32 {
+			key = b
+		} else if b, err := hex.DecodeString(line); err == nil && len(b) == 32 {
+			key = b
+		} else {
+			h := sha256.Sum256([]byte("kiro-master-v1:" + line))
+			key = h[:]
+		}
+		h := sha256.Sum256(key)
+		id := hex.EncodeToString(h[:4])
+		if k.primary == "" {
+			k.primary = id
+		}
+		k.keys[id] = key
+	}
+	if k.primary == "" {
+		return nil
+	}
+	return k
+}
+
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func gcmOpen(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("密文过短")
+	}
+	return aead.Open(nil, sealed[:n], sealed[n:], aad)
+}
+
+// envSpec 以 KIRO_MASTER_KEY 的格式（base64，逗号分隔，当前主密钥在前）导出全部密钥，供 kiro-go 子进程使用
+func (k *masterKeys) envSpec() string {
+	specs := []string{base64.StdEncoding.EncodeToString(k.keys[k.primary])}
+	for id, key := range k.keys {
+		if id != k.primary {
+			specs = append(specs, base64.StdEncoding.EncodeToString(key))
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+// seal 加密字段值，field 作为附加数据
+func (k *masterKeys) seal(field, plaintext string) (string, error) {
+	if plaintext == "" || strings.HasPrefix(plaintext, sealedPrefix) {
+		return plaintext, nil
+	}
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	wrapped, err := gcmSeal(k.keys[k.primary], dek, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	data, err := gcmSeal(dek, []byte(plaintext), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return sealedPrefix + k.primary + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(data), nil
+}
+
+// open 解密字段值，明文原样返回
+func (k *masterKeys) open(field, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	p := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(p) != 3 {
+		return "", errors.New("加密值格式无效")
+	}
+	key, ok := k.keys[p[0]]
+	if !ok {
+		return "", fmt.Errorf("缺少主密钥 %s", p[0])
+	}
+	enc := base64.RawURLEncoding
+	wrapped, err1 := enc.DecodeString(p[1])
+	data, err2 := enc.DecodeString(p[2])
+	if err1 != nil || err2 != nil {
+		return "", errors.New("加密值格式无效")
+	}
+	dek, err := gcmOpen(key, wrapped, []byte(p[0]))
+	if err != nil {
+		return "", fmt.Errorf("主密钥 %s 无法解开数据密钥", p[0])
+	}
+	plain, err := gcmOpen(dek, data, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("字段 %s 解密失败", field)
+	}
+	return string(plain), nil
+}
+
+// sealAccount 返回敏感字段已加密的副本（未配置主密钥时原样返回）
+func sealAccount(a Account) (Account, error) {
+	k := getMasterKeys()
+	if k == nil {
+		return a, nil
+	}
+	var err error
+	if a.AccessToken, err = k.seal("accessToken", a.AccessToken); err != nil {
+		return a, err
+	}
+	if a.RefreshToken, err = k.seal("refreshToken", a.RefreshToken); err != nil {
+		return a, err
+	}
+	if a.ClientSecret, err = k.seal("clientSecret", a.ClientSecret); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// secretField 待解密的字段：名称（附加数据）与值的位置
+type secretField struct {
+	name  string
+	value *string
+}
+
+// openAccount 原地解密敏感字段；失败时保留密文（保存时原样写回，不会丢数据）
+func openAccount(a *Account) error {
+	fields := []secretField{
+		{"accessToken", &a.AccessToken},
+		{"refreshToken", &a.RefreshToken},
+		{"clientSecret", &a.ClientSecret},
+	}
+	for _, f := range fields {
+		if !strings.HasPrefix(*f.value, sealedPrefix) {
+			continue
+		}
+		k := getMasterKeys()
+		if k == nil {
+			return fmt.Errorf("账号 %s 的凭据已加密，但未配置主密钥（%s / master.key / 钥匙串）", a.Email, masterKeyEnv)
+		}
+		plain, err := k.open(f.name, *f.value)
+		if err != nil {
+			return fmt.Errorf("账号 %s: %v", a.Email, err)
+		}
+		*f.value = plain
+	}
+	return nil
+}
+
+// sealCredentialsFile 返回敏感字段已加密的副本，供写入 credentials.json（未配置主密钥时原样返回）
+func sealCredentialsFile(cf *CredentialsFile) (*CredentialsFile, error) {
+	k := getMasterKeys()
+	if k == nil {
+		return cf, nil
+	}
+	sealed := *cf
+	var err error
+	if sealed.RefreshToken, err = k.seal("refreshToken", cf.RefreshToken); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		name  string
+		value **string
+	}{
+		{"accessToken", &sealed.AccessToken},
+		{"clientSecret", &sealed.ClientSecret},
+	} {
+		if *f.value == nil {
+			continue
+		}
+		v, err := k.seal(f.name, **f.value)
+		if err != nil {
+			return nil, err
+		}
+		*f.value = &v
+	}
+	return &sealed, nil
+}
+
+// openCredentialsFile 原地解密 credentials.json 中的凭据（kiro-go -secrets encrypt 之后文件中是密文）
+func openCredentialsFile(cf *CredentialsFile) error {
+	fields := []secretField{{"refreshToken", &cf.RefreshToken}}
+	if cf.AccessToken != nil {
+		fields = append(fields, secretField{"accessToken", cf.AccessToken})
+	}
+	if cf.ClientSecret != nil {
+		fields = append(fields, secretField{"clientSecret", cf.ClientSecret})
+	}
+	for _, f := range fields {
+		if !strings.HasPrefix(*f.value, sealedPrefix) {
+			continue
+		}
+		k := getMasterKeys()
+		if k == nil {
+			return fmt.Errorf("credentials.json 中的凭据已加密，但未配置主密钥（%s / master.key / 钥匙串）", masterKeyEnv)
+		}
+		plain, err := k.open(f.name, *f.value)
+		if err != nil {
+			return fmt.Errorf("credentials.json: %v", err)
+		}
+		*f.value = plain
+	}
+	return nil
+}